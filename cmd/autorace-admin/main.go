@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/admin"
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+const usage = `usage : autorace-admin <command> [arguments]
+
+commands :
+  parties [-json]                list parties with their state, server and players
+  party [-json] <party ID>       show a party
+  player [-json] <player ID>     show a player, its profile and its party
+  end [-reason text] <party ID>  force-end a party, its players get their results
+  kill <party ID>                kill the dynamic server of a party and remove the party
+  prune [-dry-run]               remove keys left behind by parties and pools which are gone
+  tail [party ID]                print lobby events until interrupted
+
+Redis and RabbitMQ are found like the servers find them : REDIS_ADDR, RABBITMQ_HOST, RABBITMQ_PORT,
+RABBITMQ_USER and RABBITMQ_PASS. end needs SESSION_SECRET, kill needs the launcher configuration of
+the static servers (DOCKER_HOST, KUBERNETES_NAMESPACE, ...)
+`
+
+var rabbitMQConfig messaging.RabbitConnectionConfiguration
+
+func init() {
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "warning"
+	}
+	// the standard output is kept for results
+	logger.SetStdLogger(logLevel, "stderr")
+	rabbitMQConfig = messaging.RabbitConnectionConfiguration{
+		Host:     os.Getenv("RABBITMQ_HOST"),
+		Port:     os.Getenv("RABBITMQ_PORT"),
+		User:     os.Getenv("RABBITMQ_USER"),
+		Password: os.Getenv("RABBITMQ_PASS"),
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	commands := map[string]func(adm *admin.Admin, args []string) error{
+		"parties": listParties,
+		"party":   showParty,
+		"player":  showPlayer,
+		"end":     endParty,
+		"kill":    killParty,
+		"prune":   pruneKeys,
+		"tail":    tailLobby,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	adm, err := admin.NewAdmin(rabbitMQConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect :", err)
+		os.Exit(1)
+	}
+	err = command(adm, os.Args[2:])
+	closeErr := adm.Close()
+	if closeErr != nil {
+		logger.Error("while closing connections :", closeErr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, os.Args[1], ":", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses the flags of a command and checks the number of remaining arguments
+func parseArgs(flags *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() < minArgs || flags.NArg() > maxArgs {
+		return nil, fmt.Errorf("expected between %d and %d arguments, got %d", minArgs, maxArgs, flags.NArg())
+	}
+	return flags.Args(), nil
+}
+
+func listParties(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("parties", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print parties as JSON")
+	_, err := parseArgs(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	parties, err := adm.Parties()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(parties)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PARTY\tNAME\tSTATE\tSERVER\tLAUNCHER\tAGE\tPLAYERS")
+	for _, party := range parties {
+		playerNames := make([]string, len(party.Players))
+		for index, player := range party.Players {
+			playerNames[index] = player.PlayerName
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			party.PartyID,
+			party.PartyName,
+			party.State,
+			serverStatus(party),
+			launcherOf(party),
+			age(party.CreatedAt),
+			strings.Join(playerNames, ", "),
+		)
+	}
+	return writer.Flush()
+}
+
+func showParty(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("party", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the party as JSON")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	party, err := adm.Party(args[0])
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(party)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "party\t%s\n", party.PartyID)
+	fmt.Fprintf(writer, "name\t%s\n", party.PartyName)
+	fmt.Fprintf(writer, "state\t%s\n", party.State)
+	fmt.Fprintf(writer, "server\t%s\n", serverStatus(party))
+	if party.Server != nil {
+		fmt.Fprintf(writer, "host\t%s\n", party.Server.Host)
+		fmt.Fprintf(writer, "tick rate\t%d/%d\n", party.Server.TickRate, party.Server.TickExpected)
+	}
+	fmt.Fprintf(writer, "launcher\t%s\n", launcherOf(party))
+	if party.Snapshot != nil {
+		fmt.Fprintf(writer, "snapshot\t%s ago, %d resumes\n", time.Since(party.Snapshot.TakenAt).Round(time.Second), party.Snapshot.Resumes)
+	}
+	fmt.Fprintf(writer, "created\t%s ago\n", age(party.CreatedAt))
+	for _, player := range party.Players {
+		fmt.Fprintf(writer, "player\t%s\t%s\n", player.PlayerID, player.PlayerName)
+	}
+	return writer.Flush()
+}
+
+func showPlayer(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("player", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the player as JSON")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	playerInfo, err := adm.Player(args[0])
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(playerInfo)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "player\t%s\n", playerInfo.Player.PlayerUUID)
+	fmt.Fprintf(writer, "name\t%s\n", playerInfo.Player.PlayerName)
+	partyID := playerInfo.PartyID
+	if partyID == "" {
+		partyID = "-"
+	}
+	fmt.Fprintf(writer, "party\t%s\n", partyID)
+	if profile := playerInfo.Profile; profile != nil {
+		fmt.Fprintf(writer, "races\t%d started, %d finished\n", profile.RacesStarted, profile.RacesFinished)
+		fmt.Fprintf(writer, "wins\t%d, %d podiums\n", profile.Wins, profile.Podiums)
+		fmt.Fprintf(writer, "distance\t%.0f\n", profile.TotalDistance)
+		for trackID, bestLap := range profile.BestLaps {
+			fmt.Fprintf(writer, "best lap\t%s\t%.3fs\n", trackID, bestLap)
+		}
+	}
+	return writer.Flush()
+}
+
+func endParty(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("end", flag.ContinueOnError)
+	reason := flags.String("reason", "", "reason logged by the dynamic server")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	err = adm.EndParty(args[0], *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Println("party", args[0], "is ending")
+	return nil
+}
+
+func killParty(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("kill", flag.ContinueOnError)
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	launchRecord, err := adm.KillParty(args[0])
+	if err != nil {
+		return err
+	}
+	if launchRecord != nil {
+		fmt.Println("killed", launchRecord.Launcher, "instance", launchRecord.InstanceID)
+	}
+	fmt.Println("party", args[0], "removed")
+	return nil
+}
+
+func pruneKeys(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print stale keys")
+	_, err := parseArgs(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	removeStaleKeys := adm.RemoveStaleKeys
+	if *dryRun {
+		removeStaleKeys = adm.StaleKeys
+	}
+	staleKeys, err := removeStaleKeys()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, staleKey := range staleKeys {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", staleKey.Database, staleKey.Key, staleKey.Reason)
+	}
+	flushErr := writer.Flush()
+	if err != nil {
+		return err
+	}
+	return flushErr
+}
+
+func tailLobby(adm *admin.Admin, args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ContinueOnError)
+	args, err := parseArgs(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	partyID := ""
+	if len(args) == 1 {
+		partyID = args[0]
+	}
+	return adm.TailLobbyEvents(partyID, func(lobbyEvent models.LobbyEvent) {
+		fmt.Printf("%s  %-14s %s %s (%d players, %s) %s\n",
+			time.Now().Format("15:04:05"),
+			lobbyEvent.Kind,
+			lobbyEvent.PartyID,
+			lobbyEvent.PartyName,
+			lobbyEvent.PlayerCount,
+			lobbyEvent.State,
+			lobbyEvent.PlayerName,
+		)
+	})
+}
+
+// serverStatus tells if the dynamic server of a party is alive
+func serverStatus(party admin.PartyInfo) string {
+	switch {
+	case party.Alive && party.Server != nil && !party.Server.Healthy():
+		return "slow"
+	case party.Alive:
+		return "alive"
+	case party.Resumable:
+		return "resumable"
+	}
+	return "gone"
+}
+
+// launcherOf returns the launcher and the instance of the dynamic server of a party
+func launcherOf(party admin.PartyInfo) string {
+	if party.Launch == nil {
+		return "-"
+	}
+	if party.Launch.InstanceID == "" {
+		return party.Launch.Launcher
+	}
+	return party.Launch.Launcher + "/" + party.Launch.InstanceID
+}
+
+// age returns how long ago a party was created
+func age(createdAt time.Time) string {
+	if createdAt.IsZero() {
+		return "-"
+	}
+	return time.Since(createdAt).Round(time.Second).String()
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -21,10 +21,7 @@ func init() {
 func main() {
 	reader := bufio.NewReader(os.Stdin)
 	config := engine.NewWindowConfiguration()
-	fmt.Print("Enter your player name : ")
-	playerName, _ := reader.ReadString('\n')
-	playerName = strings.Replace(playerName, "\n", "", -1)
-	playerName = strings.Replace(playerName, "\r", "", -1)
+	playerName := askPlayerName(reader)
 	fmt.Print("Enter server address : ")
 	rabbitMQAddr, _ := reader.ReadString('\n')
 	rabbitMQAddr = strings.Replace(rabbitMQAddr, "\n", "", -1)
@@ -40,6 +37,7 @@ func main() {
 		logger.Error("error while setting up mainWindow :", err)
 		return
 	}
+	printProfile(mainWindow)
 	fmt.Print("Do you want to create a game ? (y/n) : ")
 	var createOrNot string
 	for createOrNot != "y" && createOrNot != "yes" && createOrNot != "n" && createOrNot != "no" {
@@ -61,32 +59,81 @@ func main() {
 	}
 }
 
+// askPlayerName asks a player name until it is valid
+func askPlayerName(reader *bufio.Reader) string {
+	for {
+		fmt.Print("Enter your player name : ")
+		playerName, _ := reader.ReadString('\n')
+		playerName = strings.Replace(playerName, "\n", "", -1)
+		playerName = strings.Replace(playerName, "\r", "", -1)
+		playerName, err := models.ValidatePlayerName(playerName)
+		if err == nil {
+			return playerName
+		}
+		fmt.Println(err)
+	}
+}
+
 func joinParty(mainWindow *engine.MainGameWindow) error {
 	readyToReceive := make(chan bool)
-	partyList, err := mainWindow.GameInfo.GetPartyList()
+	// lobby events keep the party list up to date while the player is choosing
+	go func() {
+		err := mainWindow.GameInfo.HandleLobbyEvents(readyToReceive)
+		if err != nil {
+			logger.Error("error while receiving lobby events :", err)
+		}
+	}()
+	if !<-readyToReceive {
+		return errors.New("unable to receive lobby events")
+	}
+	_, err := mainWindow.GameInfo.GetPartyList()
 	if err != nil {
 		logger.Error("error while getting party list :", err)
 		return err
 	}
-	fmt.Println("Choose a party from the list below : ")
-	for index, party := range partyList {
-		fmt.Println("party", index+1, ":", party)
-	}
-
+	var partyList []string
+	var partyID string
 	chosenParty := -1
-
-	for chosenParty < 0 || chosenParty > len(partyList) {
+	for partyID == "" {
+		partyList = partyList[:0]
+		fmt.Println("Choose a party from the list below, 0 to refresh it, -1 to use an invite code or -2 for a quick play : ")
+		for index, party := range mainWindow.GameInfo.JoinableParties() {
+			fmt.Println("party", index+1, ":", party.PartyName, "(", party.PlayerCount, "players ) -", party.PartyID)
+			partyList = append(partyList, party.PartyID)
+		}
 		_, err = fmt.Scanf("%d", &chosenParty)
 		if err != nil {
 			return err
 		}
+		if chosenParty == -2 {
+			fmt.Println("waiting for other players ...")
+			partyID, err = mainWindow.GameInfo.QuickPlay()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if chosenParty == -1 {
+			partyID, err = askInviteCode(mainWindow)
+			if err != nil {
+				fmt.Println("invalid invite code :", err)
+			}
+			continue
+		}
 		chosenParty--
+		if chosenParty >= 0 && chosenParty < len(partyList) {
+			partyID = partyList[chosenParty]
+		}
 	}
-	fmt.Println("chosen party", chosenParty, ":", partyList[chosenParty])
+	fmt.Println("chosen party :", partyID)
+	fmt.Println("Party password, leave empty if none : ")
+	var password string
+	// an empty line is not an error here
+	_, _ = fmt.Scanln(&password)
 
 	go func() {
 		logger.Debug("about to start communication daemon")
-		err = mainWindow.StartCommunicationDaemonWithPartyID(partyList[chosenParty], readyToReceive)
+		err = mainWindow.StartCommunicationDaemonWithPartyID(partyID, readyToReceive)
 		if err != nil {
 			logger.Error("error while starting communication daemon :", err)
 			panic(err)
@@ -98,7 +145,7 @@ func joinParty(mainWindow *engine.MainGameWindow) error {
 		return errors.New("unable to start communication daemon")
 	}
 	logger.Trace("about to add player in party")
-	err = mainWindow.GameInfo.AddPlayerToAParty(partyList[chosenParty])
+	err = mainWindow.GameInfo.AddPlayerToAParty(partyID, password)
 	if err != nil {
 		logger.Error("could not add player in party :", err)
 		return err
@@ -112,6 +159,17 @@ func joinParty(mainWindow *engine.MainGameWindow) error {
 	return nil
 }
 
+// askInviteCode reads an invite code and returns the private party it leads to
+func askInviteCode(mainWindow *engine.MainGameWindow) (string, error) {
+	fmt.Println("Invite code : ")
+	var inviteCode string
+	_, err := fmt.Scanln(&inviteCode)
+	if err != nil {
+		return "", err
+	}
+	return mainWindow.GameInfo.ResolveInviteCode(inviteCode)
+}
+
 func createParty(mainWindow *engine.MainGameWindow) error {
 	partyToken := models.PartyCreationToken{
 		ClientID:  mainWindow.GameInfo.ActorPlayer.Player.PlayerUUID.String(),
@@ -144,3 +202,14 @@ func createParty(mainWindow *engine.MainGameWindow) error {
 	}
 	return nil
 }
+
+// printProfile prints the career of the player
+func printProfile(mainWindow *engine.MainGameWindow) {
+	profile, err := mainWindow.GameInfo.GetProfile()
+	if err != nil {
+		logger.Warning("unable to get player profile :", err)
+		return
+	}
+	fmt.Printf("Welcome %s : %d races, %d finished, %d wins, %d podiums, %.0f driven\n",
+		profile.PlayerName, profile.RacesStarted, profile.RacesFinished, profile.Wins, profile.Podiums, profile.TotalDistance)
+}
@@ -4,9 +4,12 @@ import (
 	"errors"
 	"github.com/clnbs/autorace/internal/pkg/messaging"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/clnbs/autorace/internal/app/models"
 	"github.com/clnbs/autorace/internal/app/server"
 	"github.com/clnbs/autorace/pkg/logger"
 )
@@ -17,26 +20,26 @@ var (
 )
 
 func init() {
-	var err error
-	fluentdAddr := os.Getenv("FLUENTD_HOST")
-	stringifyFluentdPort := os.Getenv("FLUENTD_PORT")
 	logLevel := os.Getenv("LOG_LEVEL")
-	fluentdPort, err := strconv.ParseInt(stringifyFluentdPort, 10, 64)
-	if err != nil {
-		panic(err)
-	}
 	logger.SetStdLogger(logLevel, "stdout")
-	err = errors.New("dummy")
-	index := 0
-	for err != nil && index < hitCounter {
-		_, err = logger.SetFluentLogger(fluentdAddr, logLevel, "dynamic", int(fluentdPort))
+	// without fluentd, logs are only written on the standard output
+	if fluentdAddr := os.Getenv("FLUENTD_HOST"); fluentdAddr != "" {
+		fluentdPort, err := strconv.ParseInt(os.Getenv("FLUENTD_PORT"), 10, 64)
 		if err != nil {
-			time.Sleep(5 * time.Second)
+			panic(err)
+		}
+		err = errors.New("dummy")
+		index := 0
+		for err != nil && index < hitCounter {
+			_, err = logger.SetFluentLogger(fluentdAddr, logLevel, "dynamic", int(fluentdPort))
+			if err != nil {
+				time.Sleep(5 * time.Second)
+			}
+			index++
+		}
+		if err != nil {
+			panic(err)
 		}
-		index++
-	}
-	if err != nil {
-		panic(err)
 	}
 	rabbitMQConfig = messaging.RabbitConnectionConfiguration{
 		Host:     os.Getenv("RABBITMQ_HOST"),
@@ -47,59 +50,60 @@ func init() {
 }
 
 func main() {
-	readyToReceive := make(chan bool)
-	srvr, err := server.NewDynamicPartyServer(os.Args[1], rabbitMQConfig)
-	if err != nil {
-		logger.Error("while creating dynamic server :", err)
-		return
-	}
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		err := srvr.ReceiveAddPlayer(readyToReceive)
-		if err != nil {
-			logger.Error("while listening to adding player in party :", err)
-			return
-		}
+		<-interrupt
+		close(stop)
 	}()
-	if !<-readyToReceive {
-		logger.Error("server could not listen continuously")
+	// without a party UUID, the dynamic server hosts a pool of parties
+	if len(os.Args) < 2 {
+		runPool(stop)
 		return
 	}
-	go func() {
-		err := srvr.ReceiveNewState(readyToReceive)
-		if err != nil {
-			logger.Error("while listening to new state :", err)
+	err := server.RunDynamicPartyServer(os.Args[1], rabbitMQConfig, stop)
+	if err != nil {
+		logger.Error("while running dynamic server :", err)
+		// launchers see the party failed from the exit status
+		os.Exit(1)
+	}
+}
+
+// runPool hosts up to DYNAMIC_POOL_CAPACITY parties, models.DefaultPoolCapacity if it is not set,
+// until stop is closed
+func runPool(stop chan struct{}) {
+	capacity := models.DefaultPoolCapacity
+	if rawCapacity := os.Getenv("DYNAMIC_POOL_CAPACITY"); rawCapacity != "" {
+		var err error
+		capacity, err = strconv.Atoi(rawCapacity)
+		if err != nil || capacity <= 0 {
+			logger.Error("invalid DYNAMIC_POOL_CAPACITY :", rawCapacity)
 			return
 		}
-	}()
-	if !<-readyToReceive {
-		logger.Error("server could not listen continuously")
+	}
+	pool, err := server.NewDynamicPool(rabbitMQConfig, capacity)
+	if err != nil {
+		logger.Error("error while creating dynamic server pool :", err)
 		return
 	}
-	go func() {
-		err := srvr.ReceiveSyncRequest(readyToReceive)
+	defer func() {
+		err := pool.Close()
 		if err != nil {
-			logger.Error("while listening to sync request :", err)
-			return
+			logger.Error("while closing ongoing connection :", err)
 		}
 	}()
-	if !<-readyToReceive {
-		logger.Error("server could not listen continuously")
-		return
-	}
+	readyToReceive := make(chan bool)
 	go func() {
-		err := srvr.ReceivePlayersInput(readyToReceive)
+		err := pool.ReceiveLaunch(readyToReceive)
 		if err != nil {
-			logger.Error("while listening to player inputs :", err)
-			return
+			logger.Error("while listening to pool launch :", err)
 		}
 	}()
 	if !<-readyToReceive {
 		logger.Error("server could not listen continuously")
 		return
 	}
-	srvr.Run()
-	err = srvr.Close()
-	if err != nil {
-		logger.Error("while closing ongoing connection :", err)
-	}
+	logger.Trace("dynamic server pool started ...")
+	pool.Run(stop)
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/gateway"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+var (
+	hitCounter     = 5
+	listenAddr     = ":8081"
+	allowedOrigins []string
+	rabbitMQConfig messaging.RabbitConnectionConfiguration
+)
+
+func init() {
+	var err error
+	fluentdAddr := os.Getenv("FLUENTD_HOST")
+	stringifyFluentdPort := os.Getenv("FLUENTD_PORT")
+	logLevel := os.Getenv("LOG_LEVEL")
+	fluentdPort, err := strconv.ParseInt(stringifyFluentdPort, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	logger.SetStdLogger(logLevel, "stdout")
+	err = errors.New("dummy")
+	index := 0
+	for err != nil && index < hitCounter {
+		_, err = logger.SetFluentLogger(fluentdAddr, logLevel, "gateway", int(fluentdPort))
+		if err != nil {
+			time.Sleep(5 * time.Second)
+		}
+		index++
+	}
+	if err != nil {
+		panic(err)
+	}
+	if addr := os.Getenv("GATEWAY_ADDR"); addr != "" {
+		listenAddr = addr
+	}
+	if origins := os.Getenv("GATEWAY_ALLOWED_ORIGINS"); origins != "" {
+		allowedOrigins = strings.Split(origins, ",")
+	}
+	rabbitMQConfig = messaging.RabbitConnectionConfiguration{
+		Host:     os.Getenv("RABBITMQ_HOST"),
+		Port:     os.Getenv("RABBITMQ_PORT"),
+		User:     os.Getenv("RABBITMQ_USER"),
+		Password: os.Getenv("RABBITMQ_PASS"),
+	}
+}
+
+func main() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	gw := gateway.NewGateway(rabbitMQConfig, allowedOrigins)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", gw)
+	httpServer := &http.Server{
+		Addr:    listenAddr,
+		Handler: mux,
+	}
+	go func() {
+		logger.Trace("gateway listening on", listenAddr)
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("while serving WebSocket connections :", err)
+			stop <- syscall.SIGTERM
+		}
+	}()
+	<-stop
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		logger.Error("while shutting down gateway :", err)
+	}
+	gw.Close()
+}
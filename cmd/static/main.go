@@ -5,10 +5,13 @@ import (
 	"github.com/clnbs/autorace/internal/pkg/messaging"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/clnbs/autorace/internal/app/server"
+	"github.com/clnbs/autorace/internal/pkg/auth"
+	"github.com/clnbs/autorace/internal/pkg/container"
 	"github.com/clnbs/autorace/pkg/logger"
 )
 
@@ -19,18 +22,24 @@ var (
 
 func init() {
 	logger.SetStdLogger("trace", "stdout")
-	var err error
-	err = errors.New("dummy")
-	index := 0
-	for err != nil && index < hitCounter {
-		_, err = logger.SetFluentLogger("fluentd", "trace", "dynamic", 24224)
+	// without fluentd, logs are only written on the standard output
+	if fluentdAddr := os.Getenv("FLUENTD_HOST"); fluentdAddr != "" {
+		fluentdPort, err := strconv.ParseInt(os.Getenv("FLUENTD_PORT"), 10, 64)
 		if err != nil {
-			time.Sleep(5 * time.Second)
+			panic(err)
+		}
+		err = errors.New("dummy")
+		index := 0
+		for err != nil && index < hitCounter {
+			_, err = logger.SetFluentLogger(fluentdAddr, "trace", "dynamic", int(fluentdPort))
+			if err != nil {
+				time.Sleep(5 * time.Second)
+			}
+			index++
+		}
+		if err != nil {
+			panic(err)
 		}
-		index++
-	}
-	if err != nil {
-		panic(err)
 	}
 	rabbitMQConfig = messaging.RabbitConnectionConfiguration{
 		Host:     os.Getenv("RABBITMQ_HOST"),
@@ -41,10 +50,15 @@ func init() {
 }
 
 func main() {
-	stop := make(chan os.Signal)
+	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	readyToReceive := make(chan bool)
-	srvr, err := server.NewStaticServer(rabbitMQConfig)
+	launcher, err := newLauncher(os.Getenv("DYNAMIC_LAUNCHER"))
+	if err != nil {
+		logger.Error("error while creating dynamic server launcher :", err)
+		return
+	}
+	srvr, err := server.NewStaticServer(rabbitMQConfig, launcher)
 	if err != nil {
 		logger.Error("error while creation server :", err)
 		return
@@ -61,6 +75,28 @@ func main() {
 		logger.Error("server could not listen continuously")
 		return
 	}
+	go func() {
+		err := srvr.ReceiveProfileRequest(readyToReceive)
+		if err != nil {
+			logger.Error("while listening to profile request :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		logger.Error("server could not listen continuously")
+		return
+	}
+	go func() {
+		err := srvr.ReceiveRename(readyToReceive)
+		if err != nil {
+			logger.Error("while listening to rename request :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		logger.Error("server could not listen continuously")
+		return
+	}
 	go func() {
 		err := srvr.PartyListRequest(readyToReceive)
 		if err != nil {
@@ -85,10 +121,78 @@ func main() {
 		logger.Error("server could not listen continuously")
 		return
 	}
+	go func() {
+		err := srvr.ReceiveInviteRequest(readyToReceive)
+		if err != nil {
+			logger.Error("while listening to invite request :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		logger.Error("server could not listen continuously")
+		return
+	}
+	go func() {
+		err := srvr.ReceiveLobbyChat(readyToReceive)
+		if err != nil {
+			logger.Error("while listening to chat messages :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		logger.Error("server could not listen continuously")
+		return
+	}
+	go func() {
+		err := srvr.ReceiveMatchRequest(readyToReceive)
+		if err != nil {
+			logger.Error("while listening to match request :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		logger.Error("server could not listen continuously")
+		return
+	}
+	go func() {
+		err := srvr.ReceiveMatchCancelled(readyToReceive)
+		if err != nil {
+			logger.Error("while listening to match cancellation :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		logger.Error("server could not listen continuously")
+		return
+	}
+	go srvr.RunReaper(server.ReaperInterval)
+	go srvr.RunMatchmaker(server.MatchmakingInterval)
 	logger.Trace("static server started ...")
 	<-stop
 	err = srvr.Close()
 	if err != nil {
 		logger.Error("while closing ongoing connection :", err)
 	}
+	if poolLauncher, ok := launcher.(*server.PoolLauncher); ok {
+		err = poolLauncher.Close()
+		if err != nil {
+			logger.Error("while closing pool launcher connection :", err)
+		}
+	}
+}
+
+// newLauncher create the launcher of dynamic servers. They run in Docker containers by default,
+// DYNAMIC_LAUNCHER=process or goroutine runs them without Docker, DYNAMIC_LAUNCHER=pool hands them
+// to dynamic server pools. Only a static server running its parties in goroutines may generate its
+// session secret : other static servers and pools would refuse the tokens it issues
+func newLauncher(kind string) (container.Launcher, error) {
+	if kind != container.GoroutineLauncherKind && !auth.HasSecretInEnv() {
+		return nil, server.ErrorSessionSecretRequired
+	}
+	if kind != server.PoolLauncherKind {
+		return container.NewLauncher(kind, container.DynamicServerPath(), func(partyID string) error {
+			return server.RunDynamicPartyServer(partyID, rabbitMQConfig, nil)
+		})
+	}
+	return server.NewPoolLauncher(rabbitMQConfig)
 }
@@ -0,0 +1,353 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/app/server"
+	"github.com/clnbs/autorace/internal/pkg/auth"
+	"github.com/clnbs/autorace/internal/pkg/container"
+	"github.com/clnbs/autorace/internal/pkg/database"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrorSessionSecret is returned when a party is force-ended without SESSION_SECRET nor
+	// SESSION_SECRET_FILE, dynamic servers only trust administration messages signed with their secret
+	ErrorSessionSecret = errors.New("SESSION_SECRET or SESSION_SECRET_FILE has to be set to force-end a party")
+	// ErrorPartyNotFound is returned when a party has no key left in Redis
+	ErrorPartyNotFound = errors.New("party not found")
+	// ErrorPartyNotAlive is returned when a party to force-end has no live dynamic server
+	ErrorPartyNotAlive = errors.New("party has no live dynamic server")
+	// ErrorNotKillable is returned when the launcher of a party can not kill its dynamic server alone
+	ErrorNotKillable = errors.New("launcher can not kill the dynamic server of a single party")
+)
+
+// PlayerSummary identifies a player of a party
+type PlayerSummary struct {
+	PlayerID   string `json:"player_id"`
+	PlayerName string `json:"player_name"`
+}
+
+// PartyInfo describes a party as seen from Redis. A party without live dynamic server may still be
+// resumed from its snapshot, or is an orphan waiting to be reaped
+type PartyInfo struct {
+	PartyID   string                `json:"party_id"`
+	PartyName string                `json:"party_name"`
+	State     models.State          `json:"state"`
+	Alive     bool                  `json:"alive"`
+	Resumable bool                  `json:"resumable"`
+	Server    *models.ServerRecord  `json:"server,omitempty"`
+	Launch    *models.LaunchRecord  `json:"launch,omitempty"`
+	Players   []PlayerSummary       `json:"players"`
+	CreatedAt time.Time             `json:"created_at"`
+	Snapshot  *models.PartySnapshot `json:"-"`
+}
+
+// PlayerInfo describes a player, its profile and the party it is playing, if any
+type PlayerInfo struct {
+	Player  *models.Player        `json:"player"`
+	Profile *models.PlayerProfile `json:"profile,omitempty"`
+	PartyID string                `json:"party_id,omitempty"`
+}
+
+// Admin operates parties and players through the Redis databases and the RabbitMQ exchanges used
+// by the servers
+type Admin struct {
+	rabbitConnection *messaging.RabbitConnection
+	redisConnection  *database.RedisClient
+	sessions         *auth.SessionSigner
+	newLauncher      func(kind string) (container.Launcher, error)
+}
+
+// NewAdmin create an Admin and its connections with Redis and RabbitMQ. Force-ending a party also
+// needs SESSION_SECRET or SESSION_SECRET_FILE, the secret shared by the servers
+func NewAdmin(rabbitConfig messaging.RabbitConnectionConfiguration) (*Admin, error) {
+	admin := &Admin{
+		newLauncher: func(kind string) (container.Launcher, error) {
+			// the process launcher only kills a process of this host running the dynamic server binary
+			return container.NewLauncher(kind, container.DynamicServerPath(), nil)
+		},
+	}
+	var err error
+	if auth.HasSecretInEnv() {
+		admin.sessions, err = auth.NewSessionSignerFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+	admin.rabbitConnection, err = messaging.NewRabbitConnection(rabbitConfig)
+	if err != nil {
+		return nil, err
+	}
+	admin.rabbitConnection.SenderID = models.AdminID
+	admin.redisConnection = database.NewRedisClient()
+	return admin, nil
+}
+
+// Parties returns every party known by Redis, registered or running, sorted by creation date
+func (admin *Admin) Parties() ([]PartyInfo, error) {
+	registeredParties, err := admin.redisConnection.GetPartyList()
+	if err != nil {
+		return nil, err
+	}
+	runningParties, err := admin.redisConnection.GetRunningPartyList()
+	if err != nil {
+		return nil, err
+	}
+	var parties []PartyInfo
+	listed := make(map[string]bool)
+	for _, partyID := range append(registeredParties, runningParties...) {
+		if listed[partyID] {
+			continue
+		}
+		listed[partyID] = true
+		party, err := admin.Party(partyID)
+		if errors.Is(err, ErrorPartyNotFound) {
+			// the party ended meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		parties = append(parties, party)
+	}
+	sort.Slice(parties, func(i, j int) bool {
+		return parties[i].CreatedAt.Before(parties[j].CreatedAt)
+	})
+	return parties, nil
+}
+
+// Party describes a party from every key it has in Redis
+func (admin *Admin) Party(partyID string) (PartyInfo, error) {
+	party := PartyInfo{PartyID: partyID}
+	found := false
+	configuration, err := admin.redisConnection.GetPartyCreationToken(partyID)
+	switch {
+	case err == nil:
+		found = true
+		party.PartyName = configuration.PartyName
+		party.CreatedAt = configuration.CreatedAt
+	case !database.IsNotFound(err):
+		return party, err
+	}
+	party.State, err = admin.redisConnection.GetPartyState(partyID)
+	if err != nil {
+		return party, err
+	}
+	serverRecord, err := admin.redisConnection.GetServerRecord(partyID)
+	switch {
+	case err == nil:
+		found = true
+		party.Alive = true
+		party.Server = &serverRecord
+	case !database.IsNotFound(err):
+		return party, err
+	}
+	launchRecord, err := admin.redisConnection.GetLaunchRecord(partyID)
+	switch {
+	case err == nil:
+		found = true
+		party.Launch = &launchRecord
+	case !database.IsNotFound(err):
+		return party, err
+	}
+	snapshot, err := admin.redisConnection.GetPartySnapshot(partyID)
+	switch {
+	case err == nil:
+		found = true
+		party.Snapshot = &snapshot
+		party.Resumable = !party.Alive && snapshot.State != models.END
+		if party.PartyName == "" {
+			party.PartyName = snapshot.Configuration.PartyName
+			party.CreatedAt = snapshot.Configuration.CreatedAt
+		}
+	case !database.IsNotFound(err):
+		return party, err
+	}
+	playerIDs, err := admin.redisConnection.GetPlayersOnParty(partyID)
+	switch {
+	case err == nil:
+		found = true
+	case !database.IsNotFound(err):
+		return party, err
+	}
+	if !found {
+		return party, fmt.Errorf("%w : %s", ErrorPartyNotFound, partyID)
+	}
+	for _, playerID := range playerIDs {
+		playerSummary := PlayerSummary{PlayerID: playerID}
+		player, err := admin.redisConnection.GetPlayer(playerID)
+		if err == nil {
+			playerSummary.PlayerName = player.PlayerName
+		}
+		party.Players = append(party.Players, playerSummary)
+	}
+	return party, nil
+}
+
+// Player describes a player, its profile and the party it is playing
+func (admin *Admin) Player(playerID string) (PlayerInfo, error) {
+	var playerInfo PlayerInfo
+	var err error
+	playerInfo.Player, err = admin.redisConnection.GetPlayer(playerID)
+	if database.IsNotFound(err) {
+		return playerInfo, fmt.Errorf("%w : %s", models.ErrorUnknownPlayer, playerID)
+	}
+	if err != nil {
+		return playerInfo, err
+	}
+	playerInfo.Profile, err = admin.redisConnection.GetPlayerProfile(playerID)
+	if err != nil && !database.IsNotFound(err) {
+		return playerInfo, err
+	}
+	runningParties, err := admin.redisConnection.GetRunningPartyList()
+	if err != nil {
+		return playerInfo, err
+	}
+	for _, partyID := range runningParties {
+		playerIDs, err := admin.redisConnection.GetPlayersOnParty(partyID)
+		if err != nil {
+			continue
+		}
+		for _, participantID := range playerIDs {
+			if participantID == playerID {
+				playerInfo.PartyID = partyID
+				return playerInfo, nil
+			}
+		}
+	}
+	return playerInfo, nil
+}
+
+// EndParty asks the dynamic server of a party to end it right away. Players get their results and
+// the party is deregistered once its grace window is over, like any ended party
+func (admin *Admin) EndParty(partyID, reason string) error {
+	if admin.sessions == nil {
+		return ErrorSessionSecret
+	}
+	alive, err := admin.redisConnection.IsPartyAlive(partyID)
+	if err != nil {
+		return err
+	}
+	if !alive {
+		return fmt.Errorf("%w : %s", ErrorPartyNotAlive, partyID)
+	}
+	admin.rabbitConnection.SessionToken, _ = admin.sessions.Issue(models.AdminID, time.Now())
+	return admin.rabbitConnection.SendMessageOnTopicWithConfirm(
+		models.ForceEnd{PartyID: partyID, Reason: reason},
+		models.ForceEndTopic(partyID),
+		messaging.DefaultConfirmTimeout,
+	)
+}
+
+// KillParty kills the dynamic server of a party from its launch record, then removes every key of
+// the party so it is neither resumed nor listed anymore. It returns the launch record of the killed
+// server, nil if no server was killed
+func (admin *Admin) KillParty(partyID string) (*models.LaunchRecord, error) {
+	var killed *models.LaunchRecord
+	launchRecord, err := admin.redisConnection.GetLaunchRecord(partyID)
+	switch {
+	case database.IsNotFound(err):
+		logger.Warning("party", partyID, "has no launch record, only its keys are removed")
+	case err != nil:
+		return nil, err
+	case launchRecord.InstanceID != "":
+		killer, err := admin.killer(launchRecord.Launcher)
+		if err != nil {
+			return nil, err
+		}
+		// a process ID only makes sense on the host which launched it, and may have been reused since
+		if processLauncher, ok := killer.(*container.ProcessLauncher); ok {
+			err = processLauncher.CheckProcess(launchRecord.Host, launchRecord.InstanceID, partyID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = killer.Kill(launchRecord.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		killed = &launchRecord
+	}
+	err = admin.redisConnection.RemoveParty(partyID)
+	if err != nil {
+		return nil, err
+	}
+	lobbyEvent := models.LobbyEvent{
+		Kind:    models.PartyEnded,
+		PartyID: partyID,
+		State:   models.END,
+	}
+	admin.rabbitConnection.SendMessageOnLobbies(lobbyEvent, lobbyEvent.Topic())
+	return killed, nil
+}
+
+// killer returns a launcher of a given kind able to kill a dynamic server. Goroutine launchers can
+// only be killed with their static server, pools with every party they host
+func (admin *Admin) killer(kind string) (container.Killer, error) {
+	if kind == server.PoolLauncherKind {
+		return nil, fmt.Errorf("%w : %s", ErrorNotKillable, kind)
+	}
+	launcher, err := admin.newLauncher(kind)
+	if err != nil {
+		return nil, err
+	}
+	killer, ok := launcher.(container.Killer)
+	if !ok {
+		return nil, fmt.Errorf("%w : %s", ErrorNotKillable, kind)
+	}
+	return killer, nil
+}
+
+// StaleKeys returns the keys left behind by parties and pools which are gone
+func (admin *Admin) StaleKeys() ([]database.StaleKey, error) {
+	return admin.redisConnection.FindStaleKeys()
+}
+
+// RemoveStaleKeys removes the keys left behind by parties and pools which are gone, and returns them
+func (admin *Admin) RemoveStaleKeys() ([]database.StaleKey, error) {
+	staleKeys, err := admin.redisConnection.FindStaleKeys()
+	if err != nil {
+		return nil, err
+	}
+	return staleKeys, admin.redisConnection.RemoveStaleKeys(staleKeys)
+}
+
+// TailLobbyEvents calls handler with every lobby event published from now on, those of a single party
+// if partyID is set. It returns when the process is interrupted or the connection is closed
+func (admin *Admin) TailLobbyEvents(partyID string, handler func(models.LobbyEvent)) error {
+	topic := models.LobbyEventsTopic
+	if partyID != "" {
+		topic = "lobby." + partyID + ".*"
+	}
+	readyToReceive := make(chan bool, 1)
+	return admin.rabbitConnection.ReceiveMessageOnLobbiesWithHandler(
+		topic,
+		func(msg amqp.Delivery) {
+			var lobbyEvent models.LobbyEvent
+			err := messaging.UnmarshalPayload(msg.Body, models.LobbyEventMessageType, &lobbyEvent)
+			if err != nil {
+				logger.Error("error while decoding lobby event :", err)
+				return
+			}
+			handler(lobbyEvent)
+		},
+		readyToReceive,
+	)
+}
+
+// Close terminate connection with Redis and RabbitMQ
+func (admin *Admin) Close() error {
+	err := admin.redisConnection.Close()
+	if err != nil {
+		logger.Error("while closing Redis connection :", err)
+	}
+	return admin.rabbitConnection.Close()
+}
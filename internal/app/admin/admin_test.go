@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/clnbs/autorace/internal/app/server"
+	"github.com/clnbs/autorace/internal/pkg/container"
+)
+
+func TestAdmin_Killer(t *testing.T) {
+	admin := &Admin{
+		newLauncher: func(kind string) (container.Launcher, error) {
+			return container.NewLauncher(kind, "", func(string) error { return nil })
+		},
+	}
+	tests := []struct {
+		kind     string
+		expected error
+	}{
+		{container.ProcessLauncherKind, nil},
+		{container.GoroutineLauncherKind, ErrorNotKillable},
+		{server.PoolLauncherKind, ErrorNotKillable},
+		{"unknown", container.ErrorUnknownLauncher},
+	}
+	for _, test := range tests {
+		killer, err := admin.killer(test.kind)
+		if !errors.Is(err, test.expected) {
+			t.Error("expected", test.expected, "for", test.kind, "launcher, got", err)
+		}
+		if err == nil && killer == nil {
+			t.Error("expected a killer for", test.kind, "launcher")
+		}
+	}
+}
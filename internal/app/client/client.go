@@ -1,9 +1,9 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"reflect"
+	"time"
 
 	"github.com/clnbs/autorace/internal/app/models"
 	"github.com/clnbs/autorace/internal/app/server"
@@ -14,9 +14,19 @@ import (
 	"github.com/streadway/amqp"
 )
 
-//AutoraceClient handle client connection to a RabbitMQ server
+// ErrorJoinTimeout is returned when a dynamic server instance does not answer a join request in time
+var ErrorJoinTimeout = errors.New("timeout while joining party")
+
+// inputQueueSize is the number of inputs a client can queue while the broker is slow
+const inputQueueSize = 256
+
+//AutoraceClient handle client connection to a RabbitMQ server. PlayerID is set before the player
+//creation to reclaim a player created in a previous session, and so its profile, along with a
+//SessionToken issued for it. SessionToken is then replaced by the token of the new session
 type AutoraceClient struct {
 	SessionID        uuid.UUID
+	PlayerID         string
+	SessionToken     string
 	playerName       string
 	playerUUID       uuid.UUID
 	partyUUID        uuid.UUID
@@ -30,21 +40,28 @@ func NewAutoraceClient(name string, rabbitConfig messaging.RabbitConnectionConfi
 	arClient.SessionID = uuid.New()
 	arClient.playerName = name
 	arClient.rabbitConnection, err = messaging.NewRabbitConnection(rabbitConfig)
-	return arClient, err
+	if err != nil {
+		return nil, err
+	}
+	arClient.rabbitConnection.SenderID = arClient.SessionID.String()
+	// inputs are sent every frame, old ones are useless when the broker is slow
+	arClient.rabbitConnection.EnableOutboundQueue(inputQueueSize, messaging.DropOldest)
+	return arClient, nil
 }
 
 //RequestPlayerCreation handle player creation with server via a RabbitMQ connection
-func (arClient *AutoraceClient) RequestPlayerCreation(readyToReceive chan bool) (*models.Player, error) {
+func (arClient *AutoraceClient) RequestPlayerCreation(ctx context.Context, readyToReceive chan bool) (*models.PlayerSession, error) {
 	//object received by the handler are pass through this "received" chan
 	received := make(chan interface{})
 	playerRequest := models.PlayerCreationToken{
 		SessionUUID: arClient.SessionID,
 		PlayerName:  arClient.playerName,
+		PlayerID:    arClient.PlayerID,
 	}
 	// start object handler from server
 	go func() {
-		err := arClient.rabbitConnection.ReceiveMessageOnTopic("autocar.player.creation."+arClient.SessionID.String(), arClient.computePlayerCreationResponse, received, readyToReceive)
-		if err != nil {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.player.creation."+arClient.SessionID.String(), arClient.computePlayerCreationResponse, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
 			logger.Error("error while receiving Player creation from server :", err)
 			return
 		}
@@ -53,43 +70,65 @@ func (arClient *AutoraceClient) RequestPlayerCreation(readyToReceive chan bool)
 	if !<-readyToReceive {
 		return nil, errors.New("could not received message on topic")
 	}
+	// a session token issued for PlayerID proves the player is reclaimed by its owner
+	arClient.rabbitConnection.SessionToken = arClient.SessionToken
 	go func() {
-		arClient.rabbitConnection.SendMessageOnTopic(playerRequest, "autocar.player.creation")
+		arClient.rabbitConnection.SendMessageOnSharedTopic(playerRequest, "autocar.player.creation")
 	}()
-	response := <-received
+	response := arClient.waitResponse(ctx, received)
 	// check response type
-	if response == nil {
-		return nil, errors.New("unable to receive response to Player creation token")
-	}
-	if reflect.TypeOf(response) == reflect.TypeOf(messaging.ErrorResponse{}) {
-		return nil, errors.New(response.(messaging.ErrorResponse).ErrorMessage)
+	switch response.(type) {
+	case *models.PlayerSession:
+		// assign player UUID and session token to this object for further usage
+		playerSession := response.(*models.PlayerSession)
+		if playerSession.Player == nil {
+			return nil, errors.New("player creation response holds no player")
+		}
+		arClient.playerUUID = playerSession.Player.PlayerUUID
+		arClient.SessionToken = playerSession.SessionToken
+		arClient.rabbitConnection.SessionToken = playerSession.SessionToken
+		return playerSession, nil
+	case error:
+		return nil, response.(error)
 	}
-	// assign player UUID to this object for further usage
-	arClient.playerUUID = response.(*models.Player).PlayerUUID
-	return response.(*models.Player), nil
+	return nil, errors.New("unable to receive response to Player creation token")
 }
 
 func (arClient *AutoraceClient) computePlayerCreationResponse(msg []byte) interface{} {
-	playerInResponse := new(models.Player)
-	err := json.Unmarshal(msg, playerInResponse)
+	return decodeResponse(msg, models.PlayerSessionMessageType, new(models.PlayerSession))
+}
+
+// waitResponse waits for a response on a chan. It returns an error if the connection is closed or the
+// context is done meanwhile
+func (arClient *AutoraceClient) waitResponse(ctx context.Context, received chan interface{}) interface{} {
+	select {
+	case response := <-received:
+		return response
+	case <-arClient.rabbitConnection.Done():
+		return messaging.ErrorConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// decodeResponse decodes a message of a given type in v and returns v. If the message carries an error
+// or is not of the expected type, decodeResponse returns an error instead
+func decodeResponse(msg []byte, messageType string, v interface{}) interface{} {
+	err := messaging.UnmarshalPayload(msg, messageType, v)
 	if err != nil {
-		serverError := new(messaging.ErrorResponse)
-		err = json.Unmarshal(msg, serverError)
-		if err != nil {
-			return messaging.ErrorResponse{ErrorMessage: "unable to read response from server :" + err.Error()}
-		}
-		return serverError
+		logger.Error("error while decoding server response :", err)
+		return err
 	}
-	return playerInResponse
+	return v
 }
 
 //RequestPartyCreation handle party creation with a static server instance via a RabbitMQ connection
-func (arClient *AutoraceClient) RequestPartyCreation(partyConfig models.PartyCreationToken, readyToReceive chan bool) (*models.Party, error) {
+func (arClient *AutoraceClient) RequestPartyCreation(ctx context.Context, partyConfig models.PartyCreationToken, readyToReceive chan bool) (*models.Party, error) {
 	received := make(chan interface{})
 	// start the handling function before sending a request
 	go func() {
-		err := arClient.rabbitConnection.ReceiveMessageOnTopic("autocar.party.creation."+arClient.playerUUID.String(), arClient.computePartyCreationResponse, received, readyToReceive)
-		if err != nil {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.party.creation."+arClient.playerUUID.String(), arClient.computePartyCreationResponse, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
 			logger.Error("error while receiving Party creation from server :", err)
 			return
 		}
@@ -98,34 +137,24 @@ func (arClient *AutoraceClient) RequestPartyCreation(partyConfig models.PartyCre
 	<-readyToReceive
 	// send the actual request
 	go func() {
-		arClient.rabbitConnection.SendMessageOnTopic(partyConfig, "autocar.party.creation")
+		arClient.rabbitConnection.SendMessageOnSharedTopic(partyConfig, "autocar.party.creation")
 	}()
 	// waiting response
-	response := <-received
+	response := arClient.waitResponse(ctx, received)
 	// handle response
-	if response == nil {
-		return nil, errors.New("unable to receive response to Party creation token")
-	}
-	if reflect.TypeOf(response) == reflect.TypeOf(messaging.ErrorResponse{}) {
-		return nil, errors.New(response.(messaging.ErrorResponse).ErrorMessage)
+	switch response.(type) {
+	case *models.Party:
+		// store party UUID for further usage
+		arClient.partyUUID = response.(*models.Party).PartyUUID
+		return response.(*models.Party), nil
+	case error:
+		return nil, response.(error)
 	}
-	// store party UUID for further usage
-	arClient.partyUUID = response.(*models.Party).PartyUUID
-	return response.(*models.Party), nil
+	return nil, errors.New("unable to receive response to Party creation token")
 }
 
 func (arClient *AutoraceClient) computePartyCreationResponse(msg []byte) interface{} {
-	newParty := new(models.Party)
-	err := json.Unmarshal(msg, newParty)
-	if err != nil {
-		newServerError := new(messaging.ErrorResponse)
-		err = json.Unmarshal(msg, newServerError)
-		if err != nil {
-			return messaging.ErrorResponse{ErrorMessage: err.Error()}
-		}
-		return newServerError
-	}
-	return newParty
+	return decodeResponse(msg, models.PartyMessageType, new(models.Party))
 }
 
 // ReceiveParty handle party sent by a dynamic server instance
@@ -144,7 +173,7 @@ func (arClient *AutoraceClient) ReceiveParty(partyID string, readyToReceive chan
 		}
 	}()
 	<-readyToReceive
-	response := <-received
+	response := arClient.waitResponse(context.Background(), received)
 	switch response.(type) {
 	case error:
 		return nil, response.(error)
@@ -157,45 +186,249 @@ func (arClient *AutoraceClient) ReceiveParty(partyID string, readyToReceive chan
 }
 
 func (arClient *AutoraceClient) mapHandler(msg amqp.Delivery) interface{} {
-	updatedParty := new(models.Party)
-	err := json.Unmarshal(msg.Body, updatedParty)
+	return decodeResponse(msg.Body, models.PartyMessageType, new(models.Party))
+}
+
+// JoinParty subscribes to the party sent by a dynamic server instance, then asks to join it. It returns
+// the party once the server accepted this client as a game participant. The password is only
+// checked by password protected parties
+func (arClient *AutoraceClient) JoinParty(partyID, password string, timeout time.Duration) (*models.Party, error) {
+	received := make(chan interface{})
+	readyToReceive := make(chan bool)
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithHeader(
+			"autocar.party."+partyID+".map."+arClient.playerUUID.String(),
+			arClient.mapHandler,
+			received,
+			readyToReceive,
+		)
+		if err != nil {
+			logger.Error("while receiving a party :", err)
+		}
+	}()
+	if !<-readyToReceive {
+		return nil, errors.New("could not receive message on party map")
+	}
+	err := arClient.AddPlayerRequest(partyID, password)
 	if err != nil {
-		logger.Error("error while trying to unmarshal sync from server :", err)
-		return err
+		return nil, err
+	}
+	var response interface{}
+	select {
+	case response = <-received:
+	case <-arClient.rabbitConnection.Done():
+		return nil, messaging.ErrorConnectionClosed
+	case <-time.After(timeout):
+		return nil, ErrorJoinTimeout
 	}
-	return updatedParty
+	switch response.(type) {
+	case error:
+		return nil, response.(error)
+	case *models.Party:
+		arClient.partyUUID = response.(*models.Party).PartyUUID
+		return response.(*models.Party), nil
+	}
+	return nil, errors.New("unable to receive response to join request")
 }
 
 // SendPlayerInput is use to send input to a dynamic server instance.
 func (arClient *AutoraceClient) SendPlayerInput(pInput *models.PlayerInput) {
-	arClient.rabbitConnection.SendMessageOnTopic(pInput, "autocar.party."+arClient.partyUUID.String()+".input")
+	arClient.rabbitConnection.SendMessageOnTopicAsync(pInput, "autocar.party."+arClient.partyUUID.String()+".input")
 }
 
 // AddPlayerRequest handle adding player. It send a request to a dynamic server instance.
 // Dynamic server instance respond by sending to the client the already-registered-client list
 // as competitor and the party's content. On the server side, the dynamic instance store this client
-// as a game participant
-func (arClient *AutoraceClient) AddPlayerRequest(partyID string) error {
+// as a game participant, or respond an error if the party is full, started or if the password is wrong
+func (arClient *AutoraceClient) AddPlayerRequest(partyID, password string) error {
 	addPlayerToken := models.PlayerToken{
 		ClientID: arClient.playerUUID.String(),
 		PartyID:  partyID,
+		Password: password,
 	}
 	arClient.rabbitConnection.SendMessageOnTopic(addPlayerToken, "autocar.party."+partyID+".addPlayer")
 	return nil
 }
 
+// ResolveInviteCode asks a static server instance which private party an invite code leads to
+func (arClient *AutoraceClient) ResolveInviteCode(ctx context.Context, inviteCode string, readyToReceive chan bool) (*models.PartyInvite, error) {
+	received := make(chan interface{})
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.party.invite."+arClient.playerUUID.String(), arClient.computePartyInvite, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("error while receiving invite from server :", err)
+			return
+		}
+	}()
+	<-readyToReceive
+	inviteRequest := models.InviteRequest{
+		ClientID:   arClient.playerUUID.String(),
+		InviteCode: inviteCode,
+	}
+	arClient.rabbitConnection.SendMessageOnSharedTopic(inviteRequest, "autocar.party.invite")
+	response := arClient.waitResponse(ctx, received)
+	switch response.(type) {
+	case *models.PartyInvite:
+		return response.(*models.PartyInvite), nil
+	case error:
+		return nil, response.(error)
+	}
+	return nil, errors.New("unable to receive response to invite request")
+}
+
+func (arClient *AutoraceClient) computePartyInvite(msg []byte) interface{} {
+	return decodeResponse(msg, models.PartyInviteMessageType, new(models.PartyInvite))
+}
+
+// RequestProfile asks a static server instance for the profile of a player. The profile of this
+// client's player is sent if playerID is empty
+func (arClient *AutoraceClient) RequestProfile(ctx context.Context, playerID string, readyToReceive chan bool) (*models.PlayerProfile, error) {
+	received := make(chan interface{})
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.player.profile."+arClient.playerUUID.String(), arClient.computePlayerProfile, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("error while receiving profile from server :", err)
+			return
+		}
+	}()
+	<-readyToReceive
+	profileRequest := models.ProfileRequest{
+		ClientID: arClient.playerUUID.String(),
+		PlayerID: playerID,
+	}
+	arClient.rabbitConnection.SendMessageOnSharedTopic(profileRequest, "autocar.player.profile")
+	response := arClient.waitResponse(ctx, received)
+	switch response.(type) {
+	case *models.PlayerProfile:
+		return response.(*models.PlayerProfile), nil
+	case error:
+		return nil, response.(error)
+	}
+	return nil, errors.New("unable to receive response to profile request")
+}
+
+func (arClient *AutoraceClient) computePlayerProfile(msg []byte) interface{} {
+	return decodeResponse(msg, models.PlayerProfileMessageType, new(models.PlayerProfile))
+}
+
+// RequestRename asks a static server instance to rename this client's player. Running parties of
+// the player are told about its new name
+func (arClient *AutoraceClient) RequestRename(ctx context.Context, playerName string, readyToReceive chan bool) (*models.Player, error) {
+	received := make(chan interface{})
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.player.rename."+arClient.playerUUID.String(), arClient.computePlayerRename, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("error while receiving renamed player from server :", err)
+			return
+		}
+	}()
+	<-readyToReceive
+	renameToken := models.RenameToken{
+		PlayerToken: models.PlayerToken{
+			ClientID: arClient.playerUUID.String(),
+			PartyID:  arClient.partyUUID.String(),
+		},
+		PlayerName: playerName,
+	}
+	arClient.rabbitConnection.SendMessageOnSharedTopic(renameToken, "autocar.player.rename")
+	response := arClient.waitResponse(ctx, received)
+	switch response.(type) {
+	case *models.Player:
+		arClient.playerName = response.(*models.Player).PlayerName
+		return response.(*models.Player), nil
+	case error:
+		return nil, response.(error)
+	}
+	return nil, errors.New("unable to receive response to rename request")
+}
+
+func (arClient *AutoraceClient) computePlayerRename(msg []byte) interface{} {
+	return decodeResponse(msg, models.PlayerMessageType, new(models.Player))
+}
+
+// RequestMatch queues this client for a quick play party and waits until a static server instance
+// found one. The party still has to be joined
+func (arClient *AutoraceClient) RequestMatch(ctx context.Context, readyToReceive chan bool) (*models.MatchFound, error) {
+	received := make(chan interface{})
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.matchmaking."+arClient.playerUUID.String(), arClient.computeMatchFound, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("error while receiving match from server :", err)
+			return
+		}
+	}()
+	<-readyToReceive
+	matchRequest := models.MatchRequest{
+		ClientID: arClient.playerUUID.String(),
+	}
+	arClient.rabbitConnection.SendMessageOnSharedTopic(matchRequest, "autocar.matchmaking")
+	response := arClient.waitResponse(ctx, received)
+	switch response.(type) {
+	case *models.MatchFound:
+		return response.(*models.MatchFound), nil
+	case error:
+		return nil, response.(error)
+	}
+	return nil, errors.New("unable to receive response to match request")
+}
+
+func (arClient *AutoraceClient) computeMatchFound(msg []byte) interface{} {
+	return decodeResponse(msg, models.MatchFoundMessageType, new(models.MatchFound))
+}
+
+// CancelMatch removes this client from the matchmaking queue
+func (arClient *AutoraceClient) CancelMatch() {
+	matchRequest := models.MatchRequest{
+		ClientID: arClient.playerUUID.String(),
+		Cancel:   true,
+	}
+	arClient.rabbitConnection.SendMessageOnSharedTopic(matchRequest, "autocar.matchmaking")
+}
+
+// LeaveParty tells the dynamic server instance that this client left the joined party
+func (arClient *AutoraceClient) LeaveParty() {
+	playerToken := models.PlayerToken{
+		ClientID: arClient.playerUUID.String(),
+		PartyID:  arClient.partyUUID.String(),
+	}
+	arClient.rabbitConnection.SendMessageOnTopic(playerToken, "autocar.party."+arClient.partyUUID.String()+".leave")
+	arClient.partyUUID = uuid.Nil
+}
+
+// ReceiveLobbyEvents receive lobby events published by server instances and send them to a given
+// chan, until the connection is closed
+func (arClient *AutoraceClient) ReceiveLobbyEvents(readyToReceive chan bool, lobbyEvents chan *models.LobbyEvent) error {
+	return arClient.rabbitConnection.ReceiveMessageOnLobbiesWithHandler(
+		models.LobbyEventsTopic,
+		func(msg amqp.Delivery) {
+			lobbyEvent := new(models.LobbyEvent)
+			err := messaging.UnmarshalPayload(msg.Body, models.LobbyEventMessageType, lobbyEvent)
+			if err != nil {
+				logger.Error("error while decoding lobby event :", err)
+				return
+			}
+			select {
+			case lobbyEvents <- lobbyEvent:
+			case <-arClient.rabbitConnection.Done():
+			}
+		},
+		readyToReceive,
+	)
+}
+
 // ReceiveSync receive sync message from a dynamic server instance, filter error message and
-// send sync message to a given chan
-func (arClient *AutoraceClient) ReceiveSync(partyID string, readyToReceive chan bool, syncMessages chan *server.SyncMessageContent) error {
+// send sync message to a given chan, until the context is done
+func (arClient *AutoraceClient) ReceiveSync(ctx context.Context, partyID string, readyToReceive chan bool, syncMessages chan *server.SyncMessageContent) error {
 	received := make(chan interface{})
 	go func() {
-		err := arClient.rabbitConnection.ReceiveMessageOnTopic(
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(
+			ctx,
 			"autocar.party."+partyID+".sync."+arClient.playerUUID.String(),
 			arClient.syncHandler,
 			received,
 			readyToReceive,
 		)
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			logger.Error("while receiving a sync message :", err)
 			return
 		}
@@ -205,12 +438,25 @@ func (arClient *AutoraceClient) ReceiveSync(partyID string, readyToReceive chan
 		return errors.New("something went wrong while listening to sync message")
 	}
 	for {
-		response := <-received
+		var response interface{}
+		select {
+		case response = <-received:
+		case <-arClient.rabbitConnection.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
 		switch response.(type) {
 		case error:
 			logger.Error("error while decoding sync response :", response.(error))
 		case *server.SyncMessageContent:
-			syncMessages <- response.(*server.SyncMessageContent)
+			select {
+			case syncMessages <- response.(*server.SyncMessageContent):
+			case <-arClient.rabbitConnection.Done():
+				return nil
+			case <-ctx.Done():
+				return nil
+			}
 		default:
 			logger.Error("sync message received but something wrong happened")
 		}
@@ -218,13 +464,7 @@ func (arClient *AutoraceClient) ReceiveSync(partyID string, readyToReceive chan
 }
 
 func (arClient *AutoraceClient) syncHandler(msg []byte) interface{} {
-	syncResponse := new(server.SyncMessageContent)
-	err := json.Unmarshal(msg, syncResponse)
-	if err != nil {
-		logger.Error("error while trying to unmarshal sync from server :", err)
-		return err
-	}
-	return syncResponse
+	return decodeResponse(msg, server.SyncMessageContentMessageType, new(server.SyncMessageContent))
 }
 
 // SendSyncRequest send a sync request message to a dynamic server instance
@@ -236,13 +476,13 @@ func (arClient *AutoraceClient) SendSyncRequest() {
 	arClient.rabbitConnection.SendMessageOnTopic(playerToken, "autocar.party."+arClient.partyUUID.String()+".sync")
 }
 
-// RequestPartyList send a request to a static server instance and receive a joinable party list by
-// filtering received message and treat response as a slice of string
-func (arClient *AutoraceClient) RequestPartyList(readyToReceive chan bool) ([]string, error) {
+// RequestPartyList send a party list request to a static server instance and receive the requested
+// page of party summaries
+func (arClient *AutoraceClient) RequestPartyList(ctx context.Context, listRequest models.PartyListRequest, readyToReceive chan bool) (*models.PartyListPage, error) {
 	received := make(chan interface{})
 	go func() {
-		err := arClient.rabbitConnection.ReceiveMessageOnTopic("autocar.party.list."+arClient.playerUUID.String(), arClient.computePartyList, received, readyToReceive)
-		if err != nil {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, "autocar.party.list."+arClient.playerUUID.String(), arClient.computePartyList, received, readyToReceive)
+		if err != nil && ctx.Err() == nil {
 			logger.Error("error while trying to receive message on request party list :", err)
 			readyToReceive <- false
 			return
@@ -251,34 +491,22 @@ func (arClient *AutoraceClient) RequestPartyList(readyToReceive chan bool) ([]st
 	if !<-readyToReceive {
 		return nil, errors.New("could not receive message on request party list")
 	}
+	listRequest.ClientID = arClient.playerUUID.String()
 	go func() {
-		arClient.rabbitConnection.SendMessageOnTopic(arClient.playerUUID.String(), "autocar.party.list")
+		arClient.rabbitConnection.SendMessageOnSharedTopic(listRequest, "autocar.party.list")
 	}()
-	response := <-received
+	response := arClient.waitResponse(ctx, received)
 	switch response.(type) {
-	case []string:
-		return response.([]string), nil
-	case string:
-		return []string{response.(string)}, nil
+	case *models.PartyListPage:
+		return response.(*models.PartyListPage), nil
 	case error:
 		return nil, response.(error)
 	}
-	return nil, nil
+	return nil, errors.New("unable to receive response to party list request")
 }
 
 func (arClient *AutoraceClient) computePartyList(msg []byte) interface{} {
-	var partyList []string
-	var partyID string
-	err := json.Unmarshal(msg, &partyList)
-	if err != nil {
-		err = json.Unmarshal(msg, &partyID)
-		if err != nil {
-			logger.Error("error while decoding server response :", string(msg))
-			return err
-		}
-		return partyID
-	}
-	return partyList
+	return decodeResponse(msg, models.PartyListPageMessageType, new(models.PartyListPage))
 }
 
 // SendGameState is used to send a changing game's state request to a dynamic server instance
@@ -287,26 +515,23 @@ func (arClient *AutoraceClient) SendGameState(newState models.ChangeStateToken)
 }
 
 func (arClient *AutoraceClient) computeNewGameState(msg []byte) interface{} {
-	var newGameState models.ChangeStateAck
-	err := json.Unmarshal(msg, &newGameState)
-	if err != nil {
-		return err
-	}
-	return newGameState
+	return decodeResponse(msg, models.ChangeStateAckMessageType, new(models.ChangeStateAck))
 }
 
-// ReceiveGameState receive a message from a dynamic server instance after a changing game state request
-func (arClient *AutoraceClient) ReceiveGameState(partyID string, readyToReceive chan bool, gameState chan models.State) error {
+// ReceiveGameState receive a message from a dynamic server instance after a changing game state request,
+// until the context is done
+func (arClient *AutoraceClient) ReceiveGameState(ctx context.Context, partyID string, readyToReceive chan bool, gameState chan models.State) error {
 	ready := make(chan bool)
 	received := make(chan interface{})
 	go func() {
-		err := arClient.rabbitConnection.ReceiveMessageOnTopic(
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(
+			ctx,
 			"autocar.party."+partyID+".state."+arClient.playerUUID.String(),
 			arClient.computeNewGameState,
 			received,
 			ready,
 		)
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			logger.Error("error while trying to receive message on game state :", err)
 			return
 		}
@@ -317,16 +542,189 @@ func (arClient *AutoraceClient) ReceiveGameState(partyID string, readyToReceive
 	}
 	readyToReceive <- true
 	for {
-		response := <-received
+		var response interface{}
+		select {
+		case response = <-received:
+		case <-arClient.rabbitConnection.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
 		switch response.(type) {
-		case models.ChangeStateAck:
-			gameState <- response.(models.ChangeStateAck).NewState
+		case *models.ChangeStateAck:
+			select {
+			case gameState <- response.(*models.ChangeStateAck).NewState:
+			case <-arClient.rabbitConnection.Done():
+				return nil
+			case <-ctx.Done():
+				return nil
+			}
 		case error:
-			return response.(error)
+			// players who are not host are refused some state changes
+			logger.Warning("game state change refused :", response.(error))
 		}
 	}
 }
 
+// SendPartyChat posts a chat message to the players of the joined party
+func (arClient *AutoraceClient) SendPartyChat(text string) {
+	chatToken := models.ChatToken{
+		PlayerToken: models.PlayerToken{
+			ClientID: arClient.playerUUID.String(),
+			PartyID:  arClient.partyUUID.String(),
+		},
+		Text: text,
+	}
+	arClient.rabbitConnection.SendMessageOnTopic(chatToken, "autocar.party."+arClient.partyUUID.String()+".chat")
+}
+
+// SendLobbyChat posts a chat message to every connected player
+func (arClient *AutoraceClient) SendLobbyChat(text string) {
+	chatToken := models.ChatToken{
+		PlayerToken: models.PlayerToken{
+			ClientID: arClient.playerUUID.String(),
+		},
+		Text: text,
+	}
+	arClient.rabbitConnection.SendMessageOnSharedTopic(chatToken, "autocar.chat.lobby")
+}
+
+// ReceivePartyChat receive chat messages of a party and send them to a given chan, until the context
+// is done. Refused chat messages are sent as errors
+func (arClient *AutoraceClient) ReceivePartyChat(ctx context.Context, partyID string, readyToReceive chan bool, chatMessages chan interface{}) error {
+	return arClient.receiveChat(ctx, "autocar.party."+partyID+".chat."+arClient.playerUUID.String(), readyToReceive, chatMessages)
+}
+
+// ReceiveLobbyChat receive chat messages of the lobby-wide channel and send them to a given chan.
+// Refused chat messages are sent as errors
+func (arClient *AutoraceClient) ReceiveLobbyChat(readyToReceive chan bool, chatMessages chan interface{}) error {
+	ready := make(chan bool)
+	go func() {
+		err := arClient.receiveChat(context.Background(), "autocar.chat.lobby."+arClient.playerUUID.String(), ready, chatMessages)
+		if err != nil {
+			logger.Error("error while receiving refused lobby chat messages :", err)
+		}
+	}()
+	if !<-ready {
+		readyToReceive <- false
+		return errors.New("could not receive refused lobby chat messages")
+	}
+	return arClient.rabbitConnection.ReceiveMessageOnLobbiesWithHandler(
+		models.LobbyChatTopic,
+		func(msg amqp.Delivery) {
+			arClient.forwardChat(context.Background(), arClient.computeChatMessage(msg.Body), chatMessages)
+		},
+		readyToReceive,
+	)
+}
+
+func (arClient *AutoraceClient) receiveChat(ctx context.Context, topic string, readyToReceive chan bool, chatMessages chan interface{}) error {
+	ready := make(chan bool)
+	received := make(chan interface{})
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(ctx, topic, arClient.computeChatMessage, received, ready)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("error while trying to receive chat messages :", err)
+			return
+		}
+	}()
+	if !<-ready {
+		readyToReceive <- false
+		return errors.New("could not receive chat messages")
+	}
+	readyToReceive <- true
+	for {
+		select {
+		case response := <-received:
+			arClient.forwardChat(ctx, response, chatMessages)
+		case <-arClient.rabbitConnection.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (arClient *AutoraceClient) forwardChat(ctx context.Context, response interface{}, chatMessages chan interface{}) {
+	select {
+	case chatMessages <- response:
+	case <-arClient.rabbitConnection.Done():
+	case <-ctx.Done():
+	}
+}
+
+func (arClient *AutoraceClient) computeChatMessage(msg []byte) interface{} {
+	return decodeResponse(msg, models.ChatMessageMessageType, new(models.ChatMessage))
+}
+
+// SetReady tells the dynamic server instance whether this client is ready to race
+func (arClient *AutoraceClient) SetReady(ready bool) {
+	readyToken := models.ReadyToken{
+		PlayerToken: models.PlayerToken{
+			ClientID: arClient.playerUUID.String(),
+			PartyID:  arClient.partyUUID.String(),
+		},
+		Ready: ready,
+	}
+	arClient.rabbitConnection.SendMessageOnTopic(readyToken, "autocar.party."+arClient.partyUUID.String()+".ready")
+}
+
+// Moderate asks the dynamic server instance to kick, ban or give the host role to another player.
+// Only the party host is allowed to do so
+func (arClient *AutoraceClient) Moderate(action models.ModerationAction, targetID string) {
+	moderationToken := models.ModerationToken{
+		PlayerToken: models.PlayerToken{
+			ClientID: arClient.playerUUID.String(),
+			PartyID:  arClient.partyUUID.String(),
+		},
+		Action:   action,
+		TargetID: targetID,
+	}
+	arClient.rabbitConnection.SendMessageOnTopic(moderationToken, "autocar.party."+arClient.partyUUID.String()+".moderation")
+}
+
+// ReceiveModerationEvents receive moderation events of a party and send them to a given chan, until the
+// context is done. Refused moderation requests are sent as errors
+func (arClient *AutoraceClient) ReceiveModerationEvents(ctx context.Context, partyID string, readyToReceive chan bool, moderationEvents chan interface{}) error {
+	received := make(chan interface{})
+	go func() {
+		err := arClient.rabbitConnection.ReceiveMessageOnTopicWithContext(
+			ctx,
+			"autocar.party."+partyID+".moderation."+arClient.playerUUID.String(),
+			arClient.computeModerationEvent,
+			received,
+			readyToReceive,
+		)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("error while trying to receive moderation events :", err)
+			return
+		}
+	}()
+	if !<-readyToReceive {
+		return errors.New("could not receive moderation events")
+	}
+	for {
+		select {
+		case response := <-received:
+			select {
+			case moderationEvents <- response:
+			case <-arClient.rabbitConnection.Done():
+				return nil
+			case <-ctx.Done():
+				return nil
+			}
+		case <-arClient.rabbitConnection.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (arClient *AutoraceClient) computeModerationEvent(msg []byte) interface{} {
+	return decodeResponse(msg, models.ModerationEventMessageType, new(models.ModerationEvent))
+}
+
 // Close terminate ongoing connection
 func (arClient *AutoraceClient) Close() error {
 	return arClient.rabbitConnection.Close()
@@ -1,12 +1,19 @@
 package engine
 
 import (
+	"context"
 	"github.com/clnbs/autorace/internal/app/client"
 	"github.com/clnbs/autorace/internal/app/models"
 	"github.com/clnbs/autorace/internal/app/server"
 	"github.com/clnbs/autorace/internal/pkg/messaging"
 	"github.com/clnbs/autorace/pkg/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/faiface/pixel"
 )
@@ -21,18 +28,27 @@ type GameCommunication struct {
 	Competitors map[string]*models.CompetitorActor
 	CheckPoints []*models.Checkpoint // unused for now
 	events      chan models.Event
+	lobbyMutex  sync.Mutex
+	lobby       map[string]models.LobbyEvent
+	ready       bool
+	chatMutex   sync.Mutex
+	chatLog     []models.ChatMessage
 }
 
+// chatLogSize is the number of chat messages kept to be printed
+const chatLogSize = 8
+
 // NewGameCommunication create game communication handler by feeding some of the main
-// GameCommunication content.
+// GameCommunication content. RabbitMQ credentials are read from RABBITMQ_USER and RABBITMQ_PASS,
+// guest credentials are used if they are not set.
 func NewGameCommunication(name, rabbitAddr string, rabbitPort int, events chan models.Event) (*GameCommunication, error) {
 	newClient := new(GameCommunication)
 	var err error
 	rabbitMQConfig := messaging.RabbitConnectionConfiguration{
 		Host:     rabbitAddr,
 		Port:     strconv.FormatInt(int64(rabbitPort), 10),
-		User:     "guest",
-		Password: "guest",
+		User:     envOrDefault("RABBITMQ_USER", "guest"),
+		Password: envOrDefault("RABBITMQ_PASS", "guest"),
 	}
 	newClient.Client, err = client.NewAutoraceClient(name, rabbitMQConfig)
 	if err != nil {
@@ -44,17 +60,79 @@ func NewGameCommunication(name, rabbitAddr string, rabbitPort int, events chan m
 	newClient.ActorPlayer.Act = new(models.Actor)
 	newClient.Competitors = make(map[string]*models.CompetitorActor)
 	newClient.CheckPoints = make([]*models.Checkpoint, 0)
+	newClient.lobby = make(map[string]models.LobbyEvent)
 	return newClient, nil
 }
 
+// envOrDefault returns the value of an environment variable, or a default value if it is not set
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 // GetNewPlayer handle player registration to servers via a RabbitMQ connection.
 // Under the hood, a static server instance create an player object, register it in a
-// Redis database and send it back to client.
+// Redis database and send it back to client. The player ID and its session token are saved in the
+// user's configuration directory, so the player and its profile are reclaimed on the next launch.
 func (gameCommunication *GameCommunication) GetNewPlayer() error {
 	readyToReceive := make(chan bool)
-	var err error
-	gameCommunication.ActorPlayer.Player, err = gameCommunication.Client.RequestPlayerCreation(readyToReceive)
-	return err
+	gameCommunication.Client.PlayerID, gameCommunication.Client.SessionToken = loadPlayerSession()
+	playerSession, err := gameCommunication.Client.RequestPlayerCreation(context.Background(), readyToReceive)
+	if err != nil {
+		return err
+	}
+	gameCommunication.ActorPlayer.Player = playerSession.Player
+	err = savePlayerSession(playerSession.Player.PlayerUUID.String(), playerSession.SessionToken)
+	if err != nil {
+		logger.Warning("unable to save player session, a new player will be created on next launch :", err)
+	}
+	return nil
+}
+
+// GetProfile returns the profile of the player, with its career statistics
+func (gameCommunication *GameCommunication) GetProfile() (*models.PlayerProfile, error) {
+	readyToReceive := make(chan bool)
+	return gameCommunication.Client.RequestProfile(context.Background(), "", readyToReceive)
+}
+
+// playerSessionFile returns the path of the file holding the player ID and its session token
+func playerSessionFile() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "autorace", "session"), nil
+}
+
+// loadPlayerSession returns the saved player ID and session token, or empty strings if there are none
+func loadPlayerSession() (string, string) {
+	path, err := playerSessionFile()
+	if err != nil {
+		return "", ""
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(content)), "\n", 2)
+	if len(lines) != 2 {
+		return "", ""
+	}
+	return strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1])
+}
+
+func savePlayerSession(playerID, sessionToken string) error {
+	path, err := playerSessionFile()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(playerID+"\n"+sessionToken+"\n"), 0600)
 }
 
 // GetNewParty handle party creation by registering it and receiving it back.
@@ -65,7 +143,7 @@ func (gameCommunication *GameCommunication) GetNewPlayer() error {
 func (gameCommunication *GameCommunication) GetNewParty(partyConfiguration models.PartyCreationToken) error {
 	readyToReceive := make(chan bool)
 	var err error
-	gameCommunication.Party, err = gameCommunication.Client.RequestPartyCreation(partyConfiguration, readyToReceive)
+	gameCommunication.Party, err = gameCommunication.Client.RequestPartyCreation(context.Background(), partyConfiguration, readyToReceive)
 	return err
 }
 
@@ -87,7 +165,7 @@ func (gameCommunication *GameCommunication) HandleSync(partyID string, readyToRe
 	// start ReceiveSync from client and communicate sync message received to
 	// this interface via a chan
 	go func() {
-		err := gameCommunication.Client.ReceiveSync(partyID, readyToReceive, syncMessages)
+		err := gameCommunication.Client.ReceiveSync(context.Background(), partyID, readyToReceive, syncMessages)
 		if err != nil {
 			logger.Error("something went wrong while listening to sync message :", err)
 			return
@@ -142,16 +220,95 @@ func (gameCommunication *GameCommunication) SendPlayerInput() {
 	gameCommunication.Client.SendPlayerInput(gameCommunication.ActorPlayer.Player.Input)
 }
 
-// GetPartyList request joinable party list from static sever instance
-func (gameCommunication *GameCommunication) GetPartyList() ([]string, error) {
+// GetPartyList request joinable party list from static sever instance. The list is then kept up
+// to date by HandleLobbyEvents
+func (gameCommunication *GameCommunication) GetPartyList() ([]models.PartySummary, error) {
 	readyToReceive := make(chan bool)
-	return gameCommunication.Client.RequestPartyList(readyToReceive)
+	listRequest := models.PartyListRequest{
+		JoinableOnly: true,
+		PageSize:     models.MaxPartyListPageSize,
+	}
+	listPage, err := gameCommunication.Client.RequestPartyList(context.Background(), listRequest, readyToReceive)
+	if err != nil {
+		return nil, err
+	}
+	gameCommunication.lobbyMutex.Lock()
+	defer gameCommunication.lobbyMutex.Unlock()
+	for _, party := range listPage.Parties {
+		if _, ok := gameCommunication.lobby[party.PartyID]; !ok {
+			gameCommunication.lobby[party.PartyID] = models.LobbyEvent{
+				PartyID:     party.PartyID,
+				PartyName:   party.PartyName,
+				PlayerCount: party.PlayerCount,
+				State:       party.State,
+			}
+		}
+	}
+	return listPage.Parties, nil
+}
+
+// HandleLobbyEvents receive lobby events from server instances and keep the joinable party list
+// up to date without polling
+func (gameCommunication *GameCommunication) HandleLobbyEvents(readyToReceive chan bool) error {
+	lobbyEvents := make(chan *models.LobbyEvent)
+	go func() {
+		err := gameCommunication.Client.ReceiveLobbyEvents(readyToReceive, lobbyEvents)
+		if err != nil {
+			logger.Error("something went wrong while listening to lobby events :", err)
+			return
+		}
+	}()
+	for {
+		lobbyEvent := <-lobbyEvents
+		gameCommunication.lobbyMutex.Lock()
+		if lobbyEvent.Joinable() {
+			gameCommunication.lobby[lobbyEvent.PartyID] = *lobbyEvent
+		} else {
+			delete(gameCommunication.lobby, lobbyEvent.PartyID)
+		}
+		gameCommunication.lobbyMutex.Unlock()
+	}
+}
+
+// JoinableParties returns the last known joinable party list, sorted by party name
+func (gameCommunication *GameCommunication) JoinableParties() []models.LobbyEvent {
+	gameCommunication.lobbyMutex.Lock()
+	defer gameCommunication.lobbyMutex.Unlock()
+	parties := make([]models.LobbyEvent, 0, len(gameCommunication.lobby))
+	for _, party := range gameCommunication.lobby {
+		parties = append(parties, party)
+	}
+	sort.Slice(parties, func(i, j int) bool {
+		if parties[i].PartyName == parties[j].PartyName {
+			return parties[i].PartyID < parties[j].PartyID
+		}
+		return parties[i].PartyName < parties[j].PartyName
+	})
+	return parties
 }
 
 // AddPlayerToAParty send request to add the player to a party. The party can only be join
-// if the party is not started
-func (gameCommunication *GameCommunication) AddPlayerToAParty(partyID string) error {
-	return gameCommunication.Client.AddPlayerRequest(partyID)
+// if the party is not started and not full. The password is needed by password protected parties
+func (gameCommunication *GameCommunication) AddPlayerToAParty(partyID, password string) error {
+	return gameCommunication.Client.AddPlayerRequest(partyID, password)
+}
+
+// QuickPlay waits for the matchmaking to find a party and returns its ID
+func (gameCommunication *GameCommunication) QuickPlay() (string, error) {
+	matchFound, err := gameCommunication.Client.RequestMatch(context.Background(), make(chan bool, 1))
+	if err != nil {
+		return "", err
+	}
+	return matchFound.PartyID, nil
+}
+
+// ResolveInviteCode returns the ID of the private party an invite code leads to
+func (gameCommunication *GameCommunication) ResolveInviteCode(inviteCode string) (string, error) {
+	partyInvite, err := gameCommunication.Client.ResolveInviteCode(context.Background(), inviteCode, make(chan bool, 1))
+	if err != nil {
+		return "", err
+	}
+	return partyInvite.PartyID, nil
 }
 
 // Sync request a sync message from server. /!\ it can only be trigger if HandleSync
@@ -174,11 +331,17 @@ func (gameCommunication *GameCommunication) SendState(newState models.State) err
 	return nil
 }
 
+// ToggleReady toggles the ready flag of the player while the party is in its lobby
+func (gameCommunication *GameCommunication) ToggleReady() {
+	gameCommunication.ready = !gameCommunication.ready
+	gameCommunication.Client.SetReady(gameCommunication.ready)
+}
+
 // HandleGameState handle changing state message from server
 func (gameCommunication *GameCommunication) HandleGameState(partyID string, readyToReceive chan bool) error {
 	gameState := make(chan models.State)
 	go func() {
-		err := gameCommunication.Client.ReceiveGameState(partyID, readyToReceive, gameState)
+		err := gameCommunication.Client.ReceiveGameState(context.Background(), partyID, readyToReceive, gameState)
 		if err != nil {
 			logger.Error("while listening to game state :", err)
 			return
@@ -191,6 +354,50 @@ func (gameCommunication *GameCommunication) HandleGameState(partyID string, read
 	}
 }
 
+// HandleChat keeps the last chat messages of a party. Refused chat messages are kept as messages
+// from the server
+func (gameCommunication *GameCommunication) HandleChat(partyID string, readyToReceive chan bool) error {
+	chatMessages := make(chan interface{})
+	go func() {
+		err := gameCommunication.Client.ReceivePartyChat(context.Background(), partyID, readyToReceive, chatMessages)
+		if err != nil {
+			logger.Error("while listening to chat messages :", err)
+			return
+		}
+	}()
+	for {
+		var chatMessage models.ChatMessage
+		switch response := (<-chatMessages).(type) {
+		case *models.ChatMessage:
+			chatMessage = *response
+		case error:
+			chatMessage = models.ChatMessage{SenderName: "server", Text: response.Error()}
+		default:
+			continue
+		}
+		gameCommunication.chatMutex.Lock()
+		gameCommunication.chatLog = append(gameCommunication.chatLog, chatMessage)
+		if len(gameCommunication.chatLog) > chatLogSize {
+			gameCommunication.chatLog = gameCommunication.chatLog[len(gameCommunication.chatLog)-chatLogSize:]
+		}
+		gameCommunication.chatMutex.Unlock()
+	}
+}
+
+// SendChat posts a chat message to the players of the party
+func (gameCommunication *GameCommunication) SendChat(text string) {
+	gameCommunication.Client.SendPartyChat(text)
+}
+
+// ChatLog returns the last chat messages, from the oldest to the newest
+func (gameCommunication *GameCommunication) ChatLog() []models.ChatMessage {
+	gameCommunication.chatMutex.Lock()
+	defer gameCommunication.chatMutex.Unlock()
+	chatLog := make([]models.ChatMessage, len(gameCommunication.chatLog))
+	copy(chatLog, gameCommunication.chatLog)
+	return chatLog
+}
+
 //Close terminate ongoing connection
 func (gameCommunication *GameCommunication) Close() error {
 	return gameCommunication.Client.Close()
@@ -35,6 +35,7 @@ type MainGameWindow struct {
 	ImdDrawer           *imdraw.IMDraw
 	GameInfo            *GameCommunication
 	events              chan models.Event
+	chat                chatBox
 }
 
 // NewMainGameWindow create a MainGameWindow structure and feed some of the main components
@@ -108,6 +109,15 @@ func (mainGameWindow *MainGameWindow) StartCommunicationDaemon() error {
 	if !<-readyToReceive {
 		return errors.New("unable to start HandleGameState")
 	}
+	go func() {
+		err := mainGameWindow.GameInfo.HandleChat(mainGameWindow.GameInfo.Party.PartyUUID.String(), readyToReceive)
+		if err != nil {
+			logger.Error("while receiving chat messages :", err)
+		}
+	}()
+	if !<-readyToReceive {
+		return errors.New("unable to start HandleChat")
+	}
 	return nil
 }
 
@@ -148,6 +158,15 @@ func (mainGameWindow *MainGameWindow) StartCommunicationDaemonWithPartyID(partyI
 	if !<-ready {
 		return errors.New("unable to start HandleGameState")
 	}
+	go func() {
+		err := mainGameWindow.GameInfo.HandleChat(partyID, ready)
+		if err != nil {
+			logger.Error("error while receiving chat messages :", err)
+		}
+	}()
+	if !<-ready {
+		return errors.New("unable to start HandleChat")
+	}
 	go func() {
 		ready <- true
 		for {
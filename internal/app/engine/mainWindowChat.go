@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font/basicfont"
+)
+
+// chatBox holds the chat overlay state of MainGameWindow
+type chatBox struct {
+	atlas  *text.Atlas
+	typing bool
+	input  string
+}
+
+// typeChat extends MainGameWindow methods. Enter opens the chat input, then typed keys are written
+// in the chat box until Enter sends the message or Escape cancels it. It returns true while keys
+// are typed in the chat box
+func (mainGameWindow *MainGameWindow) typeChat() bool {
+	window := mainGameWindow.mainWindow
+	chat := &mainGameWindow.chat
+	if !chat.typing {
+		if window.JustPressed(pixelgl.KeyEnter) {
+			chat.typing = true
+			chat.input = ""
+			return true
+		}
+		return false
+	}
+	switch {
+	case window.JustPressed(pixelgl.KeyEscape):
+		chat.typing = false
+	case window.JustPressed(pixelgl.KeyEnter):
+		chat.typing = false
+		if chat.input != "" {
+			mainGameWindow.GameInfo.SendChat(chat.input)
+		}
+	case window.JustPressed(pixelgl.KeyBackspace) || window.Repeated(pixelgl.KeyBackspace):
+		if runes := []rune(chat.input); len(runes) > 0 {
+			chat.input = string(runes[:len(runes)-1])
+		}
+	default:
+		chat.input += window.Typed()
+	}
+	return true
+}
+
+// drawChat extends MainGameWindow methods. It prints the last chat messages and the chat input in
+// the bottom left corner of the window, whatever the camera position
+func (mainGameWindow *MainGameWindow) drawChat() {
+	chat := &mainGameWindow.chat
+	if chat.atlas == nil {
+		chat.atlas = text.NewAtlas(basicfont.Face7x13, text.ASCII)
+	}
+	chatText := text.New(pixel.V(10, 10), chat.atlas)
+	chatText.Color = colornames.White
+	chatLog := mainGameWindow.GameInfo.ChatLog()
+	lines := len(chatLog)
+	if chat.typing {
+		lines++
+	}
+	// lines are written from the top, the text is moved up to stay above the window's bottom
+	chatText.Orig = pixel.V(10, 10+float64(lines)*chatText.LineHeight)
+	chatText.Dot = chatText.Orig
+	for _, chatMessage := range chatLog {
+		_, _ = fmt.Fprintf(chatText, "%s : %s\n", chatMessage.SenderName, chatMessage.Text)
+	}
+	if chat.typing {
+		_, _ = fmt.Fprintf(chatText, "> %s_\n", chat.input)
+	}
+	mainGameWindow.mainWindow.SetMatrix(pixel.IM)
+	chatText.Draw(mainGameWindow.mainWindow, pixel.IM)
+	mainGameWindow.mainWindow.SetMatrix(mainGameWindow.CameraWindow.Camera)
+}
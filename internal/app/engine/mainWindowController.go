@@ -13,6 +13,11 @@ import (
 // Controller also send player's input to a dynamic instance.
 func (mainGameWindow *MainGameWindow) Controller(input *models.PlayerInput, lastTimePauseCalled time.Time) time.Time {
 	var err error
+	if mainGameWindow.typeChat() {
+		// keys are typed in the chat box, the car is not steered meanwhile
+		mainGameWindow.sendInput(input)
+		return lastTimePauseCalled
+	}
 	if mainGameWindow.mainWindow.Pressed(pixelgl.KeyLeft) {
 		input.Turning += 1.0
 	}
@@ -41,6 +46,12 @@ func (mainGameWindow *MainGameWindow) Controller(input *models.PlayerInput, last
 		}()
 	}
 
+	if mainGameWindow.mainWindow.Pressed(pixelgl.KeyR) && time.Since(lastTimePauseCalled) > waitDuration &&
+		mainGameWindow.GameInfo.Party.GetState() == models.LOBBY {
+		lastTimePauseCalled = time.Now()
+		mainGameWindow.GameInfo.ToggleReady()
+	}
+
 	if mainGameWindow.mainWindow.Pressed(pixelgl.KeyEnd) {
 		go func() {
 			err = mainGameWindow.GameInfo.SendState(models.END)
@@ -50,9 +61,14 @@ func (mainGameWindow *MainGameWindow) Controller(input *models.PlayerInput, last
 		}()
 	}
 
+	mainGameWindow.sendInput(input)
+	return lastTimePauseCalled
+}
+
+// sendInput sends player's input to a dynamic instance while the party is running
+func (mainGameWindow *MainGameWindow) sendInput(input *models.PlayerInput) {
 	if mainGameWindow.GameInfo.Party.GetState() == models.RUN {
 		mainGameWindow.GameInfo.ActorPlayer.Player.Input = input
 		mainGameWindow.GameInfo.SendPlayerInput()
 	}
-	return lastTimePauseCalled
 }
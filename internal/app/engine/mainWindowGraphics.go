@@ -34,6 +34,7 @@ func NewMainWindowCamera() *MainWindowCamera {
 // - main player's car
 // - competitors' car
 // - checkpoints
+// - chat overlay
 func (mainGameWindow *MainGameWindow) PrintGraphicComponents() {
 	for _, cp := range mainGameWindow.GameInfo.CheckPoints {
 		newMatrix := pixel.IM
@@ -55,6 +56,7 @@ func (mainGameWindow *MainGameWindow) PrintGraphicComponents() {
 		newMatrix = newMatrix.Moved(mainGameWindow.GameInfo.ActorPlayer.Act.Car.Position)
 		mainGameWindow.GameInfo.ActorPlayer.Act.Car.CarSprite.Draw(mainGameWindow.mainWindow, newMatrix)
 	}
+	mainGameWindow.drawChat()
 	mainGameWindow.mainWindow.Update()
 }
 
@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultRequestTimeout is the time the gateway waits for servers to answer a command
+const DefaultRequestTimeout = 30 * time.Second
+
+// DefaultMatchTimeout is the time the gateway waits for the matchmaking to find a party
+const DefaultMatchTimeout = 45 * time.Second
+
+// Gateway accepts WebSocket connections and maps a JSON command and event protocol onto the
+// autocar.* topics. Every WebSocket connection is a session owning its own RabbitMQ connection,
+// so WebSocket clients never need a direct access to the broker
+type Gateway struct {
+	RequestTimeout time.Duration
+	MatchTimeout   time.Duration
+	rabbitConfig   messaging.RabbitConnectionConfiguration
+	allowedOrigins []string
+	upgrader       websocket.Upgrader
+	sessionsMutex  sync.Mutex
+	sessions       map[*session]struct{}
+	running        sync.WaitGroup
+}
+
+// NewGateway create a Gateway connecting its sessions to RabbitMQ with a given configuration.
+// If allowedOrigins is empty, WebSocket connections are accepted from any origin
+func NewGateway(rabbitConfig messaging.RabbitConnectionConfiguration, allowedOrigins []string) *Gateway {
+	gw := &Gateway{
+		RequestTimeout: DefaultRequestTimeout,
+		MatchTimeout:   DefaultMatchTimeout,
+		rabbitConfig:   rabbitConfig,
+		allowedOrigins: allowedOrigins,
+		sessions:       make(map[*session]struct{}),
+	}
+	gw.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     gw.checkOrigin,
+	}
+	return gw
+}
+
+// ServeHTTP upgrades an HTTP request to a WebSocket connection and serves it until it is closed
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := gw.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("while upgrading connection from", r.RemoteAddr, ":", err)
+		return
+	}
+	newSession := gw.newSession(conn)
+	gw.sessionsMutex.Lock()
+	gw.sessions[newSession] = struct{}{}
+	gw.running.Add(1)
+	gw.sessionsMutex.Unlock()
+	defer func() {
+		gw.sessionsMutex.Lock()
+		delete(gw.sessions, newSession)
+		gw.sessionsMutex.Unlock()
+		gw.running.Done()
+	}()
+	logger.Trace("new WebSocket session from", r.RemoteAddr)
+	newSession.run()
+	logger.Trace("WebSocket session from", r.RemoteAddr, "ended")
+}
+
+// Close terminates every ongoing session and waits for their RabbitMQ connection to be closed
+func (gw *Gateway) Close() {
+	gw.sessionsMutex.Lock()
+	for ongoingSession := range gw.sessions {
+		err := ongoingSession.conn.Close()
+		if err != nil {
+			logger.Error("while closing WebSocket connection :", err)
+		}
+	}
+	gw.sessionsMutex.Unlock()
+	gw.running.Wait()
+}
+
+func (gw *Gateway) checkOrigin(r *http.Request) bool {
+	if len(gw.allowedOrigins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	// non-browser clients do not send any origin
+	if origin == "" {
+		return true
+	}
+	for _, allowedOrigin := range gw.allowedOrigins {
+		if origin == allowedOrigin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/app/server"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialTestGateway(t *testing.T, gw *Gateway) (*websocket.Conn, func()) {
+	testServer := httptest.NewServer(gw)
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		testServer.Close()
+		t.Fatal("unable to dial gateway :", err)
+	}
+	return conn, func() {
+		_ = conn.Close()
+		gw.Close()
+		testServer.Close()
+	}
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) Event {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	event := Event{}
+	err := conn.ReadJSON(&event)
+	if err != nil {
+		t.Fatal("unable to read event :", err)
+	}
+	return event
+}
+
+func TestGateway_CommandErrors(t *testing.T) {
+	gw := NewGateway(messaging.RabbitConnectionConfiguration{}, nil)
+	conn, closeAll := dialTestGateway(t, gw)
+	defer closeAll()
+
+	tests := []struct {
+		message  string
+		code     string
+		request  string
+		errorMsg string
+	}{
+		{`{"command":"fly","request_id":"1"}`, ErrorCodeUnknownCommand, "1", "unknown command"},
+		{`{"command":"list_parties","request_id":"2"}`, ErrorCodeInvalidCommand, "2", "player required"},
+		{`{"command":"input","request_id":"3","data":{"acceleration":1}}`, ErrorCodeInvalidCommand, "3", "party required"},
+		{`{"command":"quick_play","request_id":"4"}`, ErrorCodeInvalidCommand, "4", "player required for quick play"},
+		{`{"command":"kick","request_id":"5","data":{"player_id":"toto"}}`, ErrorCodeInvalidCommand, "5", "party required for kick"},
+		{`{"command":"chat","request_id":"6","data":{"text":"hello"}}`, ErrorCodeInvalidCommand, "6", "player required for chat"},
+		{`{"command":"profile","request_id":"7"}`, ErrorCodeInvalidCommand, "7", "player required for profile"},
+		{`{"command":"rename","request_id":"8","data":{"player_name":"toto"}}`, ErrorCodeInvalidCommand, "8", "player required for rename"},
+		{`{"command":`, messaging.ErrorCodeMalformedMessage, "", "malformed command"},
+	}
+	for _, test := range tests {
+		err := conn.WriteMessage(websocket.TextMessage, []byte(test.message))
+		if err != nil {
+			t.Fatal("unable to send command :", err)
+		}
+		event := readEvent(t, conn)
+		if event.Event != EventError || event.Error == nil {
+			t.Error(test.errorMsg, ": expected an error event, got", event.Event)
+			continue
+		}
+		if event.Error.Code != test.code {
+			t.Error(test.errorMsg, ": expected code", test.code, "got", event.Error.Code)
+		}
+		if event.RequestID != test.request {
+			t.Error(test.errorMsg, ": expected request ID", test.request, "got", event.RequestID)
+		}
+	}
+}
+
+func TestGateway_CheckOrigin(t *testing.T) {
+	gw := NewGateway(messaging.RabbitConnectionConfiguration{}, []string{"http://autorace.example"})
+	request := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	if !gw.checkOrigin(request) {
+		t.Error("request without origin should be accepted")
+	}
+	request.Header.Set("Origin", "http://autorace.example")
+	if !gw.checkOrigin(request) {
+		t.Error("allowed origin should be accepted")
+	}
+	request.Header.Set("Origin", "http://evil.example")
+	if gw.checkOrigin(request) {
+		t.Error("unknown origin should be refused")
+	}
+}
+
+func TestSession_WaitForCancelsRequest(t *testing.T) {
+	s := &session{}
+	stopped := make(chan struct{})
+	_, err := s.waitFor(10*time.Millisecond, func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(stopped)
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, ErrorRequestTimeout) {
+		t.Error("expected a timeout, got", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("request given up is still running")
+	}
+	value, err := s.waitFor(time.Second, func(ctx context.Context) (interface{}, error) {
+		return "answer", nil
+	})
+	if err != nil || value != "answer" {
+		t.Error("expected the answer of the request, got", value, err)
+	}
+}
+
+// countingReceiver is a partyReceiver counting the receivers still running, which return once their
+// context is done
+type countingReceiver struct {
+	running int32
+}
+
+func (receiver *countingReceiver) receive(ctx context.Context) error {
+	atomic.AddInt32(&receiver.running, 1)
+	defer atomic.AddInt32(&receiver.running, -1)
+	<-ctx.Done()
+	return nil
+}
+
+func (receiver *countingReceiver) ReceiveSync(ctx context.Context, _ string, _ chan bool, _ chan *server.SyncMessageContent) error {
+	return receiver.receive(ctx)
+}
+
+func (receiver *countingReceiver) ReceiveGameState(ctx context.Context, _ string, _ chan bool, _ chan models.State) error {
+	return receiver.receive(ctx)
+}
+
+func (receiver *countingReceiver) ReceiveModerationEvents(ctx context.Context, _ string, _ chan bool, _ chan interface{}) error {
+	return receiver.receive(ctx)
+}
+
+func (receiver *countingReceiver) ReceivePartyChat(ctx context.Context, _ string, _ chan bool, _ chan interface{}) error {
+	return receiver.receive(ctx)
+}
+
+// eventually fails the test if a condition is still false after a second
+func eventually(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSession_FollowRejoinedParty(t *testing.T) {
+	receiver := &countingReceiver{}
+	s := &session{partyEvents: receiver, done: make(chan struct{})}
+	goroutines := runtime.NumGoroutine()
+	for attempt := 0; attempt < 2; attempt++ {
+		s.follow("party_1")
+		eventually(t, func() bool {
+			return atomic.LoadInt32(&receiver.running) == 4
+		}, "expected the 4 receivers of the followed party only")
+		s.unfollow()
+		eventually(t, func() bool {
+			return atomic.LoadInt32(&receiver.running) == 0 && runtime.NumGoroutine() <= goroutines
+		}, "receivers of the left party are still running")
+	}
+}
@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+)
+
+// commands a WebSocket client can send to the gateway
+const (
+	// CommandCreatePlayer creates the player of this session. It has to be sent first
+	CommandCreatePlayer = "create_player"
+	// CommandProfile asks for the profile of a player, the session's player by default
+	CommandProfile = "profile"
+	// CommandRename changes the name of the session's player
+	CommandRename = "rename"
+	// CommandListParties asks for the joinable party list
+	CommandListParties = "list_parties"
+	// CommandCreateParty creates a party and join it
+	CommandCreateParty = "create_party"
+	// CommandJoinParty joins an existing party
+	CommandJoinParty = "join_party"
+	// CommandQuickPlay waits for the matchmaking to find a party and join it
+	CommandQuickPlay = "quick_play"
+	// CommandLeaveParty leaves the joined party
+	CommandLeaveParty = "leave_party"
+	// CommandInput sends a player input to the joined party
+	CommandInput = "input"
+	// CommandChangeState asks the joined party to change its state
+	CommandChangeState = "change_state"
+	// CommandReady sets the ready flag of the player in the joined party lobby
+	CommandReady = "ready"
+	// CommandKick kicks a player from the joined party, host only
+	CommandKick = "kick"
+	// CommandBan bans a player from the joined party, host only
+	CommandBan = "ban"
+	// CommandTransferHost gives the host role to another player, host only
+	CommandTransferHost = "transfer_host"
+	// CommandSync asks the joined party for a sync event
+	CommandSync = "sync"
+	// CommandChat posts a chat message to the joined party or to the lobby-wide channel
+	CommandChat = "chat"
+)
+
+// events the gateway sends to a WebSocket client
+const (
+	// EventPlayer is sent once the player is created, with its session token
+	EventPlayer = "player"
+	// EventProfile is sent in response to CommandProfile
+	EventProfile = "profile"
+	// EventRenamed is sent in response to CommandRename
+	EventRenamed = "renamed"
+	// EventPartyList is sent in response to CommandListParties
+	EventPartyList = "party_list"
+	// EventParty is sent once a party is created or joined
+	EventParty = "party"
+	// EventSync is sent on every sync message of the joined party
+	EventSync = "sync"
+	// EventState is sent when the joined party changes its state
+	EventState = "state"
+	// EventLobby is sent on every lobby event, once the player is created
+	EventLobby = "lobby"
+	// EventModeration is sent when a player of the joined party is kicked or banned, or when the host
+	// changes. A kicked or banned player is not in the party anymore and should leave it
+	EventModeration = "moderation"
+	// EventChat is sent on every chat message of the lobby-wide channel and of the joined party
+	EventChat = "chat"
+	// EventLeft is sent once the joined party is left
+	EventLeft = "left"
+	// EventError is sent when a command failed
+	EventError = "error"
+)
+
+// error codes sent by the gateway, in addition to the ones from the messaging package
+const (
+	// ErrorCodeUnknownCommand is sent when a command is not known by the gateway
+	ErrorCodeUnknownCommand = "unknown_command"
+	// ErrorCodeInvalidCommand is sent when a command is not allowed in the current session state
+	ErrorCodeInvalidCommand = "invalid_command"
+	// ErrorCodeTimeout is sent when servers did not answer a command in time
+	ErrorCodeTimeout = "timeout"
+)
+
+var (
+	// ErrorUnknownCommand is returned when a command is not known by the gateway
+	ErrorUnknownCommand = errors.New("unknown command")
+	// ErrorPlayerRequired is returned when a command is sent before the player is created
+	ErrorPlayerRequired = errors.New("player must be created first")
+	// ErrorPlayerAlreadyCreated is returned when the player of a session is created twice
+	ErrorPlayerAlreadyCreated = errors.New("player is already created")
+	// ErrorPartyRequired is returned when a command is sent before a party is created or joined
+	ErrorPartyRequired = errors.New("a party must be created or joined first")
+	// ErrorPartyAlreadyJoined is returned when a session tries to join a second party
+	ErrorPartyAlreadyJoined = errors.New("a party is already joined")
+	// ErrorRequestTimeout is returned when servers did not answer a command in time
+	ErrorRequestTimeout = errors.New("servers did not answer in time")
+)
+
+// Command is a message sent by a WebSocket client. The request ID is optional and copied in the
+// event answering the command
+type Command struct {
+	Command   string          `json:"command"`
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Event is a message sent to a WebSocket client
+type Event struct {
+	Event     string                   `json:"event"`
+	RequestID string                   `json:"request_id,omitempty"`
+	Data      interface{}              `json:"data,omitempty"`
+	Error     *messaging.ErrorResponse `json:"error,omitempty"`
+}
+
+// CreatePlayerData is the data of a CommandCreatePlayer. PlayerID is set to reclaim a player
+// created in a previous session, and so its profile, along with a session token issued for it
+type CreatePlayerData struct {
+	PlayerName   string `json:"player_name"`
+	PlayerID     string `json:"player_id"`
+	SessionToken string `json:"session_token"`
+}
+
+// ProfileData is the data of a CommandProfile, the session's player profile is sent if PlayerID is not set
+type ProfileData struct {
+	PlayerID string `json:"player_id"`
+}
+
+// RenameData is the data of a CommandRename
+type RenameData struct {
+	PlayerName string `json:"player_name"`
+}
+
+// ListPartiesData is the data of a CommandListParties, every field is optional
+type ListPartiesData struct {
+	JoinableOnly bool   `json:"joinable_only"`
+	NameFilter   string `json:"name_filter"`
+	Page         int    `json:"page"`
+	PageSize     int    `json:"page_size"`
+}
+
+// CreatePartyData is the data of a CommandCreateParty
+type CreatePartyData struct {
+	PartyName      string                  `json:"party_name"`
+	Seed           int                     `json:"seed"`
+	CircuitConfig  models.CircuitMapConfig `json:"circuit_config"`
+	MaxPlayers     int                     `json:"max_players"`
+	Private        bool                    `json:"private"`
+	Password       string                  `json:"password"`
+	MinPlayers     int                     `json:"min_players"`
+	AutoStartAfter int                     `json:"auto_start_after"`
+	Laps           int                     `json:"laps"`
+}
+
+// JoinPartyData is the data of a CommandJoinParty. A private party is joined with its invite code
+// instead of its ID
+type JoinPartyData struct {
+	PartyID    string `json:"party_id"`
+	InviteCode string `json:"invite_code"`
+	Password   string `json:"password"`
+}
+
+// InputData is the data of a CommandInput
+type InputData struct {
+	Acceleration  float64 `json:"acceleration"`
+	Turning       float64 `json:"turning"`
+	MessageNumber int     `json:"message_number,omitempty"`
+}
+
+// ReadyData is the data of a CommandReady
+type ReadyData struct {
+	Ready bool `json:"ready"`
+}
+
+// ModerateData is the data of CommandKick, CommandBan and CommandTransferHost
+type ModerateData struct {
+	PlayerID string `json:"player_id"`
+}
+
+// ChatData is the data of a CommandChat. The channel is either "party" or "lobby", it defaults to
+// the party channel once a party is joined and to the lobby channel otherwise
+type ChatData struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel"`
+}
+
+// ChangeStateData is the data of a CommandChangeState
+type ChangeStateData struct {
+	DesiredState models.State `json:"desired_state"`
+}
+
+// StateData is the data of an EventState
+type StateData struct {
+	NewState models.State `json:"new_state"`
+}
+
+// newErrorEvent create an error event answering a command
+func newErrorEvent(requestID string, err error) Event {
+	var errorResponse messaging.ErrorResponse
+	switch {
+	case errors.Is(err, ErrorUnknownCommand):
+		errorResponse = messaging.ErrorResponse{Code: ErrorCodeUnknownCommand, ErrorMessage: err.Error()}
+	case errors.Is(err, ErrorPlayerRequired), errors.Is(err, ErrorPlayerAlreadyCreated),
+		errors.Is(err, ErrorPartyRequired), errors.Is(err, ErrorPartyAlreadyJoined):
+		errorResponse = messaging.ErrorResponse{Code: ErrorCodeInvalidCommand, ErrorMessage: err.Error()}
+	case errors.Is(err, ErrorRequestTimeout):
+		errorResponse = messaging.ErrorResponse{Code: ErrorCodeTimeout, ErrorMessage: err.Error()}
+	default:
+		errorResponse = messaging.NewErrorResponse(err)
+	}
+	return Event{
+		Event:     EventError,
+		RequestID: requestID,
+		Error:     &errorResponse,
+	}
+}
+
+// decodeData decodes the data of a command in v
+func decodeData(command Command, v interface{}) error {
+	if len(command.Data) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(command.Data, v)
+	if err != nil {
+		return fmt.Errorf("%w : %v", messaging.ErrorMalformedMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,628 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/client"
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/app/server"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// time allowed to write an event to a WebSocket client
+	writeWait = 10 * time.Second
+	// time allowed to read the next pong or command from a WebSocket client
+	pongWait = 60 * time.Second
+	// pings are sent with this period, it must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// maximum size of a command sent by a WebSocket client
+	maxCommandSize = 4096
+)
+
+// partyReceiver receives the messages of a joined party until a context is done, it is implemented
+// by client.AutoraceClient
+type partyReceiver interface {
+	ReceiveSync(ctx context.Context, partyID string, readyToReceive chan bool, syncMessages chan *server.SyncMessageContent) error
+	ReceiveGameState(ctx context.Context, partyID string, readyToReceive chan bool, gameState chan models.State) error
+	ReceiveModerationEvents(ctx context.Context, partyID string, readyToReceive chan bool, moderationEvents chan interface{}) error
+	ReceivePartyChat(ctx context.Context, partyID string, readyToReceive chan bool, chatMessages chan interface{}) error
+}
+
+// session is a WebSocket connection served by the gateway. Commands are handled one at a time
+// in the reading loop, events from the joined party are forwarded by a dedicated goroutine
+type session struct {
+	gateway      *Gateway
+	conn         *websocket.Conn
+	writeMutex   sync.Mutex
+	client       *client.AutoraceClient
+	partyEvents  partyReceiver
+	player       *models.Player
+	party        *models.Party
+	cancelFollow context.CancelFunc
+	done         chan struct{}
+}
+
+func (gw *Gateway) newSession(conn *websocket.Conn) *session {
+	return &session{
+		gateway: gw,
+		conn:    conn,
+		done:    make(chan struct{}),
+	}
+}
+
+// run reads commands until the WebSocket connection is closed, then closes the session
+func (s *session) run() {
+	defer s.close()
+	s.conn.SetReadLimit(maxCommandSize)
+	_ = s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go s.ping()
+	for {
+		_, message, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logger.Warning("WebSocket connection closed unexpectedly :", err)
+			}
+			return
+		}
+		var command Command
+		err = json.Unmarshal(message, &command)
+		if err != nil {
+			s.sendError("", fmt.Errorf("%w : %v", messaging.ErrorMalformedMessage, err))
+			continue
+		}
+		err = s.handle(command)
+		if err != nil {
+			logger.Warning("command", command.Command, "failed :", err)
+			s.sendError(command.RequestID, err)
+		}
+		// a command may take a while, it is also a proof the client is alive
+		_ = s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	}
+}
+
+func (s *session) handle(command Command) error {
+	switch command.Command {
+	case CommandCreatePlayer:
+		return s.createPlayer(command)
+	case CommandProfile:
+		return s.profile(command)
+	case CommandRename:
+		return s.rename(command)
+	case CommandListParties:
+		return s.listParties(command)
+	case CommandCreateParty:
+		return s.createParty(command)
+	case CommandJoinParty:
+		return s.joinParty(command)
+	case CommandQuickPlay:
+		return s.quickPlay(command)
+	case CommandLeaveParty:
+		return s.leaveParty(command)
+	case CommandInput:
+		return s.sendInput(command)
+	case CommandChangeState:
+		return s.changeState(command)
+	case CommandReady:
+		return s.setReady(command)
+	case CommandKick:
+		return s.moderate(command, models.Kick)
+	case CommandBan:
+		return s.moderate(command, models.Ban)
+	case CommandTransferHost:
+		return s.moderate(command, models.TransferHost)
+	case CommandSync:
+		return s.sync()
+	case CommandChat:
+		return s.chat(command)
+	}
+	return fmt.Errorf("%w : \"%s\"", ErrorUnknownCommand, command.Command)
+}
+
+func (s *session) createPlayer(command Command) error {
+	if s.client != nil {
+		return ErrorPlayerAlreadyCreated
+	}
+	data := CreatePlayerData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	newClient, err := client.NewAutoraceClient(data.PlayerName, s.gateway.rabbitConfig)
+	if err != nil {
+		return err
+	}
+	newClient.PlayerID = data.PlayerID
+	newClient.SessionToken = data.SessionToken
+	playerSession, err := s.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return newClient.RequestPlayerCreation(ctx, make(chan bool, 1))
+	})
+	if err != nil {
+		closeErr := newClient.Close()
+		if closeErr != nil {
+			logger.Error("while closing client :", closeErr)
+		}
+		return err
+	}
+	s.client = newClient
+	s.partyEvents = newClient
+	s.player = playerSession.(*models.PlayerSession).Player
+	s.followLobby()
+	return s.send(Event{Event: EventPlayer, RequestID: command.RequestID, Data: playerSession})
+}
+
+func (s *session) profile(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	data := ProfileData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	profile, err := s.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return s.client.RequestProfile(ctx, data.PlayerID, make(chan bool, 1))
+	})
+	if err != nil {
+		return err
+	}
+	return s.send(Event{Event: EventProfile, RequestID: command.RequestID, Data: profile})
+}
+
+func (s *session) rename(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	data := RenameData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	player, err := s.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return s.client.RequestRename(ctx, data.PlayerName, make(chan bool, 1))
+	})
+	if err != nil {
+		return err
+	}
+	s.player.PlayerName = player.(*models.Player).PlayerName
+	return s.send(Event{Event: EventRenamed, RequestID: command.RequestID, Data: player})
+}
+
+func (s *session) listParties(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	data := ListPartiesData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	listRequest := models.PartyListRequest{
+		JoinableOnly: data.JoinableOnly,
+		NameFilter:   data.NameFilter,
+		Page:         data.Page,
+		PageSize:     data.PageSize,
+	}
+	partyList, err := s.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return s.client.RequestPartyList(ctx, listRequest, make(chan bool, 2))
+	})
+	if err != nil {
+		return err
+	}
+	return s.send(Event{Event: EventPartyList, RequestID: command.RequestID, Data: partyList})
+}
+
+func (s *session) createParty(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	if s.party != nil {
+		return ErrorPartyAlreadyJoined
+	}
+	data := CreatePartyData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	partyToken := models.PartyCreationToken{
+		ClientID:       s.player.PlayerUUID.String(),
+		Seed:           data.Seed,
+		PartyName:      data.PartyName,
+		CircuitConfig:  data.CircuitConfig,
+		MaxPlayers:     data.MaxPlayers,
+		Private:        data.Private,
+		Password:       data.Password,
+		MinPlayers:     data.MinPlayers,
+		AutoStartAfter: data.AutoStartAfter,
+		Laps:           data.Laps,
+	}
+	party, err := s.withTimeout(func(ctx context.Context) (interface{}, error) {
+		return s.client.RequestPartyCreation(ctx, partyToken, make(chan bool, 1))
+	})
+	if err != nil {
+		return err
+	}
+	s.party = party.(*models.Party)
+	s.follow(s.party.PartyUUID.String())
+	return s.send(Event{Event: EventParty, RequestID: command.RequestID, Data: s.party})
+}
+
+func (s *session) joinParty(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	if s.party != nil {
+		return ErrorPartyAlreadyJoined
+	}
+	data := JoinPartyData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	if data.InviteCode != "" {
+		partyInvite, err := s.withTimeout(func(ctx context.Context) (interface{}, error) {
+			return s.client.ResolveInviteCode(ctx, data.InviteCode, make(chan bool, 1))
+		})
+		if err != nil {
+			return err
+		}
+		data.PartyID = partyInvite.(*models.PartyInvite).PartyID
+	}
+	_, err = uuid.Parse(data.PartyID)
+	if err != nil {
+		return fmt.Errorf("%w : invalid party ID : %v", messaging.ErrorMalformedMessage, err)
+	}
+	return s.join(command, data.PartyID, data.Password)
+}
+
+func (s *session) quickPlay(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	if s.party != nil {
+		return ErrorPartyAlreadyJoined
+	}
+	// no command is read while waiting, the client must not be considered dead meanwhile
+	_ = s.conn.SetReadDeadline(time.Now().Add(pongWait + s.gateway.MatchTimeout + s.gateway.RequestTimeout))
+	matchFound, err := s.waitFor(s.gateway.MatchTimeout, func(ctx context.Context) (interface{}, error) {
+		return s.client.RequestMatch(ctx, make(chan bool, 1))
+	})
+	if err != nil {
+		s.client.CancelMatch()
+		return err
+	}
+	return s.join(command, matchFound.(*models.MatchFound).PartyID, "")
+}
+
+// join joins a party and starts forwarding its events
+func (s *session) join(command Command, partyID, password string) error {
+	party, err := s.client.JoinParty(partyID, password, s.gateway.RequestTimeout)
+	if errors.Is(err, client.ErrorJoinTimeout) {
+		return fmt.Errorf("%w : %v", ErrorRequestTimeout, err)
+	}
+	if err != nil {
+		return err
+	}
+	s.party = party
+	s.follow(partyID)
+	return s.send(Event{Event: EventParty, RequestID: command.RequestID, Data: s.party})
+}
+
+func (s *session) leaveParty(command Command) error {
+	if s.party == nil {
+		return ErrorPartyRequired
+	}
+	s.client.LeaveParty()
+	s.unfollow()
+	s.party = nil
+	return s.send(Event{Event: EventLeft, RequestID: command.RequestID})
+}
+
+func (s *session) sendInput(command Command) error {
+	if s.party == nil {
+		return ErrorPartyRequired
+	}
+	data := InputData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	s.client.SendPlayerInput(&models.PlayerInput{
+		Acceleration:  data.Acceleration,
+		Turning:       data.Turning,
+		MessageNumber: data.MessageNumber,
+		Timestamp:     time.Now(),
+		PlayerUUID:    s.player.PlayerUUID,
+	})
+	return nil
+}
+
+func (s *session) changeState(command Command) error {
+	if s.party == nil {
+		return ErrorPartyRequired
+	}
+	data := ChangeStateData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	s.client.SendGameState(models.ChangeStateToken{
+		PlayerToken: models.PlayerToken{
+			ClientID: s.player.PlayerUUID.String(),
+			PartyID:  s.party.PartyUUID.String(),
+		},
+		DesiredState: data.DesiredState,
+	})
+	return nil
+}
+
+func (s *session) setReady(command Command) error {
+	if s.party == nil {
+		return ErrorPartyRequired
+	}
+	data := ReadyData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	s.client.SetReady(data.Ready)
+	return nil
+}
+
+// moderate sends a moderation request, the outcome is sent as a moderation or error event
+func (s *session) moderate(command Command, action models.ModerationAction) error {
+	if s.party == nil {
+		return ErrorPartyRequired
+	}
+	data := ModerateData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	s.client.Moderate(action, data.PlayerID)
+	return nil
+}
+
+func (s *session) sync() error {
+	if s.party == nil {
+		return ErrorPartyRequired
+	}
+	s.client.SendSyncRequest()
+	return nil
+}
+
+// chat posts a chat message, refused messages are sent back as error events
+func (s *session) chat(command Command) error {
+	if s.client == nil {
+		return ErrorPlayerRequired
+	}
+	data := ChatData{}
+	err := decodeData(command, &data)
+	if err != nil {
+		return err
+	}
+	if data.Channel == "" {
+		data.Channel = models.ChatLobbyChannel
+		if s.party != nil {
+			data.Channel = models.ChatPartyChannel
+		}
+	}
+	switch data.Channel {
+	case models.ChatLobbyChannel:
+		s.client.SendLobbyChat(data.Text)
+	case models.ChatPartyChannel:
+		if s.party == nil {
+			return ErrorPartyRequired
+		}
+		s.client.SendPartyChat(data.Text)
+	default:
+		return fmt.Errorf("%w : unknown chat channel \"%s\"", messaging.ErrorMalformedMessage, data.Channel)
+	}
+	return nil
+}
+
+// followLobby forwards lobby events and lobby chat messages to the WebSocket client
+func (s *session) followLobby() {
+	lobbyEvents := make(chan *models.LobbyEvent)
+	chatMessages := make(chan interface{})
+	go func() {
+		err := s.client.ReceiveLobbyEvents(make(chan bool, 1), lobbyEvents)
+		if err != nil {
+			logger.Error("while receiving lobby events for gateway :", err)
+		}
+	}()
+	go func() {
+		err := s.client.ReceiveLobbyChat(make(chan bool, 1), chatMessages)
+		if err != nil {
+			logger.Error("while receiving lobby chat for gateway :", err)
+		}
+	}()
+	go func() {
+		for {
+			var err error
+			select {
+			case lobbyEvent := <-lobbyEvents:
+				err = s.send(Event{Event: EventLobby, Data: lobbyEvent})
+			case chatMessage := <-chatMessages:
+				err = s.send(chatEvent(chatMessage))
+			case <-s.done:
+				return
+			}
+			if err != nil {
+				logger.Error("while forwarding lobby event, closing session :", err)
+				_ = s.conn.Close()
+				return
+			}
+		}
+	}()
+}
+
+// follow listens to sync, state, moderation and chat messages of a party and forwards them as
+// events, until the party is left
+func (s *session) follow(partyID string) {
+	syncMessages := make(chan *server.SyncMessageContent)
+	gameState := make(chan models.State)
+	moderationEvents := make(chan interface{})
+	chatMessages := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelFollow = cancel
+	go func() {
+		err := s.partyEvents.ReceiveSync(ctx, partyID, make(chan bool), syncMessages)
+		if err != nil {
+			logger.Error("while receiving sync messages for gateway :", err)
+		}
+	}()
+	go func() {
+		err := s.partyEvents.ReceiveGameState(ctx, partyID, make(chan bool, 1), gameState)
+		if err != nil {
+			logger.Error("while receiving game state for gateway :", err)
+		}
+	}()
+	go func() {
+		err := s.partyEvents.ReceiveModerationEvents(ctx, partyID, make(chan bool, 1), moderationEvents)
+		if err != nil {
+			logger.Error("while receiving moderation events for gateway :", err)
+		}
+	}()
+	go func() {
+		err := s.partyEvents.ReceivePartyChat(ctx, partyID, make(chan bool, 1), chatMessages)
+		if err != nil {
+			logger.Error("while receiving party chat for gateway :", err)
+		}
+	}()
+	go func() {
+		for {
+			var err error
+			select {
+			case syncMessage := <-syncMessages:
+				err = s.send(Event{Event: EventSync, Data: syncMessage})
+			case newState := <-gameState:
+				err = s.send(Event{Event: EventState, Data: StateData{NewState: newState}})
+			case moderationEvent := <-moderationEvents:
+				if moderationErr, ok := moderationEvent.(error); ok {
+					err = s.send(newErrorEvent("", moderationErr))
+					break
+				}
+				err = s.send(Event{Event: EventModeration, Data: moderationEvent})
+			case chatMessage := <-chatMessages:
+				err = s.send(chatEvent(chatMessage))
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			}
+			if err != nil {
+				logger.Error("while forwarding event, closing session :", err)
+				_ = s.conn.Close()
+				return
+			}
+		}
+	}()
+}
+
+// unfollow stops listening to the messages of the followed party, its subscriptions are cancelled
+func (s *session) unfollow() {
+	if s.cancelFollow != nil {
+		s.cancelFollow()
+		s.cancelFollow = nil
+	}
+}
+
+// chatEvent create the event forwarding a chat message, or an error event if it was refused
+func chatEvent(chatMessage interface{}) Event {
+	if chatErr, ok := chatMessage.(error); ok {
+		return newErrorEvent("", chatErr)
+	}
+	return Event{Event: EventChat, Data: chatMessage}
+}
+
+// withTimeout calls a request waiting for servers, and gives up after the gateway's request timeout
+func (s *session) withTimeout(request func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	return s.waitFor(s.gateway.RequestTimeout, request)
+}
+
+// waitFor calls a request waiting for servers, and gives up after a given timeout. The context of the
+// request is cancelled once waitFor returns, so a request given up stops waiting and unsubscribes
+func (s *session) waitFor(timeout time.Duration, request func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	type result struct {
+		value interface{}
+		err   error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results := make(chan result, 1)
+	go func() {
+		value, err := request(ctx)
+		results <- result{value: value, err: err}
+	}()
+	select {
+	case res := <-results:
+		if res.err != nil && ctx.Err() != nil {
+			return nil, ErrorRequestTimeout
+		}
+		return res.value, res.err
+	case <-ctx.Done():
+		return nil, ErrorRequestTimeout
+	}
+}
+
+func (s *session) send(event Event) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	err := s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteJSON(event)
+}
+
+func (s *session) sendError(requestID string, err error) {
+	sendErr := s.send(newErrorEvent(requestID, err))
+	if sendErr != nil {
+		logger.Error("while sending error event :", sendErr)
+	}
+}
+
+func (s *session) ping() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			if err != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// close terminates the session and its RabbitMQ connection. Every goroutine of the session returns
+func (s *session) close() {
+	close(s.done)
+	s.unfollow()
+	if s.client != nil {
+		err := s.client.Close()
+		if err != nil {
+			logger.Error("while closing client :", err)
+		}
+	}
+	err := s.conn.Close()
+	if err != nil {
+		logger.Trace("while closing WebSocket connection :", err)
+	}
+}
@@ -20,11 +20,13 @@ type CompetitorActor struct {
 	Position  *PlayerPosition `json:"position"`
 }
 
-// Actor contains in-game representation
+// Actor contains in-game representation. Rank is the finishing position of the actor, 0 while racing
 type Actor struct {
-	Car  *Car
-	Name string `json:"name"`
-	Rank int    `json:"rank"`
+	Car   *Car
+	Name  string `json:"name"`
+	Rank  int    `json:"rank"`
+	Lap   int    `json:"lap"`
+	Ready bool   `json:"ready"`
 }
 
 //String stringify Actor
@@ -0,0 +1,25 @@
+package models
+
+// AdminID is the ID administration messages are signed for. Player IDs are UUIDs, so no player can
+// get a session token for it
+const AdminID = "admin"
+
+// ForceEndMessageType is the message type of a ForceEnd
+const ForceEndMessageType = "party.force_end"
+
+// ForceEnd is sent by an administrator to a party instance (aka dynamic server) to end the party
+// right away, whoever is host
+type ForceEnd struct {
+	PartyID string `json:"party_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// MessageType returns ForceEnd's message type
+func (forceEnd ForceEnd) MessageType() string {
+	return ForceEndMessageType
+}
+
+// ForceEndTopic returns the topic a party instance receives ForceEnd messages on
+func ForceEndTopic(partyID string) string {
+	return "autocar.party." + partyID + ".admin.end"
+}
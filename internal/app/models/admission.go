@@ -0,0 +1,23 @@
+package models
+
+import "errors"
+
+var (
+	// ErrorPartyLimitReached is returned when a party is created while as many parties as allowed are running
+	ErrorPartyLimitReached = errors.New("too many parties running")
+	// ErrorOpenPartyLimitReached is returned when a player creates a party while as many parties it created
+	// as allowed are still open
+	ErrorOpenPartyLimitReached = errors.New("too many open parties created by this player")
+	// ErrorCreationRateLimited is returned when a player creates more parties than allowed in a window
+	ErrorCreationRateLimited = errors.New("too many parties created recently")
+)
+
+// error codes sent in an ErrorResponse when a party creation is refused by admission control
+const (
+	// ErrorCodePartyLimitReached is sent when as many parties as allowed are running
+	ErrorCodePartyLimitReached = "party_limit_reached"
+	// ErrorCodeOpenPartyLimitReached is sent when a player has as many open parties as allowed
+	ErrorCodeOpenPartyLimitReached = "open_party_limit_reached"
+	// ErrorCodeCreationRateLimited is sent when a player creates more parties than allowed in a window
+	ErrorCodeCreationRateLimited = "creation_rate_limited"
+)
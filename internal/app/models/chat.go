@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ChatMaxLength is the maximum number of characters of a chat message, longer messages are truncated
+const ChatMaxLength = 200
+
+// chat channels
+const (
+	// ChatLobbyChannel is the channel shared by every connected player
+	ChatLobbyChannel = "lobby"
+	// ChatPartyChannel is the channel of the players of a party
+	ChatPartyChannel = "party"
+)
+
+// LobbyChatTopic is the topic of the lobbies exchange where lobby chat messages are relayed
+const LobbyChatTopic = "chat.lobby"
+
+// message types used to dispatch chat messages
+const (
+	// ChatTokenMessageType is the message type of a ChatToken
+	ChatTokenMessageType = "chat.token"
+	// ChatMessageMessageType is the message type of a ChatMessage
+	ChatMessageMessageType = "chat.message"
+)
+
+var (
+	// ErrorChatRateLimited is returned when a player sends chat messages too fast
+	ErrorChatRateLimited = errors.New("too many chat messages, slow down")
+	// ErrorChatEmpty is returned when a chat message has no text
+	ErrorChatEmpty = errors.New("chat message is empty")
+)
+
+// error codes sent in an ErrorResponse when a chat message is refused
+const (
+	// ErrorCodeChatRateLimited is sent when a player sends chat messages too fast
+	ErrorCodeChatRateLimited = "chat_rate_limited"
+	// ErrorCodeChatEmpty is sent when a chat message has no text
+	ErrorCodeChatEmpty = "chat_empty"
+)
+
+// ChatToken is sent by a player to a server to post a chat message
+type ChatToken struct {
+	PlayerToken PlayerToken `json:"player_token"`
+	Text        string      `json:"text"`
+}
+
+// MessageType returns ChatToken's message type
+func (chatToken ChatToken) MessageType() string {
+	return ChatTokenMessageType
+}
+
+// ChatMessage is a chat message relayed by a server. The sender name and the time are set by the server
+type ChatMessage struct {
+	Channel    string    `json:"channel"`
+	SenderID   string    `json:"sender_id"`
+	SenderName string    `json:"sender_name"`
+	Text       string    `json:"text"`
+	SentAt     time.Time `json:"sent_at"`
+}
+
+// MessageType returns ChatMessage's message type
+func (chatMessage ChatMessage) MessageType() string {
+	return ChatMessageMessageType
+}
+
+// ChatFilter masks banned words in chat messages
+type ChatFilter struct {
+	bannedWords *regexp.Regexp
+}
+
+// NewChatFilter create a ChatFilter masking a list of words, whatever their case
+func NewChatFilter(bannedWords []string) *ChatFilter {
+	var quotedWords []string
+	for _, word := range bannedWords {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			quotedWords = append(quotedWords, regexp.QuoteMeta(word))
+		}
+	}
+	chatFilter := new(ChatFilter)
+	if len(quotedWords) > 0 {
+		chatFilter.bannedWords = regexp.MustCompile(`(?i)\b(` + strings.Join(quotedWords, "|") + `)\b`)
+	}
+	return chatFilter
+}
+
+// Clean trims and truncates a chat message text, then masks its banned words
+func (chatFilter *ChatFilter) Clean(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", ErrorChatEmpty
+	}
+	if utf8.RuneCountInString(text) > ChatMaxLength {
+		text = string([]rune(text)[:ChatMaxLength])
+	}
+	if chatFilter.bannedWords == nil {
+		return text, nil
+	}
+	return chatFilter.bannedWords.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}), nil
+}
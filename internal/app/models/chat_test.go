@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChatFilter_Clean(t *testing.T) {
+	chatFilter := NewChatFilter([]string{"darn", " heck ", ""})
+	tests := []struct {
+		text     string
+		expected string
+		errorMsg string
+	}{
+		{"  hello there  ", "hello there", "text should be trimmed"},
+		{"darn it", "**** it", "banned word should be masked"},
+		{"DaRn, what the HECK", "****, what the ****", "banned words should be masked whatever their case"},
+		{"darned", "darned", "only whole words should be masked"},
+	}
+	for _, test := range tests {
+		text, err := chatFilter.Clean(test.text)
+		if err != nil {
+			t.Error(test.errorMsg, ": unexpected error", err)
+			continue
+		}
+		if text != test.expected {
+			t.Error(test.errorMsg, ": expected", test.expected, "got", text)
+		}
+	}
+}
+
+func TestChatFilter_CleanLimits(t *testing.T) {
+	chatFilter := NewChatFilter(nil)
+	if _, err := chatFilter.Clean("   "); err != ErrorChatEmpty {
+		t.Error("blank message should be refused, got", err)
+	}
+	text, err := chatFilter.Clean(strings.Repeat("é", ChatMaxLength+10))
+	if err != nil {
+		t.Fatal("unexpected error :", err)
+	}
+	if utf8.RuneCountInString(text) != ChatMaxLength {
+		t.Error("expected message to be truncated to", ChatMaxLength, "characters, got", utf8.RuneCountInString(text))
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// MaxPartyCapacity is the biggest capacity a party can be created with
+const MaxPartyCapacity = 16
+
+// InviteCodeLength is the number of characters of an invite code
+const InviteCodeLength = 6
+
+// inviteCodeAlphabet has no character easy to confuse, like 0 and O or 1 and I
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewInviteCode generate a short human-readable invite code
+func NewInviteCode() (string, error) {
+	code := make([]byte, InviteCodeLength)
+	alphabetSize := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for index := range code {
+		letter, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[index] = inviteCodeAlphabet[letter.Int64()]
+	}
+	return string(code), nil
+}
+
+// NormalizeInviteCode formats an invite code typed by a player
+func NormalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// HashPartyPassword hash a party password salted with the party UUID. An empty password gives an
+// empty hash
+func HashPartyPassword(partyID, password string) string {
+	if password == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(partyID + ":" + password))
+	return hex.EncodeToString(hash[:])
+}
+
+// CheckPartyPassword tells if a password matches a party password hash. Any password matches an
+// empty hash
+func CheckPartyPassword(passwordHash, partyID, password string) bool {
+	if passwordHash == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(passwordHash), []byte(HashPartyPassword(partyID, password))) == 1
+}
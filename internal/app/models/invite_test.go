@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNewInviteCode(t *testing.T) {
+	inviteCode, err := NewInviteCode()
+	if err != nil {
+		t.Fatal("unable to create invite code :", err)
+	}
+	if len(inviteCode) != InviteCodeLength {
+		t.Error("expected invite code of", InviteCodeLength, "characters, got", inviteCode)
+	}
+	for _, letter := range inviteCode {
+		if !strings.ContainsRune(inviteCodeAlphabet, letter) {
+			t.Error("unexpected character in invite code", inviteCode)
+		}
+	}
+	if NormalizeInviteCode(" "+strings.ToLower(inviteCode)+"\n") != inviteCode {
+		t.Error("typed invite code should be normalized to", inviteCode)
+	}
+}
+
+func TestCheckPartyPassword(t *testing.T) {
+	passwordHash := HashPartyPassword("party_1", "secret")
+	if passwordHash == "" || strings.Contains(passwordHash, "secret") {
+		t.Fatal("unexpected password hash", passwordHash)
+	}
+	if !CheckPartyPassword(passwordHash, "party_1", "secret") {
+		t.Error("right password should match")
+	}
+	if CheckPartyPassword(passwordHash, "party_1", "wrong") {
+		t.Error("wrong password should not match")
+	}
+	if CheckPartyPassword(passwordHash, "party_2", "secret") {
+		t.Error("password hash should be salted with the party ID")
+	}
+	if !CheckPartyPassword(HashPartyPassword("party_1", ""), "party_1", "anything") {
+		t.Error("party without password should accept any password")
+	}
+}
+
+func TestParty_CanJoin(t *testing.T) {
+	partyID := uuid.New().String()
+	party, err := NewParty(PartyCreationToken{
+		MaxPlayers:   2,
+		PasswordHash: HashPartyPassword(partyID, "secret"),
+	}, partyID)
+	if err != nil {
+		t.Fatal("unable to create party :", err)
+	}
+	if party.CanJoin("player", "wrong") != ErrorWrongPassword {
+		t.Error("expected wrong password error")
+	}
+	if err = party.CanJoin("player", "secret"); err != nil {
+		t.Error("expected player to be allowed, got", err)
+	}
+	for index := 0; index < 2; index++ {
+		_ = party.AddPlayer(NewPlayer("player"))
+	}
+	if party.CanJoin("player", "secret") != ErrorPartyFull {
+		t.Error("expected party full error")
+	}
+	party.SetState(RUN)
+	if party.CanJoin("player", "secret") != ErrorPartyStarted {
+		t.Error("expected party started error")
+	}
+}
+
+func TestPartyCreationToken_Capacity(t *testing.T) {
+	tests := []struct {
+		maxPlayers int
+		expected   int
+	}{
+		{0, DefaultPartyCapacity},
+		{-3, DefaultPartyCapacity},
+		{4, 4},
+		{MaxPartyCapacity + 1, MaxPartyCapacity},
+	}
+	for _, test := range tests {
+		capacity := PartyCreationToken{MaxPlayers: test.maxPlayers}.Capacity()
+		if capacity != test.expected {
+			t.Error("max players", test.maxPlayers, ": expected capacity", test.expected, "got", capacity)
+		}
+	}
+}
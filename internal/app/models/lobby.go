@@ -0,0 +1,67 @@
+package models
+
+// LobbyEventMessageType is the message type of a LobbyEvent
+const LobbyEventMessageType = "lobby.event"
+
+// LobbyEventsTopic is the topic matching every lobby event
+const LobbyEventsTopic = "lobby.#"
+
+// LobbyEventKind is used to represent what happened to a party in a Enum style
+type LobbyEventKind int
+
+// possible lobby events
+const (
+	// PartyCreated is sent when a party is registered and its dynamic server started
+	PartyCreated LobbyEventKind = iota
+	// PlayerJoined is sent when a player joined a party
+	PlayerJoined
+	// PlayerLeft is sent when a player left a party
+	PlayerLeft
+	// PartyStarted is sent when a party left the lobby, it can not be joined anymore
+	PartyStarted
+	// PartyEnded is sent when a party is over
+	PartyEnded
+)
+
+var lobbyEventKinds = [...]string{
+	"party_created",
+	"player_joined",
+	"player_left",
+	"party_started",
+	"party_ended",
+}
+
+// String stringify a LobbyEventKind
+func (kind LobbyEventKind) String() string {
+	if kind < PartyCreated || kind > PartyEnded {
+		return "unknown"
+	}
+	return lobbyEventKinds[kind]
+}
+
+// LobbyEvent is published on the lobbies exchange every time a party changes, so party lists
+// can be updated without polling
+type LobbyEvent struct {
+	Kind        LobbyEventKind `json:"kind"`
+	PartyID     string         `json:"party_id"`
+	PartyName   string         `json:"party_name,omitempty"`
+	PlayerID    string         `json:"player_id,omitempty"`
+	PlayerName  string         `json:"player_name,omitempty"`
+	PlayerCount int            `json:"player_count"`
+	State       State          `json:"state"`
+}
+
+// MessageType returns LobbyEvent's message type
+func (lobbyEvent LobbyEvent) MessageType() string {
+	return LobbyEventMessageType
+}
+
+// Topic returns the topic a LobbyEvent is published on : lobby.[@partyID].[@kind]
+func (lobbyEvent LobbyEvent) Topic() string {
+	return "lobby." + lobbyEvent.PartyID + "." + lobbyEvent.Kind.String()
+}
+
+// Joinable tells if the party is still accepting players after this event
+func (lobbyEvent LobbyEvent) Joinable() bool {
+	return lobbyEvent.Kind != PartyEnded && lobbyEvent.State == LOBBY
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestLobbyEvent_Topic(t *testing.T) {
+	lobbyEvent := LobbyEvent{
+		Kind:    PlayerJoined,
+		PartyID: "0524e4b1-dcf7-4177-b880-af2bcb8363f0",
+	}
+	if lobbyEvent.Topic() != "lobby.0524e4b1-dcf7-4177-b880-af2bcb8363f0.player_joined" {
+		t.Error("unexpected lobby event topic :", lobbyEvent.Topic())
+	}
+	if LobbyEventKind(42).String() != "unknown" {
+		t.Error("unexpected string for an unknown lobby event kind :", LobbyEventKind(42).String())
+	}
+}
+
+func TestLobbyEvent_Joinable(t *testing.T) {
+	tests := []struct {
+		event    LobbyEvent
+		joinable bool
+	}{
+		{LobbyEvent{Kind: PartyCreated, State: LOBBY}, true},
+		{LobbyEvent{Kind: PlayerLeft, State: LOBBY}, true},
+		{LobbyEvent{Kind: PartyStarted, State: RUN}, false},
+		{LobbyEvent{Kind: PartyEnded, State: LOBBY}, false},
+	}
+	for _, test := range tests {
+		if test.event.Joinable() != test.joinable {
+			t.Error("expected", test.event.Kind, "joinable to be", test.joinable)
+		}
+	}
+}
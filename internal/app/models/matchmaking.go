@@ -0,0 +1,55 @@
+package models
+
+const (
+	// MatchRequestMessageType is the message type of a MatchRequest
+	MatchRequestMessageType = "matchmaking.request"
+	// MatchFoundMessageType is the message type of a MatchFound
+	MatchFoundMessageType = "matchmaking.match_found"
+	// MatchCancelledMessageType is the message type of a MatchCancelled
+	MatchCancelledMessageType = "matchmaking.cancelled"
+)
+
+// MatchCancelledTopic is the topic where a static server tells the other ones a player left the
+// matchmaking queue
+const MatchCancelledTopic = "autocar.matchmaking.cancelled"
+
+// MatchRequest is sent to a static server to be queued for a quick play party, or to leave the
+// queue if Cancel is set
+type MatchRequest struct {
+	ClientID string `json:"client_id"`
+	Cancel   bool   `json:"cancel,omitempty"`
+}
+
+// MessageType returns MatchRequest's message type
+func (matchRequest MatchRequest) MessageType() string {
+	return MatchRequestMessageType
+}
+
+// MatchCancelled is sent to every static server when a player leaves the matchmaking queue, since the
+// player may be queued on another static server than the one receiving the cancellation
+type MatchCancelled struct {
+	ClientID string `json:"client_id"`
+}
+
+// MessageType returns MatchCancelled's message type
+func (matchCancelled MatchCancelled) MessageType() string {
+	return MatchCancelledMessageType
+}
+
+// MatchFound is pushed to a queued player once a party is ready for it. The player still has to
+// join the party
+type MatchFound struct {
+	PartyID   string `json:"party_id"`
+	PartyName string `json:"party_name"`
+}
+
+// MessageType returns MatchFound's message type
+func (matchFound MatchFound) MessageType() string {
+	return MatchFoundMessageType
+}
+
+// Matchable tells if a quick play player can be sent to a party. Password protected and private
+// parties are left out
+func (summary PartySummary) Matchable() bool {
+	return summary.Joinable() && !summary.Private && !summary.PasswordProtected
+}
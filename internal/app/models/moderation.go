@@ -0,0 +1,192 @@
+package models
+
+import "errors"
+
+var (
+	// ErrorNotHost is returned when a player who is not host tries to moderate the party or to start
+	// or end it
+	ErrorNotHost = errors.New("only the party host can do this")
+	// ErrorInvalidTarget is returned when a moderation action targets the host itself
+	ErrorInvalidTarget = errors.New("host can not moderate itself")
+	// ErrorInvalidStateChange is returned when the host asks for a state the party can not go to from
+	// its current state
+	ErrorInvalidStateChange = errors.New("party can not go to this state")
+)
+
+// error codes sent in an ErrorResponse when a moderation action or a state change is refused
+const (
+	// ErrorCodeNotHost is sent when a player who is not host tries a host only action
+	ErrorCodeNotHost = "not_host"
+	// ErrorCodeInvalidTarget is sent when a moderation action targets the host itself
+	ErrorCodeInvalidTarget = "invalid_target"
+	// ErrorCodePlayerNotFound is sent when a moderation action targets a player who is not in the party
+	ErrorCodePlayerNotFound = "player_not_found"
+	// ErrorCodeInvalidStateChange is sent when the host asks for a state the party can not go to
+	ErrorCodeInvalidStateChange = "invalid_state_change"
+)
+
+// message types used to dispatch moderation messages
+const (
+	// ModerationTokenMessageType is the message type of a ModerationToken
+	ModerationTokenMessageType = "party.moderation_token"
+	// ModerationEventMessageType is the message type of a ModerationEvent
+	ModerationEventMessageType = "party.moderation_event"
+)
+
+// ModerationAction is used to represent what a host does to a player in a Enum style
+type ModerationAction int
+
+// possible moderation actions
+const (
+	// Kick removes a player from the party, the player can join again
+	Kick ModerationAction = iota
+	// Ban removes a player from the party for good
+	Ban
+	// TransferHost gives the host role to another player
+	TransferHost
+)
+
+var moderationActions = [...]string{
+	"kick",
+	"ban",
+	"transfer_host",
+}
+
+// String stringify a ModerationAction
+func (action ModerationAction) String() string {
+	if action < Kick || action > TransferHost {
+		return "unknown"
+	}
+	return moderationActions[action]
+}
+
+// ModerationToken is sent by a host to a party instance (aka dynamic server) to moderate a player
+type ModerationToken struct {
+	PlayerToken PlayerToken      `json:"player_token"`
+	Action      ModerationAction `json:"action"`
+	TargetID    string           `json:"target_id"`
+}
+
+// MessageType returns ModerationToken's message type
+func (moderationToken ModerationToken) MessageType() string {
+	return ModerationTokenMessageType
+}
+
+// ModerationEvent is sent to every player of a party, and to the moderated player, once a moderation
+// action is done. Automatic is set when the host left and the host role was given to the oldest player
+type ModerationEvent struct {
+	Action    ModerationAction `json:"action"`
+	TargetID  string           `json:"target_id"`
+	HostID    string           `json:"host_id"`
+	Automatic bool             `json:"automatic,omitempty"`
+}
+
+// MessageType returns ModerationEvent's message type
+func (moderationEvent ModerationEvent) MessageType() string {
+	return ModerationEventMessageType
+}
+
+// IsHost tells if a player is the party host
+func (party *Party) IsHost(playerID string) bool {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return party.isHost(playerID)
+}
+
+func (party *Party) isHost(playerID string) bool {
+	return playerID != "" && party.HostID == playerID
+}
+
+// IsBanned tells if a player is banned from the party
+func (party *Party) IsBanned(playerID string) bool {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return party.banned[playerID]
+}
+
+// Moderate applies a moderation action asked by a player. Kicked and banned players are removed
+// from the party
+func (party *Party) Moderate(playerID string, action ModerationAction, targetID string) error {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	if !party.isHost(playerID) {
+		return ErrorNotHost
+	}
+	if targetID == playerID {
+		return ErrorInvalidTarget
+	}
+	if _, ok := party.Players[targetID]; !ok {
+		return ErrorPlayerNotFound
+	}
+	switch action {
+	case Kick:
+		return party.removePlayer(targetID)
+	case Ban:
+		if party.banned == nil {
+			party.banned = make(map[string]bool)
+		}
+		party.banned[targetID] = true
+		return party.removePlayer(targetID)
+	case TransferHost:
+		party.HostID = targetID
+		return nil
+	}
+	return errors.New("unknown moderation action")
+}
+
+// CanChangeState tells if a player may ask for a state. The host starts, pauses, resumes and ends
+// the party, other players may only vote to pause or resume it
+func (party *Party) CanChangeState(playerID string, desiredState State) error {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	if party.isHost(playerID) {
+		if !party.state.CanChangeTo(desiredState) {
+			return ErrorInvalidStateChange
+		}
+		return nil
+	}
+	if _, ok := party.Players[playerID]; !ok {
+		return ErrorPlayerNotFound
+	}
+	switch {
+	case party.state == RUN && desiredState == PAUSE:
+		return nil
+	case party.state == PAUSE && desiredState == RUN:
+		return nil
+	}
+	return ErrorNotHost
+}
+
+// CanChangeTo tells if a party may go from a state to another : LOBBY to RUN, RUN to PAUSE and back,
+// and any state but END to END
+func (state State) CanChangeTo(desiredState State) bool {
+	switch {
+	case state == LOBBY && desiredState == RUN:
+		return true
+	case state == RUN && desiredState == PAUSE:
+		return true
+	case state == PAUSE && desiredState == RUN:
+		return true
+	case state != END && desiredState == END:
+		return true
+	}
+	return false
+}
+
+// VoteState registers the vote of a player who is not host and returns the number of votes for this
+// state and the number of votes needed to change the party state : most of the players
+func (party *Party) VoteState(playerID string, desiredState State) (int, int) {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	if party.stateVotes == nil {
+		party.stateVotes = make(map[string]State)
+	}
+	party.stateVotes[playerID] = desiredState
+	votes := 0
+	for _, vote := range party.stateVotes {
+		if vote == desiredState {
+			votes++
+		}
+	}
+	return votes, len(party.Players)/2 + 1
+}
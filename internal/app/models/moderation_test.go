@@ -0,0 +1,135 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newModeratedParty(t *testing.T, playerCount int) (*Party, []*Player) {
+	players := make([]*Player, playerCount)
+	for index := range players {
+		players[index] = NewPlayer("player")
+	}
+	party, err := NewParty(PartyCreationToken{ClientID: players[0].PlayerUUID.String()}, uuid.New().String())
+	if err != nil {
+		t.Fatal("unable to create party :", err)
+	}
+	for _, player := range players {
+		err = party.AddPlayer(player)
+		if err != nil {
+			t.Fatal("unable to add player :", err)
+		}
+	}
+	return party, players
+}
+
+func TestParty_Moderate(t *testing.T) {
+	party, players := newModeratedParty(t, 3)
+	hostID := players[0].PlayerUUID.String()
+	guestID := players[1].PlayerUUID.String()
+	if !party.IsHost(hostID) {
+		t.Fatal("party creator should be host")
+	}
+	if party.Moderate(guestID, Kick, hostID) != ErrorNotHost {
+		t.Error("only the host should moderate")
+	}
+	if party.Moderate(hostID, Kick, hostID) != ErrorInvalidTarget {
+		t.Error("host should not moderate itself")
+	}
+	if err := party.Moderate(hostID, Ban, guestID); err != nil {
+		t.Fatal("unable to ban player :", err)
+	}
+	if _, ok := party.Players[guestID]; ok {
+		t.Error("banned player should be removed")
+	}
+	if party.CanJoin(guestID, "") != ErrorPlayerBanned {
+		t.Error("banned player should not join again")
+	}
+	if party.Moderate(hostID, Kick, guestID) != ErrorPlayerNotFound {
+		t.Error("expected player not found error")
+	}
+	newHostID := players[2].PlayerUUID.String()
+	if err := party.Moderate(hostID, TransferHost, newHostID); err != nil || !party.IsHost(newHostID) {
+		t.Error("expected host role to be transferred, got", err)
+	}
+}
+
+func TestParty_RemovePlayer_TransferHost(t *testing.T) {
+	party, players := newModeratedParty(t, 3)
+	_ = party.RemovePlayer(players[0])
+	if !party.IsHost(players[1].PlayerUUID.String()) {
+		t.Error("oldest player should become host when the host leaves")
+	}
+	_ = party.RemovePlayer(players[2])
+	_ = party.RemovePlayer(players[1])
+	if party.HostID != "" {
+		t.Error("empty party should not have any host, got", party.HostID)
+	}
+}
+
+func TestParty_VoteState(t *testing.T) {
+	party, players := newModeratedParty(t, 4)
+	hostID := players[0].PlayerUUID.String()
+	if party.CanChangeState(players[1].PlayerUUID.String(), RUN) != ErrorNotHost {
+		t.Error("only the host should start the party")
+	}
+	if err := party.CanChangeState(hostID, RUN); err != nil {
+		t.Error("host should start the party, got", err)
+	}
+	party.SetState(RUN)
+	if err := party.CanChangeState(players[1].PlayerUUID.String(), PAUSE); err != nil {
+		t.Error("players should vote to pause, got", err)
+	}
+	if party.CanChangeState(players[1].PlayerUUID.String(), END) != ErrorNotHost {
+		t.Error("only the host should end the party")
+	}
+	votes, needed := party.VoteState(players[1].PlayerUUID.String(), PAUSE)
+	if votes != 1 || needed != 3 {
+		t.Error("expected 1/3 votes, got", votes, "/", needed)
+	}
+	party.VoteState(players[1].PlayerUUID.String(), PAUSE)
+	votes, _ = party.VoteState(players[2].PlayerUUID.String(), PAUSE)
+	if votes != 2 {
+		t.Error("a player should only vote once, got", votes, "votes")
+	}
+	votes, _ = party.VoteState(players[3].PlayerUUID.String(), PAUSE)
+	if votes != 3 {
+		t.Error("expected 3 votes, got", votes)
+	}
+	party.SetState(PAUSE)
+	votes, _ = party.VoteState(players[1].PlayerUUID.String(), RUN)
+	if votes != 1 {
+		t.Error("votes should be dropped once the state changed, got", votes)
+	}
+}
+
+func TestParty_CanChangeStateHost(t *testing.T) {
+	party, players := newModeratedParty(t, 2)
+	hostID := players[0].PlayerUUID.String()
+	tests := []struct {
+		state        State
+		desiredState State
+		expected     error
+	}{
+		{LOBBY, RUN, nil},
+		{LOBBY, PAUSE, ErrorInvalidStateChange},
+		{LOBBY, LOBBY, ErrorInvalidStateChange},
+		{LOBBY, END, nil},
+		{RUN, PAUSE, nil},
+		{RUN, LOBBY, ErrorInvalidStateChange},
+		{RUN, END, nil},
+		{PAUSE, RUN, nil},
+		{PAUSE, LOBBY, ErrorInvalidStateChange},
+		{PAUSE, END, nil},
+		{END, RUN, ErrorInvalidStateChange},
+		{END, LOBBY, ErrorInvalidStateChange},
+		{END, END, ErrorInvalidStateChange},
+	}
+	for _, test := range tests {
+		party.SetState(test.state)
+		if err := party.CanChangeState(hostID, test.desiredState); err != test.expected {
+			t.Error("expected", test.expected, "from", test.state, "to", test.desiredState, "got", err)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -13,6 +16,50 @@ var (
 	ErrorPlayerAlreadyInParty = errors.New("player is already registered in party")
 	// ErrorPlayerNotFound used to trigger an error
 	ErrorPlayerNotFound = errors.New("player not found in party")
+	// ErrorPartyFull is returned when a player tries to join a party which reached its capacity
+	ErrorPartyFull = errors.New("party is full")
+	// ErrorPartyStarted is returned when a player tries to join a party which left its lobby
+	ErrorPartyStarted = errors.New("party is already started")
+	// ErrorWrongPassword is returned when a player tries to join a party with a wrong password
+	ErrorWrongPassword = errors.New("wrong party password")
+	// ErrorInviteNotFound is returned when an invite code does not match any party
+	ErrorInviteNotFound = errors.New("invite code not found")
+	// ErrorPlayerBanned is returned when a banned player tries to join a party again
+	ErrorPlayerBanned = errors.New("player is banned from party")
+)
+
+// error codes sent in an ErrorResponse when joining a party fails
+const (
+	// ErrorCodePartyFull is sent when a party reached its capacity
+	ErrorCodePartyFull = "party_full"
+	// ErrorCodePartyStarted is sent when a party left its lobby
+	ErrorCodePartyStarted = "party_started"
+	// ErrorCodeWrongPassword is sent when a party password does not match
+	ErrorCodeWrongPassword = "wrong_password"
+	// ErrorCodeInviteNotFound is sent when an invite code does not match any party
+	ErrorCodeInviteNotFound = "invite_not_found"
+	// ErrorCodePlayerBanned is sent when a banned player tries to join a party again
+	ErrorCodePlayerBanned = "player_banned"
+)
+
+// message types used to dispatch party related messages
+const (
+	// PartyMessageType is the message type of a Party
+	PartyMessageType = "party"
+	// PartyCreationTokenMessageType is the message type of a PartyCreationToken
+	PartyCreationTokenMessageType = "party.creation_token"
+	// PartyListRequestMessageType is the message type of a PartyListRequest
+	PartyListRequestMessageType = "party.list_request"
+	// PartyListPageMessageType is the message type of a PartyListPage
+	PartyListPageMessageType = "party.list_page"
+	// InviteRequestMessageType is the message type of an InviteRequest
+	InviteRequestMessageType = "party.invite_request"
+	// PartyInviteMessageType is the message type of a PartyInvite
+	PartyInviteMessageType = "party.invite"
+	// ChangeStateTokenMessageType is the message type of a ChangeStateToken
+	ChangeStateTokenMessageType = "party.change_state_token"
+	// ChangeStateAckMessageType is the message type of a ChangeStateAck
+	ChangeStateAckMessageType = "party.change_state_ack"
 )
 
 // State is used to represent game's state in a Enum style
@@ -66,6 +113,25 @@ type PartyCreationToken struct {
 	Seed          int              `json:"seed"`
 	PartyName     string           `json:"party_name"`
 	CircuitConfig CircuitMapConfig `json:"circuit_config"`
+	// MaxPlayers is the party capacity, DefaultPartyCapacity is used if it is not set
+	MaxPlayers int `json:"max_players,omitempty"`
+	// Private parties are not listed and can only be joined with their invite code
+	Private bool `json:"private,omitempty"`
+	// Password is sent by the client, the static server stores its hash only
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	// MinPlayers is the number of players needed to start the auto-start timer, DefaultMinPlayers is
+	// used if it is not set. AutoStartAfter is the timer duration in seconds, 0 disables it
+	MinPlayers     int `json:"min_players,omitempty"`
+	AutoStartAfter int `json:"auto_start_after,omitempty"`
+	// Laps is the number of laps of the race, DefaultLaps is used if it is not set
+	Laps int `json:"laps,omitempty"`
+	// Matchmade parties are created by the matchmaking for their ClientID, they are not counted among
+	// the open parties of this player
+	Matchmade bool `json:"matchmade,omitempty"`
+	// CreatedAt and InviteCode are set by the static server when the party is registered
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+	InviteCode string    `json:"invite_code,omitempty"`
 }
 
 // String stringify PartyCreationToken
@@ -76,12 +142,64 @@ func (clientToken PartyCreationToken) String() string {
 	return str
 }
 
+// MessageType returns PartyCreationToken's message type
+func (clientToken PartyCreationToken) MessageType() string {
+	return PartyCreationTokenMessageType
+}
+
+// CreatorID returns the player whose open parties count this party, empty for a matchmade party
+func (clientToken PartyCreationToken) CreatorID() string {
+	if clientToken.Matchmade {
+		return ""
+	}
+	return clientToken.ClientID
+}
+
+// Capacity returns the number of players a party accepts
+func (clientToken PartyCreationToken) Capacity() int {
+	if clientToken.MaxPlayers <= 0 {
+		return DefaultPartyCapacity
+	}
+	if clientToken.MaxPlayers > MaxPartyCapacity {
+		return MaxPartyCapacity
+	}
+	return clientToken.MaxPlayers
+}
+
+// InviteRequest is sent to a static server to resolve an invite code
+type InviteRequest struct {
+	ClientID   string `json:"client_id"`
+	InviteCode string `json:"invite_code"`
+}
+
+// MessageType returns InviteRequest's message type
+func (inviteRequest InviteRequest) MessageType() string {
+	return InviteRequestMessageType
+}
+
+// PartyInvite is sent back to a client who resolved an invite code
+type PartyInvite struct {
+	InviteCode string `json:"invite_code"`
+	PartyID    string `json:"party_id"`
+	PartyName  string `json:"party_name"`
+}
+
+// MessageType returns PartyInvite's message type
+func (partyInvite PartyInvite) MessageType() string {
+	return PartyInviteMessageType
+}
+
 // ChangeStateToken is send to a party instance (aka dynamic server) to change game state
 type ChangeStateToken struct {
 	PlayerToken  PlayerToken `json:"player_token"`
 	DesiredState State       `json:"desired_state"`
 }
 
+// MessageType returns ChangeStateToken's message type
+func (stateToken ChangeStateToken) MessageType() string {
+	return ChangeStateTokenMessageType
+}
+
 // ChangeStateAck is send back to player who asked to change game state
 type ChangeStateAck struct {
 	PartyID      string `json:"party_id"`
@@ -90,7 +208,14 @@ type ChangeStateAck struct {
 	Message      string `json:"message,omitempty"`
 }
 
-// Party is a representation of party
+// MessageType returns ChangeStateAck's message type
+func (stateAck ChangeStateAck) MessageType() string {
+	return ChangeStateAckMessageType
+}
+
+// Party is a representation of party. Players, host, state, votes, ready flags and bans are guarded
+// by a lock, since the handlers and the game loop of a dynamic server use the party at once. Players
+// must only be accessed through methods of the party once it is shared
 type Party struct {
 	PartyUUID uuid.UUID `json:"party_uuid"`
 	PartyName string    `json:"party_name"`
@@ -98,11 +223,44 @@ type Party struct {
 	Players       map[string]*Player `json:"-"`
 	MapCircuit    PartyMap           `json:"map_circuit"`
 	CircuitConfig CircuitMapConfig   `json:"circuit_config"`
-	state         State
+	MaxPlayers    int                `json:"max_players"`
+	Private       bool               `json:"private,omitempty"`
+	InviteCode    string             `json:"invite_code,omitempty"`
+	HostID        string             `json:"host_id"`
+	MinPlayers    int                `json:"min_players"`
+	// AutoStartAfter is the auto-start timer duration in seconds, 0 if the timer is disabled
+	AutoStartAfter int `json:"auto_start_after,omitempty"`
+	Laps           int `json:"laps"`
+	passwordHash   string
+	state          State
+	// joinOrder lists players from the oldest to the newest, the oldest one becomes host when the host leaves
+	joinOrder []string
+	banned    map[string]bool
+	// stateVotes binds players who are not host to the state they voted for
+	stateVotes map[string]State
+	ready      map[string]bool
+	mutex      sync.RWMutex
+}
+
+// MessageType returns Party's message type
+func (party *Party) MessageType() string {
+	return PartyMessageType
+}
+
+// partyJSON has the fields of a Party without its methods, so it is marshaled like a Party used to be
+type partyJSON Party
+
+// MarshalJSON encodes the party while it can not be modified
+func (party *Party) MarshalJSON() ([]byte, error) {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return json.Marshal((*partyJSON)(party))
 }
 
 // String stringify a Party
 func (party *Party) String() string {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
 	str := "Party name : " + party.PartyName + "\n"
 	str += "Party state : " + party.state.String() + "\n"
 	str += "Party UUID : " + party.PartyUUID.String()
@@ -120,39 +278,196 @@ func NewParty(creationToken PartyCreationToken, stringifyUUID string) (*Party, e
 	}
 	party.Players = make(map[string]*Player)
 	party.CircuitConfig = creationToken.CircuitConfig
+	party.MaxPlayers = creationToken.Capacity()
+	party.Private = creationToken.Private
+	party.InviteCode = creationToken.InviteCode
+	party.passwordHash = creationToken.PasswordHash
+	party.HostID = creationToken.ClientID
+	party.banned = make(map[string]bool)
+	party.stateVotes = make(map[string]State)
+	party.ready = make(map[string]bool)
+	party.MinPlayers = creationToken.MinPlayersOrDefault()
+	if creationToken.AutoStartAfter > 0 {
+		party.AutoStartAfter = creationToken.AutoStartAfter
+	}
+	party.Laps = creationToken.LapsOrDefault()
 	return party, nil
 }
 
+// CanJoin tells if a player is allowed to join the party with a given password
+func (party *Party) CanJoin(playerID, password string) error {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	if party.banned[playerID] {
+		return ErrorPlayerBanned
+	}
+	if party.state != LOBBY {
+		return ErrorPartyStarted
+	}
+	if len(party.Players) >= party.MaxPlayers {
+		return ErrorPartyFull
+	}
+	if !CheckPartyPassword(party.passwordHash, party.PartyUUID.String(), password) {
+		return ErrorWrongPassword
+	}
+	return nil
+}
+
 // AddPlayer is use to add a player in a party
 func (party *Party) AddPlayer(player *Player) error {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	return party.addPlayer(player)
+}
+
+func (party *Party) addPlayer(player *Player) error {
 	if _, ok := party.Players[player.PlayerUUID.String()]; ok {
 		return ErrorPlayerAlreadyInParty
 	}
 	party.Players[player.PlayerUUID.String()] = player
+	party.joinOrder = append(party.joinOrder, player.PlayerUUID.String())
 	return nil
 }
 
 // RemovePlayer is use to remove player from a party
 func (party *Party) RemovePlayer(player *Player) error {
-	if _, ok := party.Players[player.PlayerUUID.String()]; !ok {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	return party.removePlayer(player.PlayerUUID.String())
+}
+
+func (party *Party) removePlayer(playerID string) error {
+	if _, ok := party.Players[playerID]; !ok {
 		return ErrorPlayerNotFound
 	}
-	delete(party.Players, player.PlayerUUID.String())
+	delete(party.Players, playerID)
+	delete(party.stateVotes, playerID)
+	delete(party.ready, playerID)
+	for index, joinedID := range party.joinOrder {
+		if joinedID == playerID {
+			party.joinOrder = append(party.joinOrder[:index], party.joinOrder[index+1:]...)
+			break
+		}
+	}
+	if party.HostID == playerID {
+		party.HostID = ""
+		if len(party.joinOrder) > 0 {
+			party.HostID = party.joinOrder[0]
+		}
+	}
 	return nil
 }
 
 // RemoveAllPlayer delete all registered player in a party
 func (party *Party) RemoveAllPlayer() {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	party.removeAllPlayer()
+}
+
+func (party *Party) removeAllPlayer() {
 	party.Players = make(map[string]*Player)
+	party.joinOrder = nil
+	party.stateVotes = make(map[string]State)
+	party.ready = make(map[string]bool)
+}
+
+// Player returns a copy of a player of the party
+func (party *Party) Player(playerID string) (*Player, bool) {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	player, ok := party.Players[playerID]
+	if !ok {
+		return nil, false
+	}
+	return player.copy(), true
+}
+
+// PlayerList returns a copy of every player of the party, from the oldest to the newest one
+func (party *Party) PlayerList() []*Player {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	players := make([]*Player, 0, len(party.joinOrder))
+	for _, playerID := range party.joinOrder {
+		if player, ok := party.Players[playerID]; ok {
+			players = append(players, player.copy())
+		}
+	}
+	return players
+}
+
+// PlayerIDs returns the ID of every player of the party, from the oldest to the newest one
+func (party *Party) PlayerIDs() []string {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return append([]string(nil), party.joinOrder...)
+}
+
+// PlayerCount returns the number of players of the party
+func (party *Party) PlayerCount() int {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return len(party.Players)
+}
+
+// UpdatePlayers calls update for every player of the party while nobody else reads or modifies the
+// party. update must not call methods of the party
+func (party *Party) UpdatePlayers(update func(player *Player)) {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	for _, player := range party.Players {
+		update(player)
+	}
+}
+
+// SetPlayerInput stores the last input of a player. It returns false if the player is not in the party
+func (party *Party) SetPlayerInput(playerID string, input *PlayerInput) bool {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	player, ok := party.Players[playerID]
+	if ok {
+		player.Input = input
+	}
+	return ok
+}
+
+// RenamePlayer changes the name of a player. It returns false if the player is not in the party
+func (party *Party) RenamePlayer(playerID, playerName string) bool {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	player, ok := party.Players[playerID]
+	if ok {
+		player.PlayerName = playerName
+	}
+	return ok
+}
+
+// GetHostID returns the ID of the party host, empty if the party has no player left
+func (party *Party) GetHostID() string {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return party.HostID
+}
+
+// SetState change party's state, pending votes are dropped. It returns the previous state, so
+// concurrent changes are only handled once
+func (party *Party) SetState(s State) State {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	return party.setState(s)
 }
 
-// SetState change party's state
-func (party *Party) SetState(s State) {
+func (party *Party) setState(s State) State {
+	previousState := party.state
 	party.state = s
+	party.stateVotes = make(map[string]State)
+	return previousState
 }
 
 // GetState returns party's state
 func (party *Party) GetState() State {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
 	return party.state
 }
 
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPartyCapacity is the number of players a party accepts
+const DefaultPartyCapacity = 8
+
+// DefaultPartyListPageSize is the page size used when a PartyListRequest does not set one
+const DefaultPartyListPageSize = 10
+
+// MaxPartyListPageSize is the biggest page a static server sends back
+const MaxPartyListPageSize = 50
+
+// PartySummary describe a party in a party list
+type PartySummary struct {
+	PartyID       string           `json:"party_id"`
+	PartyName     string           `json:"party_name"`
+	HostName      string           `json:"host_name"`
+	PlayerCount   int              `json:"player_count"`
+	Capacity      int              `json:"capacity"`
+	State         State            `json:"state"`
+	CircuitConfig CircuitMapConfig `json:"circuit_config"`
+	CreatedAt     time.Time        `json:"created_at"`
+	// Private parties are never listed
+	Private           bool `json:"-"`
+	PasswordProtected bool `json:"password_protected,omitempty"`
+}
+
+// Joinable tells if a party still accepts players
+func (summary PartySummary) Joinable() bool {
+	return summary.State == LOBBY && summary.PlayerCount < summary.Capacity
+}
+
+// PartyListRequest is sent to a static server to list parties. Pages start at 1
+type PartyListRequest struct {
+	ClientID     string `json:"client_id"`
+	JoinableOnly bool   `json:"joinable_only,omitempty"`
+	NameFilter   string `json:"name_filter,omitempty"`
+	Page         int    `json:"page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+}
+
+// MessageType returns PartyListRequest's message type
+func (listRequest PartyListRequest) MessageType() string {
+	return PartyListRequestMessageType
+}
+
+// PartyListPage is sent back to a client asking for a party list
+type PartyListPage struct {
+	Parties  []PartySummary `json:"parties"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int            `json:"total"`
+}
+
+// MessageType returns PartyListPage's message type
+func (listPage PartyListPage) MessageType() string {
+	return PartyListPageMessageType
+}
+
+// NewPartyListPage filters party summaries following a PartyListRequest, sorts them from the newest
+// to the oldest and returns the requested page. Private parties are left out
+func NewPartyListPage(summaries []PartySummary, listRequest PartyListRequest) PartyListPage {
+	pageSize := listRequest.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPartyListPageSize
+	}
+	if pageSize > MaxPartyListPageSize {
+		pageSize = MaxPartyListPageSize
+	}
+	page := listRequest.Page
+	if page <= 0 {
+		page = 1
+	}
+	nameFilter := strings.ToLower(listRequest.NameFilter)
+	filtered := make([]PartySummary, 0, len(summaries))
+	for _, summary := range summaries {
+		if summary.Private {
+			continue
+		}
+		if listRequest.JoinableOnly && !summary.Joinable() {
+			continue
+		}
+		if nameFilter != "" && !strings.Contains(strings.ToLower(summary.PartyName), nameFilter) {
+			continue
+		}
+		filtered = append(filtered, summary)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].CreatedAt.Equal(filtered[j].CreatedAt) {
+			return filtered[i].PartyID < filtered[j].PartyID
+		}
+		return filtered[i].CreatedAt.After(filtered[j].CreatedAt)
+	})
+	listPage := PartyListPage{
+		Parties:  []PartySummary{},
+		Page:     page,
+		PageSize: pageSize,
+		Total:    len(filtered),
+	}
+	start := (page - 1) * pageSize
+	if start >= len(filtered) {
+		return listPage
+	}
+	end := start + pageSize
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+	listPage.Parties = filtered[start:end]
+	return listPage
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewPartyListPage(t *testing.T) {
+	now := time.Now()
+	summaries := []PartySummary{
+		{PartyID: "1", PartyName: "toto party", PlayerCount: 1, Capacity: 8, State: LOBBY, CreatedAt: now.Add(-3 * time.Minute)},
+		{PartyID: "2", PartyName: "Tata party", PlayerCount: 8, Capacity: 8, State: LOBBY, CreatedAt: now.Add(-2 * time.Minute)},
+		{PartyID: "3", PartyName: "titi race", PlayerCount: 2, Capacity: 8, State: RUN, CreatedAt: now.Add(-1 * time.Minute)},
+		{PartyID: "4", PartyName: "tutu party", PlayerCount: 3, Capacity: 8, State: LOBBY, CreatedAt: now},
+		{PartyID: "5", PartyName: "secret party", PlayerCount: 1, Capacity: 8, State: LOBBY, CreatedAt: now, Private: true},
+	}
+	tests := []struct {
+		request  PartyListRequest
+		expected []string
+		total    int
+	}{
+		{PartyListRequest{}, []string{"4", "3", "2", "1"}, 4},
+		{PartyListRequest{JoinableOnly: true}, []string{"4", "1"}, 2},
+		{PartyListRequest{NameFilter: "PARTY"}, []string{"4", "2", "1"}, 3},
+		{PartyListRequest{PageSize: 3, Page: 2}, []string{"1"}, 4},
+		{PartyListRequest{PageSize: 3, Page: 3}, []string{}, 4},
+	}
+	for index, test := range tests {
+		listPage := NewPartyListPage(summaries, test.request)
+		if listPage.Total != test.total {
+			t.Error("test", index, ": expected total", test.total, "got", listPage.Total)
+		}
+		if len(listPage.Parties) != len(test.expected) {
+			t.Error("test", index, ": expected", len(test.expected), "parties, got", len(listPage.Parties))
+			continue
+		}
+		for partyIndex, party := range listPage.Parties {
+			if party.PartyID != test.expected[partyIndex] {
+				t.Error("test", index, ": expected party", test.expected[partyIndex], "at index", strconv.Itoa(partyIndex), "got", party.PartyID)
+			}
+		}
+	}
+}
+
+func TestNewPartyListPage_PageSize(t *testing.T) {
+	summaries := make([]PartySummary, MaxPartyListPageSize+10)
+	listPage := NewPartyListPage(summaries, PartyListRequest{})
+	if listPage.PageSize != DefaultPartyListPageSize || len(listPage.Parties) != DefaultPartyListPageSize {
+		t.Error("expected default page size, got", listPage.PageSize)
+	}
+	listPage = NewPartyListPage(summaries, PartyListRequest{PageSize: 1000})
+	if listPage.PageSize != MaxPartyListPageSize || len(listPage.Parties) != MaxPartyListPageSize {
+		t.Error("expected page size to be capped, got", listPage.PageSize)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"sync"
 	"testing"
 )
 
@@ -57,3 +58,42 @@ func TestNewParty(t *testing.T) {
 	jsonPartyList, err := json.Marshal(partyList)
 	fmt.Println("party list :", string(jsonPartyList))
 }
+
+func TestParty_ConcurrentAccess(t *testing.T) {
+	party, players := newModeratedParty(t, 4)
+	hostID := players[0].PlayerUUID.String()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// handlers add and remove players while the game loop moves and reads them
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			player := NewPlayer("guest")
+			_ = party.AddPlayer(player)
+			_ = party.SetReady(player.PlayerUUID.String(), true)
+			party.VoteState(player.PlayerUUID.String(), PAUSE)
+			if i%2 == 0 {
+				_ = party.Moderate(hostID, Kick, player.PlayerUUID.String())
+				continue
+			}
+			_ = party.RemovePlayer(player)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			party.UpdatePlayers(func(player *Player) {
+				player.Position.CurrentSpeed++
+			})
+			for _, player := range party.PlayerList() {
+				party.IsReady(player.PlayerUUID.String())
+			}
+			party.AllReady()
+			party.SetState(RUN)
+		}
+	}()
+	wg.Wait()
+	if party.PlayerCount() != len(players) {
+		t.Error("expected", len(players), "players, got", party.PlayerCount())
+	}
+}
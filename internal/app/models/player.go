@@ -9,6 +9,20 @@ import (
 	"github.com/google/uuid"
 )
 
+// message types used to dispatch player related messages
+const (
+	// PlayerMessageType is the message type of a Player
+	PlayerMessageType = "player"
+	// PlayerSessionMessageType is the message type of a PlayerSession
+	PlayerSessionMessageType = "player.session"
+	// PlayerCreationTokenMessageType is the message type of a PlayerCreationToken
+	PlayerCreationTokenMessageType = "player.creation_token"
+	// PlayerInputMessageType is the message type of a PlayerInput
+	PlayerInputMessageType = "player.input"
+	// PlayerTokenMessageType is the message type of a PlayerToken
+	PlayerTokenMessageType = "player.token"
+)
+
 // Player represent a game's participant
 type Player struct {
 	PlayerName string          `json:"player_name"`
@@ -17,6 +31,19 @@ type Player struct {
 	Input      *PlayerInput    `json:"input,omitempty"`
 }
 
+// PlayerSession is sent back to a client when its player is created. The session token has to be
+// attached to every message the client sends, until it expires
+type PlayerSession struct {
+	Player       *Player   `json:"player"`
+	SessionToken string    `json:"session_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// MessageType returns PlayerSession's message type
+func (playerSession PlayerSession) MessageType() string {
+	return PlayerSessionMessageType
+}
+
 // PlayerPosition represent a player's position in the race
 type PlayerPosition struct {
 	CurrentSpeed    float64          `json:"current_speed"`
@@ -24,10 +51,12 @@ type PlayerPosition struct {
 	CurrentPosition mathtool.Vector2 `json:"current_position"`
 }
 
-// PlayerCreationToken is issued to server when a client want to be registered server side
+// PlayerCreationToken is issued to server when a client want to be registered server side.
+// PlayerID is set to reclaim a player created in a previous session, and so its profile
 type PlayerCreationToken struct {
 	SessionUUID uuid.UUID `json:"session_uuid"`
 	PlayerName  string    `json:"player_name"`
+	PlayerID    string    `json:"player_id,omitempty"`
 }
 
 // PlayerInput hold player input and is send to the server side in order to be processed
@@ -43,6 +72,28 @@ type PlayerInput struct {
 type PlayerToken struct {
 	ClientID string `json:"client_id"`
 	PartyID  string `json:"party_id"`
+	// Password is only needed to join a party protected by a password
+	Password string `json:"password,omitempty"`
+}
+
+// MessageType returns Player's message type
+func (p Player) MessageType() string {
+	return PlayerMessageType
+}
+
+// MessageType returns PlayerCreationToken's message type
+func (token PlayerCreationToken) MessageType() string {
+	return PlayerCreationTokenMessageType
+}
+
+// MessageType returns PlayerInput's message type
+func (pInput PlayerInput) MessageType() string {
+	return PlayerInputMessageType
+}
+
+// MessageType returns PlayerToken's message type
+func (token PlayerToken) MessageType() string {
+	return PlayerTokenMessageType
 }
 
 // String stringify player position
@@ -77,3 +128,17 @@ func NewPlayer(name string) *Player {
 	player.Input = new(PlayerInput)
 	return player
 }
+
+// copy returns a copy of the player, its position and its input
+func (p *Player) copy() *Player {
+	player := *p
+	if p.Position != nil {
+		position := *p.Position
+		player.Position = &position
+	}
+	if p.Input != nil {
+		input := *p.Input
+		player.Input = &input
+	}
+	return &player
+}
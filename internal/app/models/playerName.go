@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MinPlayerNameLength is the minimum number of characters of a player name
+	MinPlayerNameLength = 3
+	// MaxPlayerNameLength is the maximum number of characters of a player name
+	MaxPlayerNameLength = 20
+)
+
+// PlayerRenamedTopic is the topic where static servers tell dynamic servers a player was renamed
+const PlayerRenamedTopic = "autocar.player.renamed"
+
+// message types used to dispatch player renaming messages
+const (
+	// RenameTokenMessageType is the message type of a RenameToken
+	RenameTokenMessageType = "player.rename_token"
+	// PlayerRenamedMessageType is the message type of a PlayerRenamed
+	PlayerRenamedMessageType = "player.renamed"
+)
+
+// reservedPlayerNameWords can not be a word of a player name, so no player passes for the staff
+var reservedPlayerNameWords = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"autorace":      true,
+	"moderator":     true,
+	"server":        true,
+	"system":        true,
+}
+
+var (
+	// ErrorInvalidPlayerName is returned when a player name is too short, too long or holds forbidden characters
+	ErrorInvalidPlayerName = errors.New("player name must be 3 to 20 letters, digits, spaces, '-', '_' or '.'")
+	// ErrorReservedPlayerName is returned when a player name holds a reserved word
+	ErrorReservedPlayerName = errors.New("player name holds a reserved word")
+	// ErrorPlayerNameTaken is returned when a player name is used by another active player
+	ErrorPlayerNameTaken = errors.New("player name is already taken")
+)
+
+// error codes sent in an ErrorResponse when a player name is refused
+const (
+	// ErrorCodeInvalidPlayerName is sent when a player name is too short, too long or holds forbidden characters
+	ErrorCodeInvalidPlayerName = "invalid_player_name"
+	// ErrorCodeReservedPlayerName is sent when a player name holds a reserved word
+	ErrorCodeReservedPlayerName = "reserved_player_name"
+	// ErrorCodePlayerNameTaken is sent when a player name is used by another active player
+	ErrorCodePlayerNameTaken = "player_name_taken"
+)
+
+// RenameToken is sent by a player to a static server to change its name
+type RenameToken struct {
+	PlayerToken PlayerToken `json:"player_token"`
+	PlayerName  string      `json:"player_name"`
+}
+
+// MessageType returns RenameToken's message type
+func (renameToken RenameToken) MessageType() string {
+	return RenameTokenMessageType
+}
+
+// PlayerRenamed tells dynamic servers a player was renamed. The new name is read from the stored player
+type PlayerRenamed struct {
+	PlayerID string `json:"player_id"`
+}
+
+// MessageType returns PlayerRenamed's message type
+func (playerRenamed PlayerRenamed) MessageType() string {
+	return PlayerRenamedMessageType
+}
+
+// ValidatePlayerName checks a player name and returns it cleaned : surrounding spaces are removed and
+// inner spaces are collapsed. A name is made of letters, digits, spaces, '-', '_' and '.', and none of
+// its words is reserved
+func ValidatePlayerName(name string) (string, error) {
+	if !utf8.ValidString(name) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", ErrorInvalidPlayerName
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	length := utf8.RuneCountInString(name)
+	if length < MinPlayerNameLength || length > MaxPlayerNameLength {
+		return "", ErrorInvalidPlayerName
+	}
+	for _, character := range name {
+		if !unicode.IsLetter(character) && !unicode.IsDigit(character) && !strings.ContainsRune(" -_.", character) {
+			return "", ErrorInvalidPlayerName
+		}
+	}
+	words := strings.FieldsFunc(NormalizePlayerName(name), func(character rune) bool {
+		return strings.ContainsRune(" -_.", character)
+	})
+	for _, word := range words {
+		if reservedPlayerNameWords[word] {
+			return "", ErrorReservedPlayerName
+		}
+	}
+	return name, nil
+}
+
+// NormalizePlayerName returns the form of a valid player name used to compare names, whatever their case
+func NormalizePlayerName(name string) string {
+	return strings.ToLower(name)
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePlayerName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{"toto", "toto", nil},
+		{"  Jean   Pierre ", "Jean Pierre", nil},
+		{"élodie_42", "élodie_42", nil},
+		{"max.power-1", "max.power-1", nil},
+		{"", "", ErrorInvalidPlayerName},
+		{"ab", "", ErrorInvalidPlayerName},
+		{strings.Repeat("a", MaxPlayerNameLength+1), "", ErrorInvalidPlayerName},
+		{strings.Repeat("a", 10*1024), "", ErrorInvalidPlayerName},
+		{"toto\x00", "", ErrorInvalidPlayerName},
+		{"to\nto", "", ErrorInvalidPlayerName},
+		{"toto<script>", "", ErrorInvalidPlayerName},
+		{"\xff\xfe\xfd", "", ErrorInvalidPlayerName},
+		{"Admin", "", ErrorReservedPlayerName},
+		{"the_SERVER", "", ErrorReservedPlayerName},
+		{"serverless", "serverless", nil},
+	}
+	for _, test := range tests {
+		name, err := ValidatePlayerName(test.name)
+		if err != test.err {
+			t.Errorf("%q : expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if name != test.expected {
+			t.Errorf("%q : expected name %q, got %q", test.name, test.expected, name)
+		}
+	}
+}
+
+func TestNormalizePlayerName(t *testing.T) {
+	if NormalizePlayerName("ToTo") != NormalizePlayerName("toto") {
+		t.Error("names differing by their case should be the same once normalized")
+	}
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// DefaultPoolCapacity is the number of parties a dynamic server pool hosts when its capacity is not set
+const DefaultPoolCapacity = 10
+
+// PoolLaunchMessageType is the message type of a PoolLaunch
+const PoolLaunchMessageType = "pool.launch"
+
+// ErrorNoPoolAvailable is returned when no dynamic server pool has a free slot for a new party
+var ErrorNoPoolAvailable = errors.New("no dynamic server pool available")
+
+// PoolLaunch is sent to a dynamic server pool to start a party in one of its slots. The slot is
+// reserved by the static server before the message is sent
+type PoolLaunch struct {
+	PartyID string `json:"party_id"`
+}
+
+// MessageType returns PoolLaunch's message type
+func (poolLaunch PoolLaunch) MessageType() string {
+	return PoolLaunchMessageType
+}
+
+// PoolLaunchTopic returns the topic a dynamic server pool receives PoolLaunch messages on
+func PoolLaunchTopic(poolID string) string {
+	return "autocar.pool." + poolID + ".launch"
+}
+
+// PoolRecord advertises a dynamic server pool, a process hosting many parties. Parties is the
+// number of slots in use
+type PoolRecord struct {
+	PoolID    string    `json:"pool_id"`
+	Host      string    `json:"host"`
+	Capacity  int       `json:"capacity"`
+	Parties   int       `json:"parties"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FreeSlots returns the number of parties the pool can still host
+func (record PoolRecord) FreeSlots() int {
+	if record.Parties >= record.Capacity {
+		return 0
+	}
+	return record.Capacity - record.Parties
+}
+
+// SortPoolsByLoad returns the pools with a free slot, from the least to the most loaded. Load is
+// the share of slots in use, pools with more free slots come first when loads are equal
+func SortPoolsByLoad(records []PoolRecord) []PoolRecord {
+	available := make([]PoolRecord, 0, len(records))
+	for _, record := range records {
+		if record.FreeSlots() > 0 {
+			available = append(available, record)
+		}
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		loadI := float64(available[i].Parties) / float64(available[i].Capacity)
+		loadJ := float64(available[j].Parties) / float64(available[j].Capacity)
+		if loadI != loadJ {
+			return loadI < loadJ
+		}
+		return available[i].FreeSlots() > available[j].FreeSlots()
+	})
+	return available
+}
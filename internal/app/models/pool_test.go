@@ -0,0 +1,26 @@
+package models
+
+import "testing"
+
+func TestSortPoolsByLoad(t *testing.T) {
+	records := []PoolRecord{
+		{PoolID: "full", Capacity: 4, Parties: 4},
+		{PoolID: "half", Capacity: 4, Parties: 2},
+		{PoolID: "big_half", Capacity: 8, Parties: 4},
+		{PoolID: "empty", Capacity: 2, Parties: 0},
+		{PoolID: "broken", Capacity: 0, Parties: 0},
+	}
+	sorted := SortPoolsByLoad(records)
+	expected := []string{"empty", "big_half", "half"}
+	if len(sorted) != len(expected) {
+		t.Fatal("expected", len(expected), "available pools, got", len(sorted))
+	}
+	for index, poolID := range expected {
+		if sorted[index].PoolID != poolID {
+			t.Error("expected pool", poolID, "at index", index, "got", sorted[index].PoolID)
+		}
+	}
+	if len(SortPoolsByLoad(records[:1])) != 0 {
+		t.Error("a full pool should not be available")
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import "errors"
+
+// ErrorUnknownPlayer is returned when a profile is asked for a player who does not exist
+var ErrorUnknownPlayer = errors.New("unknown player")
+
+// ErrorCodeUnknownPlayer is sent in an ErrorResponse when a profile is asked for a player who does not exist
+const ErrorCodeUnknownPlayer = "unknown_player"
+
+// message types used to dispatch player profile messages
+const (
+	// ProfileRequestMessageType is the message type of a ProfileRequest
+	ProfileRequestMessageType = "player.profile_request"
+	// PlayerProfileMessageType is the message type of a PlayerProfile
+	PlayerProfileMessageType = "player.profile"
+)
+
+// ProfileRequest is sent to a static server to get the profile of a player. The profile of the
+// client is sent if PlayerID is not set
+type ProfileRequest struct {
+	ClientID string `json:"client_id"`
+	PlayerID string `json:"player_id,omitempty"`
+}
+
+// MessageType returns ProfileRequest's message type
+func (profileRequest ProfileRequest) MessageType() string {
+	return ProfileRequestMessageType
+}
+
+// PlayerProfile holds the career of a player. It outlives parties and client sessions as long as
+// the player reclaims its UUID. BestLaps binds track IDs to best lap times in seconds
+type PlayerProfile struct {
+	PlayerID      string             `json:"player_id"`
+	PlayerName    string             `json:"player_name"`
+	RacesStarted  int                `json:"races_started"`
+	RacesFinished int                `json:"races_finished"`
+	Wins          int                `json:"wins"`
+	Podiums       int                `json:"podiums"`
+	TotalDistance float64            `json:"total_distance"`
+	BestLaps      map[string]float64 `json:"best_laps"`
+}
+
+// MessageType returns PlayerProfile's message type
+func (profile PlayerProfile) MessageType() string {
+	return PlayerProfileMessageType
+}
+
+// NewPlayerProfile create the empty profile of a player
+func NewPlayerProfile(player *Player) *PlayerProfile {
+	return &PlayerProfile{
+		PlayerID:   player.PlayerUUID.String(),
+		PlayerName: player.PlayerName,
+		BestLaps:   make(map[string]float64),
+	}
+}
+
+// Record adds the result of a race run on a given track to the profile
+func (profile *PlayerProfile) Record(trackID string, result RaceResult) {
+	if result.PlayerName != "" {
+		profile.PlayerName = result.PlayerName
+	}
+	profile.RacesStarted++
+	profile.TotalDistance += result.Distance
+	if result.Finished {
+		profile.RacesFinished++
+		if result.Position == 1 {
+			profile.Wins++
+		}
+		if result.Position <= PodiumSize {
+			profile.Podiums++
+		}
+	}
+	if result.BestLap <= 0 {
+		return
+	}
+	if profile.BestLaps == nil {
+		profile.BestLaps = make(map[string]float64)
+	}
+	if best, ok := profile.BestLaps[trackID]; !ok || result.BestLap < best {
+		profile.BestLaps[trackID] = result.BestLap
+	}
+}
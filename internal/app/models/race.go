@@ -0,0 +1,124 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultLaps is the number of laps of a race when its party does not set it
+	DefaultLaps = 3
+	// MaxLaps is the maximum number of laps of a race
+	MaxLaps = 20
+	// PodiumSize is the number of players on the podium of a race
+	PodiumSize = 3
+)
+
+// LapsOrDefault returns the number of laps of the race
+func (clientToken PartyCreationToken) LapsOrDefault() int {
+	if clientToken.Laps <= 0 {
+		return DefaultLaps
+	}
+	if clientToken.Laps > MaxLaps {
+		return MaxLaps
+	}
+	return clientToken.Laps
+}
+
+// TrackID identifies a racetrack. Racetracks are generated from their configuration, two parties
+// sharing a configuration race on the same racetrack
+func (circuitMC CircuitMapConfig) TrackID() string {
+	return fmt.Sprintf("%d-%d-%d-%gx%g", circuitMC.Seed, circuitMC.MinPoint, circuitMC.MaxPoint, circuitMC.XSize, circuitMC.YSize)
+}
+
+// RaceProgress follows a player around the racetrack during a race. Progress is counted in
+// racetrack points, whatever the direction the player drives in : a lap is completed once the
+// player went a whole racetrack length away from the start of the lap. Times are measured on the
+// race clock, which does not run while the party is paused
+type RaceProgress struct {
+	Laps     int
+	Distance float64
+	BestLap  time.Duration
+	// Position is the finishing position of the player, 0 while racing
+	Position  int
+	lastIndex int
+	progress  int
+	lapStart  time.Duration
+}
+
+// Finished tells if the player crossed the finish line
+func (raceProgress *RaceProgress) Finished() bool {
+	return raceProgress.Position > 0
+}
+
+// Advance moves the player to the racetrack point closest to it at a given race time. It returns
+// true when a lap is completed
+func (raceProgress *RaceProgress) Advance(index, trackLength int, raceTime time.Duration) bool {
+	if trackLength <= 0 || raceProgress.Finished() {
+		return false
+	}
+	delta := index - raceProgress.lastIndex
+	// the shortest way between two points may cross the start line
+	if delta > trackLength/2 {
+		delta -= trackLength
+	}
+	if delta < -trackLength/2 {
+		delta += trackLength
+	}
+	raceProgress.lastIndex = index
+	raceProgress.progress += delta
+	if raceProgress.progress < trackLength && raceProgress.progress > -trackLength {
+		return false
+	}
+	if raceProgress.progress > 0 {
+		raceProgress.progress -= trackLength
+	} else {
+		raceProgress.progress += trackLength
+	}
+	lapTime := raceTime - raceProgress.lapStart
+	if raceProgress.BestLap == 0 || lapTime < raceProgress.BestLap {
+		raceProgress.BestLap = lapTime
+	}
+	raceProgress.lapStart = raceTime
+	raceProgress.Laps++
+	return true
+}
+
+// RaceResult is the outcome of a race for one player. BestLap is in seconds, 0 if no lap was completed
+type RaceResult struct {
+	PlayerID   string  `json:"player_id"`
+	PlayerName string  `json:"player_name"`
+	Finished   bool    `json:"finished"`
+	Position   int     `json:"position"`
+	Laps       int     `json:"laps"`
+	Distance   float64 `json:"distance"`
+	BestLap    float64 `json:"best_lap"`
+}
+
+// Result create the race result of a player from its progress
+func (raceProgress *RaceProgress) Result(player *Player) RaceResult {
+	return RaceResult{
+		PlayerID:   player.PlayerUUID.String(),
+		PlayerName: player.PlayerName,
+		Finished:   raceProgress.Finished(),
+		Position:   raceProgress.Position,
+		Laps:       raceProgress.Laps,
+		Distance:   raceProgress.Distance,
+		BestLap:    raceProgress.BestLap.Seconds(),
+	}
+}
+
+// SortRaceResults sorts race results by finishing position, players who did not finish come last,
+// by completed laps
+func SortRaceResults(results []RaceResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Finished != results[j].Finished {
+			return results[i].Finished
+		}
+		if results[i].Finished {
+			return results[i].Position < results[j].Position
+		}
+		return results[i].Laps > results[j].Laps
+	})
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// driveRacetrack moves a race progress from a racetrack point, one point at a time, forward if step
+// is 1 and backward if it is -1. It returns the number of completed laps
+func driveRacetrack(raceProgress *RaceProgress, from, points, step, trackLength int, raceTime time.Duration) int {
+	laps := 0
+	index := from
+	for point := 0; point < points; point++ {
+		index = (index + step + trackLength) % trackLength
+		if raceProgress.Advance(index, trackLength, raceTime) {
+			laps++
+		}
+	}
+	return laps
+}
+
+func TestRaceProgress_Advance(t *testing.T) {
+	trackLength := 100
+	raceProgress := new(RaceProgress)
+	if laps := driveRacetrack(raceProgress, 0, 99, 1, trackLength, time.Minute); laps != 0 {
+		t.Error("no lap should be completed before the start line, got", laps)
+	}
+	if !raceProgress.Advance(0, trackLength, time.Minute) {
+		t.Fatal("expected a lap once the start line is crossed")
+	}
+	if raceProgress.Laps != 1 || raceProgress.BestLap != time.Minute {
+		t.Error("expected one lap in a minute, got", raceProgress.Laps, "laps in", raceProgress.BestLap)
+	}
+	driveRacetrack(raceProgress, 0, trackLength, -1, trackLength, 90*time.Second)
+	if raceProgress.Laps != 2 || raceProgress.BestLap != 30*time.Second {
+		t.Error("laps driven backward should count, got", raceProgress.Laps, "laps, best in", raceProgress.BestLap)
+	}
+}
+
+func TestRaceProgress_OutAndBack(t *testing.T) {
+	trackLength := 100
+	raceProgress := new(RaceProgress)
+	laps := driveRacetrack(raceProgress, 0, 60, 1, trackLength, time.Minute)
+	laps += driveRacetrack(raceProgress, 60, 60, -1, trackLength, time.Minute)
+	laps += driveRacetrack(raceProgress, 0, 70, -1, trackLength, time.Minute)
+	if laps != 0 || raceProgress.Laps != 0 {
+		t.Error("driving back and forth across the start line should not complete a lap, got", raceProgress.Laps)
+	}
+}
+
+func TestSortRaceResults(t *testing.T) {
+	results := []RaceResult{
+		{PlayerID: "dnf_short", Laps: 1},
+		{PlayerID: "second", Finished: true, Position: 2, Laps: 3},
+		{PlayerID: "dnf_long", Laps: 2},
+		{PlayerID: "first", Finished: true, Position: 1, Laps: 3},
+	}
+	SortRaceResults(results)
+	expected := []string{"first", "second", "dnf_long", "dnf_short"}
+	for index, playerID := range expected {
+		if results[index].PlayerID != playerID {
+			t.Error("expected", playerID, "at index", index, "got", results[index].PlayerID)
+		}
+	}
+}
+
+func TestPlayerProfile_Record(t *testing.T) {
+	profile := NewPlayerProfile(NewPlayer("toto"))
+	profile.Record("track", RaceResult{PlayerName: "toto", Finished: true, Position: 1, Distance: 100, BestLap: 42})
+	profile.Record("track", RaceResult{PlayerName: "toto", Finished: true, Position: 3, Distance: 50, BestLap: 40})
+	profile.Record("other", RaceResult{PlayerName: "titi", Distance: 10})
+	if profile.RacesStarted != 3 || profile.RacesFinished != 2 || profile.Wins != 1 || profile.Podiums != 2 {
+		t.Error("unexpected race counts", profile)
+	}
+	if profile.TotalDistance != 160 {
+		t.Error("expected a total distance of 160, got", profile.TotalDistance)
+	}
+	if profile.BestLaps["track"] != 40 {
+		t.Error("expected the best lap to be kept, got", profile.BestLaps["track"])
+	}
+	if _, ok := profile.BestLaps["other"]; ok {
+		t.Error("a race without any lap should not set a best lap")
+	}
+	if profile.PlayerName != "titi" {
+		t.Error("expected the profile to follow the player name, got", profile.PlayerName)
+	}
+}
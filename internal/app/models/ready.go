@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// DefaultMinPlayers is the number of players needed to start the auto-start timer of a party
+const DefaultMinPlayers = 2
+
+// message types used to dispatch ready-check messages
+const (
+	// ReadyTokenMessageType is the message type of a ReadyToken
+	ReadyTokenMessageType = "party.ready_token"
+)
+
+// ReadyToken is sent by a player in a party lobby to toggle its ready flag
+type ReadyToken struct {
+	PlayerToken PlayerToken `json:"player_token"`
+	Ready       bool        `json:"ready"`
+}
+
+// MessageType returns ReadyToken's message type
+func (readyToken ReadyToken) MessageType() string {
+	return ReadyTokenMessageType
+}
+
+// MinPlayersOrDefault returns the number of players needed to start the auto-start timer
+func (clientToken PartyCreationToken) MinPlayersOrDefault() int {
+	if clientToken.MinPlayers <= 0 {
+		return DefaultMinPlayers
+	}
+	if clientToken.MinPlayers > clientToken.Capacity() {
+		return clientToken.Capacity()
+	}
+	return clientToken.MinPlayers
+}
+
+// SetReady change the ready flag of a player. Ready flags can only be changed in the lobby
+func (party *Party) SetReady(playerID string, ready bool) error {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	if _, ok := party.Players[playerID]; !ok {
+		return ErrorPlayerNotFound
+	}
+	if party.state != LOBBY {
+		return ErrorPartyStarted
+	}
+	if party.ready == nil {
+		party.ready = make(map[string]bool)
+	}
+	party.ready[playerID] = ready
+	return nil
+}
+
+// IsReady tells if a player is ready to race
+func (party *Party) IsReady(playerID string) bool {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	return party.ready[playerID]
+}
+
+// AllReady tells if every player of a party is ready, once the party has at least MinPlayers players.
+// A host alone in a lobby does not start the party by being ready
+func (party *Party) AllReady() bool {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	if len(party.Players) == 0 || len(party.Players) < party.MinPlayers {
+		return false
+	}
+	for playerID := range party.Players {
+		if !party.ready[playerID] {
+			return false
+		}
+	}
+	return true
+}
+
+// AutoStartDelay returns the duration of the auto-start timer, and false if the timer should not
+// be running : the timer is disabled, the party left its lobby or there are not enough players
+func (party *Party) AutoStartDelay() (time.Duration, bool) {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	if party.AutoStartAfter <= 0 || party.state != LOBBY || len(party.Players) < party.MinPlayers {
+		return 0, false
+	}
+	return time.Duration(party.AutoStartAfter) * time.Second, true
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParty_SetReady(t *testing.T) {
+	party, players := newModeratedParty(t, 2)
+	firstID := players[0].PlayerUUID.String()
+	secondID := players[1].PlayerUUID.String()
+	if party.SetReady("unknown", true) != ErrorPlayerNotFound {
+		t.Error("unknown player should not be ready")
+	}
+	_ = party.SetReady(firstID, true)
+	if !party.IsReady(firstID) || party.AllReady() {
+		t.Error("expected only the first player to be ready")
+	}
+	_ = party.SetReady(secondID, true)
+	if !party.AllReady() {
+		t.Error("expected every player to be ready")
+	}
+	_ = party.RemovePlayer(players[1])
+	_ = party.AddPlayer(players[1])
+	if party.IsReady(secondID) {
+		t.Error("ready flag should be dropped when a player leaves")
+	}
+	party.SetState(RUN)
+	if party.SetReady(secondID, true) != ErrorPartyStarted {
+		t.Error("ready flags should only change in the lobby")
+	}
+}
+
+func TestParty_AllReadySoloLobby(t *testing.T) {
+	party, players := newModeratedParty(t, 1)
+	hostID := players[0].PlayerUUID.String()
+	_ = party.SetReady(hostID, true)
+	if party.AllReady() {
+		t.Error("a host alone in the lobby should not start the party by being ready")
+	}
+	second := NewPlayer("second")
+	_ = party.AddPlayer(second)
+	_ = party.SetReady(second.PlayerUUID.String(), true)
+	if !party.AllReady() {
+		t.Error("expected every player to be ready once the minimum number of players joined")
+	}
+}
+
+func TestParty_AutoStartDelay(t *testing.T) {
+	party, players := newModeratedParty(t, 1)
+	if _, ok := party.AutoStartDelay(); ok {
+		t.Error("auto-start timer should be disabled by default")
+	}
+	party.AutoStartAfter = 30
+	if _, ok := party.AutoStartDelay(); ok {
+		t.Error("auto-start timer should wait for the minimum number of players")
+	}
+	_ = party.AddPlayer(NewPlayer("second"))
+	delay, ok := party.AutoStartDelay()
+	if !ok || delay != 30*time.Second {
+		t.Error("expected a 30 seconds auto-start timer, got", delay, ok)
+	}
+	_ = party.RemovePlayer(players[0])
+	if _, ok = party.AutoStartDelay(); ok {
+		t.Error("auto-start timer should stop when players leave")
+	}
+}
+
+func TestPartyCreationToken_MinPlayersOrDefault(t *testing.T) {
+	if min := (PartyCreationToken{}).MinPlayersOrDefault(); min != DefaultMinPlayers {
+		t.Error("expected default min players, got", min)
+	}
+	if min := (PartyCreationToken{MinPlayers: 4}).MinPlayersOrDefault(); min != 4 {
+		t.Error("expected 4 min players, got", min)
+	}
+	if min := (PartyCreationToken{MaxPlayers: 3, MinPlayers: 5}).MinPlayersOrDefault(); min != 3 {
+		t.Error("min players should not exceed capacity, got", min)
+	}
+}
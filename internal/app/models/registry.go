@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// HealthyTickRatio is the share of its expected tick rate a dynamic server has to reach to be healthy
+const HealthyTickRatio = 0.9
+
+var (
+	// ErrorLaunchFailed is returned when the dynamic server of a party exited before registering
+	ErrorLaunchFailed = errors.New("dynamic server failed to start")
+	// ErrorLaunchTimeout is returned when the dynamic server of a party did not register in time
+	ErrorLaunchTimeout = errors.New("dynamic server did not start in time")
+)
+
+// error codes sent in an ErrorResponse when the dynamic server of a party could not be started
+const (
+	// ErrorCodeLaunchFailed is sent when the dynamic server of a party exited before registering
+	ErrorCodeLaunchFailed = "launch_failed"
+	// ErrorCodeLaunchTimeout is sent when the dynamic server of a party did not register in time
+	ErrorCodeLaunchTimeout = "launch_timeout"
+)
+
+// ServerRecord describes a running dynamic server in the server registry. It is written by the
+// dynamic server on startup, then refreshed with every heartbeat. TickRate is the number of ticks
+// run during the last second, 0 until the game loop ran for a second. CreatorID is the player who
+// created the party
+type ServerRecord struct {
+	PartyID      string    `json:"party_id"`
+	CreatorID    string    `json:"creator_id,omitempty"`
+	Host         string    `json:"host"`
+	TickRate     uint      `json:"tick_rate"`
+	TickExpected uint      `json:"tick_expected"`
+	PlayerCount  int       `json:"player_count"`
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Healthy tells if the dynamic server runs its game loop fast enough. A server which did not
+// measure its tick rate yet is healthy
+func (record ServerRecord) Healthy() bool {
+	if record.TickRate == 0 {
+		return true
+	}
+	return float64(record.TickRate) >= HealthyTickRatio*float64(record.TickExpected)
+}
+
+// LaunchRecord tells how the dynamic server of a party was started, so it can be inspected or killed.
+// InstanceID depends on the launcher : a container ID, a process ID, a Job name or a pool ID. Host is
+// the host of the static server which launched it, where a process runs
+type LaunchRecord struct {
+	PartyID    string    `json:"party_id"`
+	CreatorID  string    `json:"creator_id"`
+	Launcher   string    `json:"launcher"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	LaunchedAt time.Time `json:"launched_at"`
+}
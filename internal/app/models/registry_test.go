@@ -0,0 +1,22 @@
+package models
+
+import "testing"
+
+func TestServerRecord_Healthy(t *testing.T) {
+	tests := []struct {
+		tickRate uint
+		expected bool
+	}{
+		{0, true},
+		{120, true},
+		{108, true},
+		{107, false},
+		{30, false},
+	}
+	for _, test := range tests {
+		record := ServerRecord{TickRate: test.tickRate, TickExpected: 120}
+		if healthy := record.Healthy(); healthy != test.expected {
+			t.Errorf("expected healthy to be %v at %d ticks per second", test.expected, test.tickRate)
+		}
+	}
+}
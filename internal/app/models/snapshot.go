@@ -0,0 +1,121 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PartySnapshot is a checkpoint of a party stored by its dynamic server. A dynamic server started for
+// a party which has a snapshot resumes the party from it instead of creating it. The racetrack is
+// part of the snapshot since it is generated randomly. Resumes counts how many times the party was
+// resumed
+type PartySnapshot struct {
+	PartyID       string                   `json:"party_id"`
+	Configuration PartyCreationToken       `json:"configuration"`
+	MapCircuit    PartyMap                 `json:"map_circuit"`
+	State         State                    `json:"state"`
+	HostID        string                   `json:"host_id"`
+	Players       []*Player                `json:"players"`
+	Ready         []string                 `json:"ready,omitempty"`
+	Banned        []string                 `json:"banned,omitempty"`
+	Race          map[string]*RaceProgress `json:"race,omitempty"`
+	ClosestPoints map[string]int           `json:"closest_points,omitempty"`
+	RaceClock     time.Duration            `json:"race_clock"`
+	Finishers     int                      `json:"finishers"`
+	Results       []RaceResult             `json:"results,omitempty"`
+	Tick          uint64                   `json:"tick"`
+	Resumes       int                      `json:"resumes"`
+	TakenAt       time.Time                `json:"taken_at"`
+}
+
+// Snapshot returns the state of the party and a copy of its players, from the oldest to the newest one.
+// It is taken while the party can not be modified, so it can be stored while the party goes on
+func (party *Party) Snapshot() PartySnapshot {
+	party.mutex.RLock()
+	defer party.mutex.RUnlock()
+	snapshot := PartySnapshot{
+		PartyID:    party.PartyUUID.String(),
+		MapCircuit: party.MapCircuit,
+		State:      party.state,
+		HostID:     party.HostID,
+	}
+	for _, playerID := range party.joinOrder {
+		player, ok := party.Players[playerID]
+		if !ok {
+			continue
+		}
+		snapshot.Players = append(snapshot.Players, player.copy())
+		if party.ready[playerID] {
+			snapshot.Ready = append(snapshot.Ready, playerID)
+		}
+	}
+	for playerID := range party.banned {
+		snapshot.Banned = append(snapshot.Banned, playerID)
+	}
+	return snapshot
+}
+
+// Restore brings the party back to the state of a snapshot. Pending votes are dropped
+func (party *Party) Restore(snapshot PartySnapshot) {
+	party.mutex.Lock()
+	defer party.mutex.Unlock()
+	party.MapCircuit = snapshot.MapCircuit
+	party.removeAllPlayer()
+	for _, player := range snapshot.Players {
+		// a snapshot does not hold the same player twice
+		_ = party.addPlayer(player)
+	}
+	for _, playerID := range snapshot.Ready {
+		party.ready[playerID] = true
+	}
+	party.banned = make(map[string]bool)
+	for _, playerID := range snapshot.Banned {
+		party.banned[playerID] = true
+	}
+	party.HostID = snapshot.HostID
+	party.setState(snapshot.State)
+}
+
+// raceProgressJSON is the stored form of a RaceProgress, with the fields needed to follow the player
+// after a resume
+type raceProgressJSON struct {
+	Laps      int           `json:"laps"`
+	Distance  float64       `json:"distance"`
+	BestLap   time.Duration `json:"best_lap"`
+	Position  int           `json:"position"`
+	LastIndex int           `json:"last_index"`
+	Progress  int           `json:"progress"`
+	LapStart  time.Duration `json:"lap_start"`
+}
+
+// MarshalJSON encodes the whole progress of a player, unexported fields included
+func (raceProgress RaceProgress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(raceProgressJSON{
+		Laps:      raceProgress.Laps,
+		Distance:  raceProgress.Distance,
+		BestLap:   raceProgress.BestLap,
+		Position:  raceProgress.Position,
+		LastIndex: raceProgress.lastIndex,
+		Progress:  raceProgress.progress,
+		LapStart:  raceProgress.lapStart,
+	})
+}
+
+// UnmarshalJSON decodes a progress encoded by MarshalJSON
+func (raceProgress *RaceProgress) UnmarshalJSON(data []byte) error {
+	var stored raceProgressJSON
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+	*raceProgress = RaceProgress{
+		Laps:      stored.Laps,
+		Distance:  stored.Distance,
+		BestLap:   stored.BestLap,
+		Position:  stored.Position,
+		lastIndex: stored.LastIndex,
+		progress:  stored.Progress,
+		lapStart:  stored.LapStart,
+	}
+	return nil
+}
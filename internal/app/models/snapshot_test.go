@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParty_SnapshotRestore(t *testing.T) {
+	party, players := newModeratedParty(t, 4)
+	hostID := players[0].PlayerUUID.String()
+	bannedID := players[1].PlayerUUID.String()
+	if err := party.Moderate(hostID, Ban, bannedID); err != nil {
+		t.Fatal("unable to ban player :", err)
+	}
+	if err := party.SetReady(players[3].PlayerUUID.String(), true); err != nil {
+		t.Fatal("unable to set ready flag :", err)
+	}
+	party.MapCircuit.TurnPoints = []TurnPoint{{}, {}, {}}
+	party.SetState(RUN)
+	stored, err := json.Marshal(party.Snapshot())
+	if err != nil {
+		t.Fatal("unable to marshal snapshot :", err)
+	}
+	var snapshot PartySnapshot
+	err = json.Unmarshal(stored, &snapshot)
+	if err != nil {
+		t.Fatal("unable to unmarshal snapshot :", err)
+	}
+	resumed, err := NewParty(PartyCreationToken{ClientID: hostID}, party.PartyUUID.String())
+	if err != nil {
+		t.Fatal("unable to create party :", err)
+	}
+	resumed.Restore(snapshot)
+	if resumed.GetState() != RUN || resumed.HostID != hostID || len(resumed.MapCircuit.TurnPoints) != 3 {
+		t.Error("unexpected resumed party :", resumed.GetState(), resumed.HostID, len(resumed.MapCircuit.TurnPoints))
+	}
+	if len(resumed.Players) != 3 || !resumed.IsBanned(bannedID) {
+		t.Error("expected 3 players and a banned one, got", len(resumed.Players), resumed.IsBanned(bannedID))
+	}
+	if !resumed.IsReady(players[3].PlayerUUID.String()) || resumed.IsReady(players[2].PlayerUUID.String()) {
+		t.Error("expected ready flags to be restored")
+	}
+	// the oldest remaining player becomes host, as before the snapshot
+	err = resumed.RemovePlayer(players[0])
+	if err != nil || resumed.HostID != players[2].PlayerUUID.String() {
+		t.Error("expected join order to be restored, host is", resumed.HostID, err)
+	}
+}
+
+func TestRaceProgress_JSON(t *testing.T) {
+	trackLength := 100
+	raceProgress := new(RaceProgress)
+	driveRacetrack(raceProgress, 0, 130, 1, trackLength, time.Minute)
+	stored, err := json.Marshal(map[string]*RaceProgress{uuid.New().String(): raceProgress})
+	if err != nil {
+		t.Fatal("unable to marshal race progress :", err)
+	}
+	var restored map[string]*RaceProgress
+	err = json.Unmarshal(stored, &restored)
+	if err != nil {
+		t.Fatal("unable to unmarshal race progress :", err)
+	}
+	for _, resumed := range restored {
+		if *resumed != *raceProgress {
+			t.Fatalf("expected %+v, got %+v", *raceProgress, *resumed)
+		}
+		// both progresses complete their second lap on the same point
+		expected := driveRacetrack(raceProgress, 30, 70, 1, trackLength, 2*time.Minute)
+		if laps := driveRacetrack(resumed, 30, 70, 1, trackLength, 2*time.Minute); laps != expected || laps != 1 {
+			t.Error("expected resumed progress to complete a lap like the original one, got", laps, expected)
+		}
+	}
+}
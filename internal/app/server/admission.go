@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+)
+
+// default limits of an AdmissionPolicy
+const (
+	// DefaultMaxOpenParties is the number of open parties a player can have created when PLAYER_MAX_OPEN_PARTIES is not set
+	DefaultMaxOpenParties = 2
+	// DefaultPartyCreations is the number of parties a player can create within a window when PLAYER_PARTY_CREATIONS is not set
+	DefaultPartyCreations = 5
+	// DefaultCreationWindow is the window of PLAYER_PARTY_CREATIONS when PLAYER_PARTY_CREATION_WINDOW is not set
+	DefaultCreationWindow = time.Minute
+)
+
+// AdmissionPolicy limits party creations, so clients can not start dynamic servers without limit.
+// Limits are shared by every static server through Redis, a limit of 0 disables it
+type AdmissionPolicy struct {
+	// MaxParties is the number of parties running at once
+	MaxParties int
+	// MaxOpenParties is the number of open parties created by a single player
+	MaxOpenParties int
+	// Creations is the number of parties a single player can create within CreationWindow
+	Creations      int
+	CreationWindow time.Duration
+}
+
+// NewAdmissionPolicyFromEnv create an AdmissionPolicy from the environment :
+//   - MAX_PARTIES, unlimited if it is not set
+//   - PLAYER_MAX_OPEN_PARTIES, DefaultMaxOpenParties if it is not set
+//   - PLAYER_PARTY_CREATIONS, DefaultPartyCreations if it is not set
+//   - PLAYER_PARTY_CREATION_WINDOW, a Go duration, DefaultCreationWindow if it is not set
+func NewAdmissionPolicyFromEnv() (AdmissionPolicy, error) {
+	policy := AdmissionPolicy{CreationWindow: DefaultCreationWindow}
+	limits := []struct {
+		variable     string
+		limit        *int
+		defaultLimit int
+	}{
+		{"MAX_PARTIES", &policy.MaxParties, 0},
+		{"PLAYER_MAX_OPEN_PARTIES", &policy.MaxOpenParties, DefaultMaxOpenParties},
+		{"PLAYER_PARTY_CREATIONS", &policy.Creations, DefaultPartyCreations},
+	}
+	for _, limit := range limits {
+		rawLimit := os.Getenv(limit.variable)
+		if rawLimit == "" {
+			*limit.limit = limit.defaultLimit
+			continue
+		}
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 0 {
+			return policy, fmt.Errorf("%s : invalid limit \"%s\"", limit.variable, rawLimit)
+		}
+		*limit.limit = parsed
+	}
+	if rawWindow := os.Getenv("PLAYER_PARTY_CREATION_WINDOW"); rawWindow != "" {
+		window, err := time.ParseDuration(rawWindow)
+		if err != nil || window <= 0 {
+			return policy, fmt.Errorf("PLAYER_PARTY_CREATION_WINDOW : invalid duration \"%s\"", rawWindow)
+		}
+		policy.CreationWindow = window
+	}
+	return policy, nil
+}
+
+// countCreation counts a party creation of a player. It returns models.ErrorCreationRateLimited and how
+// long the player has to wait when it created too many parties within the creation window
+func (staticServer *StaticServer) countCreation(playerID string) (time.Duration, error) {
+	if staticServer.admission.Creations == 0 {
+		return 0, nil
+	}
+	creations, remaining, err := staticServer.redisConnection.CountPartyCreation(playerID, staticServer.admission.CreationWindow)
+	if err != nil {
+		return 0, err
+	}
+	if creations > staticServer.admission.Creations {
+		return remaining, models.ErrorCreationRateLimited
+	}
+	return 0, nil
+}
+
+// isAdmissionRefusal tells if a party creation was refused by admission control
+func isAdmissionRefusal(err error) bool {
+	return errors.Is(err, models.ErrorPartyLimitReached) ||
+		errors.Is(err, models.ErrorOpenPartyLimitReached) ||
+		errors.Is(err, models.ErrorCreationRateLimited)
+}
+
+// admissionRefusal create the error sent back to a player whose party creation was refused by admission
+// control, with the delay to wait before trying again if it is known
+func admissionRefusal(err error, retryAfter time.Duration) messaging.ErrorResponse {
+	response := refusalResponse(err)
+	if retryAfter > 0 {
+		response.ErrorMessage += fmt.Sprintf(" : retry in %s", retryAfter.Round(time.Second))
+	}
+	return response
+}
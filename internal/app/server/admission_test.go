@@ -0,0 +1,69 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+)
+
+func TestNewAdmissionPolicyFromEnv(t *testing.T) {
+	variables := []string{"MAX_PARTIES", "PLAYER_MAX_OPEN_PARTIES", "PLAYER_PARTY_CREATIONS", "PLAYER_PARTY_CREATION_WINDOW"}
+	for _, variable := range variables {
+		os.Setenv(variable, "")
+		defer os.Unsetenv(variable)
+	}
+	policy, err := NewAdmissionPolicyFromEnv()
+	if err != nil {
+		t.Fatal("unable to read admission policy :", err)
+	}
+	expected := AdmissionPolicy{
+		MaxOpenParties: DefaultMaxOpenParties,
+		Creations:      DefaultPartyCreations,
+		CreationWindow: DefaultCreationWindow,
+	}
+	if policy != expected {
+		t.Error("expected default policy", expected, "got", policy)
+	}
+	os.Setenv("MAX_PARTIES", "50")
+	os.Setenv("PLAYER_MAX_OPEN_PARTIES", "0")
+	os.Setenv("PLAYER_PARTY_CREATION_WINDOW", "30s")
+	policy, err = NewAdmissionPolicyFromEnv()
+	if err != nil {
+		t.Fatal("unable to read admission policy :", err)
+	}
+	if policy.MaxParties != 50 || policy.MaxOpenParties != 0 || policy.CreationWindow != 30*time.Second {
+		t.Error("unexpected policy", policy)
+	}
+	tests := []struct {
+		variable, value string
+	}{
+		{"MAX_PARTIES", "-1"},
+		{"PLAYER_PARTY_CREATIONS", "many"},
+		{"PLAYER_PARTY_CREATION_WINDOW", "0s"},
+	}
+	for _, test := range tests {
+		os.Setenv(test.variable, test.value)
+		_, err = NewAdmissionPolicyFromEnv()
+		if err == nil {
+			t.Error("expected an error with", test.variable, "=", test.value)
+		}
+		os.Setenv(test.variable, "")
+	}
+}
+
+func TestAdmissionRefusal(t *testing.T) {
+	response := admissionRefusal(models.ErrorCreationRateLimited, 41600*time.Millisecond)
+	if response.Code != models.ErrorCodeCreationRateLimited || !strings.HasSuffix(response.ErrorMessage, "retry in 42s") {
+		t.Error("unexpected refusal", response)
+	}
+	response = admissionRefusal(models.ErrorPartyLimitReached, 0)
+	if response.Code != models.ErrorCodePartyLimitReached || response.ErrorMessage != models.ErrorPartyLimitReached.Error() {
+		t.Error("unexpected refusal", response)
+	}
+	if isAdmissionRefusal(models.ErrorLaunchFailed) || !isAdmissionRefusal(models.ErrorOpenPartyLimitReached) {
+		t.Error("only admission control errors are admission refusals")
+	}
+}
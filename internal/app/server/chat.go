@@ -0,0 +1,106 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+)
+
+const (
+	// chatBurst is the number of chat messages a player can send in a row
+	chatBurst = 5
+	// chatRefillInterval is the time needed to send one more chat message once the burst is spent
+	chatRefillInterval = 2 * time.Second
+)
+
+// chatBucket is the rate limit state of one player
+type chatBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// chatRelay cleans and stamps chat messages before they are relayed. Players sending too many
+// messages are rate limited, the rate limit of a chatRelay only holds within one process
+type chatRelay struct {
+	filter       *models.ChatFilter
+	bucketsMutex sync.Mutex
+	buckets      map[string]*chatBucket
+}
+
+// newChatRelay create a chatRelay masking words listed in CHAT_BANNED_WORDS, separated by commas
+func newChatRelay() *chatRelay {
+	var bannedWords []string
+	if words := os.Getenv("CHAT_BANNED_WORDS"); words != "" {
+		bannedWords = strings.Split(words, ",")
+	}
+	return &chatRelay{
+		filter:  models.NewChatFilter(bannedWords),
+		buckets: make(map[string]*chatBucket),
+	}
+}
+
+// allow tells if a player can send a chat message now
+func (relay *chatRelay) allow(playerID string, now time.Time) bool {
+	relay.bucketsMutex.Lock()
+	defer relay.bucketsMutex.Unlock()
+	bucket, ok := relay.buckets[playerID]
+	if !ok {
+		bucket = &chatBucket{tokens: chatBurst, last: now}
+		relay.buckets[playerID] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() / chatRefillInterval.Seconds()
+	if bucket.tokens > chatBurst {
+		bucket.tokens = chatBurst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// forget drops the rate limit state of a player
+func (relay *chatRelay) forget(playerID string) {
+	relay.bucketsMutex.Lock()
+	defer relay.bucketsMutex.Unlock()
+	delete(relay.buckets, playerID)
+}
+
+// stamp turns a chat token into the chat message relayed to players, once its sender is allowed to send it
+func (relay *chatRelay) stamp(channel string, chatToken models.ChatToken, senderName string, now time.Time) (models.ChatMessage, error) {
+	if !relay.allow(chatToken.PlayerToken.ClientID, now) {
+		return models.ChatMessage{}, models.ErrorChatRateLimited
+	}
+	return relay.compose(channel, chatToken, senderName, now)
+}
+
+// compose turns a chat token into the chat message relayed to players, without rate limit
+func (relay *chatRelay) compose(channel string, chatToken models.ChatToken, senderName string, now time.Time) (models.ChatMessage, error) {
+	text, err := relay.filter.Clean(chatToken.Text)
+	if err != nil {
+		return models.ChatMessage{}, err
+	}
+	return models.ChatMessage{
+		Channel:    channel,
+		SenderID:   chatToken.PlayerToken.ClientID,
+		SenderName: senderName,
+		Text:       text,
+		SentAt:     now,
+	}, nil
+}
+
+// chatErrorResponse create the error sent back to a player whose chat message was refused
+func chatErrorResponse(err error) messaging.ErrorResponse {
+	switch err {
+	case models.ErrorChatRateLimited:
+		return messaging.ErrorResponse{Code: models.ErrorCodeChatRateLimited, ErrorMessage: err.Error()}
+	case models.ErrorChatEmpty:
+		return messaging.ErrorResponse{Code: models.ErrorCodeChatEmpty, ErrorMessage: err.Error()}
+	}
+	return refusalResponse(err)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+)
+
+func TestChatRelay_Allow(t *testing.T) {
+	relay := newChatRelay()
+	now := time.Now()
+	for index := 0; index < chatBurst; index++ {
+		if !relay.allow("player_1", now) {
+			t.Fatal("message", index, "should be allowed during the burst")
+		}
+	}
+	if relay.allow("player_1", now) {
+		t.Error("message should be refused once the burst is spent")
+	}
+	if !relay.allow("player_2", now) {
+		t.Error("other players should not be rate limited")
+	}
+	if !relay.allow("player_1", now.Add(chatRefillInterval)) {
+		t.Error("message should be allowed once refilled")
+	}
+	if relay.allow("player_1", now.Add(chatRefillInterval)) {
+		t.Error("only one message should be refilled")
+	}
+	relay.forget("player_1")
+	if !relay.allow("player_1", now.Add(chatRefillInterval)) {
+		t.Error("forgotten player should get a full burst")
+	}
+}
+
+func TestChatRelay_Stamp(t *testing.T) {
+	relay := newChatRelay()
+	now := time.Now()
+	chatToken := models.ChatToken{PlayerToken: models.PlayerToken{ClientID: "player_1"}, Text: " hi "}
+	chatMessage, err := relay.stamp(models.ChatPartyChannel, chatToken, "toto", now)
+	if err != nil {
+		t.Fatal("unexpected error :", err)
+	}
+	if chatMessage.Text != "hi" || chatMessage.SenderName != "toto" || chatMessage.SenderID != "player_1" || !chatMessage.SentAt.Equal(now) {
+		t.Error("unexpected chat message", chatMessage)
+	}
+	chatToken.Text = ""
+	_, err = relay.stamp(models.ChatPartyChannel, chatToken, "toto", now)
+	if chatErrorResponse(err).Code != models.ErrorCodeChatEmpty {
+		t.Error("expected an empty chat error, got", err)
+	}
+}
@@ -1,52 +1,140 @@
 package server
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/streadway/amqp"
 
 	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/auth"
 	"github.com/clnbs/autorace/internal/pkg/database"
 	"github.com/clnbs/autorace/internal/pkg/messaging"
 	"github.com/clnbs/autorace/pkg/logger"
 	"github.com/clnbs/autorace/pkg/systool"
 )
 
-// SyncMessageContent are send to every players every server's tick
+// ErrorSessionSecretRequired is returned when a server sharing session tokens with other processes is
+// started without SESSION_SECRET nor SESSION_SECRET_FILE : a generated secret is only known by its process
+var ErrorSessionSecretRequired = errors.New("SESSION_SECRET or SESSION_SECRET_FILE must be set, every server has to share the session secret")
+
+// outboundQueueSize is the number of messages a dynamic server can queue while the broker is slow
+const outboundQueueSize = 1024
+
+// EndGracePeriod is how long the results of a race are still sent to players once the party ended
+const EndGracePeriod = 10 * time.Second
+
+// heartbeatInterval is the period between two heartbeats of a dynamic server, it must be less than
+// database.PartyHeartbeatTTL
+const heartbeatInterval = 10 * time.Second
+
+// SyncMessageContentMessageType is the message type of a SyncMessageContent
+const SyncMessageContentMessageType = "party.sync"
+
+// SyncMessageContent are send to every players every server's tick. AutoStartAt is set while the
+// auto-start timer of the lobby is running, Results are set once the race ended
 type SyncMessageContent struct {
-	PartyState  models.State `json:"party_state"`
+	PartyState  models.State        `json:"party_state"`
+	HostID      string              `json:"host_id"`
+	AutoStartAt *time.Time          `json:"auto_start_at,omitempty"`
+	Results     []models.RaceResult `json:"results,omitempty"`
 	Competitors []*models.CompetitorActor
 	MainActor   *models.MainActor
 }
 
+// MessageType returns SyncMessageContent's message type
+func (syncMessage SyncMessageContent) MessageType() string {
+	return SyncMessageContentMessageType
+}
+
 // DynamicPartyServer hold logic to run a party from the generation of the racetrack to the end of it.
 // DynamicPartyServer also hold connection with clients.
 type DynamicPartyServer struct {
 	rabbitConnection           *messaging.RabbitConnection
 	redisConnection            *database.RedisClient
 	party                      *models.Party
+	positionMutex              sync.Mutex
 	closestRacetrackPointIndex map[string]int
 	tickPerSecond              uint
+	done                       chan struct{}
+	deregisterOnce             sync.Once
+	autoStartMutex             sync.Mutex
+	autoStartTimer             *time.Timer
+	autoStartAt                time.Time
+	chat                       *chatRelay
+	raceMutex                  sync.Mutex
+	race                       map[string]*models.RaceProgress
+	raceClock                  time.Duration
+	finishers                  int
+	raceResults                []models.RaceResult
+	sessions                   *auth.SessionSigner
+	host                       string
+	startedAt                  time.Time
+	tickRateMutex              sync.Mutex
+	tickRate                   uint
+	endGrace                   time.Duration
+	publishLobbyEvent          func(lobbyEvent models.LobbyEvent)
+	partyConfiguration         models.PartyCreationToken
+	ticks                      uint64
+	resumes                    int
 }
 
 // NewDynamicPartyServer create a DynamicPartyServer instance.
 // NewDynamicPartyServer generate a party from a stored party configuration in a Redis database
-// and send it back to the player who ask for its creation.
+// and send it back to the player who ask for its creation. If the party has a snapshot, the party
+// is resumed from it instead.
 func NewDynamicPartyServer(partyID string, rabbitConfig messaging.RabbitConnectionConfiguration) (*DynamicPartyServer, error) {
 	dServer := new(DynamicPartyServer)
 	dServer.tickPerSecond = 120
 	dServer.closestRacetrackPointIndex = make(map[string]int)
+	dServer.done = make(chan struct{})
+	dServer.chat = newChatRelay()
+	dServer.startedAt = time.Now()
+	dServer.endGrace = EndGracePeriod
+	dServer.publishLobbyEvent = func(lobbyEvent models.LobbyEvent) {
+		dServer.rabbitConnection.SendMessageOnLobbies(lobbyEvent, lobbyEvent.Topic())
+	}
+	// launchers always hand the secret of the static server over
+	if !auth.HasSecretInEnv() {
+		return nil, ErrorSessionSecretRequired
+	}
 	var err error
+	dServer.host, err = os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	dServer.sessions, err = auth.NewSessionSignerFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	dServer.rabbitConnection, err = messaging.NewRabbitConnection(rabbitConfig)
 	if err != nil {
 		return nil, err
 	}
+	dServer.rabbitConnection.SenderID = "dynamic." + partyID
+	// sync messages are sent every tick, old ones are useless when the broker is slow
+	dServer.rabbitConnection.EnableOutboundQueue(outboundQueueSize, messaging.DropOldest)
 	dServer.redisConnection = database.NewRedisClient()
+	snapshot, err := dServer.redisConnection.GetPartySnapshot(partyID)
+	switch {
+	case err == nil && snapshot.State != models.END:
+		err = dServer.resume(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return dServer, nil
+	case err != nil && !database.IsNotFound(err):
+		logger.Error("unable to get party snapshot, party is created again :", err)
+	}
 	partyConfiguration, err := dServer.redisConnection.GetPartyCreationToken(partyID)
 	if err != nil {
 		return nil, err
 	}
+	dServer.partyConfiguration = partyConfiguration
 	dServer.party, err = models.NewParty(partyConfiguration, partyID)
 	if err != nil {
 		return nil, err
@@ -56,14 +144,24 @@ func NewDynamicPartyServer(partyID string, rabbitConfig messaging.RabbitConnecti
 	if err != nil {
 		return nil, err
 	}
-	dServer.party.Players[player.PlayerUUID.String()] = player
+	err = dServer.party.AddPlayer(player)
+	if err != nil {
+		return nil, err
+	}
+	dServer.registerPlayer(player.PlayerUUID.String())
+	dServer.storeState()
+	dServer.checkAutoStart()
+	dServer.startHeartbeat()
 	dServer.SendCreatedParty(player.PlayerUUID.String())
 	return dServer, nil
 }
 
 // SendCreatedParty is used to send a just-created party back to the client who asked for it
 func (dServer *DynamicPartyServer) SendCreatedParty(playerID string) {
-	dServer.rabbitConnection.SendMessageOnTopic(dServer.party, "autocar.party.creation."+playerID)
+	err := dServer.rabbitConnection.SendMessageOnTopicWithConfirm(dServer.party, "autocar.party.creation."+playerID, messaging.DefaultConfirmTimeout)
+	if err != nil {
+		logger.Error("while sending created party :", err)
+	}
 }
 
 // SendPartyToOnePlayer is used to send the party to a player who asked for it
@@ -71,12 +169,13 @@ func (dServer *DynamicPartyServer) SendPartyToOnePlayer(playerID string) {
 	dServer.rabbitConnection.SendMessageOnTopic(dServer.party, "autocar.party."+dServer.party.PartyUUID.String()+".map."+playerID)
 }
 
-//ReceiveAddPlayer handle request to add a player in the ongoing party
+// ReceiveAddPlayer handle request to add a player in the ongoing party
 func (dServer *DynamicPartyServer) ReceiveAddPlayer(readyToReceive chan bool) error {
-	err := dServer.rabbitConnection.ReceiveMessageOnTopicWithHandler(
+	err := dServer.rabbitConnection.ReceiveMessageOnTopicWithReliableHandler(
 		"autocar.party."+dServer.party.PartyUUID.String()+".addPlayer", // topic
-		dServer.addPlayerHandler, // handler
-		readyToReceive,           // ready to receive chan
+		dServer.addPlayerHandler,     // handler
+		messaging.DefaultRetryPolicy, // retry policy
+		readyToReceive,               // ready to receive chan
 	)
 	if err != nil {
 		return err
@@ -84,47 +183,500 @@ func (dServer *DynamicPartyServer) ReceiveAddPlayer(readyToReceive chan bool) er
 	return nil
 }
 
-// TODO send a error message if the Party is already started
-func (dServer *DynamicPartyServer) addPlayerHandler(msg amqp.Delivery) {
+// addPlayerHandler adds a player in the party if the party is in its lobby, is not full and if the
+// player knows the party password. Otherwise, an error is sent back to the player
+func (dServer *DynamicPartyServer) addPlayerHandler(msg amqp.Delivery) error {
 	var addPlayerToken models.PlayerToken
-	err := json.Unmarshal(msg.Body, &addPlayerToken)
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.PlayerTokenMessageType, &addPlayerToken)
 	if err != nil {
 		logger.Error("unable to unmarshal message from client :", err)
-		return
+		return messaging.Permanent(err)
+	}
+	err = verifySession(dServer.sessions, sessionToken, addPlayerToken.ClientID)
+	if err != nil {
+		dServer.rabbitConnection.SendMessageOnTopic(
+			refusalResponse(err),
+			"autocar.party."+dServer.party.PartyUUID.String()+".map."+addPlayerToken.ClientID,
+		)
+		return nil
 	}
 	newPlayer, err := dServer.redisConnection.GetPlayer(addPlayerToken.ClientID)
 	if err != nil {
 		logger.Error("while trying to register new player in party :", err)
-		return
+		return err
+	}
+	// a player already in the party gets it again
+	if _, ok := dServer.party.Player(addPlayerToken.ClientID); !ok {
+		err = dServer.party.CanJoin(addPlayerToken.ClientID, addPlayerToken.Password)
+		if err != nil {
+			logger.Warning("player", addPlayerToken.ClientID, "could not join party :", err)
+			dServer.rabbitConnection.SendMessageOnTopic(
+				refusalResponse(err),
+				"autocar.party."+dServer.party.PartyUUID.String()+".map."+addPlayerToken.ClientID,
+			)
+			return nil
+		}
 	}
 	err = dServer.party.AddPlayer(newPlayer)
 	if err != nil {
 		logger.Error("while adding player in a party :", err)
 	}
-	dServer.closestRacetrackPointIndex[newPlayer.PlayerUUID.String()] = 0
+	dServer.setClosestRacetrackPoint(newPlayer.PlayerUUID.String(), 0)
 	dServer.SendPartyToOnePlayer(newPlayer.PlayerUUID.String())
+	dServer.checkAutoStart()
 	dServer.SyncParty()
+	if err == nil {
+		dServer.registerPlayer(newPlayer.PlayerUUID.String())
+		dServer.sendLobbyEvent(models.PlayerJoined, newPlayer)
+	}
+	return nil
+}
+
+// ReceiveLeave handle request from a player leaving the ongoing party
+func (dServer *DynamicPartyServer) ReceiveLeave(readyToReceive chan bool) error {
+	return dServer.rabbitConnection.ReceiveMessageOnTopicWithReliableHandler(
+		"autocar.party."+dServer.party.PartyUUID.String()+".leave", // topic
+		dServer.leaveHandler,         // handler
+		messaging.DefaultRetryPolicy, // retry policy
+		readyToReceive,               // ready to receive chan
+	)
+}
+
+func (dServer *DynamicPartyServer) leaveHandler(msg amqp.Delivery) error {
+	var playerToken models.PlayerToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.PlayerTokenMessageType, &playerToken)
+	if err != nil {
+		logger.Error("unable to unmarshal message from client :", err)
+		return messaging.Permanent(err)
+	}
+	if verifySession(dServer.sessions, sessionToken, playerToken.ClientID) != nil {
+		return nil
+	}
+	player, ok := dServer.party.Player(playerToken.ClientID)
+	if !ok {
+		logger.Warning("player", playerToken.ClientID, "left party but was not in it")
+		return nil
+	}
+	hostID := dServer.party.GetHostID()
+	err = dServer.party.RemovePlayer(player)
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+	dServer.playerRemoved(player)
+	if newHostID := dServer.party.GetHostID(); newHostID != hostID && newHostID != "" {
+		dServer.sendModerationEvent(models.ModerationEvent{
+			Action:    models.TransferHost,
+			TargetID:  newHostID,
+			HostID:    newHostID,
+			Automatic: true,
+		}, "")
+	}
+	return nil
+}
+
+// playerRemoved unregisters a player removed from the party and tells the others
+func (dServer *DynamicPartyServer) playerRemoved(player *models.Player) {
+	playerID := player.PlayerUUID.String()
+	dServer.forgetClosestRacetrackPoint(playerID)
+	dServer.chat.forget(playerID)
+	dServer.leaveRace(player)
+	err := dServer.redisConnection.RemovePlayerOnParty(dServer.party.PartyUUID.String(), playerID)
+	if err != nil {
+		logger.Error("unable to unregister player from party :", err)
+	}
+	dServer.sendHeartbeat()
+	dServer.checkAutoStart()
+	dServer.SyncParty()
+	dServer.sendLobbyEvent(models.PlayerLeft, player)
+}
+
+// ReceiveReady handle players toggling their ready flag in the lobby
+func (dServer *DynamicPartyServer) ReceiveReady(readyToReceive chan bool) error {
+	return dServer.rabbitConnection.ReceiveMessageOnTopicWithReliableHandler(
+		"autocar.party."+dServer.party.PartyUUID.String()+".ready", // topic
+		dServer.readyHandler,         // handler
+		messaging.DefaultRetryPolicy, // retry policy
+		readyToReceive,               // ready to receive chan
+	)
+}
+
+func (dServer *DynamicPartyServer) readyHandler(msg amqp.Delivery) error {
+	var readyToken models.ReadyToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ReadyTokenMessageType, &readyToken)
+	if err != nil {
+		logger.Error("unable to unmarshal message from client :", err)
+		return messaging.Permanent(err)
+	}
+	if verifySession(dServer.sessions, sessionToken, readyToken.PlayerToken.ClientID) != nil {
+		return nil
+	}
+	err = dServer.party.SetReady(readyToken.PlayerToken.ClientID, readyToken.Ready)
+	if err != nil {
+		logger.Warning("player", readyToken.PlayerToken.ClientID, "could not change its ready flag :", err)
+		return nil
+	}
+	dServer.checkAutoStart()
+	dServer.SyncParty()
+	return nil
+}
+
+// checkAutoStart starts the party once every player is ready, and starts or stops the auto-start
+// timer following the number of players in the lobby
+func (dServer *DynamicPartyServer) checkAutoStart() {
+	if dServer.party.GetState() != models.LOBBY {
+		dServer.stopAutoStart()
+		return
+	}
+	if dServer.party.AllReady() {
+		logger.Trace("every player is ready, starting party")
+		dServer.setState(models.RUN)
+		return
+	}
+	delay, ok := dServer.party.AutoStartDelay()
+	if !ok {
+		dServer.stopAutoStart()
+		return
+	}
+	dServer.autoStartMutex.Lock()
+	defer dServer.autoStartMutex.Unlock()
+	if dServer.autoStartTimer != nil {
+		return
+	}
+	dServer.autoStartAt = time.Now().Add(delay)
+	dServer.autoStartTimer = time.AfterFunc(delay, dServer.autoStart)
+}
+
+func (dServer *DynamicPartyServer) autoStart() {
+	dServer.autoStartMutex.Lock()
+	dServer.autoStartTimer = nil
+	dServer.autoStartMutex.Unlock()
+	if dServer.party.GetState() != models.LOBBY {
+		return
+	}
+	logger.Trace("auto-start timer expired, starting party")
+	dServer.setState(models.RUN)
+}
+
+func (dServer *DynamicPartyServer) stopAutoStart() {
+	dServer.autoStartMutex.Lock()
+	defer dServer.autoStartMutex.Unlock()
+	if dServer.autoStartTimer != nil {
+		dServer.autoStartTimer.Stop()
+		dServer.autoStartTimer = nil
+	}
+}
+
+// autoStartDeadline returns when the party starts automatically, or nil if the timer is not running
+func (dServer *DynamicPartyServer) autoStartDeadline() *time.Time {
+	dServer.autoStartMutex.Lock()
+	defer dServer.autoStartMutex.Unlock()
+	if dServer.autoStartTimer == nil {
+		return nil
+	}
+	deadline := dServer.autoStartAt
+	return &deadline
+}
+
+// ReceiveChat relays chat messages of the party channel to every player of the party
+func (dServer *DynamicPartyServer) ReceiveChat(readyToReceive chan bool) error {
+	return dServer.rabbitConnection.ReceiveMessageOnTopicWithHandler(
+		"autocar.party."+dServer.party.PartyUUID.String()+".chat", // topic
+		dServer.chatHandler, // handler
+		readyToReceive,      // ready to receive chan
+	)
+}
+
+func (dServer *DynamicPartyServer) chatHandler(msg amqp.Delivery) {
+	var chatToken models.ChatToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ChatTokenMessageType, &chatToken)
+	if err != nil {
+		logger.Error("unable to unmarshal message from client :", err)
+		return
+	}
+	topicPrefix := "autocar.party." + dServer.party.PartyUUID.String() + ".chat."
+	err = verifySession(dServer.sessions, sessionToken, chatToken.PlayerToken.ClientID)
+	if err != nil {
+		dServer.rabbitConnection.SendMessageOnTopic(chatErrorResponse(err), topicPrefix+chatToken.PlayerToken.ClientID)
+		return
+	}
+	sender, ok := dServer.party.Player(chatToken.PlayerToken.ClientID)
+	if !ok {
+		logger.Warning("chat message from a player who is not in the party :", chatToken.PlayerToken.ClientID)
+		return
+	}
+	chatMessage, err := dServer.chat.stamp(models.ChatPartyChannel, chatToken, sender.PlayerName, time.Now())
+	if err != nil {
+		dServer.rabbitConnection.SendMessageOnTopic(chatErrorResponse(err), topicPrefix+chatToken.PlayerToken.ClientID)
+		return
+	}
+	for _, playerID := range dServer.party.PlayerIDs() {
+		dServer.rabbitConnection.SendMessageOnTopic(chatMessage, topicPrefix+playerID)
+	}
+}
+
+// ReceivePlayerRenamed updates the name of a player of the party once it was renamed
+func (dServer *DynamicPartyServer) ReceivePlayerRenamed(readyToReceive chan bool) error {
+	return dServer.rabbitConnection.ReceiveMessageOnTopicWithHandler(
+		models.PlayerRenamedTopic,    // topic
+		dServer.playerRenamedHandler, // handler
+		readyToReceive,               // ready to receive chan
+	)
+}
+
+// playerRenamedHandler reads the new name from the stored player, so a forged message renames nobody
+func (dServer *DynamicPartyServer) playerRenamedHandler(msg amqp.Delivery) {
+	var playerRenamed models.PlayerRenamed
+	err := messaging.UnmarshalPayload(msg.Body, models.PlayerRenamedMessageType, &playerRenamed)
+	if err != nil {
+		logger.Error("unable to unmarshal renamed player :", err)
+		return
+	}
+	if _, ok := dServer.party.Player(playerRenamed.PlayerID); !ok {
+		return
+	}
+	storedPlayer, err := dServer.redisConnection.GetPlayer(playerRenamed.PlayerID)
+	if err != nil {
+		logger.Error("unable to get renamed player :", err)
+		return
+	}
+	if dServer.party.RenamePlayer(playerRenamed.PlayerID, storedPlayer.PlayerName) {
+		dServer.SyncParty()
+	}
+}
+
+// ReceiveModeration handle kick, ban and host transfer requests from the party host
+func (dServer *DynamicPartyServer) ReceiveModeration(readyToReceive chan bool) error {
+	return dServer.rabbitConnection.ReceiveMessageOnTopicWithReliableHandler(
+		"autocar.party."+dServer.party.PartyUUID.String()+".moderation", // topic
+		dServer.moderationHandler,    // handler
+		messaging.DefaultRetryPolicy, // retry policy
+		readyToReceive,               // ready to receive chan
+	)
+}
+
+func (dServer *DynamicPartyServer) moderationHandler(msg amqp.Delivery) error {
+	var moderationToken models.ModerationToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ModerationTokenMessageType, &moderationToken)
+	if err != nil {
+		logger.Error("unable to unmarshal message from client :", err)
+		return messaging.Permanent(err)
+	}
+	requesterID := moderationToken.PlayerToken.ClientID
+	target, _ := dServer.party.Player(moderationToken.TargetID)
+	err = verifySession(dServer.sessions, sessionToken, requesterID)
+	if err == nil {
+		err = dServer.party.Moderate(requesterID, moderationToken.Action, moderationToken.TargetID)
+	}
+	if err != nil {
+		logger.Warning("player", requesterID, "could not", moderationToken.Action.String(), moderationToken.TargetID, ":", err)
+		dServer.rabbitConnection.SendMessageOnTopic(
+			refusalResponse(err),
+			"autocar.party."+dServer.party.PartyUUID.String()+".moderation."+requesterID,
+		)
+		return nil
+	}
+	// the target may have joined between its lookup and the moderation action
+	if moderationToken.Action != models.TransferHost && target != nil {
+		dServer.playerRemoved(target)
+	}
+	// kicked and banned players are not in the party anymore, they are told separately
+	dServer.sendModerationEvent(models.ModerationEvent{
+		Action:   moderationToken.Action,
+		TargetID: moderationToken.TargetID,
+		HostID:   dServer.party.GetHostID(),
+	}, moderationToken.TargetID)
+	return nil
+}
+
+// ReceiveForceEnd handle requests from administrators to end the party right away
+func (dServer *DynamicPartyServer) ReceiveForceEnd(readyToReceive chan bool) error {
+	return dServer.rabbitConnection.ReceiveMessageOnTopicWithReliableHandler(
+		models.ForceEndTopic(dServer.party.PartyUUID.String()), // topic
+		dServer.forceEndHandler,                                // handler
+		messaging.DefaultRetryPolicy,                           // retry policy
+		readyToReceive,                                         // ready to receive chan
+	)
+}
+
+func (dServer *DynamicPartyServer) forceEndHandler(msg amqp.Delivery) error {
+	var forceEnd models.ForceEnd
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ForceEndMessageType, &forceEnd)
+	if err != nil {
+		logger.Error("unable to unmarshal message from administrator :", err)
+		return messaging.Permanent(err)
+	}
+	err = verifySession(dServer.sessions, sessionToken, models.AdminID)
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+	logger.Warning("party ended by an administrator :", forceEnd.Reason)
+	dServer.setState(models.END)
+	return nil
+}
+
+// sendModerationEvent sends a moderation event to every player of the party, and to an extra player
+// if it is not in the party anymore
+func (dServer *DynamicPartyServer) sendModerationEvent(moderationEvent models.ModerationEvent, extraPlayerID string) {
+	topicPrefix := "autocar.party." + dServer.party.PartyUUID.String() + ".moderation."
+	for _, playerID := range dServer.party.PlayerIDs() {
+		dServer.rabbitConnection.SendMessageOnTopic(moderationEvent, topicPrefix+playerID)
+	}
+	if _, ok := dServer.party.Player(extraPlayerID); extraPlayerID != "" && !ok {
+		dServer.rabbitConnection.SendMessageOnTopic(moderationEvent, topicPrefix+extraPlayerID)
+	}
+}
+
+// setState change the party's state and tells the lobby when the party starts or ends. It may be
+// called by handlers, the auto-start timer and the game loop at once, a change is only handled once
+func (dServer *DynamicPartyServer) setState(newState models.State) {
+	previousState := dServer.party.SetState(newState)
+	if previousState == newState {
+		return
+	}
+	dServer.storeState()
+	defer dServer.checkpoint()
+	if previousState == models.LOBBY {
+		dServer.stopAutoStart()
+	}
+	if previousState == models.LOBBY && newState == models.RUN {
+		dServer.startRace()
+	}
+	switch {
+	case newState == models.END:
+		// the lobby is told once the grace window is over, when the party is deregistered
+		dServer.endRace()
+	case previousState == models.LOBBY:
+		dServer.sendLobbyEvent(models.PartyStarted, nil)
+	}
+}
+
+// registerPlayer stores a player as a participant of this party, so static servers can list it
+func (dServer *DynamicPartyServer) registerPlayer(playerID string) {
+	err := dServer.redisConnection.SetPlayerOnParty(dServer.party.PartyUUID.String(), playerID)
+	if err != nil {
+		logger.Error("unable to register player on party :", err)
+	}
+	dServer.sendHeartbeat()
+}
+
+// storeState stores the party's state, so static servers can list it
+func (dServer *DynamicPartyServer) storeState() {
+	err := dServer.redisConnection.SetPartyState(dServer.party.PartyUUID.String(), dServer.party.GetState())
+	if err != nil {
+		logger.Error("unable to store party state :", err)
+	}
+}
+
+// refusalResponse create the error sent back to a player whose request was refused by the party
+func refusalResponse(err error) messaging.ErrorResponse {
+	codes := map[error]string{
+		models.ErrorPartyFull:             models.ErrorCodePartyFull,
+		models.ErrorPartyStarted:          models.ErrorCodePartyStarted,
+		models.ErrorWrongPassword:         models.ErrorCodeWrongPassword,
+		models.ErrorPlayerBanned:          models.ErrorCodePlayerBanned,
+		models.ErrorNotHost:               models.ErrorCodeNotHost,
+		models.ErrorInvalidTarget:         models.ErrorCodeInvalidTarget,
+		models.ErrorInvalidStateChange:    models.ErrorCodeInvalidStateChange,
+		models.ErrorPlayerNotFound:        models.ErrorCodePlayerNotFound,
+		auth.ErrorInvalidSession:          auth.ErrorCodeInvalidSession,
+		auth.ErrorSessionExpired:          auth.ErrorCodeSessionExpired,
+		models.ErrorUnknownPlayer:         models.ErrorCodeUnknownPlayer,
+		models.ErrorInvalidPlayerName:     models.ErrorCodeInvalidPlayerName,
+		models.ErrorReservedPlayerName:    models.ErrorCodeReservedPlayerName,
+		models.ErrorPlayerNameTaken:       models.ErrorCodePlayerNameTaken,
+		models.ErrorLaunchFailed:          models.ErrorCodeLaunchFailed,
+		models.ErrorLaunchTimeout:         models.ErrorCodeLaunchTimeout,
+		models.ErrorPartyLimitReached:     models.ErrorCodePartyLimitReached,
+		models.ErrorOpenPartyLimitReached: models.ErrorCodeOpenPartyLimitReached,
+		models.ErrorCreationRateLimited:   models.ErrorCodeCreationRateLimited,
+	}
+	if code, ok := codes[err]; ok {
+		return messaging.ErrorResponse{Code: code, ErrorMessage: err.Error()}
+	}
+	return messaging.NewErrorResponse(err)
 }
 
-//SyncParty send a Sync Message to all players in the party
+// sendLobbyEvent publishes a lobby event about this party, player may be nil. Private parties
+// are not advertised
+func (dServer *DynamicPartyServer) sendLobbyEvent(kind models.LobbyEventKind, player *models.Player) {
+	if dServer.party.Private {
+		return
+	}
+	lobbyEvent := models.LobbyEvent{
+		Kind:        kind,
+		PartyID:     dServer.party.PartyUUID.String(),
+		PartyName:   dServer.party.PartyName,
+		PlayerCount: dServer.party.PlayerCount(),
+		State:       dServer.party.GetState(),
+	}
+	if player != nil {
+		lobbyEvent.PlayerID = player.PlayerUUID.String()
+		lobbyEvent.PlayerName = player.PlayerName
+	}
+	dServer.publishLobbyEvent(lobbyEvent)
+}
+
+// SyncParty send a Sync Message to all players in the party
 func (dServer *DynamicPartyServer) SyncParty() {
-	for _, player := range dServer.party.Players {
-		dServer.SyncPartyForOnePlayer(player.PlayerUUID.String())
+	players := dServer.party.PlayerList()
+	for _, player := range players {
+		dServer.syncPlayers(players, player.PlayerUUID.String())
 	}
 }
 
 // SyncPartyForOnePlayer send a Sync Message to one player only
 func (dServer *DynamicPartyServer) SyncPartyForOnePlayer(clientID string) {
-	// creating the actual Sync Message
+	dServer.syncPlayers(dServer.party.PlayerList(), clientID)
+}
+
+// syncPlayers send a Sync Message built from copies of the players of the party to one player
+func (dServer *DynamicPartyServer) syncPlayers(players []*models.Player, clientID string) {
+	dServer.rabbitConnection.SendMessageOnTopicAsync(
+		dServer.syncMessage(players, clientID), // object to send
+		dServer.syncTopic(clientID),            // topic
+	)
+}
+
+// syncFinalResults send the Sync Message holding the race results once to every player, waiting for
+// the broker to confirm each of them
+func (dServer *DynamicPartyServer) syncFinalResults() {
+	players := dServer.party.PlayerList()
+	for _, player := range players {
+		clientID := player.PlayerUUID.String()
+		err := dServer.rabbitConnection.SendMessageOnTopicWithConfirm(
+			dServer.syncMessage(players, clientID),
+			dServer.syncTopic(clientID),
+			messaging.DefaultConfirmTimeout,
+		)
+		if err != nil {
+			logger.Error("unable to send race results to player", clientID, ":", err)
+		}
+	}
+}
+
+// syncTopic is the topic a player receives Sync Messages on
+func (dServer *DynamicPartyServer) syncTopic(clientID string) string {
+	return "autocar.party." + dServer.party.PartyUUID.String() + ".sync." + clientID
+}
+
+// syncMessage builds the Sync Message of one player from copies of the players of the party
+func (dServer *DynamicPartyServer) syncMessage(players []*models.Player, clientID string) *SyncMessageContent {
 	syncMessage := &SyncMessageContent{
-		PartyState: dServer.party.GetState(),
+		PartyState:  dServer.party.GetState(),
+		HostID:      dServer.party.GetHostID(),
+		AutoStartAt: dServer.autoStartDeadline(),
+		Results:     dServer.raceResultsCopy(),
 	}
-	for playerID, player := range dServer.party.Players {
+	for _, player := range players {
+		playerID := player.PlayerUUID.String()
+		lap, rank := dServer.raceStanding(playerID)
 		if playerID == clientID {
 			syncMessage.MainActor = &models.MainActor{
 				Act: &models.Actor{
-					Name: player.PlayerName,
-					Rank: 0,
+					Name:  player.PlayerName,
+					Rank:  rank,
+					Lap:   lap,
+					Ready: dServer.party.IsReady(playerID),
 				},
 				Player: player,
 			}
@@ -132,18 +684,16 @@ func (dServer *DynamicPartyServer) SyncPartyForOnePlayer(clientID string) {
 		}
 		syncMessage.Competitors = append(syncMessage.Competitors, &models.CompetitorActor{
 			Act: &models.Actor{
-				Name: player.PlayerName,
-				Rank: 0,
+				Name:  player.PlayerName,
+				Rank:  rank,
+				Lap:   lap,
+				Ready: dServer.party.IsReady(playerID),
 			},
 			Position:  player.Position,
 			ActorUUID: player.PlayerUUID,
 		})
 	}
-	//Sending it
-	dServer.rabbitConnection.SendMessageOnTopic(
-		syncMessage, // object to send
-		"autocar.party."+dServer.party.PartyUUID.String()+".sync."+clientID, // topic
-	)
+	return syncMessage
 }
 
 // ReceiveSyncRequest handle sync request from one player
@@ -159,7 +709,12 @@ func (dServer *DynamicPartyServer) ReceiveSyncRequest(readyToReceive chan bool)
 		return err
 	}
 	for {
-		msg := <-received
+		var msg interface{}
+		select {
+		case msg = <-received:
+		case <-dServer.done:
+			return nil
+		}
 		switch msg.(type) {
 		case models.PlayerToken:
 			dServer.SyncPartyForOnePlayer(msg.(models.PlayerToken).ClientID)
@@ -170,9 +725,12 @@ func (dServer *DynamicPartyServer) ReceiveSyncRequest(readyToReceive chan bool)
 }
 
 func (dServer *DynamicPartyServer) syncRequestHandler(msg amqp.Delivery) interface{} {
-	msgBody := msg.Body
-	var playerToken models.AddPlayerToken
-	err := json.Unmarshal(msgBody, &playerToken)
+	var playerToken models.PlayerToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.PlayerTokenMessageType, &playerToken)
+	if err != nil {
+		return err
+	}
+	err = verifySession(dServer.sessions, sessionToken, playerToken.ClientID)
 	if err != nil {
 		return err
 	}
@@ -183,11 +741,12 @@ func (dServer *DynamicPartyServer) syncRequestHandler(msg amqp.Delivery) interfa
 func (dServer *DynamicPartyServer) ReceiveNewState(readyToReceive chan bool) error {
 	defer logger.Trace(systool.TimeTrack(time.Now(), "ReceiveNewState"))
 	//received := make(chan interface{})
-	err := dServer.rabbitConnection.ReceiveMessageOnTopicWithCallback(
+	err := dServer.rabbitConnection.ReceiveMessageOnTopicWithReliableCallback(
 		"autocar.party."+dServer.party.PartyUUID.String()+".state",
 		"autocar.party."+dServer.party.PartyUUID.String()+".state",
 		dServer.rabbitConnection.SendMessageOnTopic,
 		dServer.newStateRequestHandler,
+		messaging.DefaultRetryPolicy,
 		readyToReceive,
 	)
 	if err != nil {
@@ -198,20 +757,36 @@ func (dServer *DynamicPartyServer) ReceiveNewState(readyToReceive chan bool) err
 }
 
 func (dServer *DynamicPartyServer) newStateRequestHandler(msg amqp.Delivery) (interface{}, string) {
-	msgBody := msg.Body
 	var stateRequest models.ChangeStateToken
-	err := json.Unmarshal(msgBody, &stateRequest)
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ChangeStateTokenMessageType, &stateRequest)
 	if err != nil {
-		return err, ""
+		return messaging.Permanent(err), ""
+	}
+	playerID := stateRequest.PlayerToken.ClientID
+	err = verifySession(dServer.sessions, sessionToken, playerID)
+	if err == nil {
+		err = dServer.party.CanChangeState(playerID, stateRequest.DesiredState)
+	}
+	if err != nil {
+		return refusalResponse(err), "." + playerID
+	}
+	message := "OK"
+	if dServer.party.IsHost(playerID) {
+		dServer.setState(stateRequest.DesiredState)
+	} else {
+		votes, neededVotes := dServer.party.VoteState(playerID, stateRequest.DesiredState)
+		message = fmt.Sprintf("%d/%d votes", votes, neededVotes)
+		if votes >= neededVotes {
+			dServer.setState(stateRequest.DesiredState)
+		}
 	}
-	dServer.party.SetState(stateRequest.DesiredState)
 	newState := models.ChangeStateAck{
 		PartyID:      stateRequest.PlayerToken.PartyID,
 		DesiredState: stateRequest.DesiredState,
 		NewState:     dServer.party.GetState(),
-		Message:      "OK",
+		Message:      message,
 	}
-	return newState, "." + stateRequest.PlayerToken.ClientID
+	return newState, "." + playerID
 }
 
 // ReceivePlayersInput receive and store locally players' inputs
@@ -230,7 +805,12 @@ func (dServer *DynamicPartyServer) ReceivePlayersInput(readyToReceive chan bool)
 		}
 	}()
 	for {
-		msg := <-received
+		var msg interface{}
+		select {
+		case msg = <-received:
+		case <-dServer.done:
+			return nil
+		}
 		switch msg.(type) {
 		case models.PlayerInput:
 			newPlayerInput := new(models.PlayerInput)
@@ -238,46 +818,190 @@ func (dServer *DynamicPartyServer) ReceivePlayersInput(readyToReceive chan bool)
 			newPlayerInput.MessageNumber = msg.(models.PlayerInput).MessageNumber
 			newPlayerInput.Timestamp = msg.(models.PlayerInput).Timestamp
 			newPlayerInput.Turning = msg.(models.PlayerInput).Turning
-			dServer.party.Players[msg.(models.PlayerInput).PlayerUUID.String()].Input = newPlayerInput
+			if !dServer.party.SetPlayerInput(msg.(models.PlayerInput).PlayerUUID.String(), newPlayerInput) {
+				// the player may have left the party
+				logger.Warning("input received from unknown player :", msg.(models.PlayerInput).PlayerUUID)
+			}
 		default:
 			logger.Error("got error while receiving player input :", msg.(error))
-			return msg.(error)
 		}
 	}
 }
 
 func (dServer *DynamicPartyServer) handlePlayerInput(msg []byte) interface{} {
 	var playerInput models.PlayerInput
-	err := json.Unmarshal(msg, &playerInput)
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg, models.PlayerInputMessageType, &playerInput)
+	if err != nil {
+		return err
+	}
+	// inputs are not answered, a refused input is only logged
+	err = dServer.sessions.Verify(sessionToken, playerInput.PlayerUUID.String(), time.Now())
 	if err != nil {
 		return err
 	}
 	return playerInput
 }
 
-// Close terminate connection with Redis and RabbitMQ
+// startHeartbeat registers this party's dynamic server in the server registry, then refreshes its
+// record until it is deregistered
+func (dServer *DynamicPartyServer) startHeartbeat() {
+	dServer.sendHeartbeat()
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				dServer.sendHeartbeat()
+			case <-dServer.done:
+				return
+			}
+		}
+	}()
+}
+
+// sendHeartbeat writes the registry record of this party's dynamic server, unless it was deregistered
+func (dServer *DynamicPartyServer) sendHeartbeat() {
+	select {
+	case <-dServer.done:
+		return
+	default:
+	}
+	err := dServer.redisConnection.Heartbeat(dServer.serverRecord(), dServer.party.InviteCode)
+	if err != nil {
+		logger.Error("unable to send heartbeat :", err)
+	}
+}
+
+// serverRecord describes this party's dynamic server for the server registry
+func (dServer *DynamicPartyServer) serverRecord() models.ServerRecord {
+	partyID := dServer.party.PartyUUID.String()
+	record := models.ServerRecord{
+		PartyID:      partyID,
+		CreatorID:    dServer.partyConfiguration.CreatorID(),
+		Host:         dServer.host,
+		TickRate:     dServer.getTickRate(),
+		TickExpected: dServer.tickPerSecond,
+		StartedAt:    dServer.startedAt,
+		UpdatedAt:    time.Now(),
+	}
+	players, err := dServer.redisConnection.GetPlayersOnParty(partyID)
+	if err != nil {
+		logger.Warning("unable to count players of party :", err)
+	}
+	record.PlayerCount = len(players)
+	return record
+}
+
+func (dServer *DynamicPartyServer) setTickRate(tickRate uint) {
+	dServer.tickRateMutex.Lock()
+	defer dServer.tickRateMutex.Unlock()
+	dServer.tickRate = tickRate
+}
+
+func (dServer *DynamicPartyServer) getTickRate() uint {
+	dServer.tickRateMutex.Lock()
+	defer dServer.tickRateMutex.Unlock()
+	return dServer.tickRate
+}
+
+// Deregister removes every key of this party from Redis and tells the lobby the party is over.
+// It is called once, when the party ends or when the dynamic server stops
+func (dServer *DynamicPartyServer) Deregister() {
+	dServer.deregisterOnce.Do(func() {
+		close(dServer.done)
+		dServer.stopAutoStart()
+		err := dServer.redisConnection.RemoveParty(dServer.party.PartyUUID.String())
+		if err != nil {
+			logger.Error("unable to deregister party :", err)
+		}
+		// a party ending normally already recorded its race
+		if dServer.party.GetState() != models.END {
+			dServer.endRace()
+		}
+		dServer.sendLobbyEvent(models.PartyEnded, nil)
+	})
+}
+
+// Close deregister the party and terminate connection with Redis and RabbitMQ
 func (dServer *DynamicPartyServer) Close() error {
+	dServer.Deregister()
+	return dServer.closeConnections()
+}
+
+// Abandon stops this dynamic server without deregistering the party, so a replacement server can
+// resume it from its snapshot. Connections with Redis and RabbitMQ are terminated
+func (dServer *DynamicPartyServer) Abandon() error {
+	dServer.deregisterOnce.Do(func() {
+		close(dServer.done)
+		dServer.stopAutoStart()
+	})
+	return dServer.closeConnections()
+}
+
+func (dServer *DynamicPartyServer) closeConnections() error {
+	err := dServer.redisConnection.Close()
+	if err != nil {
+		logger.Error("while closing Redis connection :", err)
+	}
 	return dServer.rabbitConnection.Close()
 }
 
-// Run start the actual game loop until the party is over
+// finishParty publishes the final results of the race once with broker confirmation, then sends them
+// again every second during the grace window for players who missed them. It returns once the grace
+// window is over, or once the party is deregistered
+func (dServer *DynamicPartyServer) finishParty() {
+	dServer.syncFinalResults()
+	grace := time.NewTimer(dServer.endGrace)
+	defer grace.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			dServer.SyncParty()
+		case <-grace.C:
+			return
+		case <-dServer.done:
+			return
+		}
+	}
+}
+
+// Run start the actual game loop until the party is over, or until the party is deregistered
 func (dServer *DynamicPartyServer) Run() {
 	tickDuration := time.Duration((1.0/float64(dServer.tickPerSecond))*1000000000) * time.Nanosecond
 	ticker := time.NewTicker(tickDuration)
+	defer ticker.Stop()
 	last := time.Now()
-	tick := 0
+	lastCheckpoint := last
 	go func() {
 		second := time.NewTicker(time.Second)
+		defer second.Stop()
+		var metrics messaging.PublishMetrics
+		lastTicks := atomic.LoadUint64(&dServer.ticks)
 		for {
-			<-second.C
-			if uint(tick) < dServer.tickPerSecond {
-				logger.Warning("dynamic server's tick is too low :", tick)
+			select {
+			case <-second.C:
+			case <-dServer.done:
+				return
+			}
+			ticks := atomic.LoadUint64(&dServer.ticks)
+			tickRate := uint(ticks - lastTicks)
+			lastTicks = ticks
+			if tickRate < dServer.tickPerSecond {
+				logger.Warning("dynamic server's tick is too low :", tickRate)
 			}
-			tick = 0
+			dServer.setTickRate(tickRate)
+			metrics = dServer.rabbitConnection.LogMetrics(metrics)
 		}
 	}()
 	for {
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-dServer.done:
+			return
+		}
 		deltaTime := time.Since(last).Seconds()
 		last = time.Now()
 		switch dServer.party.GetState() {
@@ -285,17 +1009,23 @@ func (dServer *DynamicPartyServer) Run() {
 			dServer.setCarAtStart()
 			dServer.SyncParty()
 		case models.END:
-			//TODO end game and self destruct and remove container as well
 			ticker.Stop()
-			dServer.SyncParty()
-			time.Sleep(1 * time.Second)
+			dServer.finishParty()
 			return
 		case models.PAUSE:
 			dServer.SyncParty()
 		case models.RUN:
 			dServer.computeNewPosition(deltaTime)
+			if dServer.advanceRace(deltaTime) {
+				// every player crossed the finish line
+				dServer.setState(models.END)
+			}
 			dServer.SyncParty()
 		}
-		tick++
+		atomic.AddUint64(&dServer.ticks, 1)
+		if time.Since(lastCheckpoint) >= snapshotInterval {
+			lastCheckpoint = time.Now()
+			dServer.checkpoint()
+		}
 	}
 }
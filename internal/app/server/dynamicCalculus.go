@@ -23,12 +23,14 @@ var (
 
 func (dServer *DynamicPartyServer) computeNewPosition(deltaTime float64) {
 	logger.Trace(systool.TimeTrack(time.Now(), "compute players position"))
-	for _, player := range dServer.party.Players {
+	dServer.positionMutex.Lock()
+	defer dServer.positionMutex.Unlock()
+	dServer.party.UpdatePlayers(func(player *models.Player) {
 		dServer.computeNewPlayerSpeed(player, deltaTime)
 		dServer.computeNewPlayerAngle(player, deltaTime)
 		player.Position.CurrentPosition.Y += (player.Position.CurrentSpeed * deltaTime) * math.Sin(player.Position.CurrentAngle)
 		player.Position.CurrentPosition.X += (player.Position.CurrentSpeed * deltaTime) * math.Cos(player.Position.CurrentAngle)
-	}
+	})
 }
 
 func (dServer *DynamicPartyServer) computeNewPlayerAngle(p *models.Player, deltaTime float64) {
@@ -71,10 +73,10 @@ func (dServer *DynamicPartyServer) computeDeceleration(p *models.Player, multipl
 func (dServer *DynamicPartyServer) setCarAtStart() {
 	startPosition := dServer.party.MapCircuit.TurnPoints[0].Position
 	startAngle := mathtool.GetNormalizedDirection(dServer.party.MapCircuit.TurnPoints[0].Position, dServer.party.MapCircuit.TurnPoints[100].Position).GetVectorAngle() + math.Pi
-	for _, player := range dServer.party.Players {
+	dServer.party.UpdatePlayers(func(player *models.Player) {
 		player.Position.CurrentPosition = startPosition
 		player.Position.CurrentAngle = startAngle
-	}
+	})
 }
 
 func (dServer *DynamicPartyServer) computeClosestRacetrackPointIndex(player *models.Player) {
@@ -96,6 +98,36 @@ func (dServer *DynamicPartyServer) computeClosestRacetrackPointIndex(player *mod
 	dServer.closestRacetrackPointIndex[player.PlayerUUID.String()] = closestIndex
 }
 
+// closestRacetrackPoint returns the index of the racetrack point a player was the closest to
+func (dServer *DynamicPartyServer) closestRacetrackPoint(playerID string) int {
+	dServer.positionMutex.Lock()
+	defer dServer.positionMutex.Unlock()
+	return dServer.closestRacetrackPointIndex[playerID]
+}
+
+func (dServer *DynamicPartyServer) setClosestRacetrackPoint(playerID string, index int) {
+	dServer.positionMutex.Lock()
+	defer dServer.positionMutex.Unlock()
+	dServer.closestRacetrackPointIndex[playerID] = index
+}
+
+func (dServer *DynamicPartyServer) forgetClosestRacetrackPoint(playerID string) {
+	dServer.positionMutex.Lock()
+	defer dServer.positionMutex.Unlock()
+	delete(dServer.closestRacetrackPointIndex, playerID)
+}
+
+// closestRacetrackPoints returns a copy of the racetrack point every player was the closest to
+func (dServer *DynamicPartyServer) closestRacetrackPoints() map[string]int {
+	dServer.positionMutex.Lock()
+	defer dServer.positionMutex.Unlock()
+	closestPoints := make(map[string]int, len(dServer.closestRacetrackPointIndex))
+	for playerID, index := range dServer.closestRacetrackPointIndex {
+		closestPoints[playerID] = index
+	}
+	return closestPoints
+}
+
 func (dServer *DynamicPartyServer) searchIndexBoundaries(currentIndex int) []int {
 	var indexBoundaries []int
 	var realNumberOfPostionToCheck int
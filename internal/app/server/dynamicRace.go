@@ -0,0 +1,112 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// startRace starts following every player around the racetrack
+func (dServer *DynamicPartyServer) startRace() {
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	dServer.race = make(map[string]*models.RaceProgress)
+	for _, playerID := range dServer.party.PlayerIDs() {
+		dServer.race[playerID] = new(models.RaceProgress)
+	}
+	dServer.raceClock = 0
+	dServer.finishers = 0
+}
+
+// advanceRace moves players along the racetrack after their position was computed. It returns true
+// once every player crossed the finish line
+func (dServer *DynamicPartyServer) advanceRace(deltaTime float64) bool {
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	dServer.raceClock += time.Duration(deltaTime * float64(time.Second))
+	trackLength := len(dServer.party.MapCircuit.TurnPoints)
+	allFinished := len(dServer.race) > 0
+	for playerID, raceProgress := range dServer.race {
+		player, ok := dServer.party.Player(playerID)
+		if !ok || raceProgress.Finished() {
+			continue
+		}
+		raceProgress.Distance += math.Abs(player.Position.CurrentSpeed) * deltaTime
+		lapCompleted := raceProgress.Advance(dServer.closestRacetrackPoint(playerID), trackLength, dServer.raceClock)
+		if lapCompleted && raceProgress.Laps >= dServer.party.Laps {
+			dServer.finishers++
+			raceProgress.Position = dServer.finishers
+			logger.Debug("player", player.PlayerName, "finished in position", raceProgress.Position)
+			continue
+		}
+		allFinished = false
+	}
+	return allFinished
+}
+
+// raceStanding returns the completed laps and the finishing position of a player, 0 while racing
+func (dServer *DynamicPartyServer) raceStanding(playerID string) (int, int) {
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	raceProgress, ok := dServer.race[playerID]
+	if !ok {
+		return 0, 0
+	}
+	return raceProgress.Laps, raceProgress.Position
+}
+
+// leaveRace records the result of a player leaving a running race
+func (dServer *DynamicPartyServer) leaveRace(player *models.Player) {
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	playerID := player.PlayerUUID.String()
+	raceProgress, ok := dServer.race[playerID]
+	if !ok {
+		return
+	}
+	delete(dServer.race, playerID)
+	dServer.recordResult(player, raceProgress)
+}
+
+// endRace records the result of every player still racing, then keeps the results to send them
+// in sync messages
+func (dServer *DynamicPartyServer) endRace() {
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	for playerID, raceProgress := range dServer.race {
+		player, ok := dServer.party.Player(playerID)
+		if !ok {
+			continue
+		}
+		dServer.recordResult(player, raceProgress)
+	}
+	dServer.race = nil
+	models.SortRaceResults(dServer.raceResults)
+}
+
+// recordResult updates the profile of a player with its race result
+func (dServer *DynamicPartyServer) recordResult(player *models.Player, raceProgress *models.RaceProgress) {
+	result := raceProgress.Result(player)
+	dServer.raceResults = append(dServer.raceResults, result)
+	trackID := dServer.party.CircuitConfig.TrackID()
+	err := dServer.redisConnection.UpdatePlayerProfile(player, func(profile *models.PlayerProfile) {
+		profile.Record(trackID, result)
+	})
+	if err != nil {
+		logger.Error("unable to update profile of player", result.PlayerID, ":", err)
+	}
+}
+
+// raceResultsCopy returns the race results once the race ended, nil otherwise
+func (dServer *DynamicPartyServer) raceResultsCopy() []models.RaceResult {
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	if dServer.race != nil || dServer.raceResults == nil {
+		return nil
+	}
+	results := make([]models.RaceResult, len(dServer.raceResults))
+	copy(results, dServer.raceResults)
+	return results
+}
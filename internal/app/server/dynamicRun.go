@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// ErrorServerCrashed is returned when the game loop of a dynamic server panicked
+var ErrorServerCrashed = errors.New("dynamic server crashed")
+
+// RunDynamicPartyServer creates the dynamic server of a party, listens to its players and runs the
+// party until it is over or until stop is closed. The party is deregistered and connections are
+// closed before it returns. It returns ErrorServerCrashed if the game loop panicked, nil once the
+// party is over or interrupted. A crashed party is not deregistered, a replacement server started
+// for the same party resumes it from its snapshot
+func RunDynamicPartyServer(partyID string, rabbitConfig messaging.RabbitConnectionConfiguration, stop <-chan struct{}) (err error) {
+	dServer, err := NewDynamicPartyServer(partyID, rabbitConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeConnection := dServer.Close
+		if errors.Is(err, ErrorServerCrashed) {
+			closeConnection = dServer.Abandon
+		}
+		closeErr := closeConnection()
+		if closeErr != nil {
+			logger.Error("while closing ongoing connection :", closeErr)
+		}
+	}()
+	receivers := []struct {
+		subject string
+		receive func(readyToReceive chan bool) error
+	}{
+		{"adding player in party", dServer.ReceiveAddPlayer},
+		{"new state", dServer.ReceiveNewState},
+		{"sync request", dServer.ReceiveSyncRequest},
+		{"player inputs", dServer.ReceivePlayersInput},
+		{"leaving players", dServer.ReceiveLeave},
+		{"ready flags", dServer.ReceiveReady},
+		{"chat messages", dServer.ReceiveChat},
+		{"renamed players", dServer.ReceivePlayerRenamed},
+		{"moderation requests", dServer.ReceiveModeration},
+		{"force end requests", dServer.ReceiveForceEnd},
+	}
+	readyToReceive := make(chan bool)
+	for _, receiver := range receivers {
+		receiver := receiver
+		go func() {
+			err := receiver.receive(readyToReceive)
+			if err != nil {
+				logger.Error("while listening to", receiver.subject, ":", err)
+			}
+		}()
+		if !<-readyToReceive {
+			return errors.New("server could not listen continuously")
+		}
+	}
+	ended := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("dynamic server crashed :", r)
+				ended <- fmt.Errorf("%w : %v", ErrorServerCrashed, r)
+			}
+		}()
+		dServer.Run()
+		ended <- nil
+	}()
+	select {
+	case err = <-ended:
+		return err
+	case <-stop:
+		logger.Trace("dynamic server interrupted")
+	}
+	return nil
+}
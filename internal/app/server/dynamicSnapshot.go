@@ -0,0 +1,91 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// snapshotInterval is the period between two snapshots of a running party
+const snapshotInterval = time.Second
+
+// snapshot returns the state of the party, its race included. It may be called by handlers while the
+// game loop runs, the party, the racetrack points and the race are copied under their own lock
+func (dServer *DynamicPartyServer) snapshot() models.PartySnapshot {
+	snapshot := dServer.party.Snapshot()
+	snapshot.Configuration = dServer.partyConfiguration
+	snapshot.ClosestPoints = dServer.closestRacetrackPoints()
+	snapshot.Tick = atomic.LoadUint64(&dServer.ticks)
+	snapshot.Resumes = dServer.resumes
+	snapshot.TakenAt = time.Now()
+	dServer.raceMutex.Lock()
+	defer dServer.raceMutex.Unlock()
+	if dServer.race != nil {
+		snapshot.Race = make(map[string]*models.RaceProgress, len(dServer.race))
+		for playerID, raceProgress := range dServer.race {
+			progress := *raceProgress
+			snapshot.Race[playerID] = &progress
+		}
+	}
+	snapshot.RaceClock = dServer.raceClock
+	snapshot.Finishers = dServer.finishers
+	snapshot.Results = append([]models.RaceResult(nil), dServer.raceResults...)
+	return snapshot
+}
+
+// checkpoint stores a snapshot of the party, unless it was deregistered
+func (dServer *DynamicPartyServer) checkpoint() {
+	select {
+	case <-dServer.done:
+		return
+	default:
+	}
+	err := dServer.redisConnection.SetPartySnapshot(dServer.snapshot())
+	if err != nil {
+		logger.Error("unable to store party snapshot :", err)
+	}
+}
+
+// resume brings back a party from its snapshot, after its previous dynamic server stopped without
+// deregistering it. The party is registered again, players get it from their next sync message
+func (dServer *DynamicPartyServer) resume(snapshot models.PartySnapshot) error {
+	var err error
+	dServer.partyConfiguration = snapshot.Configuration
+	dServer.party, err = models.NewParty(snapshot.Configuration, snapshot.PartyID)
+	if err != nil {
+		return err
+	}
+	dServer.party.Restore(snapshot)
+	for playerID, index := range snapshot.ClosestPoints {
+		dServer.closestRacetrackPointIndex[playerID] = index
+	}
+	dServer.ticks = snapshot.Tick
+	dServer.resumes = snapshot.Resumes + 1
+	dServer.race = snapshot.Race
+	dServer.raceClock = snapshot.RaceClock
+	dServer.finishers = snapshot.Finishers
+	dServer.raceResults = snapshot.Results
+	// the keys of the party expired while it had no dynamic server
+	err = dServer.redisConnection.SetPartyConfiguration(snapshot.PartyID, snapshot.Configuration)
+	if err != nil {
+		return err
+	}
+	if snapshot.Configuration.InviteCode != "" {
+		_, err = dServer.redisConnection.SetInviteCode(snapshot.Configuration.InviteCode, snapshot.PartyID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, player := range snapshot.Players {
+		dServer.registerPlayer(player.PlayerUUID.String())
+	}
+	dServer.storeState()
+	dServer.checkAutoStart()
+	dServer.startHeartbeat()
+	dServer.checkpoint()
+	logger.Warning("party", snapshot.PartyID, "resumed in state", snapshot.State.String(), "at tick", snapshot.Tick,
+		"after", dServer.resumes, "resume(s)")
+	return nil
+}
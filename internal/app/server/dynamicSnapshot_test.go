@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/clnbs/autorace/internal/app/models"
+
+	"github.com/google/uuid"
+)
+
+func TestDynamicPartyServer_Snapshot(t *testing.T) {
+	player := models.NewPlayer("toto")
+	playerID := player.PlayerUUID.String()
+	configuration := models.PartyCreationToken{ClientID: playerID, PartyName: "toto party", Laps: 2}
+	party, err := models.NewParty(configuration, uuid.New().String())
+	if err != nil {
+		t.Fatal("unable to create party :", err)
+	}
+	err = party.AddPlayer(player)
+	if err != nil {
+		t.Fatal("unable to add player :", err)
+	}
+	dServer := &DynamicPartyServer{
+		party:                      party,
+		partyConfiguration:         configuration,
+		closestRacetrackPointIndex: map[string]int{playerID: 12},
+		done:                       make(chan struct{}),
+		ticks:                      240,
+		resumes:                    1,
+	}
+	dServer.party.SetState(models.RUN)
+	dServer.startRace()
+	dServer.race[playerID].Laps = 1
+	snapshot := dServer.snapshot()
+	dServer.race[playerID].Laps = 2
+	if snapshot.State != models.RUN || snapshot.Tick != 240 || snapshot.Resumes != 1 || snapshot.Configuration.Laps != 2 {
+		t.Error("unexpected snapshot", snapshot.State, snapshot.Tick, snapshot.Resumes, snapshot.Configuration.Laps)
+	}
+	if len(snapshot.Players) != 1 || snapshot.ClosestPoints[playerID] != 12 {
+		t.Error("expected the player and its racetrack point, got", snapshot.Players, snapshot.ClosestPoints)
+	}
+	if snapshot.Race[playerID] == nil || snapshot.Race[playerID].Laps != 1 {
+		t.Error("expected race progress to be copied, got", snapshot.Race[playerID])
+	}
+}
+
+func TestDynamicPartyServer_SnapshotWhileRacing(t *testing.T) {
+	party, err := models.NewParty(models.PartyCreationToken{PartyName: "toto party"}, uuid.New().String())
+	if err != nil {
+		t.Fatal("unable to create party :", err)
+	}
+	party.MapCircuit.TurnPoints = make([]models.TurnPoint, 200)
+	err = party.AddPlayer(models.NewPlayer("toto"))
+	if err != nil {
+		t.Fatal("unable to add player :", err)
+	}
+	dServer := &DynamicPartyServer{
+		party:                      party,
+		closestRacetrackPointIndex: make(map[string]int),
+		done:                       make(chan struct{}),
+	}
+	dServer.party.SetState(models.RUN)
+	dServer.startRace()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// players join and leave while the game loop runs and handlers take snapshots
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			player := models.NewPlayer("guest")
+			playerID := player.PlayerUUID.String()
+			_ = dServer.party.AddPlayer(player)
+			dServer.setClosestRacetrackPoint(playerID, 0)
+			_ = dServer.party.RemovePlayer(player)
+			dServer.forgetClosestRacetrackPoint(playerID)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			dServer.computeNewPosition(0.01)
+			dServer.advanceRace(0.01)
+			atomic.AddUint64(&dServer.ticks, 1)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		_, err = json.Marshal(dServer.snapshot())
+		if err != nil {
+			t.Fatal("unable to marshal snapshot :", err)
+		}
+	}
+	wg.Wait()
+	if snapshot := dServer.snapshot(); len(snapshot.Players) != 1 || snapshot.Tick != 200 {
+		t.Error("expected the player and 200 ticks, got", len(snapshot.Players), snapshot.Tick)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/database"
 	"github.com/clnbs/autorace/internal/pkg/mathtool"
+	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSyncMessageContent(t *testing.T) {
@@ -78,4 +82,82 @@ func TestSyncMessageContent(t *testing.T) {
 		t.Fatal("could not marshal state ack :", err)
 	}
 	fmt.Println("state ack :", string(jsonStateAck))
-}
\ No newline at end of file
+}
+
+// newEndedPartyServer create the dynamic server of an ended party without players, counting the
+// PartyEnded lobby events it sends. Redis is unreachable, so deregistering the party only logs errors
+func newEndedPartyServer(t *testing.T, endGrace time.Duration, partyEnded *int32) *DynamicPartyServer {
+	party, err := models.NewParty(models.PartyCreationToken{PartyName: "toto party"}, uuid.New().String())
+	if err != nil {
+		t.Fatal("unable to create party :", err)
+	}
+	party.SetState(models.END)
+	redisAddr := os.Getenv("REDIS_ADDR")
+	os.Setenv("REDIS_ADDR", "127.0.0.1:1")
+	defer os.Setenv("REDIS_ADDR", redisAddr)
+	return &DynamicPartyServer{
+		party:           party,
+		redisConnection: database.NewRedisClient(),
+		tickPerSecond:   120,
+		done:            make(chan struct{}),
+		endGrace:        endGrace,
+		publishLobbyEvent: func(lobbyEvent models.LobbyEvent) {
+			if lobbyEvent.Kind == models.PartyEnded {
+				atomic.AddInt32(partyEnded, 1)
+			}
+		},
+	}
+}
+
+// runParty runs the game loop of a dynamic server, the returned chan is closed once Run returned
+func runParty(dServer *DynamicPartyServer) chan struct{} {
+	returned := make(chan struct{})
+	go func() {
+		dServer.Run()
+		close(returned)
+	}()
+	return returned
+}
+
+func TestDynamicPartyServer_RunEndGrace(t *testing.T) {
+	var partyEnded int32
+	endGrace := 200 * time.Millisecond
+	dServer := newEndedPartyServer(t, endGrace, &partyEnded)
+	defer dServer.redisConnection.Close()
+	start := time.Now()
+	select {
+	case <-runParty(dServer):
+	case <-time.After(endGrace + 2*time.Second):
+		t.Fatal("Run did not return once the grace window was over")
+	}
+	if elapsed := time.Since(start); elapsed < endGrace {
+		t.Error("Run returned before the grace window was over, after", elapsed)
+	}
+	if atomic.LoadInt32(&partyEnded) != 0 {
+		t.Error("the lobby was told the party ended before it was deregistered")
+	}
+	dServer.Deregister()
+	dServer.Deregister()
+	if ended := atomic.LoadInt32(&partyEnded); ended != 1 {
+		t.Error("expected one PartyEnded event, got", ended)
+	}
+}
+
+func TestDynamicPartyServer_DeregisterDuringEndGrace(t *testing.T) {
+	var partyEnded int32
+	dServer := newEndedPartyServer(t, time.Hour, &partyEnded)
+	defer dServer.redisConnection.Close()
+	returned := runParty(dServer)
+	// let the game loop enter the grace window
+	time.Sleep(50 * time.Millisecond)
+	dServer.Deregister()
+	select {
+	case <-returned:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return once the party was deregistered")
+	}
+	dServer.Deregister()
+	if ended := atomic.LoadInt32(&partyEnded); ended != 1 {
+		t.Error("expected one PartyEnded event, got", ended)
+	}
+}
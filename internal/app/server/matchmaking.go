@@ -0,0 +1,387 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/database"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+	"github.com/clnbs/autorace/pkg/systool"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// MatchmakingInterval is the period between two matchmaking rounds
+	MatchmakingInterval = 2 * time.Second
+	// MatchmakingMinPlayers is the number of waiting players needed to create a fresh party
+	MatchmakingMinPlayers = 2
+	// MatchmakingTimeout is the time a player waits for other players before a fresh party is
+	// created anyway
+	MatchmakingTimeout = 20 * time.Second
+	// MatchmakingMembershipTTL is how long a player stays queued in Redis without being refreshed by a
+	// matchmaking round, players of a static server which stopped leave the queue once it is over
+	MatchmakingMembershipTTL = 10 * MatchmakingInterval
+	// quickPlayAutoStartAfter is the auto-start timer of quick play parties, in seconds
+	quickPlayAutoStartAfter = 30
+)
+
+// waitingPlayer is a player queued for a quick play party
+type waitingPlayer struct {
+	clientID string
+	since    time.Time
+}
+
+// pendingParty is a party created by the matchmaking whose dynamic server did not send its first
+// heartbeat yet. Its players are told about it once it can be joined
+type pendingParty struct {
+	partyID   string
+	partyName string
+	players   []waitingPlayer
+	createdAt time.Time
+}
+
+// matchmakingQueue holds players waiting for a quick play party
+type matchmakingQueue struct {
+	mutex   sync.Mutex
+	waiting []waitingPlayer
+	pending []pendingParty
+}
+
+func newMatchmakingQueue() *matchmakingQueue {
+	return &matchmakingQueue{}
+}
+
+// enqueue adds a player at the end of the queue, a player already waiting keeps its place
+func (queue *matchmakingQueue) enqueue(clientID string, now time.Time) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for _, player := range queue.waiting {
+		if player.clientID == clientID {
+			return
+		}
+	}
+	queue.waiting = append(queue.waiting, waitingPlayer{clientID: clientID, since: now})
+}
+
+// cancel removes a player from the queue. A player already matched with a pending party is not removed
+func (queue *matchmakingQueue) cancel(clientID string) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for index, player := range queue.waiting {
+		if player.clientID == clientID {
+			queue.waiting = append(queue.waiting[:index], queue.waiting[index+1:]...)
+			return
+		}
+	}
+}
+
+// matchResult is the outcome of a matchmaking round
+type matchResult struct {
+	// joins maps open party IDs to the players sent to them
+	joins map[string][]waitingPlayer
+	// newParties are groups of players needing a fresh party
+	newParties [][]waitingPlayer
+	// remaining players keep waiting
+	remaining []waitingPlayer
+}
+
+// matchPlayers sends waiting players to the fullest open parties first, then groups the others in
+// fresh parties once there are enough of them or once the oldest one waited too long
+func matchPlayers(waiting []waitingPlayer, openParties []models.PartySummary, now time.Time) matchResult {
+	result := matchResult{joins: make(map[string][]waitingPlayer)}
+	parties := make([]models.PartySummary, 0, len(openParties))
+	for _, party := range openParties {
+		if party.Matchable() {
+			parties = append(parties, party)
+		}
+	}
+	sort.SliceStable(parties, func(i, j int) bool {
+		return parties[i].PlayerCount > parties[j].PlayerCount
+	})
+	queue := waiting
+	for _, party := range parties {
+		room := party.Capacity - party.PlayerCount
+		for room > 0 && len(queue) > 0 {
+			result.joins[party.PartyID] = append(result.joins[party.PartyID], queue[0])
+			queue = queue[1:]
+			room--
+		}
+	}
+	for len(queue) > 0 {
+		groupSize := len(queue)
+		if groupSize > models.DefaultPartyCapacity {
+			groupSize = models.DefaultPartyCapacity
+		}
+		if groupSize < MatchmakingMinPlayers && now.Sub(queue[0].since) < MatchmakingTimeout {
+			break
+		}
+		result.newParties = append(result.newParties, queue[:groupSize])
+		queue = queue[groupSize:]
+	}
+	result.remaining = queue
+	return result
+}
+
+// ReceiveMatchRequest queues players asking for a quick play party
+// Matchmaking use case
+func (staticServer *StaticServer) ReceiveMatchRequest(readyToReceive chan bool) error {
+	return staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableHandler(
+		"autocar.matchmaking",            // topic
+		staticServer.matchRequestHandler, // handler
+		messaging.DefaultRetryPolicy,     // retry policy
+		readyToReceive,                   // ready to receive chan
+	)
+}
+
+func (staticServer *StaticServer) matchRequestHandler(msg amqp.Delivery) error {
+	var matchRequest models.MatchRequest
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.MatchRequestMessageType, &matchRequest)
+	if err != nil {
+		logger.Error("could not unmarshal match request :", err)
+		return messaging.Permanent(err)
+	}
+	err = verifySession(staticServer.sessions, sessionToken, matchRequest.ClientID)
+	if err != nil {
+		staticServer.rabbitConnection.SendMessageOnTopic(refusalResponse(err), "autocar.matchmaking."+matchRequest.ClientID)
+		return nil
+	}
+	if matchRequest.Cancel {
+		// the static server queuing the player drops it on its next round if it misses the cancellation
+		err = staticServer.redisConnection.LeaveMatchmaking(matchRequest.ClientID)
+		if err != nil {
+			logger.Error("unable to remove player from the matchmaking queue :", err)
+			return err
+		}
+		staticServer.matchmaking.cancel(matchRequest.ClientID)
+		staticServer.rabbitConnection.SendMessageOnTopic(models.MatchCancelled{ClientID: matchRequest.ClientID}, models.MatchCancelledTopic)
+		return nil
+	}
+	joined, err := staticServer.redisConnection.JoinMatchmaking(matchRequest.ClientID, MatchmakingMembershipTTL)
+	if err != nil {
+		logger.Error("unable to add player to the matchmaking queue :", err)
+		return err
+	}
+	// a player already queued, maybe by another static server, keeps its place
+	if joined {
+		staticServer.matchmaking.enqueue(matchRequest.ClientID, time.Now())
+	}
+	return nil
+}
+
+// ReceiveMatchCancelled removes players from the queue of this static server when another static
+// server received their cancellation. A missed cancellation is found on the next matchmaking round
+func (staticServer *StaticServer) ReceiveMatchCancelled(readyToReceive chan bool) error {
+	return staticServer.rabbitConnection.ReceiveMessageOnTopicWithHandler(
+		models.MatchCancelledTopic,         // topic
+		staticServer.matchCancelledHandler, // handler
+		readyToReceive,                     // ready to receive chan
+	)
+}
+
+func (staticServer *StaticServer) matchCancelledHandler(msg amqp.Delivery) {
+	var matchCancelled models.MatchCancelled
+	err := messaging.UnmarshalPayload(msg.Body, models.MatchCancelledMessageType, &matchCancelled)
+	if err != nil {
+		logger.Error("could not unmarshal match cancellation :", err)
+		return
+	}
+	staticServer.matchmaking.cancel(matchCancelled.ClientID)
+}
+
+// RunMatchmaker periodically matches queued players with parties. It returns when the server is closed
+func (staticServer *StaticServer) RunMatchmaker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			staticServer.matchmake()
+		case <-staticServer.rabbitConnection.Done():
+			return
+		}
+	}
+}
+
+func (staticServer *StaticServer) matchmake() {
+	staticServer.pushPendingParties()
+	queue := staticServer.matchmaking
+	queue.mutex.Lock()
+	waiting := queue.waiting
+	queue.waiting = nil
+	queue.mutex.Unlock()
+	if len(waiting) == 0 {
+		return
+	}
+	defer logger.Trace(systool.TimeTrack(time.Now(), "matchmake"))
+	waiting = staticServer.stillQueued(waiting)
+	result := matchPlayers(waiting, staticServer.openParties(), time.Now())
+	for partyID, players := range result.joins {
+		staticServer.leaveMatchmaking(players)
+		staticServer.sendMatchFound(models.MatchFound{PartyID: partyID}, players)
+	}
+	for _, players := range result.newParties {
+		partyToken := quickPlayPartyToken(players[0].clientID)
+		partyID, err := staticServer.createParty(partyToken)
+		if err != nil {
+			if isAdmissionRefusal(err) {
+				// players stay queued until a party ends
+				logger.Warning("quick play party refused by admission control :", err)
+			} else {
+				logger.Error("unable to create quick play party :", err)
+			}
+			result.remaining = append(result.remaining, players...)
+			continue
+		}
+		staticServer.leaveMatchmaking(players)
+		queue.mutex.Lock()
+		queue.pending = append(queue.pending, pendingParty{
+			partyID:   partyID,
+			partyName: partyToken.PartyName,
+			players:   players,
+			createdAt: time.Now(),
+		})
+		queue.mutex.Unlock()
+	}
+	// players queued meanwhile go after the ones who were already waiting
+	queue.mutex.Lock()
+	queue.waiting = append(result.remaining, queue.waiting...)
+	queue.mutex.Unlock()
+}
+
+// pushPendingParties tells players about their fresh party once its dynamic server is alive. Players
+// of a party which could not be launched are queued again
+func (staticServer *StaticServer) pushPendingParties() {
+	queue := staticServer.matchmaking
+	queue.mutex.Lock()
+	pending := queue.pending
+	queue.pending = nil
+	queue.mutex.Unlock()
+	var stillPending []pendingParty
+	var requeued []waitingPlayer
+	for _, party := range pending {
+		alive, err := staticServer.redisConnection.IsPartyAlive(party.partyID)
+		if err != nil {
+			logger.Error("unable to check party heartbeat :", err)
+		}
+		switch {
+		case alive:
+			staticServer.sendMatchFound(models.MatchFound{PartyID: party.partyID, PartyName: party.partyName}, party.players)
+		case time.Since(party.createdAt) >= database.PartyLaunchTTL:
+			logger.Warning("quick play party", party.partyID, "was not launched, players are queued again")
+			requeued = append(requeued, staticServer.rejoinMatchmaking(party.players)...)
+		default:
+			stillPending = append(stillPending, party)
+		}
+	}
+	queue.mutex.Lock()
+	queue.pending = append(queue.pending, stillPending...)
+	queue.waiting = append(requeued, queue.waiting...)
+	queue.mutex.Unlock()
+}
+
+// stillQueued refreshes the players waiting in the queue of this static server, and leaves out those who
+// left the queue through another static server. Players are kept if Redis can not tell
+func (staticServer *StaticServer) stillQueued(waiting []waitingPlayer) []waitingPlayer {
+	clientIDs := make([]string, len(waiting))
+	for index, player := range waiting {
+		clientIDs[index] = player.clientID
+	}
+	queuedIDs, err := staticServer.redisConnection.RefreshMatchmaking(clientIDs, MatchmakingMembershipTTL)
+	if err != nil {
+		logger.Error("unable to refresh the matchmaking queue :", err)
+		return waiting
+	}
+	return filterWaitingPlayers(waiting, queuedIDs)
+}
+
+// filterWaitingPlayers keeps the waiting players whose client ID is listed, in order
+func filterWaitingPlayers(waiting []waitingPlayer, clientIDs []string) []waitingPlayer {
+	listed := make(map[string]bool, len(clientIDs))
+	for _, clientID := range clientIDs {
+		listed[clientID] = true
+	}
+	kept := make([]waitingPlayer, 0, len(waiting))
+	for _, player := range waiting {
+		if listed[player.clientID] {
+			kept = append(kept, player)
+		}
+	}
+	return kept
+}
+
+// leaveMatchmaking removes matched players from the queue, so they can ask for another match
+func (staticServer *StaticServer) leaveMatchmaking(players []waitingPlayer) {
+	clientIDs := make([]string, len(players))
+	for index, player := range players {
+		clientIDs[index] = player.clientID
+	}
+	err := staticServer.redisConnection.LeaveMatchmaking(clientIDs...)
+	if err != nil {
+		logger.Error("unable to remove matched players from the matchmaking queue :", err)
+	}
+}
+
+// rejoinMatchmaking queues again players whose party could not be launched. Players who were queued
+// again meanwhile, maybe by another static server, are left out
+func (staticServer *StaticServer) rejoinMatchmaking(players []waitingPlayer) []waitingPlayer {
+	var rejoined []waitingPlayer
+	for _, player := range players {
+		joined, err := staticServer.redisConnection.JoinMatchmaking(player.clientID, MatchmakingMembershipTTL)
+		if err != nil {
+			logger.Error("unable to add player back to the matchmaking queue :", err)
+			continue
+		}
+		if joined {
+			rejoined = append(rejoined, player)
+		}
+	}
+	return rejoined
+}
+
+// openParties describe running parties which may accept quick play players
+func (staticServer *StaticServer) openParties() []models.PartySummary {
+	summaries, err := staticServer.registeredParties()
+	if err != nil {
+		logger.Error("unable to list running parties :", err)
+		return nil
+	}
+	return summaries
+}
+
+func (staticServer *StaticServer) sendMatchFound(matchFound models.MatchFound, players []waitingPlayer) {
+	if matchFound.PartyName == "" {
+		partyCreationToken, err := staticServer.redisConnection.GetPartyCreationToken(matchFound.PartyID)
+		if err == nil {
+			matchFound.PartyName = partyCreationToken.PartyName
+		}
+	}
+	for _, player := range players {
+		err := staticServer.rabbitConnection.SendMessageOnTopicWithConfirm(matchFound, "autocar.matchmaking."+player.clientID, messaging.DefaultConfirmTimeout)
+		if err != nil {
+			logger.Error("unable to send match to player", player.clientID, ":", err)
+		}
+	}
+}
+
+// quickPlayPartyToken create the configuration of a party created by the matchmaking
+func quickPlayPartyToken(hostID string) models.PartyCreationToken {
+	seed := int(time.Now().UnixNano() % 1000000)
+	return models.PartyCreationToken{
+		ClientID:       hostID,
+		Matchmade:      true,
+		Seed:           seed,
+		PartyName:      "quick play",
+		AutoStartAfter: quickPlayAutoStartAfter,
+		CircuitConfig: models.CircuitMapConfig{
+			Seed:     seed,
+			MaxPoint: 100,
+			MinPoint: 50,
+			XSize:    4000,
+			YSize:    4000,
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+)
+
+func newWaitingPlayers(count int, since time.Time) []waitingPlayer {
+	players := make([]waitingPlayer, count)
+	for index := range players {
+		players[index] = waitingPlayer{clientID: "player_" + strconv.Itoa(index), since: since}
+	}
+	return players
+}
+
+func TestMatchPlayers_OpenParties(t *testing.T) {
+	now := time.Now()
+	openParties := []models.PartySummary{
+		{PartyID: "almost_empty", PlayerCount: 1, Capacity: 8, State: models.LOBBY},
+		{PartyID: "almost_full", PlayerCount: 6, Capacity: 8, State: models.LOBBY},
+		{PartyID: "started", PlayerCount: 1, Capacity: 8, State: models.RUN},
+		{PartyID: "protected", PlayerCount: 1, Capacity: 8, State: models.LOBBY, PasswordProtected: true},
+	}
+	result := matchPlayers(newWaitingPlayers(3, now), openParties, now)
+	if len(result.joins["almost_full"]) != 2 {
+		t.Error("expected the fullest party to be filled first, got", len(result.joins["almost_full"]), "players")
+	}
+	if len(result.joins["almost_empty"]) != 1 {
+		t.Error("expected one player left for the other party, got", len(result.joins["almost_empty"]))
+	}
+	if len(result.joins["started"]) != 0 || len(result.joins["protected"]) != 0 {
+		t.Error("players should not be sent to started or password protected parties")
+	}
+	if len(result.newParties) != 0 || len(result.remaining) != 0 {
+		t.Error("every player should have been matched")
+	}
+}
+
+func TestMatchPlayers_NewParties(t *testing.T) {
+	now := time.Now()
+	result := matchPlayers(newWaitingPlayers(1, now), nil, now)
+	if len(result.newParties) != 0 || len(result.remaining) != 1 {
+		t.Error("a lonely player should keep waiting")
+	}
+	result = matchPlayers(newWaitingPlayers(1, now.Add(-MatchmakingTimeout)), nil, now)
+	if len(result.newParties) != 1 || len(result.remaining) != 0 {
+		t.Error("a player who waited too long should get a fresh party")
+	}
+	result = matchPlayers(newWaitingPlayers(models.DefaultPartyCapacity+1, now), nil, now)
+	if len(result.newParties) != 1 || len(result.newParties[0]) != models.DefaultPartyCapacity {
+		t.Fatal("expected one full fresh party, got", len(result.newParties))
+	}
+	if len(result.remaining) != 1 || result.remaining[0].clientID != "player_"+strconv.Itoa(models.DefaultPartyCapacity) {
+		t.Error("expected the last player to keep waiting")
+	}
+}
+
+func TestMatchmakingQueue(t *testing.T) {
+	queue := newMatchmakingQueue()
+	now := time.Now()
+	queue.enqueue("player_1", now)
+	queue.enqueue("player_2", now)
+	queue.enqueue("player_1", now.Add(time.Second))
+	if len(queue.waiting) != 2 {
+		t.Fatal("a player should only be queued once, got", len(queue.waiting), "waiting players")
+	}
+	queue.cancel("player_1")
+	if len(queue.waiting) != 1 || queue.waiting[0].clientID != "player_2" {
+		t.Error("expected player_1 to leave the queue")
+	}
+}
+
+func TestQuickPlayPartyToken(t *testing.T) {
+	partyToken := quickPlayPartyToken("player_1")
+	if partyToken.ClientID != "player_1" {
+		t.Error("expected player_1 to host the quick play party, got", partyToken.ClientID)
+	}
+	if partyToken.CreatorID() != "" {
+		t.Error("a quick play party should not count among the open parties of its host")
+	}
+}
+
+func TestFilterWaitingPlayers(t *testing.T) {
+	waiting := newWaitingPlayers(3, time.Now())
+	kept := filterWaitingPlayers(waiting, []string{"player_2", "player_0"})
+	if len(kept) != 2 || kept[0].clientID != "player_0" || kept[1].clientID != "player_2" {
+		t.Error("expected player_0 and player_2 to keep their place, got", kept)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/auth"
+	"github.com/clnbs/autorace/internal/pkg/database"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// ErrorPoolSessionSecret is returned when a dynamic server pool is created without SESSION_SECRET nor
+// SESSION_SECRET_FILE : parties hosted by a pool are not launched by a static server sharing its secret
+// with them
+var ErrorPoolSessionSecret = errors.New("SESSION_SECRET or SESSION_SECRET_FILE must be set to run a dynamic server pool")
+
+// poolRestarts is the number of times a pool restarts the crashed dynamic server of a party
+const poolRestarts = 3
+
+// ErrorPoolFull is returned when a pool is sent a party while every slot is in use, or while it stops
+var ErrorPoolFull = errors.New("pool is full or stopping")
+
+// DynamicPool hosts the dynamic servers of many parties in one process. Each party runs its own game
+// loop and listens to its own topics, like a dynamic server running alone. The pool advertises its
+// capacity in Redis, static servers reserve one of its slots before sending it a party
+type DynamicPool struct {
+	poolID           string
+	host             string
+	capacity         int
+	rabbitConfig     messaging.RabbitConnectionConfiguration
+	rabbitConnection *messaging.RabbitConnection
+	redisConnection  *database.RedisClient
+	partiesMutex     sync.Mutex
+	parties          map[string]chan struct{}
+	stopping         bool
+	running          sync.WaitGroup
+	runDynamicServer func(partyID string, stop <-chan struct{}) error
+}
+
+// NewDynamicPool create a DynamicPool hosting up to capacity parties
+func NewDynamicPool(rabbitConfig messaging.RabbitConnectionConfiguration, capacity int) (*DynamicPool, error) {
+	if !auth.HasSecretInEnv() {
+		return nil, ErrorPoolSessionSecret
+	}
+	pool := new(DynamicPool)
+	pool.poolID = uuid.New().String()
+	pool.capacity = capacity
+	pool.rabbitConfig = rabbitConfig
+	pool.parties = make(map[string]chan struct{})
+	pool.runDynamicServer = func(partyID string, stop <-chan struct{}) error {
+		return RunDynamicPartyServer(partyID, pool.rabbitConfig, stop)
+	}
+	var err error
+	pool.host, err = os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	pool.rabbitConnection, err = messaging.NewRabbitConnection(rabbitConfig)
+	if err != nil {
+		return nil, err
+	}
+	pool.rabbitConnection.SenderID = "pool." + pool.poolID
+	pool.redisConnection = database.NewRedisClient()
+	return pool, nil
+}
+
+// ReceiveLaunch handle requests to start a party in a slot of the pool
+func (pool *DynamicPool) ReceiveLaunch(readyToReceive chan bool) error {
+	return pool.rabbitConnection.ReceiveMessageOnTopicWithReliableHandler(
+		models.PoolLaunchTopic(pool.poolID), // topic
+		pool.launchHandler,                  // handler
+		messaging.DefaultRetryPolicy,        // retry policy
+		readyToReceive,                      // ready to receive chan
+	)
+}
+
+func (pool *DynamicPool) launchHandler(msg amqp.Delivery) error {
+	var poolLaunch models.PoolLaunch
+	err := messaging.UnmarshalPayload(msg.Body, models.PoolLaunchMessageType, &poolLaunch)
+	if err != nil {
+		logger.Error("unable to unmarshal pool launch :", err)
+		return messaging.Permanent(err)
+	}
+	err = pool.launch(poolLaunch.PartyID)
+	if err != nil {
+		logger.Warning("party", poolLaunch.PartyID, "is not launched :", err)
+		// the static server sees the party lost its slot and tells its creator the launch failed
+		releaseErr := pool.redisConnection.ReleasePoolSlot(pool.poolID, poolLaunch.PartyID)
+		if releaseErr != nil {
+			logger.Error("unable to release slot of party", poolLaunch.PartyID, ":", releaseErr)
+			return releaseErr
+		}
+		return messaging.Permanent(err)
+	}
+	return nil
+}
+
+// launch runs the dynamic server of a party in a new goroutine, until its party is over or the
+// pool stops. It returns ErrorPoolFull if the pool can not host the party
+func (pool *DynamicPool) launch(partyID string) error {
+	pool.partiesMutex.Lock()
+	if _, ok := pool.parties[partyID]; ok {
+		pool.partiesMutex.Unlock()
+		return nil
+	}
+	if pool.stopping || len(pool.parties) >= pool.capacity {
+		pool.partiesMutex.Unlock()
+		return ErrorPoolFull
+	}
+	stop := make(chan struct{})
+	pool.parties[partyID] = stop
+	pool.running.Add(1)
+	pool.partiesMutex.Unlock()
+	go func() {
+		defer pool.running.Done()
+		pool.run(partyID, stop)
+		pool.release(partyID)
+	}()
+	pool.advertise()
+	return nil
+}
+
+// run runs the dynamic server of a party until its party is over or stop is closed. A crashed dynamic
+// server is restarted in the slot of its party up to poolRestarts times, and resumes it from its snapshot :
+// a party losing its slot would be reaped by static servers
+func (pool *DynamicPool) run(partyID string, stop chan struct{}) {
+	for restarts := 0; ; restarts++ {
+		err := pool.runDynamicServer(partyID, stop)
+		if err == nil {
+			return
+		}
+		logger.Error("dynamic server of party", partyID, "stopped :", err)
+		if !errors.Is(err, ErrorServerCrashed) || restarts >= poolRestarts {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		logger.Warning("restarting dynamic server of party", partyID)
+	}
+}
+
+// release frees the slot of a party once its dynamic server stopped
+func (pool *DynamicPool) release(partyID string) {
+	pool.partiesMutex.Lock()
+	delete(pool.parties, partyID)
+	pool.partiesMutex.Unlock()
+	err := pool.redisConnection.ReleasePoolSlot(pool.poolID, partyID)
+	if err != nil {
+		logger.Error("unable to release slot of party", partyID, ":", err)
+	}
+	pool.advertise()
+}
+
+// advertise writes the record of the pool in Redis, unless the pool is stopping
+func (pool *DynamicPool) advertise() {
+	pool.partiesMutex.Lock()
+	if pool.stopping {
+		pool.partiesMutex.Unlock()
+		return
+	}
+	record := models.PoolRecord{
+		PoolID:    pool.poolID,
+		Host:      pool.host,
+		Capacity:  pool.capacity,
+		Parties:   len(pool.parties),
+		UpdatedAt: time.Now(),
+	}
+	pool.partiesMutex.Unlock()
+	err := pool.redisConnection.RegisterPool(record)
+	if err != nil {
+		logger.Error("unable to advertise pool :", err)
+	}
+}
+
+// Run advertises the pool until stop is closed, then stops every party of the pool and withdraws it
+func (pool *DynamicPool) Run(stop <-chan struct{}) {
+	pool.advertise()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pool.advertise()
+		case <-stop:
+			pool.partiesMutex.Lock()
+			pool.stopping = true
+			pool.partiesMutex.Unlock()
+			// static servers must not send parties to a stopping pool
+			err := pool.redisConnection.RemovePool(pool.poolID)
+			if err != nil {
+				logger.Error("unable to withdraw pool :", err)
+			}
+			pool.partiesMutex.Lock()
+			for _, partyStop := range pool.parties {
+				close(partyStop)
+			}
+			pool.partiesMutex.Unlock()
+			pool.running.Wait()
+			return
+		}
+	}
+}
+
+// Close terminate connection with Redis and RabbitMQ
+func (pool *DynamicPool) Close() error {
+	err := pool.redisConnection.Close()
+	if err != nil {
+		logger.Error("while closing Redis connection :", err)
+	}
+	return pool.rabbitConnection.Close()
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/container"
+	"github.com/clnbs/autorace/internal/pkg/database"
+	"github.com/clnbs/autorace/internal/pkg/messaging"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// PoolLauncherKind hands parties to dynamic server pools
+const PoolLauncherKind = "pool"
+
+// PoolLauncher hands new parties to the least loaded dynamic server pool advertising a free slot.
+// Pools are configured by their own environment, the launch environment is not used
+type PoolLauncher struct {
+	rabbitConnection *messaging.RabbitConnection
+	redisConnection  *database.RedisClient
+}
+
+// NewPoolLauncher create a PoolLauncher and its connections with Redis and RabbitMQ
+func NewPoolLauncher(rabbitConfig messaging.RabbitConnectionConfiguration) (*PoolLauncher, error) {
+	launcher := new(PoolLauncher)
+	var err error
+	launcher.rabbitConnection, err = messaging.NewRabbitConnection(rabbitConfig)
+	if err != nil {
+		return nil, err
+	}
+	launcher.redisConnection = database.NewRedisClient()
+	return launcher, nil
+}
+
+// Kind returns PoolLauncherKind
+func (launcher *PoolLauncher) Kind() string {
+	return PoolLauncherKind
+}
+
+// Launch reserves a slot for a party in the least loaded pool, then sends the party to this pool.
+// It returns the pool ID
+func (launcher *PoolLauncher) Launch(request container.LaunchRequest) (string, error) {
+	partyID := request.PartyID
+	records, err := launcher.redisConnection.GetPoolRecords()
+	if err != nil {
+		return "", err
+	}
+	for _, record := range models.SortPoolsByLoad(records) {
+		reserved, err := launcher.redisConnection.ReservePoolSlot(record.PoolID, partyID, record.Capacity)
+		if err != nil {
+			return "", err
+		}
+		if !reserved {
+			// the pool was filled meanwhile
+			continue
+		}
+		err = launcher.rabbitConnection.SendMessageOnTopicWithConfirm(
+			models.PoolLaunch{PartyID: partyID},
+			models.PoolLaunchTopic(record.PoolID),
+			messaging.DefaultConfirmTimeout,
+		)
+		if err != nil {
+			releaseErr := launcher.redisConnection.ReleasePoolSlot(record.PoolID, partyID)
+			if releaseErr != nil {
+				logger.Error("unable to release slot of party", partyID, ":", releaseErr)
+			}
+			return "", err
+		}
+		logger.Debug("party", partyID, "handed to pool", record.PoolID, "on", record.Host)
+		return record.PoolID, nil
+	}
+	return "", models.ErrorNoPoolAvailable
+}
+
+// Status tells what became of the dynamic server of a party. A party keeps its pool slot until its
+// dynamic server stops, or until the pool refuses it : a party without slot failed, unless it ended
+// and its launch record was removed with it
+func (launcher *PoolLauncher) Status(partyID string) (container.LaunchStatus, error) {
+	launchRecord, err := launcher.redisConnection.GetLaunchRecord(partyID)
+	if database.IsNotFound(err) {
+		return container.LaunchUnknown, nil
+	}
+	if err != nil {
+		return container.LaunchUnknown, err
+	}
+	hasSlot, err := launcher.redisConnection.HasPoolSlot(launchRecord.InstanceID, partyID)
+	if err != nil {
+		return container.LaunchUnknown, err
+	}
+	if !hasSlot {
+		return container.LaunchFailed, nil
+	}
+	return container.LaunchRunning, nil
+}
+
+// Close terminate connection with Redis and RabbitMQ
+func (launcher *PoolLauncher) Close() error {
+	err := launcher.redisConnection.Close()
+	if err != nil {
+		logger.Error("while closing Redis connection :", err)
+	}
+	return launcher.rabbitConnection.Close()
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clnbs/autorace/internal/pkg/database"
+)
+
+func TestDynamicPool_LaunchFull(t *testing.T) {
+	pool := &DynamicPool{
+		capacity: 0,
+		parties:  make(map[string]chan struct{}),
+	}
+	if err := pool.launch("party_1"); !errors.Is(err, ErrorPoolFull) {
+		t.Error("expected a full pool to refuse the party, got", err)
+	}
+	pool.capacity = 1
+	pool.stopping = true
+	if err := pool.launch("party_1"); !errors.Is(err, ErrorPoolFull) {
+		t.Error("expected a stopping pool to refuse the party, got", err)
+	}
+}
+
+func TestDynamicPool_RestartCrashed(t *testing.T) {
+	tests := []struct {
+		crashes  int32
+		err      error
+		runs     int32
+		keepSlot bool
+	}{
+		{0, nil, 1, true},
+		{2, ErrorServerCrashed, 3, true},
+		{poolRestarts + 1, ErrorServerCrashed, poolRestarts + 1, false},
+		{1, errors.New("unreachable"), 1, false},
+	}
+	// Redis is unreachable, releasing slots and advertising the pool only logs errors
+	redisAddr := os.Getenv("REDIS_ADDR")
+	os.Setenv("REDIS_ADDR", "127.0.0.1:1")
+	defer os.Setenv("REDIS_ADDR", redisAddr)
+	for _, test := range tests {
+		test := test
+		var runs int32
+		pool := &DynamicPool{
+			capacity:        1,
+			parties:         make(map[string]chan struct{}),
+			redisConnection: database.NewRedisClient(),
+			runDynamicServer: func(partyID string, stop <-chan struct{}) error {
+				if atomic.AddInt32(&runs, 1) <= test.crashes {
+					return test.err
+				}
+				<-stop
+				return nil
+			},
+		}
+		if err := pool.launch("party_1"); err != nil {
+			t.Fatal("unable to launch party :", err)
+		}
+		// let the pool restart the dynamic server
+		time.Sleep(100 * time.Millisecond)
+		if ran := atomic.LoadInt32(&runs); ran != test.runs {
+			t.Errorf("expected %d runs after %d errors %v, got %d", test.runs, test.crashes, test.err, ran)
+		}
+		pool.partiesMutex.Lock()
+		stop, hasSlot := pool.parties["party_1"]
+		pool.partiesMutex.Unlock()
+		if hasSlot != test.keepSlot {
+			t.Errorf("expected party to keep its slot to be %v after %d errors %v", test.keepSlot, test.crashes, test.err)
+		}
+		if hasSlot {
+			close(stop)
+		}
+		pool.running.Wait()
+		pool.redisConnection.Close()
+	}
+}
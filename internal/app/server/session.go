@@ -0,0 +1,18 @@
+package server
+
+import (
+	"time"
+
+	"github.com/clnbs/autorace/internal/pkg/auth"
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// verifySession checks a message was sent with a valid session token of the player it claims to
+// come from
+func verifySession(sessions *auth.SessionSigner, sessionToken, playerID string) error {
+	err := sessions.Verify(sessionToken, playerID, time.Now())
+	if err != nil {
+		logger.Warning("message refused for player", playerID, ":", err)
+	}
+	return err
+}
@@ -1,12 +1,13 @@
 package server
 
 import (
-	"encoding/json"
 	"errors"
-	"github.com/clnbs/autorace/internal/app/models"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/auth"
 	"github.com/clnbs/autorace/internal/pkg/container"
 	"github.com/clnbs/autorace/internal/pkg/database"
 	"github.com/clnbs/autorace/internal/pkg/messaging"
@@ -17,30 +18,72 @@ import (
 	"github.com/streadway/amqp"
 )
 
-//StaticServer handle creation request and the list of ongoing parties who had not launched yet.
+// inviteCodeAttempts is the number of invite codes generated before giving up when they are all taken
+const inviteCodeAttempts = 5
+
+// playerStore stores players and binds their names on them, it is implemented by database.RedisClient
+type playerStore interface {
+	SetPlayer(player *models.Player) error
+	ClaimPlayerName(name, playerID string, ttl time.Duration) (bool, error)
+	ReleasePlayerName(name, playerID string) error
+}
+
+// StaticServer handle creation request and the list of ongoing parties who had not launched yet.
 // Every time a party is created, it start a new DynamicPartyServer instance
 type StaticServer struct {
 	rabbitConnection *messaging.RabbitConnection
 	redisConnection  *database.RedisClient
+	players          playerStore
+	matchmaking      *matchmakingQueue
+	chat             *chatRelay
+	sessions         *auth.SessionSigner
+	uniqueNames      bool
+	launcher         container.Launcher
+	admission        AdmissionPolicy
+	host             string
 }
 
-// NewStaticServer generate a StaticServer (aka static server) with a particular RabbitMQ address
-func NewStaticServer(rabbitConfig messaging.RabbitConnectionConfiguration) (*StaticServer, error) {
+// NewStaticServer generate a StaticServer (aka static server) with a particular RabbitMQ address.
+// Dynamic servers are started with a given launcher. Player names are unique among active players if
+// PLAYER_NAME_UNIQUE is true. Party creations are limited with NewAdmissionPolicyFromEnv
+func NewStaticServer(rabbitConfig messaging.RabbitConnectionConfiguration, launcher container.Launcher) (*StaticServer, error) {
 	newCreatorServer := new(StaticServer)
 	var err error
+	newCreatorServer.launcher = launcher
+	newCreatorServer.host, err = os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	newCreatorServer.uniqueNames, _ = strconv.ParseBool(os.Getenv("PLAYER_NAME_UNIQUE"))
+	newCreatorServer.admission, err = NewAdmissionPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	newCreatorServer.redisConnection = database.NewRedisClient()
+	newCreatorServer.players = newCreatorServer.redisConnection
+	newCreatorServer.matchmaking = newMatchmakingQueue()
+	newCreatorServer.chat = newChatRelay()
+	newCreatorServer.sessions, err = auth.NewSessionSignerFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	newCreatorServer.rabbitConnection, err = messaging.NewRabbitConnection(rabbitConfig)
-	return newCreatorServer, err
+	if err != nil {
+		return nil, err
+	}
+	newCreatorServer.rabbitConnection.SenderID = "static"
+	return newCreatorServer, nil
 }
 
-//ReceivePlayerCreation create a player instance and store it in a Redis database
+// ReceivePlayerCreation create a player instance and store it in a Redis database
 // Player creation use case
 func (staticServer *StaticServer) ReceivePlayerCreation(readyToReceive chan bool) error {
-	return staticServer.rabbitConnection.ReceiveMessageOnTopicWithCallback(
+	return staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableCallback(
 		"autocar.player.creation",                        //topic
 		"autocar.player.creation",                        //response topic
 		staticServer.rabbitConnection.SendMessageOnTopic, //callback
 		staticServer.playerCreator,                       // response creator
+		messaging.DefaultRetryPolicy,                     // retry policy
 		readyToReceive,                                   // ready to receive chan
 	)
 }
@@ -48,42 +91,349 @@ func (staticServer *StaticServer) ReceivePlayerCreation(readyToReceive chan bool
 func (staticServer *StaticServer) playerCreator(msg amqp.Delivery) (interface{}, string) {
 	defer logger.Trace(systool.TimeTrack(time.Now(), "playerCreator"))
 	var playerCreationToken models.PlayerCreationToken
-	err := json.Unmarshal(msg.Body, &playerCreationToken)
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.PlayerCreationTokenMessageType, &playerCreationToken)
 	if err != nil {
-		return err, "." + playerCreationToken.SessionUUID.String()
+		return messaging.Permanent(err), "." + playerCreationToken.SessionUUID.String()
+	}
+	playerCreationToken.PlayerName, err = models.ValidatePlayerName(playerCreationToken.PlayerName)
+	if err != nil {
+		return refusalResponse(err), "." + playerCreationToken.SessionUUID.String()
+	}
+	newPlayer := staticServer.reclaimPlayer(playerCreationToken, sessionToken)
+	err = staticServer.claimPlayerName(newPlayer)
+	if err != nil {
+		return refusalResponse(err), "." + playerCreationToken.SessionUUID.String()
 	}
-	newPlayer := models.NewPlayer(playerCreationToken.PlayerName)
 	err = staticServer.redisConnection.SetPlayer(newPlayer)
 	if err != nil {
+		// a retry may create another player, it has to be able to claim the same name
+		staticServer.releasePlayerName(newPlayer)
 		return err, "." + playerCreationToken.SessionUUID.String()
 	}
-	return newPlayer, "." + playerCreationToken.SessionUUID.String()
+	playerSession := models.PlayerSession{Player: newPlayer}
+	playerSession.SessionToken, playerSession.ExpiresAt = staticServer.sessions.Issue(newPlayer.PlayerUUID.String(), time.Now())
+	return playerSession, "." + playerCreationToken.SessionUUID.String()
+}
+
+// reclaimPlayer returns the player a client asked for, renamed, if it was created in a previous session.
+// The client has to prove it owns the player with a session token issued for it, even one expired
+// for less than SESSION_RECLAIM_TTL. A new player is created otherwise, clients never choose the UUID
+// of a new player
+func (staticServer *StaticServer) reclaimPlayer(playerCreationToken models.PlayerCreationToken, sessionToken string) *models.Player {
+	newPlayer := models.NewPlayer(playerCreationToken.PlayerName)
+	if playerCreationToken.PlayerID == "" {
+		return newPlayer
+	}
+	err := staticServer.sessions.VerifyOwner(sessionToken, playerCreationToken.PlayerID, time.Now())
+	if err != nil {
+		logger.Warning("unable to reclaim player", playerCreationToken.PlayerID, ":", err)
+		return newPlayer
+	}
+	storedPlayer, err := staticServer.redisConnection.GetPlayer(playerCreationToken.PlayerID)
+	if err != nil {
+		if !database.IsNotFound(err) {
+			logger.Error("unable to get player to reclaim :", err)
+		}
+		return newPlayer
+	}
+	newPlayer.PlayerUUID = storedPlayer.PlayerUUID
+	return newPlayer
+}
+
+// claimPlayerName binds the name of a player on it while its session lasts, if names are unique
+func (staticServer *StaticServer) claimPlayerName(player *models.Player) error {
+	if !staticServer.uniqueNames {
+		return nil
+	}
+	claimed, err := staticServer.players.ClaimPlayerName(player.PlayerName, player.PlayerUUID.String(), staticServer.sessions.TTL())
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return models.ErrorPlayerNameTaken
+	}
+	return nil
+}
+
+// releasePlayerName unbinds the name of a player from it, if names are unique
+func (staticServer *StaticServer) releasePlayerName(player *models.Player) {
+	if !staticServer.uniqueNames {
+		return
+	}
+	err := staticServer.players.ReleasePlayerName(player.PlayerName, player.PlayerUUID.String())
+	if err != nil {
+		logger.Warning("unable to release name of player", player.PlayerUUID.String(), ":", err)
+	}
+}
+
+// ReceiveRename changes the name of a player, running parties are told about it
+// Player renaming use case
+func (staticServer *StaticServer) ReceiveRename(readyToReceive chan bool) error {
+	return staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableCallback(
+		"autocar.player.rename",                          //topic
+		"autocar.player.rename",                          //response topic
+		staticServer.rabbitConnection.SendMessageOnTopic, //callback
+		staticServer.renameResolver,                      // response creator
+		messaging.DefaultRetryPolicy,                     // retry policy
+		readyToReceive,                                   // ready to receive chan
+	)
+}
+
+func (staticServer *StaticServer) renameResolver(msg amqp.Delivery) (interface{}, string) {
+	var renameToken models.RenameToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.RenameTokenMessageType, &renameToken)
+	if err != nil {
+		logger.Error("could not unmarshal rename token :", err)
+		return messaging.Permanent(err), ""
+	}
+	playerID := renameToken.PlayerToken.ClientID
+	err = verifySession(staticServer.sessions, sessionToken, playerID)
+	if err != nil {
+		return refusalResponse(err), "." + playerID
+	}
+	playerName, err := models.ValidatePlayerName(renameToken.PlayerName)
+	if err != nil {
+		return refusalResponse(err), "." + playerID
+	}
+	player, err := staticServer.redisConnection.GetPlayer(playerID)
+	if database.IsNotFound(err) {
+		return refusalResponse(models.ErrorUnknownPlayer), "." + playerID
+	}
+	if err != nil {
+		return err, "." + playerID
+	}
+	oldName := player.PlayerName
+	player.PlayerName = playerName
+	err = staticServer.claimPlayerName(player)
+	if err != nil {
+		return refusalResponse(err), "." + playerID
+	}
+	err = staticServer.storeRenamedPlayer(player, oldName)
+	if err != nil {
+		return err, "." + playerID
+	}
+	staticServer.rabbitConnection.SendMessageOnTopic(models.PlayerRenamed{PlayerID: playerID}, models.PlayerRenamedTopic)
+	return player, "." + playerID
+}
+
+// storeRenamedPlayer stores a player whose new name is claimed, then releases its old name. If the
+// player could not be stored, its new name is released instead so it can be claimed again
+func (staticServer *StaticServer) storeRenamedPlayer(player *models.Player, oldName string) error {
+	nameChanged := models.NormalizePlayerName(oldName) != models.NormalizePlayerName(player.PlayerName)
+	err := staticServer.players.SetPlayer(player)
+	if err != nil {
+		if nameChanged {
+			staticServer.releasePlayerName(player)
+		}
+		return err
+	}
+	if nameChanged {
+		staticServer.releasePlayerName(&models.Player{PlayerUUID: player.PlayerUUID, PlayerName: oldName})
+	}
+	return nil
 }
 
-//ReceivePartyCreation store a party configuration in a Redis data and start a
+// ReceiveProfileRequest sends the profile of a player, with its career statistics
+// Player profile use case
+func (staticServer *StaticServer) ReceiveProfileRequest(readyToReceive chan bool) error {
+	return staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableCallback(
+		"autocar.player.profile",                         //topic
+		"autocar.player.profile",                         //response topic
+		staticServer.rabbitConnection.SendMessageOnTopic, //callback
+		staticServer.profileResolver,                     // response creator
+		messaging.DefaultRetryPolicy,                     // retry policy
+		readyToReceive,                                   // ready to receive chan
+	)
+}
+
+func (staticServer *StaticServer) profileResolver(msg amqp.Delivery) (interface{}, string) {
+	var profileRequest models.ProfileRequest
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ProfileRequestMessageType, &profileRequest)
+	if err != nil {
+		logger.Error("could not unmarshal profile request :", err)
+		return messaging.Permanent(err), ""
+	}
+	err = verifySession(staticServer.sessions, sessionToken, profileRequest.ClientID)
+	if err != nil {
+		return refusalResponse(err), "." + profileRequest.ClientID
+	}
+	playerID := profileRequest.PlayerID
+	if playerID == "" {
+		playerID = profileRequest.ClientID
+	}
+	player, err := staticServer.redisConnection.GetPlayer(playerID)
+	if database.IsNotFound(err) {
+		return messaging.ErrorResponse{
+			Code:         models.ErrorCodeUnknownPlayer,
+			ErrorMessage: models.ErrorUnknownPlayer.Error(),
+		}, "." + profileRequest.ClientID
+	}
+	if err != nil {
+		return err, "." + profileRequest.ClientID
+	}
+	profile, err := staticServer.redisConnection.GetPlayerProfile(playerID)
+	if database.IsNotFound(err) {
+		// players who never raced have an empty profile
+		return models.NewPlayerProfile(player), "." + profileRequest.ClientID
+	}
+	if err != nil {
+		return err, "." + profileRequest.ClientID
+	}
+	profile.PlayerName = player.PlayerName
+	return profile, "." + profileRequest.ClientID
+}
+
+// ReceivePartyCreation store a party configuration in a Redis data and start a
 // DynamicServer with a generated UUID
 // Party creation use case
 func (staticServer *StaticServer) ReceivePartyCreation(readyToReceive chan bool) error {
-	err := staticServer.rabbitConnection.ReceiveMessageOnTopicWithHandler(
+	retryPolicy := messaging.DefaultRetryPolicy
+	retryPolicy.OnDeadLetter = staticServer.partyCreationFailed
+	err := staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableHandler(
 		"autocar.party.creation",  //topic
-		staticServer.partyCreator, // response creator
+		staticServer.partyCreator, // handler
+		retryPolicy,               // retry policy
 		readyToReceive,            // ready to receive chan
 	)
 	return err
 }
 
-func (staticServer *StaticServer) partyCreator(msg amqp.Delivery) {
+func (staticServer *StaticServer) partyCreator(msg amqp.Delivery) error {
 	defer logger.Trace(systool.TimeTrack(time.Now(), "partyCreator"))
 	var partyCreationToken models.PartyCreationToken
-	err := json.Unmarshal(msg.Body, &partyCreationToken)
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.PartyCreationTokenMessageType, &partyCreationToken)
 	if err != nil {
 		logger.Error("error while decoding party creation token :", err)
+		return messaging.Permanent(err)
+	}
+	responseTopic := "autocar.party.creation." + partyCreationToken.ClientID
+	err = verifySession(staticServer.sessions, sessionToken, partyCreationToken.ClientID)
+	if err != nil {
+		staticServer.rabbitConnection.SendMessageOnTopic(refusalResponse(err), responseTopic)
+		return nil
+	}
+	// a creation request is only counted once, whatever its retries
+	if !messaging.IsRetry(msg) {
+		retryAfter, err := staticServer.countCreation(partyCreationToken.ClientID)
+		if isAdmissionRefusal(err) {
+			logger.Warning("party creation of player", partyCreationToken.ClientID, "refused :", err)
+			staticServer.rabbitConnection.SendMessageOnTopic(admissionRefusal(err, retryAfter), responseTopic)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	partyID, err := staticServer.createParty(partyCreationToken)
+	if isAdmissionRefusal(err) {
+		logger.Warning("party creation of player", partyCreationToken.ClientID, "refused :", err)
+		staticServer.rabbitConnection.SendMessageOnTopic(admissionRefusal(err, 0), responseTopic)
+		return nil
+	}
+	if err != nil {
+		return err
 	}
+	go staticServer.watchLaunch(partyID, partyCreationToken.ClientID, partyCreationToken.Private)
+	return nil
+}
+
+// launchPollInterval is the period between two checks of a dynamic server being launched
+const launchPollInterval = time.Second
+
+// watchLaunch waits for the dynamic server of a party to register in the server registry. When the
+// dynamic server exits or does not register within database.PartyLaunchTTL, the party is removed
+// and its creator is told
+func (staticServer *StaticServer) watchLaunch(partyID, clientID string, private bool) {
+	ticker := time.NewTicker(launchPollInterval)
+	defer ticker.Stop()
+	launchedAt := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-staticServer.rabbitConnection.Done():
+			return
+		}
+		alive, err := staticServer.redisConnection.IsPartyAlive(partyID)
+		if err != nil {
+			logger.Error("unable to check party heartbeat :", err)
+			continue
+		}
+		if alive {
+			return
+		}
+		switch {
+		case staticServer.launchEnded(partyID):
+			err = models.ErrorLaunchFailed
+		case time.Since(launchedAt) >= database.PartyLaunchTTL:
+			err = models.ErrorLaunchTimeout
+		default:
+			continue
+		}
+		staticServer.abortLaunch(partyID, clientID, private, err)
+		return
+	}
+}
+
+// abortLaunch removes a party whose dynamic server could not be started and tells its creator. A
+// dynamic server still starting is killed, when the launcher is able to
+func (staticServer *StaticServer) abortLaunch(partyID, clientID string, private bool, err error) {
+	logger.Warning("party", partyID, "was not launched :", err)
+	staticServer.killLaunch(partyID)
+	removeErr := staticServer.redisConnection.RemoveParty(partyID)
+	if removeErr != nil {
+		logger.Error("unable to remove party :", removeErr)
+	}
+	staticServer.rabbitConnection.SendMessageOnTopic(refusalResponse(err), "autocar.party.creation."+clientID)
+	if private {
+		return
+	}
+	lobbyEvent := models.LobbyEvent{
+		Kind:    models.PartyEnded,
+		PartyID: partyID,
+		State:   models.END,
+	}
+	staticServer.rabbitConnection.SendMessageOnLobbies(lobbyEvent, lobbyEvent.Topic())
+}
+
+// createParty registers a party configuration, starts its dynamic server and returns the party ID. The
+// party has to be admitted by admission control first, a matchmade party only counts among all the parties
+func (staticServer *StaticServer) createParty(partyCreationToken models.PartyCreationToken) (_ string, err error) {
 	newPartyUUID := uuid.New()
+	err = staticServer.redisConnection.AdmitParty(
+		newPartyUUID.String(),
+		partyCreationToken.CreatorID(),
+		staticServer.admission.MaxParties,
+		staticServer.admission.MaxOpenParties,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// a party which could not be launched is not counted
+		if err == nil {
+			return
+		}
+		releaseErr := staticServer.redisConnection.ReleaseParty(newPartyUUID.String(), partyCreationToken.CreatorID())
+		if releaseErr != nil {
+			logger.Error("unable to release party admission :", releaseErr)
+		}
+	}()
+	partyCreationToken.CreatedAt = time.Now()
+	partyCreationToken.MaxPlayers = partyCreationToken.Capacity()
+	// only the hash of the password is stored
+	partyCreationToken.PasswordHash = models.HashPartyPassword(newPartyUUID.String(), partyCreationToken.Password)
+	partyCreationToken.Password = ""
+	if partyCreationToken.Private {
+		partyCreationToken.InviteCode, err = staticServer.newInviteCode(newPartyUUID.String())
+		if err != nil {
+			logger.Error("unable to create invite code :", err)
+			return "", err
+		}
+	}
 	err = staticServer.redisConnection.SetPartyConfiguration(newPartyUUID.String(), partyCreationToken)
 	if err != nil {
-		staticServer.rabbitConnection.SendMessageOnTopic(errors.New("unable to register party with current configuration"), "autocar.parties.creation."+partyCreationToken.ClientID)
 		logger.Error("unable to register party :", err)
+		return "", err
 	}
 	envConfig := []string{
 		"FLUENTD_HOST=" + os.Getenv("FLUENTD_HOST"),
@@ -93,41 +443,383 @@ func (staticServer *StaticServer) partyCreator(msg amqp.Delivery) {
 		"RABBITMQ_PORT=" + os.Getenv("RABBITMQ_PORT"),
 		"RABBITMQ_USER=" + os.Getenv("RABBITMQ_USER"),
 		"RABBITMQ_PASS=" + os.Getenv("RABBITMQ_PASS"),
+		"REDIS_ADDR=" + os.Getenv("REDIS_ADDR"),
+		"CHAT_BANNED_WORDS=" + os.Getenv("CHAT_BANNED_WORDS"),
+	}
+	envConfig = append(envConfig, staticServer.sessions.Env()...)
+	instanceID, err := staticServer.launcher.Launch(container.LaunchRequest{
+		PartyID:       newPartyUUID.String(),
+		CreatorID:     partyCreationToken.ClientID,
+		Env:           envConfig,
+		SessionSecret: staticServer.sessions.Secret(),
+	})
+	if err != nil {
+		logger.Error("unable to start a party dynamic server :", err)
+		// the party will be registered again with a new UUID on the next attempt
+		removeErr := staticServer.redisConnection.RemovePartyCreationToken(newPartyUUID.String())
+		if removeErr != nil {
+			logger.Error("unable to remove party configuration :", removeErr)
+		}
+		return "", err
+	}
+	err = staticServer.redisConnection.SetLaunchRecord(models.LaunchRecord{
+		PartyID:    newPartyUUID.String(),
+		CreatorID:  partyCreationToken.ClientID,
+		Launcher:   staticServer.launcher.Kind(),
+		InstanceID: instanceID,
+		Host:       staticServer.host,
+		LaunchedAt: time.Now(),
+	})
+	if err != nil {
+		// the party runs anyway, it can not be killed from its launch record
+		logger.Error("unable to record party launch :", err)
+	}
+	if partyCreationToken.Private {
+		return newPartyUUID.String(), nil
+	}
+	lobbyEvent := models.LobbyEvent{
+		Kind:        models.PartyCreated,
+		PartyID:     newPartyUUID.String(),
+		PartyName:   partyCreationToken.PartyName,
+		PlayerID:    partyCreationToken.ClientID,
+		PlayerCount: 1,
+		State:       models.LOBBY,
+	}
+	staticServer.rabbitConnection.SendMessageOnLobbies(lobbyEvent, lobbyEvent.Topic())
+	return newPartyUUID.String(), nil
+}
+
+// newInviteCode generate an invite code and binds it on a party
+func (staticServer *StaticServer) newInviteCode(partyID string) (string, error) {
+	for attempt := 0; attempt < inviteCodeAttempts; attempt++ {
+		inviteCode, err := models.NewInviteCode()
+		if err != nil {
+			return "", err
+		}
+		ok, err := staticServer.redisConnection.SetInviteCode(inviteCode, partyID)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return inviteCode, nil
+		}
+	}
+	return "", errors.New("no invite code available")
+}
+
+// partyCreationFailed tells the client that its party could not be created once every attempt failed
+func (staticServer *StaticServer) partyCreationFailed(msg amqp.Delivery, err error) {
+	var partyCreationToken models.PartyCreationToken
+	if messaging.UnmarshalPayload(msg.Body, models.PartyCreationTokenMessageType, &partyCreationToken) != nil || partyCreationToken.ClientID == "" {
+		return
 	}
-	err = container.CreateDynamicServer(newPartyUUID.String(), envConfig)
+	err = staticServer.rabbitConnection.SendMessageOnTopicWithConfirm(
+		messaging.ErrorResponse{Code: messaging.ErrorCodeInternal, ErrorMessage: "unable to create party : " + err.Error()},
+		"autocar.party.creation."+partyCreationToken.ClientID,
+		messaging.DefaultConfirmTimeout,
+	)
 	if err != nil {
-		staticServer.rabbitConnection.SendMessageOnTopic(errors.New("unable to start a party server"), "autocar.parties.creation."+partyCreationToken.ClientID)
-		logger.Error("unable to start a party container :", err)
+		logger.Error("unable to notify client of party creation failure :", err)
 	}
 }
 
-//PartyListRequest generate a party list from registered party in a Redis database
+// PartyListRequest generate a party list from registered party in a Redis database
 // List all current parties use case
 func (staticServer *StaticServer) PartyListRequest(readyToReceive chan bool) error {
 	defer logger.Trace(systool.TimeTrack(time.Now(), "PartyListRequest"))
-	err := staticServer.rabbitConnection.ReceiveMessageOnTopicWithCallback(
+	err := staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableCallback(
 		"autocar.party.list",                             //topic
 		"autocar.party.list",                             //response topic
 		staticServer.rabbitConnection.SendMessageOnTopic, //callback
 		staticServer.partyListCreator,                    // response creator
+		messaging.DefaultRetryPolicy,                     // retry policy
 		readyToReceive,                                   // ready to receive chan
 	)
 	return err
 }
 
 func (staticServer *StaticServer) partyListCreator(msg amqp.Delivery) (interface{}, string) {
-	var partyList []string
-	var clientID string
-	err := json.Unmarshal(msg.Body, &clientID)
+	var listRequest models.PartyListRequest
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.PartyListRequestMessageType, &listRequest)
+	if err != nil {
+		logger.Error("could not unmarshal party list request :", err)
+		return messaging.Permanent(err), ""
+	}
+	err = verifySession(staticServer.sessions, sessionToken, listRequest.ClientID)
+	if err != nil {
+		return refusalResponse(err), "." + listRequest.ClientID
+	}
+	summaries, err := staticServer.registeredParties()
+	if err != nil {
+		return err, "." + listRequest.ClientID
+	}
+	return models.NewPartyListPage(summaries, listRequest), "." + listRequest.ClientID
+}
+
+// registeredParties describe every party whose dynamic server is in the server registry. Parties
+// still being launched are not described
+func (staticServer *StaticServer) registeredParties() ([]models.PartySummary, error) {
+	records, err := staticServer.redisConnection.GetServerRecords()
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]models.PartySummary, 0, len(records))
+	for _, record := range records {
+		summary, err := staticServer.partySummary(record)
+		if err != nil {
+			logger.Warning("unable to describe party", record.PartyID, ":", err)
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// partySummary describe a party from its configuration, its running state and the registry record
+// of its dynamic server
+func (staticServer *StaticServer) partySummary(record models.ServerRecord) (models.PartySummary, error) {
+	partyCreationToken, err := staticServer.redisConnection.GetPartyCreationToken(record.PartyID)
+	if err != nil {
+		return models.PartySummary{}, err
+	}
+	summary := models.PartySummary{
+		PartyID:           record.PartyID,
+		PartyName:         partyCreationToken.PartyName,
+		PlayerCount:       record.PlayerCount,
+		Capacity:          partyCreationToken.Capacity(),
+		CircuitConfig:     partyCreationToken.CircuitConfig,
+		CreatedAt:         partyCreationToken.CreatedAt,
+		Private:           partyCreationToken.Private,
+		PasswordProtected: partyCreationToken.PasswordHash != "",
+	}
+	host, err := staticServer.redisConnection.GetPlayer(partyCreationToken.ClientID)
+	if err == nil {
+		summary.HostName = host.PlayerName
+	}
+	summary.State, err = staticServer.redisConnection.GetPartyState(record.PartyID)
+	if err != nil {
+		return models.PartySummary{}, err
+	}
+	return summary, nil
+}
+
+// ReceiveInviteRequest resolves invite codes of private parties
+func (staticServer *StaticServer) ReceiveInviteRequest(readyToReceive chan bool) error {
+	return staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableCallback(
+		"autocar.party.invite",                           //topic
+		"autocar.party.invite",                           //response topic
+		staticServer.rabbitConnection.SendMessageOnTopic, //callback
+		staticServer.inviteResolver,                      // response creator
+		messaging.DefaultRetryPolicy,                     // retry policy
+		readyToReceive,                                   // ready to receive chan
+	)
+}
+
+func (staticServer *StaticServer) inviteResolver(msg amqp.Delivery) (interface{}, string) {
+	var inviteRequest models.InviteRequest
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.InviteRequestMessageType, &inviteRequest)
+	if err != nil {
+		logger.Error("could not unmarshal invite request :", err)
+		return messaging.Permanent(err), ""
+	}
+	err = verifySession(staticServer.sessions, sessionToken, inviteRequest.ClientID)
+	if err != nil {
+		return refusalResponse(err), "." + inviteRequest.ClientID
+	}
+	inviteCode := models.NormalizeInviteCode(inviteRequest.InviteCode)
+	partyID, err := staticServer.redisConnection.ResolveInviteCode(inviteCode)
+	if database.IsNotFound(err) {
+		return messaging.ErrorResponse{
+			Code:         models.ErrorCodeInviteNotFound,
+			ErrorMessage: models.ErrorInviteNotFound.Error(),
+		}, "." + inviteRequest.ClientID
+	}
+	if err != nil {
+		return err, "." + inviteRequest.ClientID
+	}
+	partyInvite := models.PartyInvite{
+		InviteCode: inviteCode,
+		PartyID:    partyID,
+	}
+	partyCreationToken, err := staticServer.redisConnection.GetPartyCreationToken(partyID)
+	if err == nil {
+		partyInvite.PartyName = partyCreationToken.PartyName
+	}
+	return partyInvite, "." + inviteRequest.ClientID
+}
+
+// ReceiveLobbyChat relays chat messages of the lobby-wide channel on the lobbies exchange
+func (staticServer *StaticServer) ReceiveLobbyChat(readyToReceive chan bool) error {
+	return staticServer.rabbitConnection.ReceiveMessageOnSharedQueueWithReliableHandler(
+		"autocar.chat.lobby",          // topic
+		staticServer.lobbyChatHandler, // handler
+		messaging.DefaultRetryPolicy,  // retry policy
+		readyToReceive,                // ready to receive chan
+	)
+}
+
+func (staticServer *StaticServer) lobbyChatHandler(msg amqp.Delivery) error {
+	var chatToken models.ChatToken
+	sessionToken, err := messaging.UnmarshalSignedPayload(msg.Body, models.ChatTokenMessageType, &chatToken)
+	if err != nil {
+		logger.Error("could not unmarshal chat message :", err)
+		return messaging.Permanent(err)
+	}
+	playerID := chatToken.PlayerToken.ClientID
+	err = verifySession(staticServer.sessions, sessionToken, playerID)
+	if err != nil {
+		staticServer.rabbitConnection.SendMessageOnTopic(chatErrorResponse(err), "autocar.chat.lobby."+playerID)
+		return nil
+	}
+	player, err := staticServer.redisConnection.GetPlayer(playerID)
+	if err != nil {
+		logger.Warning("chat message from unknown player", playerID, ":", err)
+		return nil
+	}
+	allowed, err := staticServer.allowLobbyChat(playerID)
+	if err != nil {
+		logger.Error("unable to count chat message :", err)
+		return err
+	}
+	if !allowed {
+		staticServer.rabbitConnection.SendMessageOnTopic(chatErrorResponse(models.ErrorChatRateLimited), "autocar.chat.lobby."+playerID)
+		return nil
+	}
+	chatMessage, err := staticServer.chat.compose(models.ChatLobbyChannel, chatToken, player.PlayerName, time.Now())
+	if err != nil {
+		staticServer.rabbitConnection.SendMessageOnTopic(chatErrorResponse(err), "autocar.chat.lobby."+playerID)
+		return nil
+	}
+	staticServer.rabbitConnection.SendMessageOnLobbies(chatMessage, models.LobbyChatTopic)
+	return nil
+}
+
+// allowLobbyChat tells if a player can send a lobby chat message now. Static servers share the count in
+// Redis : a player sends at most chatBurst messages in chatBurst refill intervals, whatever the static
+// server handling them
+func (staticServer *StaticServer) allowLobbyChat(playerID string) (bool, error) {
+	messages, _, err := staticServer.redisConnection.CountLobbyChatMessage(playerID, chatBurst*chatRefillInterval)
 	if err != nil {
-		logger.Error("could not unmarshal client ID :", err)
-		return err, ""
+		return false, err
 	}
-	partyList, err = staticServer.redisConnection.GetPartyList()
+	return messages <= chatBurst, nil
+}
+
+// ReaperInterval is the period between two orphan party reaping
+const ReaperInterval = 30 * time.Second
+
+// RunReaper periodically removes parties whose dynamic server stopped sending heartbeats. It returns
+// when the server is closed
+func (staticServer *StaticServer) RunReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			staticServer.reapOrphanParties()
+		case <-staticServer.rabbitConnection.Done():
+			return
+		}
+	}
+}
+
+func (staticServer *StaticServer) reapOrphanParties() {
+	defer logger.Trace(systool.TimeTrack(time.Now(), "reapOrphanParties"))
+	registeredParties, err := staticServer.redisConnection.GetPartyList()
 	if err != nil {
-		return err, "." + clientID
+		logger.Error("unable to list registered parties :", err)
+		return
+	}
+	runningParties, err := staticServer.redisConnection.GetRunningPartyList()
+	if err != nil {
+		logger.Error("unable to list running parties :", err)
+		return
+	}
+	checked := make(map[string]bool)
+	for _, partyID := range append(registeredParties, runningParties...) {
+		if checked[partyID] {
+			continue
+		}
+		checked[partyID] = true
+		if !staticServer.isOrphan(partyID) {
+			continue
+		}
+		logger.Warning("removing orphan party", partyID)
+		err = staticServer.redisConnection.RemoveParty(partyID)
+		if err != nil {
+			logger.Error("unable to remove orphan party :", err)
+			continue
+		}
+		lobbyEvent := models.LobbyEvent{
+			Kind:    models.PartyEnded,
+			PartyID: partyID,
+			State:   models.END,
+		}
+		staticServer.rabbitConnection.SendMessageOnLobbies(lobbyEvent, lobbyEvent.Topic())
+	}
+}
+
+// isOrphan tells if a party has no live dynamic server. A party still being launched, or which can still
+// be resumed from its snapshot, is not an orphan, unless the launcher reports its dynamic server already exited
+func (staticServer *StaticServer) isOrphan(partyID string) bool {
+	alive, err := staticServer.redisConnection.IsPartyAlive(partyID)
+	if err != nil {
+		logger.Error("unable to check party heartbeat :", err)
+		return false
+	}
+	if alive {
+		return false
+	}
+	if staticServer.launchEnded(partyID) {
+		return true
+	}
+	snapshot, err := staticServer.redisConnection.GetPartySnapshot(partyID)
+	if err == nil && snapshot.State != models.END {
+		return false
+	}
+	partyCreationToken, err := staticServer.redisConnection.GetPartyCreationToken(partyID)
+	if database.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		logger.Error("unable to get party configuration :", err)
+		return false
+	}
+	return time.Since(partyCreationToken.CreatedAt) >= database.PartyLaunchTTL
+}
+
+// killLaunch kills the instance started for a party, from its launch record
+func (staticServer *StaticServer) killLaunch(partyID string) {
+	killer, ok := staticServer.launcher.(container.Killer)
+	if !ok {
+		return
+	}
+	launchRecord, err := staticServer.redisConnection.GetLaunchRecord(partyID)
+	if err != nil || launchRecord.InstanceID == "" {
+		return
+	}
+	err = killer.Kill(launchRecord.InstanceID)
+	if err != nil {
+		logger.Warning("unable to kill dynamic server of party", partyID, ":", err)
+	}
+}
+
+// launchEnded tells if the launcher reports the dynamic server of a party exited. It is always false
+// with launchers unable to report the status of dynamic servers
+func (staticServer *StaticServer) launchEnded(partyID string) bool {
+	reporter, ok := staticServer.launcher.(container.StatusReporter)
+	if !ok {
+		return false
+	}
+	status, err := reporter.Status(partyID)
+	if err != nil {
+		logger.Error("unable to get dynamic server status of party", partyID, ":", err)
+		return false
+	}
+	if status == container.LaunchFailed {
+		logger.Warning("dynamic server of party", partyID, "failed")
 	}
-	return partyList, "." + clientID
+	return status == container.LaunchFailed || status == container.LaunchSucceeded
 }
 
 // Close is used to terminate ongoing connection with Redis and RabbitMQ
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clnbs/autorace/internal/app/models"
+	"github.com/clnbs/autorace/internal/pkg/auth"
+	"github.com/clnbs/autorace/internal/pkg/container"
+	"github.com/google/uuid"
+)
+
+// reportingLauncher is a launcher reporting the same status for every party
+type reportingLauncher struct {
+	status container.LaunchStatus
+	err    error
+}
+
+func (launcher reportingLauncher) Kind() string {
+	return "reporting"
+}
+
+func (launcher reportingLauncher) Launch(container.LaunchRequest) (string, error) {
+	return "", nil
+}
+
+func (launcher reportingLauncher) Status(string) (container.LaunchStatus, error) {
+	return launcher.status, launcher.err
+}
+
+func TestStaticServer_LaunchEnded(t *testing.T) {
+	tests := []struct {
+		launcher container.Launcher
+		expected bool
+	}{
+		{container.NewProcessLauncher("dynamic"), false},
+		{reportingLauncher{status: container.LaunchPending}, false},
+		{reportingLauncher{status: container.LaunchRunning}, false},
+		{reportingLauncher{status: container.LaunchUnknown}, false},
+		{reportingLauncher{status: container.LaunchFailed, err: errors.New("unreachable")}, false},
+		{reportingLauncher{status: container.LaunchFailed}, true},
+		{reportingLauncher{status: container.LaunchSucceeded}, true},
+	}
+	for _, test := range tests {
+		staticServer := &StaticServer{launcher: test.launcher}
+		if ended := staticServer.launchEnded("party_1"); ended != test.expected {
+			t.Errorf("expected launchEnded to be %v with %+v", test.expected, test.launcher)
+		}
+	}
+}
+
+// namingStore is an in-memory player store binding normalized names on player IDs
+type namingStore struct {
+	names  map[string]string
+	setErr error
+}
+
+func (store *namingStore) SetPlayer(*models.Player) error {
+	return store.setErr
+}
+
+func (store *namingStore) ClaimPlayerName(name, playerID string, _ time.Duration) (bool, error) {
+	owner, taken := store.names[models.NormalizePlayerName(name)]
+	if taken && owner != playerID {
+		return false, nil
+	}
+	store.names[models.NormalizePlayerName(name)] = playerID
+	return true, nil
+}
+
+func (store *namingStore) ReleasePlayerName(name, playerID string) error {
+	if store.names[models.NormalizePlayerName(name)] == playerID {
+		delete(store.names, models.NormalizePlayerName(name))
+	}
+	return nil
+}
+
+func TestStaticServer_StoreRenamedPlayer(t *testing.T) {
+	tests := []struct {
+		setErr   error
+		released string
+		kept     string
+	}{
+		{nil, "alice", "bob"},
+		{errors.New("unreachable"), "bob", "alice"},
+	}
+	for _, test := range tests {
+		store := &namingStore{names: map[string]string{}, setErr: test.setErr}
+		staticServer := &StaticServer{
+			players:     store,
+			uniqueNames: true,
+			sessions:    auth.NewSessionSigner([]byte("secret"), time.Hour),
+		}
+		player := &models.Player{PlayerUUID: uuid.New(), PlayerName: "alice"}
+		if err := staticServer.claimPlayerName(player); err != nil {
+			t.Fatal("unable to claim name:", err)
+		}
+		player.PlayerName = "bob"
+		if err := staticServer.claimPlayerName(player); err != nil {
+			t.Fatal("unable to claim new name:", err)
+		}
+		if err := staticServer.storeRenamedPlayer(player, "alice"); err != test.setErr {
+			t.Errorf("expected error %v, got %v", test.setErr, err)
+		}
+		if _, taken := store.names[models.NormalizePlayerName(test.released)]; taken {
+			t.Errorf("expected %s to be released with error %v", test.released, test.setErr)
+		}
+		if _, taken := store.names[models.NormalizePlayerName(test.kept)]; !taken {
+			t.Errorf("expected %s to be kept with error %v", test.kept, test.setErr)
+		}
+		other := &models.Player{PlayerUUID: uuid.New(), PlayerName: test.released}
+		if err := staticServer.claimPlayerName(other); err != nil {
+			t.Errorf("expected %s to be claimable again, got %v", test.released, err)
+		}
+	}
+}
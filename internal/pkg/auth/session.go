@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// DefaultSessionTTL is how long a session token is valid when SESSION_TTL is not set
+const DefaultSessionTTL = 24 * time.Hour
+
+// DefaultSessionReclaimTTL is how long an expired session token still reclaims its player when
+// SESSION_RECLAIM_TTL is not set
+const DefaultSessionReclaimTTL = 30 * 24 * time.Hour
+
+// secretSize is the size in bytes of a generated session secret
+const secretSize = 32
+
+var (
+	// ErrorInvalidSession is returned when a session token is missing, forged, or issued for another player
+	ErrorInvalidSession = errors.New("invalid session token")
+	// ErrorSessionExpired is returned when a session token expired, the player has to be created again
+	ErrorSessionExpired = errors.New("session token expired")
+)
+
+// error codes sent in an ErrorResponse when a session token is refused
+const (
+	// ErrorCodeInvalidSession is sent when a session token is missing, forged, or issued for another player
+	ErrorCodeInvalidSession = "invalid_session"
+	// ErrorCodeSessionExpired is sent when a session token expired
+	ErrorCodeSessionExpired = "session_expired"
+)
+
+// SessionSigner issues and verifies session tokens. A session token binds a player ID to an
+// expiry date, signed with HMAC-SHA256 : "[@playerID].[@expiry].[@signature]"
+type SessionSigner struct {
+	secret     []byte
+	ttl        time.Duration
+	reclaimTTL time.Duration
+}
+
+// NewSessionSigner create a SessionSigner from a secret, tokens are valid for a given duration and
+// reclaim their player during DefaultSessionReclaimTTL once expired
+func NewSessionSigner(secret []byte, ttl time.Duration) *SessionSigner {
+	return &SessionSigner{
+		secret:     secret,
+		ttl:        ttl,
+		reclaimTTL: DefaultSessionReclaimTTL,
+	}
+}
+
+// NewSessionSignerFromEnv create a SessionSigner from SESSION_SECRET, or from the file named by
+// SESSION_SECRET_FILE, SESSION_TTL and SESSION_RECLAIM_TTL (Go durations, DefaultSessionTTL and
+// DefaultSessionReclaimTTL if they are not set). A random secret is generated if neither
+// SESSION_SECRET nor SESSION_SECRET_FILE is set : tokens are then only valid in this process, it
+// is meant for a single static server running its parties in goroutines
+func NewSessionSignerFromEnv() (*SessionSigner, error) {
+	ttl := DefaultSessionTTL
+	if rawTTL := os.Getenv("SESSION_TTL"); rawTTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(rawTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reclaimTTL := DefaultSessionReclaimTTL
+	if rawReclaimTTL := os.Getenv("SESSION_RECLAIM_TTL"); rawReclaimTTL != "" {
+		var err error
+		reclaimTTL, err = time.ParseDuration(rawReclaimTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if secretFile := os.Getenv("SESSION_SECRET_FILE"); len(secret) == 0 && secretFile != "" {
+		content, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		// secret files usually end with a new line
+		secret = bytes.TrimSpace(content)
+	}
+	if len(secret) == 0 {
+		logger.Warning("SESSION_SECRET is not set, a random secret is generated : session tokens are only valid in this process")
+		randomSecret := make([]byte, secretSize)
+		_, err := rand.Read(randomSecret)
+		if err != nil {
+			return nil, err
+		}
+		// the secret is written in files handed to dynamic servers
+		secret = []byte(base64.RawURLEncoding.EncodeToString(randomSecret))
+	}
+	signer := NewSessionSigner(secret, ttl)
+	signer.reclaimTTL = reclaimTTL
+	return signer, nil
+}
+
+// HasSecretInEnv tells if a session secret is set by SESSION_SECRET or SESSION_SECRET_FILE, so it is
+// shared by every server configured alike
+func HasSecretInEnv() bool {
+	return os.Getenv("SESSION_SECRET") != "" || os.Getenv("SESSION_SECRET_FILE") != ""
+}
+
+// Env returns the environment variables configuring a SessionSigner like this one. The secret is
+// not part of it, launchers hand it over by reference
+func (signer *SessionSigner) Env() []string {
+	return []string{
+		"SESSION_TTL=" + signer.ttl.String(),
+		"SESSION_RECLAIM_TTL=" + signer.reclaimTTL.String(),
+	}
+}
+
+// Secret returns the secret signing the session tokens, to be shared with the dynamic servers
+func (signer *SessionSigner) Secret() []byte {
+	return signer.secret
+}
+
+// TTL returns how long the tokens issued by this signer are valid
+func (signer *SessionSigner) TTL() time.Duration {
+	return signer.ttl
+}
+
+// Issue create a session token for a player, valid from now on
+func (signer *SessionSigner) Issue(playerID string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(signer.ttl).Truncate(time.Second)
+	claims := playerID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return claims + "." + signer.sign(claims), expiresAt
+}
+
+// Verify checks a session token was issued by a signer sharing this one's secret, for a given
+// player, and did not expire
+func (signer *SessionSigner) Verify(token, playerID string, now time.Time) error {
+	expiresAt, err := signer.authenticate(token, playerID)
+	if err != nil {
+		return err
+	}
+	if !now.Before(expiresAt) {
+		return ErrorSessionExpired
+	}
+	return nil
+}
+
+// VerifyOwner checks a session token was issued for a given player, even if it expired less than
+// the reclaim duration ago. It is used to reclaim a player whose session is over
+func (signer *SessionSigner) VerifyOwner(token, playerID string, now time.Time) error {
+	expiresAt, err := signer.authenticate(token, playerID)
+	if err != nil {
+		return err
+	}
+	if !now.Before(expiresAt.Add(signer.reclaimTTL)) {
+		return ErrorSessionExpired
+	}
+	return nil
+}
+
+func (signer *SessionSigner) authenticate(token, playerID string) (time.Time, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return time.Time{}, ErrorInvalidSession
+	}
+	claims, signature := token[:separator], token[separator+1:]
+	if !hmac.Equal([]byte(signature), []byte(signer.sign(claims))) {
+		return time.Time{}, ErrorInvalidSession
+	}
+	separator = strings.LastIndex(claims, ".")
+	if separator < 0 || claims[:separator] != playerID {
+		return time.Time{}, ErrorInvalidSession
+	}
+	expiry, err := strconv.ParseInt(claims[separator+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, ErrorInvalidSession
+	}
+	return time.Unix(expiry, 0), nil
+}
+
+func (signer *SessionSigner) sign(claims string) string {
+	mac := hmac.New(sha256.New, signer.secret)
+	mac.Write([]byte(claims))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionSigner_Verify(t *testing.T) {
+	signer := NewSessionSigner([]byte("secret"), time.Hour)
+	now := time.Now()
+	token, expiresAt := signer.Issue("player_1", now)
+	if !expiresAt.After(now) {
+		t.Error("expected the token to expire in the future, got", expiresAt)
+	}
+	tests := []struct {
+		token    string
+		playerID string
+		at       time.Time
+		expected error
+		errorMsg string
+	}{
+		{token, "player_1", now, nil, "valid token"},
+		{token, "player_2", now, ErrorInvalidSession, "token of another player"},
+		{token, "player_1", now.Add(2 * time.Hour), ErrorSessionExpired, "expired token"},
+		{"", "player_1", now, ErrorInvalidSession, "missing token"},
+		{strings.Replace(token, "player_1", "player_2", 1), "player_2", now, ErrorInvalidSession, "forged player"},
+		{token[:len(token)-2] + "xx", "player_1", now, ErrorInvalidSession, "forged signature"},
+	}
+	for _, test := range tests {
+		err := signer.Verify(test.token, test.playerID, test.at)
+		if err != test.expected {
+			t.Error(test.errorMsg, ": expected", test.expected, "got", err)
+		}
+	}
+	otherSigner := NewSessionSigner([]byte("other secret"), time.Hour)
+	if otherSigner.Verify(token, "player_1", now) != ErrorInvalidSession {
+		t.Error("token signed with another secret should be refused")
+	}
+}
+
+func TestSessionSigner_VerifyOwner(t *testing.T) {
+	signer := NewSessionSigner([]byte("secret"), time.Hour)
+	token, _ := signer.Issue("player_1", time.Now().Add(-2*time.Hour))
+	if signer.Verify(token, "player_1", time.Now()) != ErrorSessionExpired {
+		t.Fatal("expected the token to be expired")
+	}
+	if err := signer.VerifyOwner(token, "player_1", time.Now()); err != nil {
+		t.Error("expired token should still prove its owner, got", err)
+	}
+	if signer.VerifyOwner(token, "player_2", time.Now()) != ErrorInvalidSession {
+		t.Error("token should not prove another owner")
+	}
+	signer.reclaimTTL = time.Hour
+	if signer.VerifyOwner(token, "player_1", time.Now()) != ErrorSessionExpired {
+		t.Error("token expired for longer than the reclaim duration should not prove its owner")
+	}
+}
+
+func TestNewSessionSignerFromEnv(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "")
+	t.Setenv("SESSION_TTL", "1m")
+	t.Setenv("SESSION_RECLAIM_TTL", "1h")
+	signer, err := NewSessionSignerFromEnv()
+	if err != nil {
+		t.Fatal("unable to create signer :", err)
+	}
+	if signer.reclaimTTL != time.Hour {
+		t.Error("expected expired tokens to reclaim their player for an hour, got", signer.reclaimTTL)
+	}
+	for _, variable := range signer.Env() {
+		if strings.HasPrefix(variable, "SESSION_SECRET") {
+			t.Fatal("the secret should not be passed as an environment variable, got", variable)
+		}
+	}
+	secret := signer.Secret()
+	if len(secret) == 0 {
+		t.Fatal("expected a generated secret")
+	}
+	token, _ := signer.Issue("player_1", time.Now())
+	sharedSigner := NewSessionSigner(secret, time.Minute)
+	if err := sharedSigner.Verify(token, "player_1", time.Now()); err != nil {
+		t.Error("signer created from env should share the secret, got", err)
+	}
+}
+
+func TestNewSessionSignerFromEnv_SecretFile(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "session_secret")
+	err := ioutil.WriteFile(secretFile, []byte("secret\n"), 0600)
+	if err != nil {
+		t.Fatal("unable to write secret file :", err)
+	}
+	t.Setenv("SESSION_SECRET", "")
+	t.Setenv("SESSION_SECRET_FILE", secretFile)
+	signer, err := NewSessionSignerFromEnv()
+	if err != nil {
+		t.Fatal("unable to create signer :", err)
+	}
+	if string(signer.Secret()) != "secret" {
+		t.Error("expected the secret of the file, got", string(signer.Secret()))
+	}
+}
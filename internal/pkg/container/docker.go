@@ -1,72 +1,334 @@
 package container
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/docker/client"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
-// CreateDynamicServer create a dynamic server with a PartyUUID in argument.
-// CreateDynamicServer bind the dynamic server instance to networks in order to make it works
-func CreateDynamicServer(partyID string, env []string) error {
+// labels set on the containers of dynamic servers
+const (
+	// DockerPartyLabel holds the UUID of the party served by a container
+	DockerPartyLabel = "autorace.party-id"
+	// DockerCreatorLabel holds the UUID of the player who created the party served by a container
+	DockerCreatorLabel = "autorace.creator-id"
+)
+
+// DockerLaunchConfig describes the containers created by a DockerLauncher. Containers are connected
+// to the networks named in Networks and to the networks holding every label of NetworkLabels. When
+// none of them is set, networks are found by their name, like in the Docker compose deployment
+type DockerLaunchConfig struct {
+	Image         string
+	Networks      []string
+	NetworkLabels map[string]string
+	// NanoCPUs is the CPU limit of a container in billionths of CPU, 0 for no limit
+	NanoCPUs int64
+	// Memory is the memory limit of a container in bytes, 0 for no limit
+	Memory int64
+	// Env holds "KEY=value" variables added to the launch environment of every container
+	Env []string
+	// Restarts is the number of times a crashed container is restarted. Containers are only removed
+	// once stopped when it is 0
+	Restarts int
+}
+
+// NewDockerLaunchConfigFromEnv create a DockerLaunchConfig from the environment :
+//   - DYNAMIC_IMAGE, the image and its tag, DefaultDynamicImage if it is not set
+//   - DYNAMIC_NETWORKS, network names separated by commas
+//   - DYNAMIC_NETWORK_LABELS, "key=value" network labels separated by commas
+//   - DYNAMIC_CPU_LIMIT and DYNAMIC_MEMORY_LIMIT, as Kubernetes quantities ("500m", "128Mi", ...)
+//   - DYNAMIC_EXTRA_ENV, "KEY=value" variables separated by commas
+//   - DYNAMIC_RESTARTS, the number of times a crashed container is restarted
+func NewDockerLaunchConfigFromEnv() (DockerLaunchConfig, error) {
+	config := DockerLaunchConfig{
+		Image:         os.Getenv("DYNAMIC_IMAGE"),
+		Networks:      splitList(os.Getenv("DYNAMIC_NETWORKS")),
+		NetworkLabels: make(map[string]string),
+		Env:           splitList(os.Getenv("DYNAMIC_EXTRA_ENV")),
+	}
+	if config.Image == "" {
+		config.Image = DefaultDynamicImage
+	}
+	for _, label := range splitList(os.Getenv("DYNAMIC_NETWORK_LABELS")) {
+		keyValue := strings.SplitN(label, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return config, fmt.Errorf("malformed network label \"%s\"", label)
+		}
+		config.NetworkLabels[keyValue[0]] = keyValue[1]
+	}
+	for _, variable := range config.Env {
+		if !strings.Contains(variable, "=") || strings.HasPrefix(variable, "=") {
+			return config, fmt.Errorf("malformed environment variable \"%s\"", variable)
+		}
+	}
+	if rawCPU := os.Getenv("DYNAMIC_CPU_LIMIT"); rawCPU != "" {
+		cpu, err := resource.ParseQuantity(rawCPU)
+		if err != nil {
+			return config, fmt.Errorf("DYNAMIC_CPU_LIMIT : %w", err)
+		}
+		config.NanoCPUs = cpu.MilliValue() * 1000000
+	}
+	if rawMemory := os.Getenv("DYNAMIC_MEMORY_LIMIT"); rawMemory != "" {
+		memory, err := resource.ParseQuantity(rawMemory)
+		if err != nil {
+			return config, fmt.Errorf("DYNAMIC_MEMORY_LIMIT : %w", err)
+		}
+		config.Memory = memory.Value()
+	}
+	var err error
+	config.Restarts, err = restartsFromEnv()
+	return config, err
+}
+
+// DockerLauncher starts dynamic servers in Docker containers, the Docker daemon is found from the
+// environment. Containers are labelled with the party and creator UUIDs, and removed once stopped
+// unless crashed containers are restarted
+type DockerLauncher struct {
+	config DockerLaunchConfig
+}
+
+// NewDockerLauncher create a DockerLauncher creating containers from a given configuration
+func NewDockerLauncher(config DockerLaunchConfig) *DockerLauncher {
+	return &DockerLauncher{
+		config: config,
+	}
+}
+
+// Kind returns DockerLauncherKind
+func (launcher *DockerLauncher) Kind() string {
+	return DockerLauncherKind
+}
+
+// Launch starts the dynamic server of a party in a new container, connected to the configured
+// networks. It returns the container ID
+func (launcher *DockerLauncher) Launch(request LaunchRequest) (string, error) {
 	ctx := context.Background()
-	var networkID []string
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
-		return err
+		return "", err
 	}
-	imageName := "autorace_dynamic:latest"
+	defer cli.Close()
 	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{})
 	if err != nil {
-		return err
+		return "", err
 	}
-	for _, net := range networks {
-		if strings.Contains(strings.ToLower(net.Name), "rabbitmq") ||
-			strings.Contains(strings.ToLower(net.Name), "logs") ||
-			strings.Contains(strings.ToLower(net.Name), "autorace_cache") {
-			networkID = append(networkID, net.ID)
-		}
+	networkIDs := launcher.selectNetworks(networks)
+	if len(networkIDs) == 0 {
+		return "", errors.New("unable to find networks")
 	}
-	if len(networkID) == 0 {
-		return errors.New("unable to find networks")
+	// the launch environment overrides the extra environment
+	env := append(append([]string{}, launcher.config.Env...), request.Env...)
+	if len(request.SessionSecret) > 0 {
+		env = append(env, SessionSecretFileEnv+"=/"+dockerSessionSecretPath)
 	}
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Hostname:   "dynamic_" + partyID,
-		Domainname: "",
-		User:       "",
+		Hostname: "dynamic_" + request.PartyID,
 		Cmd: strslice.StrSlice{
-			partyID,
+			request.PartyID,
+		},
+		Image: launcher.config.Image,
+		Env:   env,
+		Labels: map[string]string{
+			DockerPartyLabel:   request.PartyID,
+			DockerCreatorLabel: request.CreatorID,
 		},
-		ArgsEscaped: false,
-		Image:       imageName,
-		Entrypoint:  nil,
-		Env:         env,
 	}, &container.HostConfig{
-		Binds:           nil,
-		ContainerIDFile: "",
-		NetworkMode:     "",
-		RestartPolicy:   container.RestartPolicy{},
-		AutoRemove:      true,
+		AutoRemove: launcher.config.Restarts == 0,
+		RestartPolicy: container.RestartPolicy{
+			Name:              launcher.restartPolicy(),
+			MaximumRetryCount: launcher.config.Restarts,
+		},
+		Resources: container.Resources{
+			NanoCPUs: launcher.config.NanoCPUs,
+			Memory:   launcher.config.Memory,
+		},
 	},
 		nil,
 		nil,
-		"dynamic_"+partyID)
+		"dynamic_"+request.PartyID)
 	if err != nil {
-		return err
+		return "", err
 	}
-	for _, netID := range networkID {
-		err = cli.NetworkConnect(ctx, netID, resp.ID, nil)
+	for _, networkID := range networkIDs {
+		err = cli.NetworkConnect(ctx, networkID, resp.ID, nil)
 		if err != nil {
-			return err
+			return resp.ID, err
 		}
 	}
-	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+	if len(request.SessionSecret) > 0 {
+		secretArchive, err := sessionSecretArchive(request.SessionSecret)
+		if err != nil {
+			return resp.ID, err
+		}
+		err = cli.CopyToContainer(ctx, resp.ID, "/", secretArchive, types.CopyToContainerOptions{})
+		if err != nil {
+			return resp.ID, err
+		}
+	}
+	err = cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return resp.ID, err
+	}
+	return resp.ID, nil
+}
+
+// Status tells what became of the container of a party, LaunchUnknown if it does not exist
+func (launcher *DockerLauncher) Status(partyID string) (LaunchStatus, error) {
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return LaunchUnknown, err
+	}
+	defer cli.Close()
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", DockerPartyLabel+"="+partyID)),
+	})
+	if err != nil {
+		return LaunchUnknown, err
+	}
+	if len(containers) == 0 {
+		return LaunchUnknown, nil
+	}
+	return dockerLaunchStatus(containers[0].State, containers[0].Status), nil
+}
+
+// Kill kills the container of a dynamic server from its container ID
+func (launcher *DockerLauncher) Kill(instanceID string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
 		return err
 	}
-	return nil
+	defer cli.Close()
+	return cli.ContainerKill(context.Background(), instanceID, "SIGKILL")
+}
+
+// dockerSessionSecretPath is where the session secret is copied in the container of a dynamic server,
+// from the root of its file system
+const dockerSessionSecretPath = "run/secrets/autorace_session_secret"
+
+// sessionSecretArchive returns a tar archive holding the session secret at dockerSessionSecretPath,
+// only readable by its owner. The secret is copied in the container before it starts, so it is not
+// part of the container configuration
+func sessionSecretArchive(secret []byte) (io.Reader, error) {
+	archive := new(bytes.Buffer)
+	writer := tar.NewWriter(archive)
+	for _, directory := range []string{path.Dir(path.Dir(dockerSessionSecretPath)), path.Dir(dockerSessionSecretPath)} {
+		err := writer.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: directory + "/", Mode: 0755})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: dockerSessionSecretPath, Mode: 0400, Size: int64(len(secret))})
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(secret)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// selectNetworks returns the IDs of the networks a dynamic server container is connected to
+func (launcher *DockerLauncher) selectNetworks(networks []types.NetworkResource) []string {
+	var networkIDs []string
+	byName := len(launcher.config.Networks) > 0 || len(launcher.config.NetworkLabels) > 0
+	for _, network := range networks {
+		if !byName && isComposeNetwork(network.Name) {
+			networkIDs = append(networkIDs, network.ID)
+			continue
+		}
+		if byName && (containsString(launcher.config.Networks, network.Name) || hasLabels(network.Labels, launcher.config.NetworkLabels)) {
+			networkIDs = append(networkIDs, network.ID)
+		}
+	}
+	return networkIDs
+}
+
+// isComposeNetwork tells if a network is one of the networks of the Docker compose deployment a
+// dynamic server needs
+func isComposeNetwork(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "rabbitmq") ||
+		strings.Contains(name, "logs") ||
+		strings.Contains(name, "autorace_cache")
+}
+
+// hasLabels tells if labels hold every expected label, false if no label is expected
+func hasLabels(labels, expected map[string]string) bool {
+	if len(expected) == 0 {
+		return false
+	}
+	for key, value := range expected {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// dockerLaunchStatus converts the state of a container. The exit code of an exited container is only
+// given in its human readable status, "Exited (0) 2 seconds ago" for instance
+func dockerLaunchStatus(state, status string) LaunchStatus {
+	switch state {
+	case "created", "restarting":
+		return LaunchPending
+	case "running", "paused":
+		return LaunchRunning
+	case "exited":
+		if strings.HasPrefix(status, "Exited (0)") {
+			return LaunchSucceeded
+		}
+		return LaunchFailed
+	case "dead":
+		return LaunchFailed
+	}
+	return LaunchUnknown
+}
+
+// restartPolicy returns the name of the restart policy of containers, a crashed container is
+// restarted only if restarts are configured
+func (launcher *DockerLauncher) restartPolicy() string {
+	if launcher.config.Restarts == 0 {
+		return "no"
+	}
+	return "on-failure"
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, empty items are left out
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
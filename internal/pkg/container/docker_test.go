@@ -1,10 +1,132 @@
 package container
 
-import "testing"
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
 
 func TestExample(t *testing.T) {
-	err := CreateDynamicServer("testingID")
+	_, err := NewDockerLauncher(DockerLaunchConfig{Image: DefaultDynamicImage}).Launch(LaunchRequest{PartyID: "testingID"})
 	if err != nil {
 		t.Fatal("error while creating a new dynamic server")
 	}
-}
\ No newline at end of file
+}
+
+func TestDockerLauncher_SelectNetworks(t *testing.T) {
+	networks := []types.NetworkResource{
+		{ID: "1", Name: "autorace_rabbitmq"},
+		{ID: "2", Name: "autorace_logs"},
+		{ID: "3", Name: "backend", Labels: map[string]string{"autorace": "true", "tier": "back"}},
+		{ID: "4", Name: "frontend", Labels: map[string]string{"autorace": "true"}},
+		{ID: "5", Name: "bridge"},
+	}
+	tests := []struct {
+		config   DockerLaunchConfig
+		expected []string
+	}{
+		{DockerLaunchConfig{}, []string{"1", "2"}},
+		{DockerLaunchConfig{Networks: []string{"bridge", "autorace_logs"}}, []string{"2", "5"}},
+		{DockerLaunchConfig{NetworkLabels: map[string]string{"autorace": "true", "tier": "back"}}, []string{"3"}},
+		{DockerLaunchConfig{Networks: []string{"bridge"}, NetworkLabels: map[string]string{"autorace": "true"}}, []string{"3", "4", "5"}},
+	}
+	for _, test := range tests {
+		networkIDs := NewDockerLauncher(test.config).selectNetworks(networks)
+		if len(networkIDs) != len(test.expected) {
+			t.Error("expected networks", test.expected, "got", networkIDs)
+			continue
+		}
+		for index, networkID := range networkIDs {
+			if networkID != test.expected[index] {
+				t.Error("expected networks", test.expected, "got", networkIDs)
+				break
+			}
+		}
+	}
+}
+
+func TestNewDockerLaunchConfigFromEnv(t *testing.T) {
+	variables := map[string]string{
+		"DYNAMIC_IMAGE":          "registry.local/autorace_dynamic:1.2",
+		"DYNAMIC_NETWORKS":       "rabbitmq, cache",
+		"DYNAMIC_NETWORK_LABELS": "autorace=true",
+		"DYNAMIC_CPU_LIMIT":      "500m",
+		"DYNAMIC_MEMORY_LIMIT":   "128Mi",
+		"DYNAMIC_EXTRA_ENV":      "LOG_LEVEL=debug,TZ=UTC",
+		"DYNAMIC_RESTARTS":       "2",
+	}
+	for key, value := range variables {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	config, err := NewDockerLaunchConfigFromEnv()
+	if err != nil {
+		t.Fatal("unable to read configuration :", err)
+	}
+	if config.Image != "registry.local/autorace_dynamic:1.2" {
+		t.Error("unexpected image", config.Image)
+	}
+	if len(config.Networks) != 2 || config.Networks[1] != "cache" || config.NetworkLabels["autorace"] != "true" {
+		t.Error("unexpected networks", config.Networks, config.NetworkLabels)
+	}
+	if config.NanoCPUs != 500000000 || config.Memory != 128*1024*1024 {
+		t.Error("unexpected limits", config.NanoCPUs, config.Memory)
+	}
+	if len(config.Env) != 2 || config.Env[1] != "TZ=UTC" {
+		t.Error("unexpected extra environment", config.Env)
+	}
+	if config.Restarts != 2 {
+		t.Error("expected 2 restarts, got", config.Restarts)
+	}
+	os.Setenv("DYNAMIC_NETWORK_LABELS", "malformed")
+	_, err = NewDockerLaunchConfigFromEnv()
+	if err == nil {
+		t.Error("expected an error with a malformed network label")
+	}
+}
+
+func TestDockerLaunchStatus(t *testing.T) {
+	tests := []struct {
+		state, status string
+		expected      LaunchStatus
+	}{
+		{"created", "Created", LaunchPending},
+		{"running", "Up 2 minutes", LaunchRunning},
+		{"exited", "Exited (0) 2 seconds ago", LaunchSucceeded},
+		{"exited", "Exited (2) 2 seconds ago", LaunchFailed},
+		{"removing", "Removal In Progress", LaunchUnknown},
+	}
+	for _, test := range tests {
+		if status := dockerLaunchStatus(test.state, test.status); status != test.expected {
+			t.Error("expected status", test.expected, "for", test.status, "got", status)
+		}
+	}
+}
+
+func TestSessionSecretArchive(t *testing.T) {
+	archive, err := sessionSecretArchive([]byte("secret"))
+	if err != nil {
+		t.Fatal("unable to create archive :", err)
+	}
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			t.Fatal("expected the archive to hold the session secret, got", err)
+		}
+		if header.Name != dockerSessionSecretPath {
+			continue
+		}
+		if header.Mode != 0400 {
+			t.Error("expected the session secret to be only readable by its owner, got", header.Mode)
+		}
+		content, err := ioutil.ReadAll(reader)
+		if err != nil || string(content) != "secret" {
+			t.Error("unexpected session secret", string(content), err)
+		}
+		return
+	}
+}
@@ -1,3 +1,289 @@
 package container
 
-// TODO k8s api abstraction
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// KubernetesLauncherKind starts dynamic servers in Kubernetes Jobs
+const KubernetesLauncherKind = "kubernetes"
+
+// labels set on the Jobs and Pods of dynamic servers
+const (
+	// KubernetesAppLabel identifies the Jobs and Pods of dynamic servers
+	KubernetesAppLabel = "app.kubernetes.io/name"
+	// KubernetesPartyLabel holds the UUID of the party served by a Job or a Pod
+	KubernetesPartyLabel = "autorace/party-id"
+	// KubernetesCreatorLabel holds the UUID of the player who created the party served by a Job or a Pod
+	KubernetesCreatorLabel = "autorace/creator-id"
+	// kubernetesAppName is the value of KubernetesAppLabel
+	kubernetesAppName = "autorace-dynamic"
+)
+
+// finishedJobTTL is how long a finished Job is kept before Kubernetes deletes it, in seconds
+const finishedJobTTL int32 = 60
+
+// KubernetesLaunchConfig describes the Jobs created by a KubernetesLauncher
+type KubernetesLaunchConfig struct {
+	Namespace       string
+	Image           string
+	ImagePullPolicy corev1.PullPolicy
+	Resources       corev1.ResourceRequirements
+	// Restarts is the number of times the Pod of a crashed dynamic server is replaced
+	Restarts int32
+	// Owner is set on every Job, Kubernetes deletes them once the owner is deleted
+	Owner *metav1.OwnerReference
+	// SessionSecret is the key of the Kubernetes Secret holding the session secret, given to the
+	// dynamic servers as SESSION_SECRET
+	SessionSecret *corev1.SecretKeySelector
+}
+
+// defaultSessionSecretKey is the key of the session secret in its Kubernetes Secret when
+// DYNAMIC_SESSION_SECRET_KEY is not set
+const defaultSessionSecretKey = "session-secret"
+
+// ErrorSessionSecretReference is returned when a dynamic server needing the session secret is launched
+// without a Kubernetes Secret holding it
+var ErrorSessionSecretReference = errors.New("DYNAMIC_SESSION_SECRET_NAME has to name the Kubernetes Secret holding the session secret")
+
+// NewKubernetesLaunchConfigFromEnv create a KubernetesLaunchConfig from the environment :
+//   - KUBERNETES_NAMESPACE, the namespace of the static server (POD_NAMESPACE) if it is not set
+//   - DYNAMIC_IMAGE and DYNAMIC_IMAGE_PULL_POLICY
+//   - DYNAMIC_CPU_REQUEST, DYNAMIC_CPU_LIMIT, DYNAMIC_MEMORY_REQUEST and DYNAMIC_MEMORY_LIMIT, as
+//     Kubernetes quantities
+//   - DYNAMIC_RESTARTS, the number of times the Pod of a crashed dynamic server is replaced
+//   - POD_NAME and POD_UID, the static server Pod owning the Jobs, given by the downward API
+//   - DYNAMIC_SESSION_SECRET_NAME and DYNAMIC_SESSION_SECRET_KEY, the Kubernetes Secret and its key
+//     holding the session secret, "session-secret" if the key is not set
+func NewKubernetesLaunchConfigFromEnv() (KubernetesLaunchConfig, error) {
+	config := KubernetesLaunchConfig{
+		Namespace:       os.Getenv("KUBERNETES_NAMESPACE"),
+		Image:           os.Getenv("DYNAMIC_IMAGE"),
+		ImagePullPolicy: corev1.PullPolicy(os.Getenv("DYNAMIC_IMAGE_PULL_POLICY")),
+		Resources: corev1.ResourceRequirements{
+			Requests: make(corev1.ResourceList),
+			Limits:   make(corev1.ResourceList),
+		},
+	}
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if config.Namespace == "" {
+		config.Namespace = metav1.NamespaceDefault
+	}
+	if config.Image == "" {
+		config.Image = DefaultDynamicImage
+	}
+	quantities := []struct {
+		variable string
+		list     corev1.ResourceList
+		name     corev1.ResourceName
+	}{
+		{"DYNAMIC_CPU_REQUEST", config.Resources.Requests, corev1.ResourceCPU},
+		{"DYNAMIC_CPU_LIMIT", config.Resources.Limits, corev1.ResourceCPU},
+		{"DYNAMIC_MEMORY_REQUEST", config.Resources.Requests, corev1.ResourceMemory},
+		{"DYNAMIC_MEMORY_LIMIT", config.Resources.Limits, corev1.ResourceMemory},
+	}
+	for _, quantity := range quantities {
+		rawQuantity := os.Getenv(quantity.variable)
+		if rawQuantity == "" {
+			continue
+		}
+		parsed, err := resource.ParseQuantity(rawQuantity)
+		if err != nil {
+			return config, fmt.Errorf("%s : %w", quantity.variable, err)
+		}
+		quantity.list[quantity.name] = parsed
+	}
+	restarts, err := restartsFromEnv()
+	if err != nil {
+		return config, err
+	}
+	config.Restarts = int32(restarts)
+	podName, podUID := os.Getenv("POD_NAME"), os.Getenv("POD_UID")
+	if podName != "" && podUID != "" {
+		config.Owner = &metav1.OwnerReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       podName,
+			UID:        types.UID(podUID),
+		}
+	}
+	if secretName := os.Getenv("DYNAMIC_SESSION_SECRET_NAME"); secretName != "" {
+		config.SessionSecret = &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+			Key:                  os.Getenv("DYNAMIC_SESSION_SECRET_KEY"),
+		}
+		if config.SessionSecret.Key == "" {
+			config.SessionSecret.Key = defaultSessionSecretKey
+		}
+	}
+	return config, nil
+}
+
+// KubernetesLauncher starts dynamic servers in Kubernetes Jobs, one Job per party, labelled with
+// the party UUID. The Pod of a failed dynamic server is replaced as many times as configured
+type KubernetesLauncher struct {
+	clientset kubernetes.Interface
+	config    KubernetesLaunchConfig
+}
+
+// NewKubernetesLauncher create a KubernetesLauncher creating Jobs through a given clientset
+func NewKubernetesLauncher(clientset kubernetes.Interface, config KubernetesLaunchConfig) *KubernetesLauncher {
+	return &KubernetesLauncher{
+		clientset: clientset,
+		config:    config,
+	}
+}
+
+// NewInClusterKubernetesLauncher create a KubernetesLauncher from the service account of the
+// static server Pod, configured with NewKubernetesLaunchConfigFromEnv
+func NewInClusterKubernetesLauncher() (*KubernetesLauncher, error) {
+	config, err := NewKubernetesLaunchConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewKubernetesLauncher(clientset, config), nil
+}
+
+// Kind returns KubernetesLauncherKind
+func (launcher *KubernetesLauncher) Kind() string {
+	return KubernetesLauncherKind
+}
+
+// Launch starts the dynamic server of a party in a new Job. It returns the name of the Job
+func (launcher *KubernetesLauncher) Launch(request LaunchRequest) (string, error) {
+	job, err := launcher.job(request)
+	if err != nil {
+		return "", err
+	}
+	_, err = launcher.clientset.BatchV1().Jobs(launcher.config.Namespace).Create(context.Background(), job, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return job.Name, nil
+}
+
+// Kill deletes the Job of a dynamic server from its name, with its Pods
+func (launcher *KubernetesLauncher) Kill(instanceID string) error {
+	propagation := metav1.DeletePropagationBackground
+	return launcher.clientset.BatchV1().Jobs(launcher.config.Namespace).Delete(context.Background(), instanceID, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+}
+
+// Status tells what became of the Job of a party, LaunchUnknown if it does not exist
+func (launcher *KubernetesLauncher) Status(partyID string) (LaunchStatus, error) {
+	job, err := launcher.clientset.BatchV1().Jobs(launcher.config.Namespace).Get(context.Background(), kubernetesJobName(partyID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return LaunchUnknown, nil
+	}
+	if err != nil {
+		return LaunchUnknown, err
+	}
+	backoffLimit := int32(0)
+	if job.Spec.BackoffLimit != nil {
+		backoffLimit = *job.Spec.BackoffLimit
+	}
+	// a failed Pod is replaced until the backoff limit is exceeded
+	switch {
+	case job.Status.Succeeded > 0:
+		return LaunchSucceeded, nil
+	case job.Status.Active > 0:
+		return LaunchRunning, nil
+	case job.Status.Failed > backoffLimit:
+		return LaunchFailed, nil
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return LaunchFailed, nil
+		}
+	}
+	return LaunchPending, nil
+}
+
+// job create the Job running the dynamic server of a party
+func (launcher *KubernetesLauncher) job(request LaunchRequest) (*batchv1.Job, error) {
+	partyID := request.PartyID
+	envVars := make([]corev1.EnvVar, 0, len(request.Env))
+	for _, variable := range request.Env {
+		keyValue := strings.SplitN(variable, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return nil, fmt.Errorf("malformed environment variable \"%s\"", variable)
+		}
+		envVars = append(envVars, corev1.EnvVar{Name: keyValue[0], Value: keyValue[1]})
+	}
+	// the session secret is read from its Kubernetes Secret, it is not written in the Job
+	if len(request.SessionSecret) > 0 {
+		if launcher.config.SessionSecret == nil {
+			return nil, ErrorSessionSecretReference
+		}
+		envVars = append(envVars, corev1.EnvVar{
+			Name:      "SESSION_SECRET",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: launcher.config.SessionSecret},
+		})
+	}
+	labels := map[string]string{
+		KubernetesAppLabel:     kubernetesAppName,
+		KubernetesPartyLabel:   partyID,
+		KubernetesCreatorLabel: request.CreatorID,
+	}
+	backoffLimit := launcher.config.Restarts
+	ttl := finishedJobTTL
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubernetesJobName(partyID),
+			Namespace: launcher.config.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:            "dynamic",
+						Image:           launcher.config.Image,
+						ImagePullPolicy: launcher.config.ImagePullPolicy,
+						Args:            []string{partyID},
+						Env:             envVars,
+						Resources:       launcher.config.Resources,
+					}},
+				},
+			},
+		},
+	}
+	if launcher.config.Owner != nil {
+		job.OwnerReferences = []metav1.OwnerReference{*launcher.config.Owner}
+	}
+	return job, nil
+}
+
+// kubernetesJobName returns the name of the Job of a party
+func kubernetesJobName(partyID string) string {
+	return "dynamic-" + strings.ToLower(partyID)
+}
@@ -0,0 +1,224 @@
+package container
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewKubernetesLaunchConfigFromEnv(t *testing.T) {
+	variables := map[string]string{
+		"KUBERNETES_NAMESPACE":        "autorace",
+		"DYNAMIC_CPU_REQUEST":         "250m",
+		"DYNAMIC_MEMORY_LIMIT":        "128Mi",
+		"POD_NAME":                    "static-0",
+		"POD_UID":                     "2f0e3a56-7c1b-4a8e-9d4f-0b6c1e2d3a4b",
+		"DYNAMIC_IMAGE":               "",
+		"DYNAMIC_CPU_LIMIT":           "",
+		"DYNAMIC_MEMORY_REQUEST":      "",
+		"DYNAMIC_RESTARTS":            "",
+		"DYNAMIC_SESSION_SECRET_NAME": "autorace",
+		"DYNAMIC_SESSION_SECRET_KEY":  "",
+	}
+	for key, value := range variables {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	config, err := NewKubernetesLaunchConfigFromEnv()
+	if err != nil {
+		t.Fatal("unable to read configuration :", err)
+	}
+	if config.Namespace != "autorace" || config.Image != DefaultDynamicImage {
+		t.Error("unexpected namespace or image :", config.Namespace, config.Image)
+	}
+	if cpu := config.Resources.Requests[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("250m")) != 0 {
+		t.Error("expected a 250m CPU request, got", cpu.String())
+	}
+	if memory := config.Resources.Limits[corev1.ResourceMemory]; memory.Cmp(resource.MustParse("128Mi")) != 0 {
+		t.Error("expected a 128Mi memory limit, got", memory.String())
+	}
+	if _, ok := config.Resources.Limits[corev1.ResourceCPU]; ok {
+		t.Error("expected no CPU limit")
+	}
+	if config.Owner == nil || config.Owner.Name != "static-0" || config.Owner.Kind != "Pod" {
+		t.Error("expected static-0 Pod to own the Jobs, got", config.Owner)
+	}
+	if config.Restarts != 0 {
+		t.Error("expected no restart by default, got", config.Restarts)
+	}
+	if config.SessionSecret == nil || config.SessionSecret.Name != "autorace" || config.SessionSecret.Key != defaultSessionSecretKey {
+		t.Error("expected the session secret to be read from the autorace Secret, got", config.SessionSecret)
+	}
+	os.Setenv("DYNAMIC_CPU_LIMIT", "a lot")
+	_, err = NewKubernetesLaunchConfigFromEnv()
+	if err == nil {
+		t.Error("expected an error with a malformed quantity")
+	}
+	os.Setenv("DYNAMIC_CPU_LIMIT", "")
+	os.Setenv("DYNAMIC_RESTARTS", "-1")
+	_, err = NewKubernetesLaunchConfigFromEnv()
+	if err == nil {
+		t.Error("expected an error with a negative number of restarts")
+	}
+}
+
+func TestKubernetesLauncher_Launch(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	owner := &metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "static-0", UID: "uid"}
+	launcher := NewKubernetesLauncher(clientset, KubernetesLaunchConfig{
+		Namespace: "autorace",
+		Image:     DefaultDynamicImage,
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+		},
+		Owner: owner,
+		SessionSecret: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "autorace"},
+			Key:                  "session-secret",
+		},
+	})
+	partyID := "2F0E3A56-7C1B-4A8E-9D4F-0B6C1E2D3A4B"
+	jobName, err := launcher.Launch(LaunchRequest{
+		PartyID:       partyID,
+		CreatorID:     "creator",
+		Env:           []string{"REDIS_ADDR=redis:6379", "LOG_LEVEL=a=b"},
+		SessionSecret: []byte("secret"),
+	})
+	if err != nil {
+		t.Fatal("unable to launch party :", err)
+	}
+	job, err := clientset.BatchV1().Jobs("autorace").Get(context.Background(), jobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("unable to get Job :", err)
+	}
+	if job.Labels[KubernetesPartyLabel] != partyID || job.Spec.Template.Labels[KubernetesPartyLabel] != partyID {
+		t.Error("expected Job and Pod to be labelled with the party UUID, got", job.Labels, job.Spec.Template.Labels)
+	}
+	if job.Labels[KubernetesCreatorLabel] != "creator" {
+		t.Error("expected Job to be labelled with the creator UUID, got", job.Labels)
+	}
+	if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].Name != owner.Name {
+		t.Error("expected Job to be owned by", owner.Name, "got", job.OwnerReferences)
+	}
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 0 {
+		t.Error("expected a failed Job not to be retried")
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if len(container.Args) != 1 || container.Args[0] != partyID {
+		t.Error("expected party UUID as only argument, got", container.Args)
+	}
+	if len(container.Env) != 3 || container.Env[1].Name != "LOG_LEVEL" || container.Env[1].Value != "a=b" {
+		t.Fatal("unexpected environment", container.Env)
+	}
+	secretVar := container.Env[2]
+	if secretVar.Name != "SESSION_SECRET" || secretVar.Value != "" || secretVar.ValueFrom == nil ||
+		secretVar.ValueFrom.SecretKeyRef == nil || secretVar.ValueFrom.SecretKeyRef.Name != "autorace" {
+		t.Error("expected the session secret to be read from its Kubernetes Secret, got", secretVar)
+	}
+	if cpu := container.Resources.Limits[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("500m")) != 0 {
+		t.Error("expected a 500m CPU limit, got", cpu.String())
+	}
+	_, err = launcher.Launch(LaunchRequest{PartyID: partyID})
+	if err == nil {
+		t.Error("expected an error when a party is launched twice")
+	}
+	_, err = launcher.Launch(LaunchRequest{PartyID: "party_2", Env: []string{"malformed"}})
+	if err == nil {
+		t.Error("expected an error with a malformed environment")
+	}
+	launcher.config.SessionSecret = nil
+	_, err = launcher.Launch(LaunchRequest{PartyID: "party_3", SessionSecret: []byte("secret")})
+	if err != ErrorSessionSecretReference {
+		t.Error("expected the session secret to be refused without Kubernetes Secret, got", err)
+	}
+	err = launcher.Kill(jobName)
+	if err != nil {
+		t.Fatal("unable to kill party :", err)
+	}
+	status, err := launcher.Status(partyID)
+	if err != nil || status != LaunchUnknown {
+		t.Error("expected a killed party to be unknown, got", status, err)
+	}
+}
+
+func TestKubernetesLauncher_StatusWithRestarts(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	launcher := NewKubernetesLauncher(clientset, KubernetesLaunchConfig{Namespace: "autorace", Restarts: 2})
+	_, err := launcher.Launch(LaunchRequest{PartyID: "party_1"})
+	if err != nil {
+		t.Fatal("unable to launch party :", err)
+	}
+	jobs := clientset.BatchV1().Jobs("autorace")
+	tests := []struct {
+		active, failed int32
+		expected       LaunchStatus
+	}{
+		{1, 1, LaunchRunning},
+		{0, 2, LaunchPending},
+		{0, 3, LaunchFailed},
+	}
+	for _, test := range tests {
+		job, err := jobs.Get(context.Background(), kubernetesJobName("party_1"), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal("unable to get Job :", err)
+		}
+		if *job.Spec.BackoffLimit != 2 {
+			t.Fatal("expected a backoff limit of 2, got", *job.Spec.BackoffLimit)
+		}
+		job.Status.Active, job.Status.Failed = test.active, test.failed
+		_, err = jobs.UpdateStatus(context.Background(), job, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatal("unable to update Job status :", err)
+		}
+		status, err := launcher.Status("party_1")
+		if err != nil || status != test.expected {
+			t.Error("expected status", test.expected, "with", test.failed, "failed Pods, got", status, err)
+		}
+	}
+}
+
+func TestKubernetesLauncher_Status(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	launcher := NewKubernetesLauncher(clientset, KubernetesLaunchConfig{Namespace: "autorace"})
+	status, err := launcher.Status("party_1")
+	if err != nil || status != LaunchUnknown {
+		t.Error("expected an unknown status for a party never launched, got", status, err)
+	}
+	_, err = launcher.Launch(LaunchRequest{PartyID: "party_1"})
+	if err != nil {
+		t.Fatal("unable to launch party :", err)
+	}
+	jobs := clientset.BatchV1().Jobs("autorace")
+	tests := []struct {
+		active, succeeded, failed int32
+		expected                  LaunchStatus
+	}{
+		{0, 0, 0, LaunchPending},
+		{1, 0, 0, LaunchRunning},
+		{0, 1, 0, LaunchSucceeded},
+		{0, 0, 1, LaunchFailed},
+	}
+	for _, test := range tests {
+		job, err := jobs.Get(context.Background(), kubernetesJobName("party_1"), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal("unable to get Job :", err)
+		}
+		job.Status.Active, job.Status.Succeeded, job.Status.Failed = test.active, test.succeeded, test.failed
+		_, err = jobs.UpdateStatus(context.Background(), job, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatal("unable to update Job status :", err)
+		}
+		status, err := launcher.Status("party_1")
+		if err != nil {
+			t.Fatal("unable to get status :", err)
+		}
+		if status != test.expected {
+			t.Error("expected status", test.expected, "got", status)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/clnbs/autorace/pkg/logger"
+)
+
+// launcher kinds, chosen with NewLauncher
+const (
+	// DockerLauncherKind starts dynamic servers in Docker containers
+	DockerLauncherKind = "docker"
+	// ProcessLauncherKind starts dynamic servers as local processes
+	ProcessLauncherKind = "process"
+	// GoroutineLauncherKind runs dynamic servers in goroutines of the current process
+	GoroutineLauncherKind = "goroutine"
+)
+
+// DefaultDynamicImage is the image of dynamic servers when DYNAMIC_IMAGE is not set
+const DefaultDynamicImage = "autorace_dynamic:latest"
+
+// LaunchStatus is the state of a launched dynamic server
+type LaunchStatus string
+
+// states of a launched dynamic server
+const (
+	// LaunchPending is the state of a dynamic server waiting to be scheduled or pulled
+	LaunchPending LaunchStatus = "pending"
+	// LaunchRunning is the state of a running dynamic server
+	LaunchRunning LaunchStatus = "running"
+	// LaunchSucceeded is the state of a dynamic server which exited normally
+	LaunchSucceeded LaunchStatus = "succeeded"
+	// LaunchFailed is the state of a dynamic server which could not start or crashed
+	LaunchFailed LaunchStatus = "failed"
+	// LaunchUnknown is the state of a dynamic server the launcher knows nothing about
+	LaunchUnknown LaunchStatus = "unknown"
+)
+
+// StatusReporter is implemented by launchers able to tell what became of a dynamic server
+type StatusReporter interface {
+	Status(partyID string) (LaunchStatus, error)
+}
+
+var (
+	// ErrorUnknownLauncher is returned when a launcher kind is not known
+	ErrorUnknownLauncher = errors.New("unknown launcher")
+	// ErrorForeignProcess is returned when a process to kill was launched on another host
+	ErrorForeignProcess = errors.New("process was launched on another host")
+	// ErrorNotDynamicProcess is returned when a process to kill does not run the dynamic server of a party
+	ErrorNotDynamicProcess = errors.New("process is not the dynamic server of the party")
+)
+
+// DynamicServerPath returns the dynamic server binary started by a ProcessLauncher, DYNAMIC_SERVER_PATH
+// or "dynamic" found in the PATH
+func DynamicServerPath() string {
+	if path := os.Getenv("DYNAMIC_SERVER_PATH"); path != "" {
+		return path
+	}
+	return "dynamic"
+}
+
+// restartsFromEnv returns DYNAMIC_RESTARTS, the number of times a crashed dynamic server is restarted
+// to resume its party, 0 if it is not set
+func restartsFromEnv() (int, error) {
+	rawRestarts := os.Getenv("DYNAMIC_RESTARTS")
+	if rawRestarts == "" {
+		return 0, nil
+	}
+	restarts, err := strconv.Atoi(rawRestarts)
+	if err != nil || restarts < 0 {
+		return 0, fmt.Errorf("DYNAMIC_RESTARTS : invalid number of restarts \"%s\"", rawRestarts)
+	}
+	return restarts, nil
+}
+
+// SessionSecretFileEnv names the file holding the session secret of a dynamic server
+const SessionSecretFileEnv = "SESSION_SECRET_FILE"
+
+// LaunchRequest describes the dynamic server to start for a party. Env holds the configuration of
+// the dynamic server, as "KEY=value" variables. SessionSecret is never put in Env : a launcher hands
+// it over by reference, a Kubernetes Secret or a file only the dynamic server reads
+type LaunchRequest struct {
+	PartyID       string
+	CreatorID     string
+	Env           []string
+	SessionSecret []byte
+}
+
+// Launcher starts the dynamic server of a party. Launch returns the ID of the started instance, a
+// container ID or a process ID for instance, empty if the launcher has no such ID
+type Launcher interface {
+	Kind() string
+	Launch(request LaunchRequest) (string, error)
+}
+
+// Killer is implemented by launchers able to kill the instance they started for a party
+type Killer interface {
+	Kill(instanceID string) error
+}
+
+// NewLauncher create a Launcher of a given kind, DockerLauncherKind if kind is empty. path is the
+// dynamic server binary used by a ProcessLauncher, run is the function used by a GoroutineLauncher.
+// DockerLauncher and KubernetesLauncher are configured from the environment, a KubernetesLauncher
+// only works inside the cluster
+func NewLauncher(kind, path string, run func(partyID string) error) (Launcher, error) {
+	switch kind {
+	case "", DockerLauncherKind:
+		config, err := NewDockerLaunchConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewDockerLauncher(config), nil
+	case ProcessLauncherKind:
+		return NewProcessLauncher(path), nil
+	case GoroutineLauncherKind:
+		return NewGoroutineLauncher(run), nil
+	case KubernetesLauncherKind:
+		launcher, err := NewInClusterKubernetesLauncher()
+		if err != nil {
+			return nil, err
+		}
+		return launcher, nil
+	}
+	return nil, fmt.Errorf("%w : \"%s\"", ErrorUnknownLauncher, kind)
+}
+
+// ProcessLauncher starts dynamic servers as local processes of the dynamic server binary. A
+// process inherits the environment of the current process, overridden by the launch environment
+type ProcessLauncher struct {
+	path string
+}
+
+// NewProcessLauncher create a ProcessLauncher running the dynamic server binary found at a given
+// path, or in the PATH if the path holds no separator
+func NewProcessLauncher(path string) *ProcessLauncher {
+	return &ProcessLauncher{
+		path: path,
+	}
+}
+
+// Kind returns ProcessLauncherKind
+func (launcher *ProcessLauncher) Kind() string {
+	return ProcessLauncherKind
+}
+
+// Launch starts the dynamic server of a party in a new process, its output is the output of the
+// current process. The session secret is written in a file only readable by the current user, removed
+// once the process exited. It returns the process ID
+func (launcher *ProcessLauncher) Launch(request LaunchRequest) (string, error) {
+	cmd := exec.Command(launcher.path, request.PartyID)
+	// later variables override earlier ones, the secret of the current process is not inherited
+	cmd.Env = append(withoutVariable(os.Environ(), "SESSION_SECRET"), request.Env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	secretFile := ""
+	if len(request.SessionSecret) > 0 {
+		var err error
+		secretFile, err = writeSecretFile(request.SessionSecret)
+		if err != nil {
+			return "", err
+		}
+		cmd.Env = append(cmd.Env, SessionSecretFileEnv+"="+secretFile)
+	}
+	err := cmd.Start()
+	if err != nil {
+		removeSecretFile(secretFile)
+		return "", err
+	}
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			logger.Warning("dynamic server of party", request.PartyID, "exited :", err)
+		}
+		removeSecretFile(secretFile)
+	}()
+	return strconv.Itoa(cmd.Process.Pid), nil
+}
+
+// writeSecretFile writes a secret in a new temporary file only readable by the current user and
+// returns its path
+func writeSecretFile(secret []byte) (string, error) {
+	file, err := ioutil.TempFile("", "autorace_session_secret_")
+	if err != nil {
+		return "", err
+	}
+	// TempFile creates files readable by their owner only
+	_, err = file.Write(secret)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeSecretFile(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func removeSecretFile(path string) {
+	if path == "" {
+		return
+	}
+	err := os.Remove(path)
+	if err != nil {
+		logger.Warning("unable to remove session secret file :", err)
+	}
+}
+
+// withoutVariable returns "KEY=value" variables without the ones named key
+func withoutVariable(env []string, key string) []string {
+	kept := make([]string, 0, len(env))
+	for _, variable := range env {
+		if !strings.HasPrefix(variable, key+"=") {
+			kept = append(kept, variable)
+		}
+	}
+	return kept
+}
+
+// CheckProcess checks the process launched for a party on a given host is the dynamic server of this
+// party, run from the binary of this launcher on the current host. A process ID may have been reused
+// since the launch, and a process of another host can not be told apart from a local one
+func (launcher *ProcessLauncher) CheckProcess(host, instanceID, partyID string) error {
+	localHost, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	if host != localHost {
+		return fmt.Errorf("%w : \"%s\"", ErrorForeignProcess, host)
+	}
+	if _, err = strconv.Atoi(instanceID); err != nil {
+		return fmt.Errorf("malformed process ID \"%s\"", instanceID)
+	}
+	cmdline, err := ioutil.ReadFile("/proc/" + instanceID + "/cmdline")
+	if err != nil {
+		return fmt.Errorf("%w : %v", ErrorNotDynamicProcess, err)
+	}
+	return launcher.checkCommandLine(cmdline, partyID)
+}
+
+// checkCommandLine checks a process command line, arguments separated by NUL bytes, runs the binary of
+// this launcher for a party
+func (launcher *ProcessLauncher) checkCommandLine(cmdline []byte, partyID string) error {
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	if len(args) != 2 || filepath.Base(args[0]) != filepath.Base(launcher.path) || args[1] != partyID {
+		return fmt.Errorf("%w : \"%s\"", ErrorNotDynamicProcess, strings.Join(args, " "))
+	}
+	return nil
+}
+
+// Kill kills the process of a dynamic server from its process ID
+func (launcher *ProcessLauncher) Kill(instanceID string) error {
+	pid, err := strconv.Atoi(instanceID)
+	if err != nil {
+		return fmt.Errorf("malformed process ID \"%s\"", instanceID)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
+
+// GoroutineLauncher runs dynamic servers in goroutines of the current process. The launch
+// environment is applied to the current process, so it has to be the same for every party
+type GoroutineLauncher struct {
+	run func(partyID string) error
+}
+
+// NewGoroutineLauncher create a GoroutineLauncher. run creates the dynamic server of a party and
+// returns once its party is over
+func NewGoroutineLauncher(run func(partyID string) error) *GoroutineLauncher {
+	return &GoroutineLauncher{
+		run: run,
+	}
+}
+
+// Kind returns GoroutineLauncherKind
+func (launcher *GoroutineLauncher) Kind() string {
+	return GoroutineLauncherKind
+}
+
+// Launch runs the dynamic server of a party in a new goroutine, goroutines have no instance ID. The
+// session secret stays in the current process
+func (launcher *GoroutineLauncher) Launch(request LaunchRequest) (string, error) {
+	err := setEnv(request.Env)
+	if err != nil {
+		return "", err
+	}
+	if len(request.SessionSecret) > 0 {
+		err = setEnv([]string{"SESSION_SECRET=" + string(request.SessionSecret)})
+		if err != nil {
+			return "", err
+		}
+	}
+	go func() {
+		err := launcher.run(request.PartyID)
+		if err != nil {
+			logger.Error("dynamic server of party", request.PartyID, "stopped :", err)
+		}
+	}()
+	return "", nil
+}
+
+// setEnv applies "KEY=value" variables to the environment of the current process
+func setEnv(env []string) error {
+	for _, variable := range env {
+		keyValue := strings.SplitN(variable, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return fmt.Errorf("malformed environment variable \"%s\"", variable)
+		}
+		if value, ok := os.LookupEnv(keyValue[0]); ok && value == keyValue[1] {
+			continue
+		}
+		err := os.Setenv(keyValue[0], keyValue[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewLauncher(t *testing.T) {
+	tests := []struct {
+		kind     string
+		expected string
+	}{
+		{"", "*container.DockerLauncher"},
+		{DockerLauncherKind, "*container.DockerLauncher"},
+		{ProcessLauncherKind, "*container.ProcessLauncher"},
+		{GoroutineLauncherKind, "*container.GoroutineLauncher"},
+	}
+	for _, test := range tests {
+		launcher, err := NewLauncher(test.kind, "dynamic", func(string) error { return nil })
+		if err != nil {
+			t.Fatal("unable to create launcher", test.kind, ":", err)
+		}
+		if launcherType := fmt.Sprintf("%T", launcher); launcherType != test.expected {
+			t.Error("expected a", test.expected, "for kind", test.kind, "got", launcherType)
+		}
+	}
+	_, err := NewLauncher("kubelet", "", nil)
+	if !errors.Is(err, ErrorUnknownLauncher) {
+		t.Error("expected an unknown launcher error, got", err)
+	}
+}
+
+func TestGoroutineLauncher_Launch(t *testing.T) {
+	launched := make(chan string, 1)
+	launcher := NewGoroutineLauncher(func(partyID string) error {
+		launched <- partyID + " " + os.Getenv("AUTORACE_LAUNCHER_TEST")
+		return nil
+	})
+	defer os.Unsetenv("AUTORACE_LAUNCHER_TEST")
+	_, err := launcher.Launch(LaunchRequest{PartyID: "party_1", Env: []string{"AUTORACE_LAUNCHER_TEST=a=b"}})
+	if err != nil {
+		t.Fatal("unable to launch party :", err)
+	}
+	select {
+	case got := <-launched:
+		if got != "party_1 a=b" {
+			t.Error("expected party_1 to run with its environment, got", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("party was not launched")
+	}
+	_, err = launcher.Launch(LaunchRequest{PartyID: "party_2", Env: []string{"malformed"}})
+	if err == nil {
+		t.Error("expected an error with a malformed environment")
+	}
+}
+
+func TestProcessLauncher_Launch(t *testing.T) {
+	launcher := NewProcessLauncher("/nonexistent/dynamic")
+	if _, err := launcher.Launch(LaunchRequest{PartyID: "party_1"}); err == nil {
+		t.Error("expected an error when the dynamic server binary does not exist")
+	}
+}
+
+func TestWithoutVariable(t *testing.T) {
+	env := withoutVariable([]string{"SESSION_SECRET=secret", "SESSION_SECRET_FILE=/secret", "LOG_LEVEL=debug"}, "SESSION_SECRET")
+	if len(env) != 2 || env[0] != "SESSION_SECRET_FILE=/secret" || env[1] != "LOG_LEVEL=debug" {
+		t.Error("expected SESSION_SECRET only to be removed, got", env)
+	}
+}
+
+func TestProcessLauncher_CheckProcess(t *testing.T) {
+	launcher := NewProcessLauncher("/usr/local/bin/dynamic")
+	err := launcher.CheckProcess("another-host", strconv.Itoa(os.Getpid()), "party_1")
+	if !errors.Is(err, ErrorForeignProcess) {
+		t.Error("expected a process of another host to be refused, got", err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal("could not get host name :", err)
+	}
+	// the test binary is not the dynamic server
+	err = launcher.CheckProcess(host, strconv.Itoa(os.Getpid()), "party_1")
+	if !errors.Is(err, ErrorNotDynamicProcess) {
+		t.Error("expected the test process to be refused, got", err)
+	}
+	tests := []struct {
+		cmdline  string
+		expected error
+	}{
+		{"dynamic\x00party_1\x00", nil},
+		{"/usr/local/bin/dynamic\x00party_1\x00", nil},
+		{"dynamic\x00party_2\x00", ErrorNotDynamicProcess},
+		{"/usr/bin/sleep\x00party_1\x00", ErrorNotDynamicProcess},
+		{"dynamic\x00", ErrorNotDynamicProcess},
+	}
+	for _, test := range tests {
+		err := launcher.checkCommandLine([]byte(test.cmdline), "party_1")
+		if !errors.Is(err, test.expected) {
+			t.Error("expected", test.expected, "for", test.cmdline, "got", err)
+		}
+	}
+}
@@ -3,12 +3,29 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/clnbs/autorace/internal/app/models"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// profileUpdateAttempts is the number of times a profile update is tried when the profile keeps changing
+const profileUpdateAttempts = 5
+
+const (
+	// PartyLaunchTTL is how long a party configuration is kept before its dynamic server sends a first heartbeat
+	PartyLaunchTTL = 2 * time.Minute
+	// PartyHeartbeatTTL is how long running party keys are kept without any heartbeat from their dynamic server
+	PartyHeartbeatTTL = 30 * time.Second
+	// PartySnapshotTTL is how long the snapshot of a party is kept once its dynamic server stopped, a
+	// replacement server has to start within this delay to resume the party
+	PartySnapshotTTL = 2 * time.Minute
+)
+
 // RedisClient hold connection to three separate database :
 // - one to store party configuration related object
 // - one to store player configuration related object
@@ -19,52 +36,51 @@ type RedisClient struct {
 	redisRunningConnection      *redis.Client
 }
 
-//NewRedisClient create a RedisClient and connect each endpoint
-//TODO get configuration from config file
+// DefaultRedisAddr is the address of the Redis server when REDIS_ADDR is not set
+const DefaultRedisAddr = "redis:6379"
+
+//NewRedisClient create a RedisClient and connect each endpoint of the Redis server found at
+//REDIS_ADDR, DefaultRedisAddr if it is not set
 //TODO test connectivity with a ping first
 func NewRedisClient() *RedisClient {
 	redisClient := new(RedisClient)
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = DefaultRedisAddr
+	}
 	redisClient.redisPartyConfigConnection = redis.NewClient(&redis.Options{
-		Addr:     "redis:6379",
+		Addr:     redisAddr,
 		Password: "",
 		DB:       0,
 	})
 	redisClient.redisPlayerConfigConnection = redis.NewClient(&redis.Options{
-		Addr:     "redis:6379",
+		Addr:     redisAddr,
 		Password: "",
 		DB:       1,
 	})
 	redisClient.redisRunningConnection = redis.NewClient(&redis.Options{
-		Addr:     "redis:6379",
+		Addr:     redisAddr,
 		Password: "",
 		DB:       2,
 	})
 	return redisClient
 }
 
-// SetPartyConfiguration store a party configuration a Redis database
+// SetPartyConfiguration store a party configuration a Redis database. The configuration expires
+// if its dynamic server does not send any heartbeat within PartyLaunchTTL
 func (rdsClient *RedisClient) SetPartyConfiguration(partyID string, partyOption models.PartyCreationToken) error {
 	ctx := context.Background()
 	stringifyPartyOption, err := json.Marshal(partyOption)
 	if err != nil {
 		return err
 	}
-	status := rdsClient.redisPartyConfigConnection.Set(ctx, partyID, string(stringifyPartyOption), 0)
+	status := rdsClient.redisPartyConfigConnection.Set(ctx, partyID, string(stringifyPartyOption), PartyLaunchTTL)
 	return status.Err()
 }
 
 // GetPartyList returns a list of all keys store in the Party Config database. Keys are Party UUID
 func (rdsClient *RedisClient) GetPartyList() ([]string, error) {
-	ctx := context.Background()
-	result, err := rdsClient.redisPartyConfigConnection.Do(ctx, "KEYS", "*").Result()
-	if err != nil {
-		return nil, err
-	}
-	partyList := make([]string, len(result.([]interface{})))
-	for index, r := range result.([]interface{}) {
-		partyList[index] = r.(string)
-	}
-	return partyList, nil
+	return scanKeys(rdsClient.redisPartyConfigConnection, "*")
 }
 
 // GetPartyCreationToken returns a party config store in a redis database
@@ -112,6 +128,102 @@ func (rdsClient *RedisClient) GetPlayer(playerID string) (*models.Player, error)
 	return player, err
 }
 
+// GetPlayerProfile get the profile of a player from player's UUID
+func (rdsClient *RedisClient) GetPlayerProfile(playerID string) (*models.PlayerProfile, error) {
+	ctx := context.Background()
+	stringifyProfile, err := rdsClient.redisPlayerConfigConnection.Get(ctx, profileKey(playerID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	profile := new(models.PlayerProfile)
+	err = json.Unmarshal([]byte(stringifyProfile), profile)
+	return profile, err
+}
+
+// UpdatePlayerProfile applies a change to the profile of a player, the profile is created if it
+// does not exist yet. The change is applied again if the profile was changed meanwhile
+func (rdsClient *RedisClient) UpdatePlayerProfile(player *models.Player, update func(profile *models.PlayerProfile)) error {
+	ctx := context.Background()
+	key := profileKey(player.PlayerUUID.String())
+	transaction := func(tx *redis.Tx) error {
+		profile := models.NewPlayerProfile(player)
+		stringifyProfile, err := tx.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			err = json.Unmarshal([]byte(stringifyProfile), profile)
+			if err != nil {
+				return err
+			}
+		}
+		update(profile)
+		updatedProfile, err := json.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Set(ctx, key, string(updatedProfile), 0).Err()
+		})
+		return err
+	}
+	var err error
+	for attempt := 0; attempt < profileUpdateAttempts; attempt++ {
+		err = rdsClient.redisPlayerConfigConnection.Watch(ctx, transaction, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
+}
+
+// ClaimPlayerName binds a player name on a player UUID for a given duration. It returns false if the
+// name is bound on another player, names are compared whatever their case
+func (rdsClient *RedisClient) ClaimPlayerName(name, playerID string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	key := playerNameKey(name)
+	claimed := false
+	transaction := func(tx *redis.Tx) error {
+		ownerID, err := tx.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		claimed = err == redis.Nil || ownerID == playerID
+		if !claimed {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Set(ctx, key, playerID, ttl).Err()
+		})
+		return err
+	}
+	err := rdsClient.redisPlayerConfigConnection.Watch(ctx, transaction, key)
+	if err == redis.TxFailedErr {
+		// the name was claimed meanwhile
+		return false, nil
+	}
+	return claimed, err
+}
+
+// ReleasePlayerName unbinds a player name from a player UUID, if it is still bound on it
+func (rdsClient *RedisClient) ReleasePlayerName(name, playerID string) error {
+	ctx := context.Background()
+	key := playerNameKey(name)
+	return rdsClient.redisPlayerConfigConnection.Watch(ctx, func(tx *redis.Tx) error {
+		ownerID, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil || ownerID != playerID {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Del(ctx, key).Err()
+		})
+		return err
+	}, key)
+}
+
 // SetPlayerOnParty store a player UUID bind on a Party UUID
 func (rdsClient *RedisClient) SetPlayerOnParty(partyID, playerID string) error {
 	ctx := context.Background()
@@ -131,7 +243,7 @@ func (rdsClient *RedisClient) SetPlayerOnParty(partyID, playerID string) error {
 	if err != nil {
 		return err
 	}
-	return rdsClient.redisRunningConnection.Set(ctx, partyID, string(stringifyPlayer), 0).Err()
+	return rdsClient.redisRunningConnection.Set(ctx, partyID, string(stringifyPlayer), PartyHeartbeatTTL).Err()
 }
 
 // GetPlayersOnParty returns players UUID bind by a party UUID
@@ -149,6 +261,679 @@ func (rdsClient *RedisClient) GetPlayersOnParty(partyID string) ([]string, error
 	return player, nil
 }
 
+// RemovePlayerOnParty remove a player UUID from the players bind on a Party UUID
+func (rdsClient *RedisClient) RemovePlayerOnParty(partyID, playerID string) error {
+	ctx := context.Background()
+	players, err := rdsClient.GetPlayersOnParty(partyID)
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	remainingPlayers := make([]string, 0, len(players))
+	for _, player := range players {
+		if player != playerID {
+			remainingPlayers = append(remainingPlayers, player)
+		}
+	}
+	stringifyPlayers, err := json.Marshal(remainingPlayers)
+	if err != nil {
+		return err
+	}
+	return rdsClient.redisRunningConnection.Set(ctx, partyID, string(stringifyPlayers), PartyHeartbeatTTL).Err()
+}
+
+// SetPartyState store the state of a running party
+func (rdsClient *RedisClient) SetPartyState(partyID string, state models.State) error {
+	ctx := context.Background()
+	return rdsClient.redisRunningConnection.Set(ctx, partyStateKey(partyID), int(state), PartyHeartbeatTTL).Err()
+}
+
+// GetPartyState returns the state of a running party. A party without any stored state is
+// still in its lobby
+func (rdsClient *RedisClient) GetPartyState(partyID string) (models.State, error) {
+	ctx := context.Background()
+	state, err := rdsClient.redisRunningConnection.Get(ctx, partyStateKey(partyID)).Int()
+	if err == redis.Nil {
+		return models.LOBBY, nil
+	}
+	if err != nil {
+		return models.LOBBY, err
+	}
+	return models.State(state), nil
+}
+
+// SetInviteCode binds an invite code on a party UUID. It returns false if the code is already used
+func (rdsClient *RedisClient) SetInviteCode(inviteCode, partyID string) (bool, error) {
+	ctx := context.Background()
+	return rdsClient.redisRunningConnection.SetNX(ctx, inviteCodeKey(inviteCode), partyID, PartyLaunchTTL).Result()
+}
+
+// ResolveInviteCode returns the party UUID bound on an invite code
+func (rdsClient *RedisClient) ResolveInviteCode(inviteCode string) (string, error) {
+	ctx := context.Background()
+	return rdsClient.redisRunningConnection.Get(ctx, inviteCodeKey(inviteCode)).Result()
+}
+
+// Heartbeat registers a party's dynamic server in the server registry and refreshes the TTL of every
+// key of this party. inviteCode is empty if the party has no invite code
+func (rdsClient *RedisClient) Heartbeat(record models.ServerRecord, inviteCode string) error {
+	ctx := context.Background()
+	partyID := record.PartyID
+	if inviteCode != "" {
+		err := rdsClient.redisRunningConnection.Expire(ctx, inviteCodeKey(inviteCode), PartyHeartbeatTTL).Err()
+		if err != nil {
+			return err
+		}
+	}
+	stringifyRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = rdsClient.redisRunningConnection.Set(ctx, partyHeartbeatKey(partyID), string(stringifyRecord), PartyHeartbeatTTL).Err()
+	if err != nil {
+		return err
+	}
+	err = rdsClient.redisPartyConfigConnection.Expire(ctx, partyID, PartyHeartbeatTTL).Err()
+	if err != nil {
+		return err
+	}
+	err = rdsClient.redisRunningConnection.Expire(ctx, partyID, PartyHeartbeatTTL).Err()
+	if err != nil {
+		return err
+	}
+	err = rdsClient.redisRunningConnection.Expire(ctx, launchKey(partyID), PartyHeartbeatTTL).Err()
+	if err != nil {
+		return err
+	}
+	err = rdsClient.refreshAdmission(partyID, record.CreatorID, PartyHeartbeatTTL)
+	if err != nil {
+		return err
+	}
+	return rdsClient.redisRunningConnection.Expire(ctx, partyStateKey(partyID), PartyHeartbeatTTL).Err()
+}
+
+// SetLaunchRecord stores how the dynamic server of a party was started. The record expires with
+// the party configuration, heartbeats refresh it
+func (rdsClient *RedisClient) SetLaunchRecord(record models.LaunchRecord) error {
+	ctx := context.Background()
+	stringifyRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return rdsClient.redisRunningConnection.Set(ctx, launchKey(record.PartyID), string(stringifyRecord), PartyLaunchTTL).Err()
+}
+
+// GetLaunchRecord returns how the dynamic server of a party was started
+func (rdsClient *RedisClient) GetLaunchRecord(partyID string) (models.LaunchRecord, error) {
+	ctx := context.Background()
+	var record models.LaunchRecord
+	stringifyRecord, err := rdsClient.redisRunningConnection.Get(ctx, launchKey(partyID)).Result()
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal([]byte(stringifyRecord), &record)
+	return record, err
+}
+
+// SetPartySnapshot stores the latest snapshot of a party for PartySnapshotTTL
+func (rdsClient *RedisClient) SetPartySnapshot(snapshot models.PartySnapshot) error {
+	ctx := context.Background()
+	stringifySnapshot, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return rdsClient.redisRunningConnection.Set(ctx, snapshotKey(snapshot.PartyID), string(stringifySnapshot), PartySnapshotTTL).Err()
+}
+
+// GetPartySnapshot returns the latest snapshot of a party
+func (rdsClient *RedisClient) GetPartySnapshot(partyID string) (models.PartySnapshot, error) {
+	ctx := context.Background()
+	var snapshot models.PartySnapshot
+	stringifySnapshot, err := rdsClient.redisRunningConnection.Get(ctx, snapshotKey(partyID)).Result()
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal([]byte(stringifySnapshot), &snapshot)
+	return snapshot, err
+}
+
+// IsPartyAlive tells if a party's dynamic server sent a heartbeat within PartyHeartbeatTTL
+func (rdsClient *RedisClient) IsPartyAlive(partyID string) (bool, error) {
+	ctx := context.Background()
+	exists, err := rdsClient.redisRunningConnection.Exists(ctx, partyHeartbeatKey(partyID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return exists != 0, nil
+}
+
+// GetServerRecord returns the registry record of a party's dynamic server, a not found error if it
+// did not send a heartbeat within PartyHeartbeatTTL
+func (rdsClient *RedisClient) GetServerRecord(partyID string) (models.ServerRecord, error) {
+	ctx := context.Background()
+	var record models.ServerRecord
+	stringifyRecord, err := rdsClient.redisRunningConnection.Get(ctx, partyHeartbeatKey(partyID)).Result()
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal([]byte(stringifyRecord), &record)
+	return record, err
+}
+
+// GetServerRecords returns the registry record of every live dynamic server
+func (rdsClient *RedisClient) GetServerRecords() ([]models.ServerRecord, error) {
+	ctx := context.Background()
+	keys, err := scanKeys(rdsClient.redisRunningConnection, partyHeartbeatKey("*"))
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	values, err := rdsClient.redisRunningConnection.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]models.ServerRecord, 0, len(values))
+	for _, value := range values {
+		// a record may expire between SCAN and MGET
+		stringifyRecord, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var record models.ServerRecord
+		err = json.Unmarshal([]byte(stringifyRecord), &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// GetRunningPartyList returns the UUID of every party with a stored state
+func (rdsClient *RedisClient) GetRunningPartyList() ([]string, error) {
+	keys, err := scanKeys(rdsClient.redisRunningConnection, partyStateKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	partyList := make([]string, len(keys))
+	for index, key := range keys {
+		partyList[index] = strings.TrimPrefix(key, partyStateKey(""))
+	}
+	return partyList, nil
+}
+
+// RemoveParty remove every key of a party : its configuration, its invite code, its players, its state, its server
+// record, its launch record and its snapshot. The party is not counted by admission control anymore
+func (rdsClient *RedisClient) RemoveParty(partyID string) error {
+	ctx := context.Background()
+	partyToken, err := rdsClient.GetPartyCreationToken(partyID)
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if partyToken.InviteCode != "" {
+		err = rdsClient.redisRunningConnection.Del(ctx, inviteCodeKey(partyToken.InviteCode)).Err()
+		if err != nil {
+			return err
+		}
+	}
+	err = rdsClient.RemovePartyCreationToken(partyID)
+	if err != nil {
+		return err
+	}
+	err = rdsClient.ReleaseParty(partyID, partyToken.CreatorID())
+	if err != nil {
+		return err
+	}
+	return rdsClient.redisRunningConnection.Del(ctx, partyID, partyStateKey(partyID), partyHeartbeatKey(partyID), launchKey(partyID), snapshotKey(partyID)).Err()
+}
+
+// admissionAttempts is the number of times a party admission is tried when other parties are admitted meanwhile
+const admissionAttempts = 5
+
+// AdmitParty counts a new party among the parties running, unless maxParties parties are already
+// running or its creator already has maxOpenParties open parties. A limit of 0 disables it, an empty
+// creatorID only applies maxParties. An admitted
+// party is counted until its configuration would expire, heartbeats of its dynamic server keep it counted.
+// It returns models.ErrorPartyLimitReached or models.ErrorOpenPartyLimitReached when a limit is reached
+func (rdsClient *RedisClient) AdmitParty(partyID, creatorID string, maxParties, maxOpenParties int) error {
+	ctx := context.Background()
+	partiesKey := admittedPartiesKey("")
+	keys := []string{partiesKey}
+	if creatorID != "" {
+		keys = append(keys, admittedPartiesKey(creatorID))
+	}
+	var refusal error
+	transaction := func(tx *redis.Tx) error {
+		now := time.Now()
+		expired := strconv.FormatInt(now.Unix(), 10)
+		refusal = nil
+		parties, err := tx.ZCount(ctx, partiesKey, "("+expired, "+inf").Result()
+		if err != nil {
+			return err
+		}
+		if maxParties > 0 && int(parties) >= maxParties {
+			refusal = models.ErrorPartyLimitReached
+			return nil
+		}
+		if creatorID != "" {
+			openParties, err := tx.ZCount(ctx, admittedPartiesKey(creatorID), "("+expired, "+inf").Result()
+			if err != nil {
+				return err
+			}
+			if maxOpenParties > 0 && int(openParties) >= maxOpenParties {
+				refusal = models.ErrorOpenPartyLimitReached
+				return nil
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			admittedUntil := &redis.Z{Score: float64(now.Add(PartyLaunchTTL).Unix()), Member: partyID}
+			for _, key := range keys {
+				pipe.ZRemRangeByScore(ctx, key, "-inf", expired)
+				pipe.ZAdd(ctx, key, admittedUntil)
+				pipe.Expire(ctx, key, PartyLaunchTTL)
+			}
+			return nil
+		})
+		return err
+	}
+	var err error
+	for attempt := 0; attempt < admissionAttempts; attempt++ {
+		err = rdsClient.redisRunningConnection.Watch(ctx, transaction, keys...)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return refusal
+}
+
+// refreshAdmission keeps a party counted by admission control for a given duration. Admission keys are
+// kept PartyLaunchTTL, as long as the longest admission
+func (rdsClient *RedisClient) refreshAdmission(partyID, creatorID string, ttl time.Duration) error {
+	ctx := context.Background()
+	admittedUntil := &redis.Z{Score: float64(time.Now().Add(ttl).Unix()), Member: partyID}
+	keys := []string{admittedPartiesKey("")}
+	if creatorID != "" {
+		keys = append(keys, admittedPartiesKey(creatorID))
+	}
+	_, err := rdsClient.redisRunningConnection.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.ZAdd(ctx, key, admittedUntil)
+			pipe.Expire(ctx, key, PartyLaunchTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// ReleaseParty stops counting a party in admission control, creatorID may be empty if it is unknown
+func (rdsClient *RedisClient) ReleaseParty(partyID, creatorID string) error {
+	ctx := context.Background()
+	err := rdsClient.redisRunningConnection.ZRem(ctx, admittedPartiesKey(""), partyID).Err()
+	if err != nil || creatorID == "" {
+		return err
+	}
+	return rdsClient.redisRunningConnection.ZRem(ctx, admittedPartiesKey(creatorID), partyID).Err()
+}
+
+// CountPartyCreation counts a party creation of a player in the current window, a window starts with
+// the first creation following the previous one. It returns the number of creations in the window and
+// how long the window still lasts
+func (rdsClient *RedisClient) CountPartyCreation(playerID string, window time.Duration) (int, time.Duration, error) {
+	return rdsClient.countInWindow(partyCreationsKey(playerID), window)
+}
+
+// CountLobbyChatMessage counts a lobby chat message of a player in the current window, like
+// CountPartyCreation. The count is shared by every static server
+func (rdsClient *RedisClient) CountLobbyChatMessage(playerID string, window time.Duration) (int, time.Duration, error) {
+	return rdsClient.countInWindow(lobbyChatKey(playerID), window)
+}
+
+// countInWindow increments a counter which is reset once a window is over, a window starts with the
+// first increment following the previous one. It returns the counter and how long the window still lasts
+func (rdsClient *RedisClient) countInWindow(key string, window time.Duration) (int, time.Duration, error) {
+	ctx := context.Background()
+	count, err := rdsClient.redisRunningConnection.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	remaining, err := rdsClient.redisRunningConnection.TTL(ctx, key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	// a window without expiry was just started, or its expiry was never set
+	if remaining < 0 {
+		remaining = window
+		err = rdsClient.redisRunningConnection.Expire(ctx, key, window).Err()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return int(count), remaining, nil
+}
+
+// JoinMatchmaking marks a player as queued for a quick play party for a given duration. It returns false
+// if the player is already queued, by any static server
+func (rdsClient *RedisClient) JoinMatchmaking(clientID string, ttl time.Duration) (bool, error) {
+	return rdsClient.redisRunningConnection.SetNX(context.Background(), matchmakingKey(clientID), true, ttl).Result()
+}
+
+// RefreshMatchmaking keeps queued players marked for a given duration. It returns the players still
+// queued, players who left the queue meanwhile are left out
+func (rdsClient *RedisClient) RefreshMatchmaking(clientIDs []string, ttl time.Duration) ([]string, error) {
+	ctx := context.Background()
+	refreshes := make([]*redis.BoolCmd, len(clientIDs))
+	_, err := rdsClient.redisRunningConnection.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for index, clientID := range clientIDs {
+			refreshes[index] = pipe.Expire(ctx, matchmakingKey(clientID), ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var queued []string
+	for index, refresh := range refreshes {
+		// Expire is false for a key which does not exist
+		if refresh.Val() {
+			queued = append(queued, clientIDs[index])
+		}
+	}
+	return queued, nil
+}
+
+// LeaveMatchmaking removes players from the quick play queue
+func (rdsClient *RedisClient) LeaveMatchmaking(clientIDs ...string) error {
+	if len(clientIDs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(clientIDs))
+	for index, clientID := range clientIDs {
+		keys[index] = matchmakingKey(clientID)
+	}
+	return rdsClient.redisRunningConnection.Del(context.Background(), keys...).Err()
+}
+
+// databases a StaleKey can be found in
+const (
+	// PartyConfigDatabase is the database holding party configurations
+	PartyConfigDatabase = "party"
+	// RunningDatabase is the database holding running party related objects
+	RunningDatabase = "running"
+)
+
+// StaleKey is a key left behind by a party or a pool which is gone, Reason tells why it is stale
+type StaleKey struct {
+	Database string `json:"database"`
+	Key      string `json:"key"`
+	Reason   string `json:"reason"`
+}
+
+// FindStaleKeys returns the keys of the party configuration and running databases which are left
+// behind : keys which never expire, slots of pools which are gone and invite codes of parties which
+// are gone. Every key of these databases is meant to expire, players are kept for good in their own
+// database
+func (rdsClient *RedisClient) FindStaleKeys() ([]StaleKey, error) {
+	var staleKeys []StaleKey
+	connections := []struct {
+		database   string
+		connection *redis.Client
+	}{
+		{PartyConfigDatabase, rdsClient.redisPartyConfigConnection},
+		{RunningDatabase, rdsClient.redisRunningConnection},
+	}
+	for _, connection := range connections {
+		keys, err := scanKeys(connection.connection, "*")
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			reason, err := rdsClient.staleReason(connection.connection, key)
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				staleKeys = append(staleKeys, StaleKey{Database: connection.database, Key: key, Reason: reason})
+			}
+		}
+	}
+	return staleKeys, nil
+}
+
+// staleReason tells why a key is stale, empty if it is not
+func (rdsClient *RedisClient) staleReason(connection *redis.Client, key string) (string, error) {
+	ctx := context.Background()
+	ttl, err := connection.TTL(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case ttl == -2:
+		// the key expired meanwhile
+		return "", nil
+	case ttl == -1:
+		return "never expires", nil
+	case connection != rdsClient.redisRunningConnection:
+		return "", nil
+	case strings.HasPrefix(key, poolSlotsKey("")):
+		exists, err := connection.Exists(ctx, poolKey(strings.TrimPrefix(key, poolSlotsKey("")))).Result()
+		if err != nil || exists != 0 {
+			return "", err
+		}
+		return "pool is gone", nil
+	case strings.HasPrefix(key, inviteCodeKey("")):
+		partyID, err := connection.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		exists, err := rdsClient.redisPartyConfigConnection.Exists(ctx, partyID).Result()
+		if err != nil || exists != 0 {
+			return "", err
+		}
+		return "party is gone", nil
+	}
+	return "", nil
+}
+
+// RemoveStaleKeys removes keys found by FindStaleKeys
+func (rdsClient *RedisClient) RemoveStaleKeys(staleKeys []StaleKey) error {
+	ctx := context.Background()
+	for _, staleKey := range staleKeys {
+		connection := rdsClient.redisRunningConnection
+		if staleKey.Database == PartyConfigDatabase {
+			connection = rdsClient.redisPartyConfigConnection
+		}
+		err := connection.Del(ctx, staleKey.Key).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterPool advertises a dynamic server pool for PartyHeartbeatTTL, its slots are kept as long
+func (rdsClient *RedisClient) RegisterPool(record models.PoolRecord) error {
+	ctx := context.Background()
+	stringifyRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = rdsClient.redisRunningConnection.Set(ctx, poolKey(record.PoolID), string(stringifyRecord), PartyHeartbeatTTL).Err()
+	if err != nil {
+		return err
+	}
+	return rdsClient.redisRunningConnection.Expire(ctx, poolSlotsKey(record.PoolID), PartyHeartbeatTTL).Err()
+}
+
+// GetPoolRecords returns the record of every advertised dynamic server pool. The slots in use are
+// counted from the reserved slots, whatever the pool advertised
+func (rdsClient *RedisClient) GetPoolRecords() ([]models.PoolRecord, error) {
+	ctx := context.Background()
+	keys, err := scanKeys(rdsClient.redisRunningConnection, poolKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]models.PoolRecord, 0, len(keys))
+	for _, key := range keys {
+		stringifyRecord, err := rdsClient.redisRunningConnection.Get(ctx, key).Result()
+		if err == redis.Nil {
+			// the pool stopped meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record models.PoolRecord
+		err = json.Unmarshal([]byte(stringifyRecord), &record)
+		if err != nil {
+			return nil, err
+		}
+		parties, err := rdsClient.redisRunningConnection.SCard(ctx, poolSlotsKey(record.PoolID)).Result()
+		if err != nil {
+			return nil, err
+		}
+		record.Parties = int(parties)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReservePoolSlot reserves a slot of a dynamic server pool for a party. It returns false if every
+// slot of the pool is in use
+func (rdsClient *RedisClient) ReservePoolSlot(poolID, partyID string, capacity int) (bool, error) {
+	ctx := context.Background()
+	key := poolSlotsKey(poolID)
+	reserved := false
+	transaction := func(tx *redis.Tx) error {
+		parties, err := tx.SCard(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		reserved = int(parties) < capacity
+		if !reserved {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, key, partyID)
+			return pipe.Expire(ctx, key, PartyHeartbeatTTL).Err()
+		})
+		return err
+	}
+	err := rdsClient.redisRunningConnection.Watch(ctx, transaction, key)
+	if err == redis.TxFailedErr {
+		// a slot was reserved meanwhile
+		return false, nil
+	}
+	return reserved, err
+}
+
+// ReleasePoolSlot frees the slot of a party in a dynamic server pool
+func (rdsClient *RedisClient) ReleasePoolSlot(poolID, partyID string) error {
+	ctx := context.Background()
+	return rdsClient.redisRunningConnection.SRem(ctx, poolSlotsKey(poolID), partyID).Err()
+}
+
+// HasPoolSlot tells if a slot of a dynamic server pool is reserved for a party
+func (rdsClient *RedisClient) HasPoolSlot(poolID, partyID string) (bool, error) {
+	ctx := context.Background()
+	return rdsClient.redisRunningConnection.SIsMember(ctx, poolSlotsKey(poolID), partyID).Result()
+}
+
+// RemovePool removes the record and the slots of a dynamic server pool
+func (rdsClient *RedisClient) RemovePool(poolID string) error {
+	ctx := context.Background()
+	return rdsClient.redisRunningConnection.Del(ctx, poolKey(poolID), poolSlotsKey(poolID)).Err()
+}
+
+// scanCount is the number of keys SCAN looks at in one call
+const scanCount = 100
+
+// scanKeys returns the keys matching a pattern. Unlike KEYS, SCAN walks the database a few keys at
+// a time, so Redis keeps serving other clients meanwhile. A key may be returned several times by
+// SCAN, it is returned once
+func scanKeys(connection *redis.Client, pattern string) ([]string, error) {
+	ctx := context.Background()
+	var keys []string
+	found := make(map[string]bool)
+	iterator := connection.Scan(ctx, 0, pattern, scanCount).Iterator()
+	for iterator.Next(ctx) {
+		key := iterator.Val()
+		if !found[key] {
+			found[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, iterator.Err()
+}
+
+// IsNotFound tells if an error was returned because a key does not exist
+func IsNotFound(err error) bool {
+	return err == redis.Nil
+}
+
+func partyStateKey(partyID string) string {
+	return "state:" + partyID
+}
+
+func partyHeartbeatKey(partyID string) string {
+	return "heartbeat:" + partyID
+}
+
+func launchKey(partyID string) string {
+	return "launch:" + partyID
+}
+
+func snapshotKey(partyID string) string {
+	return "snapshot:" + partyID
+}
+
+// admittedPartiesKey returns the key of the parties counted by admission control, those created by a
+// given player if playerID is set
+func admittedPartiesKey(playerID string) string {
+	if playerID == "" {
+		return "admitted"
+	}
+	return "admitted:" + playerID
+}
+
+func partyCreationsKey(playerID string) string {
+	return "creations:" + playerID
+}
+
+func lobbyChatKey(playerID string) string {
+	return "chat:" + playerID
+}
+
+func matchmakingKey(clientID string) string {
+	return "matchmaking:" + clientID
+}
+
+func poolKey(poolID string) string {
+	return "pool:" + poolID
+}
+
+func poolSlotsKey(poolID string) string {
+	return "poolslots:" + poolID
+}
+
+func inviteCodeKey(inviteCode string) string {
+	return "invite:" + inviteCode
+}
+
+func profileKey(playerID string) string {
+	return "profile:" + playerID
+}
+
+func playerNameKey(name string) string {
+	return "name:" + models.NormalizePlayerName(name)
+}
+
 // Close terminate Redis connection
 func (rdsClient *RedisClient) Close() error {
 	err := rdsClient.redisRunningConnection.Close()
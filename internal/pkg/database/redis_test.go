@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -100,3 +101,344 @@ func TestRedisClient_GetPlayerOnParty(t *testing.T) {
 		fmt.Println(p)
 	}
 }
+
+func TestRedisClient_Heartbeat(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_Heartbeat"))
+	rdsClient := NewRedisClient()
+	err := rdsClient.SetPartyConfiguration("heartbeat", models.PartyCreationToken{
+		ClientID:  "heartbeat",
+		PartyName: "heartbeat",
+	})
+	if err != nil {
+		t.Fatal("error while inserting data in redis :", err)
+	}
+	err = rdsClient.SetPartyState("heartbeat", models.RUN)
+	if err != nil {
+		t.Fatal("error while setting party state :", err)
+	}
+	alive, err := rdsClient.IsPartyAlive("heartbeat")
+	if err != nil {
+		t.Fatal("error while checking heartbeat :", err)
+	}
+	if alive {
+		t.Error("party should not be alive before its first heartbeat")
+	}
+	err = rdsClient.SetLaunchRecord(models.LaunchRecord{PartyID: "heartbeat", Launcher: "process", InstanceID: "42"})
+	if err != nil {
+		t.Fatal("error while recording launch :", err)
+	}
+	err = rdsClient.Heartbeat(models.ServerRecord{PartyID: "heartbeat", Host: "dynamic", PlayerCount: 2}, "")
+	if err != nil {
+		t.Fatal("error while sending heartbeat :", err)
+	}
+	alive, err = rdsClient.IsPartyAlive("heartbeat")
+	if err != nil {
+		t.Fatal("error while checking heartbeat :", err)
+	}
+	if !alive {
+		t.Error("party should be alive after a heartbeat")
+	}
+	record, err := rdsClient.GetServerRecord("heartbeat")
+	if err != nil || record.Host != "dynamic" || record.PlayerCount != 2 {
+		t.Error("unexpected server record", record, err)
+	}
+	launchRecord, err := rdsClient.GetLaunchRecord("heartbeat")
+	if err != nil || launchRecord.InstanceID != "42" {
+		t.Error("unexpected launch record", launchRecord, err)
+	}
+	records, err := rdsClient.GetServerRecords()
+	if err != nil || len(records) == 0 {
+		t.Error("expected the server to be registered, got", records, err)
+	}
+	err = rdsClient.SetPartySnapshot(models.PartySnapshot{PartyID: "heartbeat", State: models.RUN, Tick: 42})
+	if err != nil {
+		t.Fatal("error while storing snapshot :", err)
+	}
+	snapshot, err := rdsClient.GetPartySnapshot("heartbeat")
+	if err != nil || snapshot.State != models.RUN || snapshot.Tick != 42 {
+		t.Error("unexpected snapshot", snapshot, err)
+	}
+	err = rdsClient.RemoveParty("heartbeat")
+	if err != nil {
+		t.Fatal("error while removing party :", err)
+	}
+	_, err = rdsClient.GetPartyCreationToken("heartbeat")
+	if !IsNotFound(err) {
+		t.Error("party configuration should be removed, got :", err)
+	}
+	state, err := rdsClient.GetPartyState("heartbeat")
+	if err != nil || state != models.LOBBY {
+		t.Error("party state should be removed, got :", state, err)
+	}
+	_, err = rdsClient.GetServerRecord("heartbeat")
+	if !IsNotFound(err) {
+		t.Error("server record should be removed, got :", err)
+	}
+	_, err = rdsClient.GetLaunchRecord("heartbeat")
+	if !IsNotFound(err) {
+		t.Error("launch record should be removed, got :", err)
+	}
+	_, err = rdsClient.GetPartySnapshot("heartbeat")
+	if !IsNotFound(err) {
+		t.Error("snapshot should be removed, got :", err)
+	}
+}
+
+func TestRedisClient_UpdatePlayerProfile(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_UpdatePlayerProfile"))
+	rdsClient := NewRedisClient()
+	player := models.NewPlayer("profile")
+	_, err := rdsClient.GetPlayerProfile(player.PlayerUUID.String())
+	if !IsNotFound(err) {
+		t.Fatal("new player should not have a profile, got", err)
+	}
+	for race := 0; race < 2; race++ {
+		err = rdsClient.UpdatePlayerProfile(player, func(profile *models.PlayerProfile) {
+			profile.Record("track", models.RaceResult{Finished: true, Position: 1, Distance: 10})
+		})
+		if err != nil {
+			t.Fatal("error while updating profile :", err)
+		}
+	}
+	profile, err := rdsClient.GetPlayerProfile(player.PlayerUUID.String())
+	if err != nil {
+		t.Fatal("error while getting profile :", err)
+	}
+	if profile.RacesStarted != 2 || profile.Wins != 2 || profile.PlayerName != "profile" {
+		t.Error("expected two won races, got", profile)
+	}
+}
+
+func TestRedisClient_ClaimPlayerName(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_ClaimPlayerName"))
+	rdsClient := NewRedisClient()
+	claimed, err := rdsClient.ClaimPlayerName("Claimed", "one", time.Minute)
+	if err != nil || !claimed {
+		t.Fatal("expected the name to be claimed, got", claimed, err)
+	}
+	claimed, err = rdsClient.ClaimPlayerName("claimed", "one", time.Minute)
+	if err != nil || !claimed {
+		t.Error("expected the owner to claim its name again, got", claimed, err)
+	}
+	claimed, err = rdsClient.ClaimPlayerName("CLAIMED", "two", time.Minute)
+	if err != nil || claimed {
+		t.Error("expected the name to be taken, got", claimed, err)
+	}
+	err = rdsClient.ReleasePlayerName("claimed", "two")
+	if err != nil {
+		t.Fatal("error while releasing name :", err)
+	}
+	claimed, _ = rdsClient.ClaimPlayerName("claimed", "two", time.Minute)
+	if claimed {
+		t.Error("only the owner of a name should release it")
+	}
+	err = rdsClient.ReleasePlayerName("claimed", "one")
+	if err != nil {
+		t.Fatal("error while releasing name :", err)
+	}
+	claimed, err = rdsClient.ClaimPlayerName("claimed", "two", time.Minute)
+	if err != nil || !claimed {
+		t.Error("expected a released name to be claimed, got", claimed, err)
+	}
+}
+
+func TestRedisClient_ReservePoolSlot(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_ReservePoolSlot"))
+	rdsClient := NewRedisClient()
+	defer rdsClient.RemovePool("pool_test")
+	err := rdsClient.RegisterPool(models.PoolRecord{PoolID: "pool_test", Host: "dynamic", Capacity: 2})
+	if err != nil {
+		t.Fatal("error while registering pool :", err)
+	}
+	for _, partyID := range []string{"party_1", "party_2"} {
+		reserved, err := rdsClient.ReservePoolSlot("pool_test", partyID, 2)
+		if err != nil || !reserved {
+			t.Fatal("expected a slot to be reserved for", partyID, "got", reserved, err)
+		}
+	}
+	reserved, err := rdsClient.ReservePoolSlot("pool_test", "party_3", 2)
+	if err != nil || reserved {
+		t.Error("expected the pool to be full, got", reserved, err)
+	}
+	records, err := rdsClient.GetPoolRecords()
+	if err != nil {
+		t.Fatal("error while listing pools :", err)
+	}
+	for _, record := range records {
+		if record.PoolID == "pool_test" && record.Parties != 2 {
+			t.Error("expected 2 parties in the pool, got", record.Parties)
+		}
+	}
+	err = rdsClient.ReleasePoolSlot("pool_test", "party_1")
+	if err != nil {
+		t.Fatal("error while releasing slot :", err)
+	}
+	for partyID, expected := range map[string]bool{"party_1": false, "party_2": true} {
+		hasSlot, err := rdsClient.HasPoolSlot("pool_test", partyID)
+		if err != nil || hasSlot != expected {
+			t.Error("expected", partyID, "to have a slot :", expected, "got", hasSlot, err)
+		}
+	}
+	reserved, err = rdsClient.ReservePoolSlot("pool_test", "party_3", 2)
+	if err != nil || !reserved {
+		t.Error("expected a released slot to be reserved, got", reserved, err)
+	}
+}
+
+func TestRedisClient_FindStaleKeys(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_FindStaleKeys"))
+	rdsClient := NewRedisClient()
+	ctx := context.Background()
+	err := rdsClient.redisRunningConnection.Set(ctx, "stale_test", "left behind", 0).Err()
+	if err != nil {
+		t.Fatal("error while inserting data in redis :", err)
+	}
+	_, err = rdsClient.ReservePoolSlot("stale_pool_test", "party_1", 2)
+	if err != nil {
+		t.Fatal("error while reserving slot :", err)
+	}
+	_, err = rdsClient.SetInviteCode("STALE1", "stale_party_test")
+	if err != nil {
+		t.Fatal("error while setting invite code :", err)
+	}
+	staleKeys, err := rdsClient.FindStaleKeys()
+	if err != nil {
+		t.Fatal("error while finding stale keys :", err)
+	}
+	expected := map[string]string{
+		"stale_test":                    "never expires",
+		poolSlotsKey("stale_pool_test"): "pool is gone",
+		inviteCodeKey("STALE1"):         "party is gone",
+	}
+	found := make([]StaleKey, 0, len(expected))
+	for _, staleKey := range staleKeys {
+		if reason, ok := expected[staleKey.Key]; ok {
+			if staleKey.Reason != reason || staleKey.Database != RunningDatabase {
+				t.Error("unexpected stale key", staleKey)
+			}
+			found = append(found, staleKey)
+		}
+	}
+	if len(found) != len(expected) {
+		t.Fatal("expected", len(expected), "stale keys, got", found)
+	}
+	err = rdsClient.RemoveStaleKeys(found)
+	if err != nil {
+		t.Fatal("error while removing stale keys :", err)
+	}
+	exists, err := rdsClient.redisRunningConnection.Exists(ctx, "stale_test", poolSlotsKey("stale_pool_test"), inviteCodeKey("STALE1")).Result()
+	if err != nil || exists != 0 {
+		t.Error("expected stale keys to be removed, got", exists, err)
+	}
+}
+
+func TestRedisClient_AdmitParty(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_AdmitParty"))
+	rdsClient := NewRedisClient()
+	ctx := context.Background()
+	rdsClient.redisRunningConnection.Del(ctx, admittedPartiesKey(""), admittedPartiesKey("creator_1"), admittedPartiesKey("creator_2"))
+	defer rdsClient.redisRunningConnection.Del(ctx, admittedPartiesKey(""), admittedPartiesKey("creator_1"), admittedPartiesKey("creator_2"))
+	tests := []struct {
+		partyID, creatorID string
+		expected           error
+	}{
+		{"party_1", "creator_1", nil},
+		{"party_2", "creator_1", nil},
+		{"party_3", "creator_1", models.ErrorOpenPartyLimitReached},
+		{"party_3", "creator_2", nil},
+		{"party_4", "creator_2", models.ErrorPartyLimitReached},
+	}
+	for _, test := range tests {
+		err := rdsClient.AdmitParty(test.partyID, test.creatorID, 3, 2)
+		if err != test.expected {
+			t.Error("expected", test.expected, "admitting", test.partyID, "of", test.creatorID, "got", err)
+		}
+	}
+	err := rdsClient.ReleaseParty("party_1", "creator_1")
+	if err != nil {
+		t.Fatal("error while releasing party :", err)
+	}
+	err = rdsClient.AdmitParty("party_4", "creator_1", 3, 2)
+	if err != nil {
+		t.Error("expected a released party to free a slot, got", err)
+	}
+	err = rdsClient.Heartbeat(models.ServerRecord{PartyID: "party_4", CreatorID: "creator_1"}, "")
+	if err != nil {
+		t.Fatal("error while sending heartbeat :", err)
+	}
+	ttl, err := rdsClient.redisRunningConnection.TTL(ctx, admittedPartiesKey("creator_1")).Result()
+	if err != nil || ttl <= PartyHeartbeatTTL {
+		t.Error("expected admission keys to be kept as long as a launch, got", ttl, err)
+	}
+	err = rdsClient.AdmitParty("party_5", "", 5, 1)
+	if err != nil {
+		t.Error("expected a party without creator to only be limited by the party cap, got", err)
+	}
+}
+
+func TestRedisClient_CountPartyCreation(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_CountPartyCreation"))
+	rdsClient := NewRedisClient()
+	ctx := context.Background()
+	rdsClient.redisRunningConnection.Del(ctx, partyCreationsKey("creator_1"))
+	defer rdsClient.redisRunningConnection.Del(ctx, partyCreationsKey("creator_1"))
+	for expected := 1; expected <= 3; expected++ {
+		creations, remaining, err := rdsClient.CountPartyCreation("creator_1", time.Minute)
+		if err != nil {
+			t.Fatal("error while counting party creation :", err)
+		}
+		if creations != expected || remaining <= 0 || remaining > time.Minute {
+			t.Error("expected creation", expected, "within a minute, got", creations, remaining)
+		}
+	}
+}
+
+func TestRedisClient_CountLobbyChatMessage(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_CountLobbyChatMessage"))
+	rdsClient := NewRedisClient()
+	ctx := context.Background()
+	rdsClient.redisRunningConnection.Del(ctx, lobbyChatKey("player_1"), partyCreationsKey("player_1"))
+	defer rdsClient.redisRunningConnection.Del(ctx, lobbyChatKey("player_1"), partyCreationsKey("player_1"))
+	for expected := 1; expected <= 3; expected++ {
+		messages, remaining, err := rdsClient.CountLobbyChatMessage("player_1", time.Minute)
+		if err != nil {
+			t.Fatal("error while counting chat message :", err)
+		}
+		if messages != expected || remaining <= 0 || remaining > time.Minute {
+			t.Error("expected message", expected, "within a minute, got", messages, remaining)
+		}
+	}
+	creations, _, err := rdsClient.CountPartyCreation("player_1", time.Minute)
+	if err != nil || creations != 1 {
+		t.Error("chat messages should not be counted as party creations, got", creations, err)
+	}
+}
+
+func TestRedisClient_Matchmaking(t *testing.T) {
+	defer fmt.Println(systool.TimeTrack(time.Now(), "TestRedisClient_Matchmaking"))
+	rdsClient := NewRedisClient()
+	ctx := context.Background()
+	rdsClient.redisRunningConnection.Del(ctx, matchmakingKey("player_1"), matchmakingKey("player_2"))
+	defer rdsClient.redisRunningConnection.Del(ctx, matchmakingKey("player_1"), matchmakingKey("player_2"))
+	for _, clientID := range []string{"player_1", "player_2"} {
+		joined, err := rdsClient.JoinMatchmaking(clientID, time.Minute)
+		if err != nil || !joined {
+			t.Fatal("expected", clientID, "to join the queue, got", joined, err)
+		}
+	}
+	joined, err := rdsClient.JoinMatchmaking("player_1", time.Minute)
+	if err != nil || joined {
+		t.Error("a queued player should not be queued twice, got", joined, err)
+	}
+	err = rdsClient.LeaveMatchmaking("player_1")
+	if err != nil {
+		t.Fatal("error while leaving the queue :", err)
+	}
+	queued, err := rdsClient.RefreshMatchmaking([]string{"player_1", "player_2"}, time.Minute)
+	if err != nil {
+		t.Fatal("error while refreshing the queue :", err)
+	}
+	if len(queued) != 1 || queued[0] != "player_2" {
+		t.Error("expected player_2 only to be queued, got", queued)
+	}
+}
@@ -1,10 +1,11 @@
 package messaging
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"os/signal"
-	"reflect"
+	"sync"
 	"syscall"
 
 	"github.com/clnbs/autorace/pkg/logger"
@@ -14,18 +15,29 @@ import (
 
 // ErrorResponse is sent back when a error occurs
 type ErrorResponse struct {
+	Code         string `json:"code,omitempty"`
 	ErrorMessage string `json:"error_message"`
 }
 
-// RabbitConnection hold a representation of a RabbitMQ connection
+// RabbitConnection hold a representation of a RabbitMQ connection. SessionToken is attached to
+// every envelope sent, it proves which player sends the messages
 type RabbitConnection struct {
 	RabbitURL       string
+	SenderID        string
+	SessionToken    string
 	conn            *amqp.Connection
 	partiesChannel  *amqp.Channel
 	lobbiesChannel  *amqp.Channel
+	sharedChannel   *amqp.Channel
 	name            string
 	ReceivedMessage map[string]chan []byte
 	SendingMessage  map[string]chan []byte
+	confirmMutex    sync.Mutex
+	confirmation    *confirmation
+	outbound        *outboundQueue
+	metrics         *publishMetrics
+	done            chan struct{}
+	closeOnce       sync.Once
 }
 
 // RabbitConnectionConfiguration hold configuration to make a RabbitMQ connection possible
@@ -41,6 +53,8 @@ func NewRabbitConnection(config RabbitConnectionConfiguration) (*RabbitConnectio
 	rConn := new(RabbitConnection)
 	rConn.ReceivedMessage = make(map[string]chan []byte)
 	rConn.SendingMessage = make(map[string]chan []byte)
+	rConn.metrics = new(publishMetrics)
+	rConn.done = make(chan struct{})
 	var err error
 	rConn.RabbitURL = "amqp://" + config.User + ":" + config.Password + "@" + config.Host + ":" + config.Port + "/"
 	rConn.conn, err = amqp.Dial(rConn.RabbitURL)
@@ -71,7 +85,7 @@ func NewRabbitConnection(config RabbitConnectionConfiguration) (*RabbitConnectio
 		return nil, err
 	}
 	err = rConn.lobbiesChannel.ExchangeDeclare(
-		"lobbies_topic", // name
+		LobbiesExchange, // name
 		"topic",         // type
 		true,            // durable
 		false,           // auto-deleted
@@ -79,32 +93,74 @@ func NewRabbitConnection(config RabbitConnectionConfiguration) (*RabbitConnectio
 		false,           // no-wait
 		nil,             // arguments
 	)
+	if err != nil {
+		return nil, err
+	}
+	err = rConn.declareDeadLetter()
+	if err != nil {
+		return nil, err
+	}
+	err = rConn.declareSharedChannel()
+	if err != nil {
+		return nil, err
+	}
 	return rConn, nil
 }
 
-// SendMessageOnTopic is used to send a message on a specific topic
+// SendMessageOnTopic is used to send a message on a specific topic. The message is wrapped
+// in an Envelope, errors are sent as structured errors
 func (rConn *RabbitConnection) SendMessageOnTopic(message interface{}, topic string) {
-	switch message.(type) {
-	case error:
-		return
+	err := rConn.publish(rConn.partiesChannel, message, topic)
+	if err != nil {
+		logger.Error("error while sending message :", err)
+	}
+}
+
+// publish wraps a message in an Envelope and publish it on a given channel
+func (rConn *RabbitConnection) publish(channel *amqp.Channel, message interface{}, topic string) error {
+	publishing, err := rConn.prepare(message)
+	if err != nil {
+		return err
 	}
-	bitifyMessage, err := json.Marshal(message)
+	return rConn.publishPrepared(channel, publishing, topic)
+}
+
+// prepare wraps a message in an Envelope and marshal it in a publishing ready to be sent
+func (rConn *RabbitConnection) prepare(message interface{}) (amqp.Publishing, error) {
+	envelope, err := NewEnvelope(message, rConn.SenderID)
 	if err != nil {
-		logger.Error("while marshaling \"get\" :", err)
-		return
+		return amqp.Publishing{}, err
 	}
-	err = rConn.partiesChannel.Publish(
-		"parties_topic", // exchange
-		topic,           // routing key
-		false,           // mandatory
-		false,           // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        bitifyMessage,
-		})
+	envelope.SessionToken = rConn.SessionToken
+	bitifyMessage, err := json.Marshal(envelope)
 	if err != nil {
-		logger.Error("error while sending message :", err)
+		return amqp.Publishing{}, err
 	}
+	return amqp.Publishing{
+		ContentType: "application/json",
+		Type:        envelope.Type,
+		Timestamp:   envelope.Timestamp,
+		Body:        bitifyMessage,
+	}, nil
+}
+
+func (rConn *RabbitConnection) publishPrepared(channel *amqp.Channel, publishing amqp.Publishing, topic string) error {
+	return rConn.publishOnExchange(channel, "parties_topic", publishing, topic)
+}
+
+func (rConn *RabbitConnection) publishOnExchange(channel *amqp.Channel, exchange string, publishing amqp.Publishing, topic string) error {
+	err := channel.Publish(
+		exchange, // exchange
+		topic,    // routing key
+		false,    // mandatory
+		false,    // immediate
+		publishing,
+	)
+	if err != nil {
+		return err
+	}
+	rConn.metrics.published()
+	return nil
 }
 
 // ReceiveMessageOnTopic is used to receive a specific message on a given topic. This method
@@ -113,6 +169,12 @@ func (rConn *RabbitConnection) SendMessageOnTopic(message interface{}, topic str
 // `func handler(msg []byte) interface{}`
 // The slice of byte pass in argument of `handler` is the body of the message received on the topic
 func (rConn *RabbitConnection) ReceiveMessageOnTopic(topic string, handler func([]byte) interface{}, communicationChan chan interface{}, readyToReceive chan bool) error {
+	return rConn.ReceiveMessageOnTopicWithContext(context.Background(), topic, handler, communicationChan, readyToReceive)
+}
+
+// ReceiveMessageOnTopicWithContext works like ReceiveMessageOnTopic, until the context is done : the
+// subscription is then cancelled, so its queue is deleted, and it returns the error of the context
+func (rConn *RabbitConnection) ReceiveMessageOnTopicWithContext(ctx context.Context, topic string, handler func([]byte) interface{}, communicationChan chan interface{}, readyToReceive chan bool) error {
 	queue, err := rConn.partiesChannel.QueueDeclare(
 		"",    // name
 		false, // type
@@ -138,9 +200,10 @@ func (rConn *RabbitConnection) ReceiveMessageOnTopic(topic string, handler func(
 	}
 
 	logger.Trace("waiting message on topic :", topic)
+	// the queue name is unique, it tags the consumer to cancel
 	msgs, err := rConn.partiesChannel.Consume(
 		queue.Name, // queue
-		"",         // consumer
+		queue.Name, // consumer
 		true,       // auto ack
 		false,      // exclusive
 		false,      // no local
@@ -151,16 +214,28 @@ func (rConn *RabbitConnection) ReceiveMessageOnTopic(topic string, handler func(
 		readyToReceive <- false
 		return err
 	}
-	loop := make(chan os.Signal)
-	signal.Notify(loop, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		readyToReceive <- true
 		for msg := range msgs {
-			communicationChan <- handler(msg.Body)
+			select {
+			case communicationChan <- handler(msg.Body):
+			case <-rConn.done:
+				return
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
-	<-loop
-	return nil
+	rConn.waitForStopOrDone(ctx)
+	if ctx.Err() == nil {
+		return nil
+	}
+	// an auto-deleted queue is deleted along with its last consumer
+	err = rConn.partiesChannel.Cancel(queue.Name, false)
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
 //ReceiveMessageOnTopicWithHeader is used to receive a specific message on a given topic. This method
@@ -204,16 +279,18 @@ func (rConn *RabbitConnection) ReceiveMessageOnTopicWithHeader(topic string, han
 		readyToReceive <- false
 		return err
 	}
-	loop := make(chan os.Signal)
-	signal.Notify(loop, os.Interrupt, syscall.SIGTERM)
 	logger.Trace("waiting message on topic :", topic)
 	go func() {
 		readyToReceive <- true
 		for msg := range msgs {
-			communicationChan <- handler(msg)
+			select {
+			case communicationChan <- handler(msg):
+			case <-rConn.done:
+				return
+			}
 		}
 	}()
-	<-loop
+	rConn.waitForStop()
 	return nil
 }
 
@@ -260,13 +337,10 @@ func (rConn *RabbitConnection) ReceiveMessageOnTopicWithCallback(topic, response
 		readyToReceive <- false
 		return err
 	}
-	loop := make(chan os.Signal)
-	signal.Notify(loop, os.Interrupt, syscall.SIGTERM)
 	logger.Trace("waiting message on topic :", topic)
 	go func() {
 		readyToReceive <- true
 		for msg := range msgs {
-			var err error
 			responseForged, forgedResponseTopic := responseCreator(msg)
 			if forgedResponseTopic == "" {
 				forgedResponseTopic = responseTopic
@@ -274,16 +348,15 @@ func (rConn *RabbitConnection) ReceiveMessageOnTopicWithCallback(topic, response
 			if []byte(forgedResponseTopic)[0] == byte('.') {
 				forgedResponseTopic = responseTopic + forgedResponseTopic
 			}
-			if reflect.TypeOf(responseForged) == reflect.TypeOf(err) {
-				logger.Error("error while calling response creator :", responseForged)
-				errMessage := ErrorResponse{ErrorMessage: "error while calling response creator :" + responseForged.(error).Error()}
-				callback(errMessage, responseTopic)
+			if err, ok := responseForged.(error); ok {
+				logger.Error("error while calling response creator :", err)
+				callback(NewErrorResponse(err), forgedResponseTopic)
 				continue
 			}
 			callback(responseForged, forgedResponseTopic)
 		}
 	}()
-	<-loop
+	rConn.waitForStop()
 	return nil
 }
 
@@ -330,23 +403,44 @@ func (rConn *RabbitConnection) ReceiveMessageOnTopicWithHandler(topic string, ha
 		readyToReceive <- false
 		return err
 	}
-	loop := make(chan os.Signal)
-	signal.Notify(loop, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		readyToReceive <- true
 		for msg := range msgs {
 			handler(msg)
 		}
 	}()
-	<-loop
+	rConn.waitForStop()
 	return nil
 }
 
-// Close terminate RabbitMQ connection
-func (rConn *RabbitConnection) Close() error {
-	err := rConn.lobbiesChannel.Close()
-	if err != nil {
-		return err
+// Done returns a chan closed when the connection is closed
+func (rConn *RabbitConnection) Done() <-chan struct{} {
+	return rConn.done
+}
+
+// waitForStop blocks until the process is interrupted or the connection is closed
+func (rConn *RabbitConnection) waitForStop() {
+	rConn.waitForStopOrDone(context.Background())
+}
+
+// waitForStopOrDone blocks until the process is interrupted, the connection is closed or the context is done
+func (rConn *RabbitConnection) waitForStopOrDone(ctx context.Context) {
+	loop := make(chan os.Signal, 1)
+	signal.Notify(loop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(loop)
+	select {
+	case <-loop:
+	case <-rConn.done:
+	case <-ctx.Done():
 	}
-	return rConn.partiesChannel.Close()
+}
+
+// Close terminate RabbitMQ connection. Every receiving loop of this connection returns
+func (rConn *RabbitConnection) Close() error {
+	var err error
+	rConn.closeOnce.Do(func() {
+		close(rConn.done)
+		err = rConn.conn.Close()
+	})
+	return err
 }
@@ -1,7 +1,6 @@
 package messaging
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/streadway/amqp"
@@ -14,7 +13,11 @@ func TestNewRabbitConnection(t *testing.T) {
 }
 
 func byteToString(msg []byte) interface{} {
-	return string(msg)
+	envelope, err := OpenEnvelope(msg)
+	if err != nil {
+		return err.Error()
+	}
+	return string(envelope.Payload)
 }
 
 func TestRabbitConnection_ReceiveMessageOnTopic(t *testing.T) {
@@ -76,7 +79,7 @@ func TestRabbitConnection_ReceiveMessageOnTopicWithHandler(t *testing.T) {
 	go func() {
 		err := rConn.ReceiveMessageOnTopicWithHandler("test.topic.handler", stringHandler, rdyToReceive)
 		if err != nil {
-			t.Fatal("could not receive data with handler :", err)
+			t.Error("could not receive data with handler :", err)
 		}
 	}()
 	<-rdyToReceive
@@ -94,7 +97,7 @@ func TestRabbitConnection_ReceiveMessageOnTopicWithHandler(t *testing.T) {
 
 func stringResponseCreator(msg amqp.Delivery) (interface{}, string) {
 	clientID := new(string)
-	err := json.Unmarshal(msg.Body, clientID)
+	err := UnmarshalPayload(msg.Body, "string", clientID)
 	if err != nil {
 		return err, ""
 	}
@@ -107,7 +110,7 @@ func stringHandlerCallback(msg []byte) {
 
 func computeTestCallbackResponse(msg []byte) interface{} {
 	str := new(string)
-	err := json.Unmarshal(msg, str)
+	err := UnmarshalPayload(msg, "string", str)
 	if err != nil {
 		return err
 	}
@@ -133,7 +136,7 @@ func TestRabbitConnection_ReceiveMessageOnTopicWithCallback(t *testing.T) {
 	go func() {
 		err := rConn.ReceiveMessageOnTopicWithCallback("test.topic.callback.testing", "test.topic.callback", rConn.SendMessageOnTopic, stringResponseCreator, rdyToReceive)
 		if err != nil {
-			t.Fatal("could not receive data with callback :", err)
+			t.Error("could not receive data with callback :", err)
 		}
 	}()
 	<-rdyToReceive
@@ -142,7 +145,7 @@ func TestRabbitConnection_ReceiveMessageOnTopicWithCallback(t *testing.T) {
 	go func() {
 		err := rConn.ReceiveMessageOnTopic("test.topic.callback."+clientID.String(), computeTestCallbackResponse, received, rdyToReceive)
 		if err != nil {
-			t.Fatal("could not receive data with handler :", err)
+			t.Error("could not receive data with handler :", err)
 		}
 	}()
 	<-rdyToReceive
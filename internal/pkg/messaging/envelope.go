@@ -0,0 +1,195 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// SchemaVersion is the version of the message schema spoken by this build. A receiver refuses
+// envelopes issued with another version
+const SchemaVersion = 1
+
+// ErrorMessageType is the type of an envelope carrying an error instead of a payload
+const ErrorMessageType = "error"
+
+// possible error codes sent in an ErrorResponse
+const (
+	// ErrorCodeVersionMismatch is sent when client and server do not speak the same schema version
+	ErrorCodeVersionMismatch = "version_mismatch"
+	// ErrorCodeUnexpectedType is sent when a message type is not the one expected on a topic
+	ErrorCodeUnexpectedType = "unexpected_message_type"
+	// ErrorCodeMalformedMessage is sent when a message could not be decoded
+	ErrorCodeMalformedMessage = "malformed_message"
+	// ErrorCodeInternal is sent for any other error
+	ErrorCodeInternal = "internal_error"
+)
+
+var (
+	// ErrorVersionMismatch is returned when an envelope was issued with another schema version
+	ErrorVersionMismatch = errors.New("schema version mismatch")
+	// ErrorUnexpectedType is returned when an envelope does not hold the expected message type
+	ErrorUnexpectedType = errors.New("unexpected message type")
+	// ErrorMalformedMessage is returned when an envelope or its payload could not be decoded
+	ErrorMalformedMessage = errors.New("malformed message")
+)
+
+// Typed is implemented by messages declaring their own type. Receivers dispatch
+// messages on this type
+type Typed interface {
+	MessageType() string
+}
+
+// Envelope wraps every message sent on a topic
+type Envelope struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version"`
+	SenderID  string    `json:"sender_id"`
+	Timestamp time.Time `json:"timestamp"`
+	// SessionToken is the session token of the player sending the message, if any
+	SessionToken string          `json:"session_token,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Error        *ErrorResponse  `json:"error,omitempty"`
+}
+
+// RemoteError is returned when an envelope carries an ErrorResponse
+type RemoteError struct {
+	Response ErrorResponse
+}
+
+// Error stringify a RemoteError
+func (remoteError RemoteError) Error() string {
+	if remoteError.Response.Code == "" {
+		return remoteError.Response.ErrorMessage
+	}
+	return remoteError.Response.Code + " : " + remoteError.Response.ErrorMessage
+}
+
+// NewErrorResponse create an ErrorResponse from an error, with a code matching the error
+func NewErrorResponse(err error) ErrorResponse {
+	var remoteError RemoteError
+	switch {
+	case errors.As(err, &remoteError):
+		return remoteError.Response
+	case errors.Is(err, ErrorVersionMismatch):
+		return ErrorResponse{Code: ErrorCodeVersionMismatch, ErrorMessage: err.Error()}
+	case errors.Is(err, ErrorUnexpectedType):
+		return ErrorResponse{Code: ErrorCodeUnexpectedType, ErrorMessage: err.Error()}
+	case errors.Is(err, ErrorMalformedMessage):
+		return ErrorResponse{Code: ErrorCodeMalformedMessage, ErrorMessage: err.Error()}
+	}
+	return ErrorResponse{Code: ErrorCodeInternal, ErrorMessage: err.Error()}
+}
+
+// NewEnvelope wraps a message in an Envelope. Errors and ErrorResponse are sent as structured errors
+func NewEnvelope(message interface{}, senderID string) (*Envelope, error) {
+	envelope := &Envelope{
+		Type:      MessageTypeOf(message),
+		Version:   SchemaVersion,
+		SenderID:  senderID,
+		Timestamp: time.Now(),
+	}
+	switch message.(type) {
+	case ErrorResponse:
+		errorResponse := message.(ErrorResponse)
+		envelope.Error = &errorResponse
+		return envelope, nil
+	case *ErrorResponse:
+		envelope.Error = message.(*ErrorResponse)
+		return envelope, nil
+	case error:
+		errorResponse := NewErrorResponse(message.(error))
+		envelope.Error = &errorResponse
+		return envelope, nil
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Payload = payload
+	return envelope, nil
+}
+
+// MessageTypeOf returns the type of a message sent in an Envelope. Messages implementing Typed
+// give their own type, other messages are typed after their Go type
+func MessageTypeOf(message interface{}) string {
+	switch message.(type) {
+	case Typed:
+		return message.(Typed).MessageType()
+	case ErrorResponse, *ErrorResponse, error:
+		return ErrorMessageType
+	case nil:
+		return "nil"
+	}
+	messageType := reflect.TypeOf(message)
+	for messageType.Kind() == reflect.Ptr {
+		messageType = messageType.Elem()
+	}
+	return messageType.String()
+}
+
+// OpenEnvelope decodes an Envelope from a message body and checks its schema version
+func OpenEnvelope(body []byte) (*Envelope, error) {
+	envelope := new(Envelope)
+	err := json.Unmarshal(body, envelope)
+	if err != nil {
+		return nil, fmt.Errorf("%w : %v", ErrorMalformedMessage, err)
+	}
+	if envelope.Version != SchemaVersion {
+		return envelope, fmt.Errorf("%w : received version %d, expected version %d", ErrorVersionMismatch, envelope.Version, SchemaVersion)
+	}
+	return envelope, nil
+}
+
+// Err returns a RemoteError if the envelope carries an error, nil otherwise
+func (envelope *Envelope) Err() error {
+	if envelope.Error == nil {
+		return nil
+	}
+	return RemoteError{Response: *envelope.Error}
+}
+
+// Decode unmarshal the envelope payload in v. It returns a RemoteError if the envelope carries an error
+func (envelope *Envelope) Decode(v interface{}) error {
+	if envelope.Error != nil {
+		return envelope.Err()
+	}
+	err := json.Unmarshal(envelope.Payload, v)
+	if err != nil {
+		return fmt.Errorf("%w : %v", ErrorMalformedMessage, err)
+	}
+	return nil
+}
+
+// UnmarshalPayload opens the envelope of a message body, checks its type and decodes its payload in v
+func UnmarshalPayload(body []byte, messageType string, v interface{}) error {
+	envelope, err := OpenEnvelope(body)
+	if err != nil {
+		return err
+	}
+	if envelope.Error != nil {
+		return envelope.Err()
+	}
+	if envelope.Type != messageType {
+		return fmt.Errorf("%w : received \"%s\", expected \"%s\"", ErrorUnexpectedType, envelope.Type, messageType)
+	}
+	return envelope.Decode(v)
+}
+
+// UnmarshalSignedPayload works like UnmarshalPayload and returns the session token the message was
+// sent with, to be verified by the receiver
+func UnmarshalSignedPayload(body []byte, messageType string, v interface{}) (string, error) {
+	envelope, err := OpenEnvelope(body)
+	if err != nil {
+		return "", err
+	}
+	if envelope.Error != nil {
+		return envelope.SessionToken, envelope.Err()
+	}
+	if envelope.Type != messageType {
+		return envelope.SessionToken, fmt.Errorf("%w : received \"%s\", expected \"%s\"", ErrorUnexpectedType, envelope.Type, messageType)
+	}
+	return envelope.SessionToken, envelope.Decode(v)
+}
@@ -0,0 +1,104 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type typedMessage struct {
+	Content string `json:"content"`
+}
+
+func (message typedMessage) MessageType() string {
+	return "test.typed"
+}
+
+func TestNewEnvelope(t *testing.T) {
+	envelope, err := NewEnvelope(typedMessage{Content: "toto"}, "sender")
+	if err != nil {
+		t.Fatal("could not create envelope :", err)
+	}
+	bitifyEnvelope, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal("could not marshal envelope :", err)
+	}
+	var message typedMessage
+	err = UnmarshalPayload(bitifyEnvelope, "test.typed", &message)
+	if err != nil {
+		t.Fatal("could not unmarshal payload :", err)
+	}
+	if message.Content != "toto" {
+		t.Fatal("wrong payload content :", message.Content)
+	}
+	err = UnmarshalPayload(bitifyEnvelope, "test.other", &message)
+	if !errors.Is(err, ErrorUnexpectedType) {
+		t.Fatal("expected an unexpected type error, got :", err)
+	}
+	if NewErrorResponse(err).Code != ErrorCodeUnexpectedType {
+		t.Fatal("wrong error code :", NewErrorResponse(err).Code)
+	}
+}
+
+func TestNewEnvelope_Error(t *testing.T) {
+	envelope, err := NewEnvelope(errors.New("something went wrong"), "sender")
+	if err != nil {
+		t.Fatal("could not create envelope :", err)
+	}
+	if envelope.Type != ErrorMessageType || envelope.Error == nil {
+		t.Fatal("an error should be sent as a structured error")
+	}
+	bitifyEnvelope, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal("could not marshal envelope :", err)
+	}
+	var message typedMessage
+	err = UnmarshalPayload(bitifyEnvelope, "test.typed", &message)
+	var remoteError RemoteError
+	if !errors.As(err, &remoteError) {
+		t.Fatal("expected a remote error, got :", err)
+	}
+	if remoteError.Response.ErrorMessage != "something went wrong" {
+		t.Fatal("wrong error message :", remoteError.Response.ErrorMessage)
+	}
+}
+
+func TestOpenEnvelope_VersionMismatch(t *testing.T) {
+	_, err := OpenEnvelope([]byte(`{"type":"test.typed","version":0,"payload":{"content":"toto"}}`))
+	if !errors.Is(err, ErrorVersionMismatch) {
+		t.Fatal("expected a version mismatch error, got :", err)
+	}
+	// bare payloads sent by outdated clients have no version
+	_, err = OpenEnvelope([]byte(`{"content":"toto"}`))
+	if !errors.Is(err, ErrorVersionMismatch) {
+		t.Fatal("expected a version mismatch error, got :", err)
+	}
+	_, err = OpenEnvelope([]byte(`not json`))
+	if !errors.Is(err, ErrorMalformedMessage) {
+		t.Fatal("expected a malformed message error, got :", err)
+	}
+}
+
+func TestUnmarshalSignedPayload(t *testing.T) {
+	envelope, err := NewEnvelope(typedMessage{Content: "toto"}, "sender")
+	if err != nil {
+		t.Fatal("unable to create envelope :", err)
+	}
+	envelope.SessionToken = "token"
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal("unable to marshal envelope :", err)
+	}
+	var message typedMessage
+	sessionToken, err := UnmarshalSignedPayload(body, "test.typed", &message)
+	if err != nil {
+		t.Fatal("unable to unmarshal payload :", err)
+	}
+	if sessionToken != "token" || message.Content != "toto" {
+		t.Error("expected session token \"token\" and content \"toto\", got", sessionToken, message.Content)
+	}
+	_, err = UnmarshalSignedPayload(body, "test.other", &message)
+	if !errors.Is(err, ErrorUnexpectedType) {
+		t.Error("expected an unexpected type error, got :", err)
+	}
+}
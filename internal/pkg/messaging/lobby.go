@@ -0,0 +1,75 @@
+package messaging
+
+import (
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/streadway/amqp"
+)
+
+// LobbiesExchange is the exchange where lobby events are published. Unlike parties_topic, it is
+// meant to be listened by every client
+const LobbiesExchange = "lobbies_topic"
+
+// SendMessageOnLobbies is used to send a message on a specific topic of the lobbies exchange
+func (rConn *RabbitConnection) SendMessageOnLobbies(message interface{}, topic string) {
+	publishing, err := rConn.prepare(message)
+	if err != nil {
+		logger.Error("error while preparing lobby message :", err)
+		return
+	}
+	err = rConn.publishOnExchange(rConn.lobbiesChannel, LobbiesExchange, publishing, topic)
+	if err != nil {
+		logger.Error("error while sending lobby message :", err)
+	}
+}
+
+// ReceiveMessageOnLobbiesWithHandler works like ReceiveMessageOnTopicWithHandler on the lobbies exchange.
+// The func passed in argument has to be declared like the following example :
+// `handler func(delivery amqp.Delivery)`
+func (rConn *RabbitConnection) ReceiveMessageOnLobbiesWithHandler(topic string, handler func(amqp.Delivery), readyToReceive chan bool) error {
+	queue, err := rConn.lobbiesChannel.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // auto-deleted
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		readyToReceive <- false
+		return err
+	}
+	err = rConn.lobbiesChannel.QueueBind(
+		queue.Name,      //queue name
+		topic,           //routing key
+		LobbiesExchange, // exchange
+		false,           // no wait
+		nil,             // args
+	)
+	if err != nil {
+		readyToReceive <- false
+		return err
+	}
+	msgs, err := rConn.lobbiesChannel.Consume(
+		queue.Name, // queue
+		"",         // consumer
+		true,       // auto ack
+		false,      // exclusive
+		false,      // no local
+		false,      // no wait
+		nil,        // args
+	)
+	if err != nil {
+		readyToReceive <- false
+		return err
+	}
+	logger.Trace("waiting lobby message on topic :", topic)
+	go func() {
+		readyToReceive <- true
+		for msg := range msgs {
+			handler(msg)
+		}
+	}()
+	rConn.waitForStop()
+	return nil
+}
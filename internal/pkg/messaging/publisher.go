@@ -0,0 +1,318 @@
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrorPublishNotConfirmed is returned when the broker refused a message sent with confirmation
+	ErrorPublishNotConfirmed = errors.New("message was not confirmed by the broker")
+	// ErrorConfirmTimeout is returned when the broker did not confirm a message in time
+	ErrorConfirmTimeout = errors.New("timeout while waiting for broker confirmation")
+	// ErrorConnectionClosed is returned when sending a message on a closed connection
+	ErrorConnectionClosed = errors.New("connection is closed")
+)
+
+// DefaultConfirmTimeout is the time to wait for a broker confirmation before giving up
+const DefaultConfirmTimeout = 5 * time.Second
+
+// DropPolicy tells what an outbound queue does with a new message when it is full
+type DropPolicy int
+
+// possible drop policies
+const (
+	// Block waits for room in the queue
+	Block DropPolicy = iota
+	// DropNewest drops the message being queued
+	DropNewest
+	// DropOldest drops the oldest queued message to make room for the new one
+	DropOldest
+)
+
+var dropPolicies = [...]string{
+	"Block",
+	"DropNewest",
+	"DropOldest",
+}
+
+// String stringify a DropPolicy
+func (policy DropPolicy) String() string {
+	if policy < Block || policy > DropOldest {
+		return "unknown drop policy"
+	}
+	return dropPolicies[policy]
+}
+
+// PublishMetrics is a snapshot of a RabbitConnection's publishing statistics
+type PublishMetrics struct {
+	Published          uint64
+	Dropped            uint64
+	Queued             int
+	Confirmed          uint64
+	NotConfirmed       uint64
+	ConfirmTimeouts    uint64
+	LastConfirmLatency time.Duration
+	MeanConfirmLatency time.Duration
+	MaxConfirmLatency  time.Duration
+}
+
+type publishMetrics struct {
+	publishedCount    uint64
+	droppedCount      uint64
+	confirmedCount    uint64
+	notConfirmedCount uint64
+	timeoutCount      uint64
+	latencyMutex      sync.Mutex
+	lastLatency       time.Duration
+	totalLatency      time.Duration
+	maxLatency        time.Duration
+}
+
+func (metrics *publishMetrics) published() {
+	atomic.AddUint64(&metrics.publishedCount, 1)
+}
+
+func (metrics *publishMetrics) dropped() {
+	atomic.AddUint64(&metrics.droppedCount, 1)
+}
+
+func (metrics *publishMetrics) confirmed(latency time.Duration, ack bool) {
+	if !ack {
+		atomic.AddUint64(&metrics.notConfirmedCount, 1)
+		return
+	}
+	atomic.AddUint64(&metrics.confirmedCount, 1)
+	metrics.latencyMutex.Lock()
+	defer metrics.latencyMutex.Unlock()
+	metrics.lastLatency = latency
+	metrics.totalLatency += latency
+	if latency > metrics.maxLatency {
+		metrics.maxLatency = latency
+	}
+}
+
+func (metrics *publishMetrics) timedOut() {
+	atomic.AddUint64(&metrics.timeoutCount, 1)
+}
+
+// Metrics returns a snapshot of publishing statistics of this connection
+func (rConn *RabbitConnection) Metrics() PublishMetrics {
+	metrics := rConn.metrics
+	snapshot := PublishMetrics{
+		Published:       atomic.LoadUint64(&metrics.publishedCount),
+		Dropped:         atomic.LoadUint64(&metrics.droppedCount),
+		Confirmed:       atomic.LoadUint64(&metrics.confirmedCount),
+		NotConfirmed:    atomic.LoadUint64(&metrics.notConfirmedCount),
+		ConfirmTimeouts: atomic.LoadUint64(&metrics.timeoutCount),
+	}
+	if rConn.outbound != nil {
+		snapshot.Queued = len(rConn.outbound.messages)
+	}
+	metrics.latencyMutex.Lock()
+	defer metrics.latencyMutex.Unlock()
+	snapshot.LastConfirmLatency = metrics.lastLatency
+	snapshot.MaxConfirmLatency = metrics.maxLatency
+	if snapshot.Confirmed > 0 {
+		snapshot.MeanConfirmLatency = metrics.totalLatency / time.Duration(snapshot.Confirmed)
+	}
+	return snapshot
+}
+
+type confirmation struct {
+	channel       *amqp.Channel
+	confirms      chan amqp.Confirmation
+	closed        chan *amqp.Error
+	lastPublished uint64
+}
+
+// isClosed tells if the broker or the connection closed the channel
+func (confirm *confirmation) isClosed() bool {
+	select {
+	case <-confirm.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// confirmChannel lazily opens a channel in confirm mode, dedicated to messages sent with confirmation.
+// A closed channel is opened again
+func (rConn *RabbitConnection) confirmChannel() (*confirmation, error) {
+	if rConn.confirmation != nil && !rConn.confirmation.isClosed() {
+		return rConn.confirmation, nil
+	}
+	rConn.confirmation = nil
+	channel, err := rConn.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	err = channel.Confirm(false)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+	rConn.confirmation = &confirmation{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 64)),
+		closed:   channel.NotifyClose(make(chan *amqp.Error, 1)),
+	}
+	return rConn.confirmation, nil
+}
+
+// discardConfirmChannel drops a confirm channel which failed, the next message sent with confirmation
+// opens a new one
+func (rConn *RabbitConnection) discardConfirmChannel() {
+	if rConn.confirmation == nil {
+		return
+	}
+	rConn.confirmation.channel.Close()
+	rConn.confirmation = nil
+}
+
+// SendMessageOnTopicWithConfirm is used to send an important message on a specific topic. It waits until
+// the broker confirms the message, or until the timeout expires. Messages sent with confirmation are
+// serialized on a dedicated channel
+func (rConn *RabbitConnection) SendMessageOnTopicWithConfirm(message interface{}, topic string, timeout time.Duration) error {
+	rConn.confirmMutex.Lock()
+	defer rConn.confirmMutex.Unlock()
+	publishing, err := rConn.prepare(message)
+	if err != nil {
+		return err
+	}
+	confirm, err := rConn.confirmChannel()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = rConn.publishPrepared(confirm.channel, publishing, topic)
+	if err != nil {
+		rConn.discardConfirmChannel()
+		return err
+	}
+	confirm.lastPublished++
+	deliveryTag := confirm.lastPublished
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case confirmed, ok := <-confirm.confirms:
+			if !ok {
+				rConn.discardConfirmChannel()
+				return ErrorConnectionClosed
+			}
+			// confirmations of messages that timed out earlier may still arrive
+			if confirmed.DeliveryTag < deliveryTag {
+				continue
+			}
+			rConn.metrics.confirmed(time.Since(start), confirmed.Ack)
+			if !confirmed.Ack {
+				return ErrorPublishNotConfirmed
+			}
+			return nil
+		case <-timer.C:
+			rConn.metrics.timedOut()
+			return ErrorConfirmTimeout
+		}
+	}
+}
+
+type outboundMessage struct {
+	publishing amqp.Publishing
+	topic      string
+}
+
+type outboundQueue struct {
+	messages chan outboundMessage
+	policy   DropPolicy
+}
+
+// EnableOutboundQueue starts a bounded queue of `size` messages published asynchronously by a worker.
+// Messages sent with SendMessageOnTopicAsync go through this queue and follow the drop policy when
+// it is full, so a slow broker does not block the sender
+func (rConn *RabbitConnection) EnableOutboundQueue(size int, policy DropPolicy) {
+	if rConn.outbound != nil {
+		return
+	}
+	rConn.outbound = &outboundQueue{
+		messages: make(chan outboundMessage, size),
+		policy:   policy,
+	}
+	go func() {
+		for {
+			select {
+			case outMessage := <-rConn.outbound.messages:
+				err := rConn.publishPrepared(rConn.partiesChannel, outMessage.publishing, outMessage.topic)
+				if err != nil {
+					logger.Error("error while sending queued message :", err)
+				}
+			case <-rConn.done:
+				return
+			}
+		}
+	}()
+}
+
+// SendMessageOnTopicAsync is used to send a message on a specific topic without waiting for the broker.
+// The message is marshaled at once, so it can be modified as soon as this method returns.
+// If no outbound queue is enabled, the message is sent synchronously
+func (rConn *RabbitConnection) SendMessageOnTopicAsync(message interface{}, topic string) {
+	if rConn.outbound == nil {
+		rConn.SendMessageOnTopic(message, topic)
+		return
+	}
+	publishing, err := rConn.prepare(message)
+	if err != nil {
+		logger.Error("error while preparing message :", err)
+		return
+	}
+	outMessage := outboundMessage{publishing: publishing, topic: topic}
+	switch rConn.outbound.policy {
+	case Block:
+		select {
+		case rConn.outbound.messages <- outMessage:
+		case <-rConn.done:
+		}
+	case DropNewest:
+		select {
+		case rConn.outbound.messages <- outMessage:
+		default:
+			rConn.metrics.dropped()
+		}
+	case DropOldest:
+		for {
+			select {
+			case rConn.outbound.messages <- outMessage:
+				return
+			default:
+			}
+			select {
+			case <-rConn.outbound.messages:
+				rConn.metrics.dropped()
+			default:
+			}
+		}
+	}
+}
+
+// LogMetrics logs publishing statistics and warns about dropped messages since a previous snapshot.
+// It returns the current snapshot
+func (rConn *RabbitConnection) LogMetrics(previous PublishMetrics) PublishMetrics {
+	current := rConn.Metrics()
+	if current.Dropped > previous.Dropped {
+		logger.Warning("outbound queue dropped", current.Dropped-previous.Dropped, "messages, queued :", current.Queued)
+	}
+	if current.ConfirmTimeouts > previous.ConfirmTimeouts {
+		logger.Warning("broker did not confirm", current.ConfirmTimeouts-previous.ConfirmTimeouts, "messages in time")
+	}
+	logger.Trace("published :", current.Published, "dropped :", current.Dropped,
+		"confirmed :", current.Confirmed, "mean confirm latency :", current.MeanConfirmLatency,
+		"max confirm latency :", current.MaxConfirmLatency)
+	return current
+}
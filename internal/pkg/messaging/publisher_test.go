@@ -0,0 +1,71 @@
+package messaging
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+// newQueuedConnection creates a connection with an outbound queue but without worker, so the
+// queue is never emptied
+func newQueuedConnection(size int, policy DropPolicy) *RabbitConnection {
+	rConn := new(RabbitConnection)
+	rConn.metrics = new(publishMetrics)
+	rConn.done = make(chan struct{})
+	rConn.outbound = &outboundQueue{
+		messages: make(chan outboundMessage, size),
+		policy:   policy,
+	}
+	return rConn
+}
+
+func TestRabbitConnection_SendMessageOnTopicAsync_DropOldest(t *testing.T) {
+	rConn := newQueuedConnection(2, DropOldest)
+	rConn.SendMessageOnTopicAsync("message 1", "test.topic.async")
+	rConn.SendMessageOnTopicAsync("message 2", "test.topic.async")
+	rConn.SendMessageOnTopicAsync("message 3", "test.topic.async")
+	metrics := rConn.Metrics()
+	if metrics.Dropped != 1 || metrics.Queued != 2 {
+		t.Fatal("wrong metrics :", metrics.Dropped, "dropped and", metrics.Queued, "queued")
+	}
+	oldest := <-rConn.outbound.messages
+	var message string
+	err := UnmarshalPayload(oldest.publishing.Body, "string", &message)
+	if err != nil {
+		t.Fatal("could not read queued message :", err)
+	}
+	if message != "message 2" {
+		t.Fatal("oldest message should have been dropped, got :", message)
+	}
+}
+
+func TestRabbitConnection_SendMessageOnTopicAsync_DropNewest(t *testing.T) {
+	rConn := newQueuedConnection(2, DropNewest)
+	rConn.SendMessageOnTopicAsync("message 1", "test.topic.async")
+	rConn.SendMessageOnTopicAsync("message 2", "test.topic.async")
+	rConn.SendMessageOnTopicAsync("message 3", "test.topic.async")
+	metrics := rConn.Metrics()
+	if metrics.Dropped != 1 || metrics.Queued != 2 {
+		t.Fatal("wrong metrics :", metrics.Dropped, "dropped and", metrics.Queued, "queued")
+	}
+	oldest := <-rConn.outbound.messages
+	var message string
+	err := UnmarshalPayload(oldest.publishing.Body, "string", &message)
+	if err != nil {
+		t.Fatal("could not read queued message :", err)
+	}
+	if message != "message 1" {
+		t.Fatal("newest message should have been dropped, got :", message)
+	}
+}
+
+func TestConfirmation_IsClosed(t *testing.T) {
+	confirm := &confirmation{closed: make(chan *amqp.Error, 1)}
+	if confirm.isClosed() {
+		t.Fatal("an open confirm channel should not be closed")
+	}
+	close(confirm.closed)
+	if !confirm.isClosed() {
+		t.Fatal("a confirm channel closed by the broker should be opened again")
+	}
+}
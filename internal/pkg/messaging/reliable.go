@@ -0,0 +1,392 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DeadLetterExchange is the exchange where messages that could not be handled are published
+	DeadLetterExchange = "dead_letter_topic"
+	// DeadLetterQueue is a durable queue bound on every dead-lettered message. An operator can
+	// inspect it and replay its content with ReplayDeadLetters
+	DeadLetterQueue = "autocar.dead_letter"
+
+	retryCountHeader    = "x-retry-count"
+	originalTopicHeader = "x-original-topic"
+	failureReasonHeader = "x-failure-reason"
+)
+
+// RetryPolicy describe how a message whose handler failed is redelivered before being dead-lettered
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// OnDeadLetter is called, if set, when a message is sent to the dead letter queue
+	OnDeadLetter func(delivery amqp.Delivery, err error)
+}
+
+// DefaultRetryPolicy is the policy used for control-plane topics
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// Backoff returns the delay to wait before a given redelivery attempt. The first attempt is 1.
+// The delay doubles on each attempt and is capped by MaxBackoff
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	backoff := policy.InitialBackoff
+	for index := 1; index < attempt; index++ {
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+	return backoff
+}
+
+type permanentError struct {
+	err error
+}
+
+func (pErr permanentError) Error() string {
+	return pErr.err.Error()
+}
+
+func (pErr permanentError) Unwrap() error {
+	return pErr.err
+}
+
+// Permanent wraps an error that should not be retried, like a malformed message.
+// A message whose handler returns a permanent error is dead-lettered at once
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent tells if an error was wrapped by Permanent
+func IsPermanent(err error) bool {
+	var pErr permanentError
+	return errors.As(err, &pErr)
+}
+
+// deadLetters tells if a message whose handler failed with an error is dead-lettered instead of being
+// redelivered : the error is permanent, or the message was already retried as many times as allowed
+func (policy RetryPolicy) deadLetters(msg amqp.Delivery, err error) bool {
+	return IsPermanent(err) || retryCountOf(msg) >= policy.MaxRetries
+}
+
+// IsRetry tells if a message was redelivered following a retry policy, after its handler failed
+func IsRetry(msg amqp.Delivery) bool {
+	return retryCountOf(msg) > 0
+}
+
+// ReceiveMessageOnTopicWithReliableHandler is used to receive a specific message on a given topic with
+// manual acknowledgement. A message is acknowledged when the handler returns nil. If the handler returns
+// an error or panics, the message is redelivered following the retry policy, then dead-lettered.
+// The func passed in argument has to be declared like the following example :
+// `handler func(delivery amqp.Delivery) error`
+func (rConn *RabbitConnection) ReceiveMessageOnTopicWithReliableHandler(topic string, handler func(amqp.Delivery) error, policy RetryPolicy, readyToReceive chan bool) error {
+	queueName, msgs, err := rConn.consumeOnTopic(topic, false)
+	if err != nil {
+		readyToReceive <- false
+		return err
+	}
+	logger.Trace("waiting message with manual ack on topic :", topic)
+	go func() {
+		readyToReceive <- true
+		for msg := range msgs {
+			rConn.processReliably(msg, queueName, policy, handler)
+		}
+	}()
+	rConn.waitForStop()
+	return nil
+}
+
+// ReceiveMessageOnTopicWithReliableCallback works like ReceiveMessageOnTopicWithCallback with manual
+// acknowledgement. A message is acknowledged once its response is sent back. If the response creator
+// returns an error or panics, the message is redelivered following the retry policy, then dead-lettered.
+// A returned error is sent back once the message is dead-lettered : at once if it was wrapped by
+// Permanent, after the last retry otherwise
+func (rConn *RabbitConnection) ReceiveMessageOnTopicWithReliableCallback(topic, responseTopic string, callback func(interface{}, string), responseCreator func(delivery amqp.Delivery) (interface{}, string), policy RetryPolicy, readyToReceive chan bool) error {
+	return rConn.ReceiveMessageOnTopicWithReliableHandler(topic, reliableCallbackHandler(responseTopic, callback, responseCreator, policy), policy, readyToReceive)
+}
+
+// reliableCallbackHandler turns a response creator into the handler of a reliable consumer, see
+// ReceiveMessageOnTopicWithReliableCallback
+func reliableCallbackHandler(responseTopic string, callback func(interface{}, string), responseCreator func(delivery amqp.Delivery) (interface{}, string), policy RetryPolicy) func(amqp.Delivery) error {
+	return func(msg amqp.Delivery) error {
+		responseForged, forgedResponseTopic := responseCreator(msg)
+		if forgedResponseTopic == "" {
+			forgedResponseTopic = responseTopic
+		}
+		if []byte(forgedResponseTopic)[0] == byte('.') {
+			forgedResponseTopic = responseTopic + forgedResponseTopic
+		}
+		if err, ok := responseForged.(error); ok {
+			// the client only gets an error once the message will not be handled again
+			if policy.deadLetters(msg, err) {
+				callback(NewErrorResponse(err), forgedResponseTopic)
+			}
+			return err
+		}
+		callback(responseForged, forgedResponseTopic)
+		return nil
+	}
+}
+
+// consumeOnTopic declares an anonymous queue bound on a topic and start consuming it
+func (rConn *RabbitConnection) consumeOnTopic(topic string, autoAck bool) (string, <-chan amqp.Delivery, error) {
+	queue, err := rConn.partiesChannel.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // auto-deleted
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	err = rConn.partiesChannel.QueueBind(
+		queue.Name,      //queue name
+		topic,           //routing key
+		"parties_topic", // exchange
+		false,           // no wait
+		nil,             // args
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	msgs, err := rConn.partiesChannel.Consume(
+		queue.Name, // queue
+		"",         // consumer
+		autoAck,    // auto ack
+		false,      // exclusive
+		false,      // no local
+		false,      // no wait
+		nil,        // args
+	)
+	return queue.Name, msgs, err
+}
+
+func (rConn *RabbitConnection) processReliably(msg amqp.Delivery, queueName string, policy RetryPolicy, handler func(amqp.Delivery) error) {
+	err := callSafely(handler, msg)
+	if err == nil {
+		rConn.acknowledge(msg)
+		return
+	}
+	retryCount := retryCountOf(msg)
+	if policy.deadLetters(msg, err) {
+		logger.Error("dead-lettering message from topic", originalTopicOf(msg), "after", retryCount, "retries :", err)
+		if policy.OnDeadLetter != nil {
+			policy.OnDeadLetter(msg, err)
+		}
+		deadLetterErr := rConn.deadLetter(msg, err)
+		if deadLetterErr != nil {
+			logger.Error("unable to dead-letter message :", deadLetterErr)
+			err = msg.Nack(false, true)
+			if err != nil {
+				logger.Error("unable to requeue message :", err)
+			}
+			return
+		}
+		rConn.acknowledge(msg)
+		return
+	}
+	delay := policy.Backoff(retryCount + 1)
+	logger.Warning("handler failed on topic", originalTopicOf(msg), ", retrying in", delay, ":", err)
+	time.AfterFunc(delay, func() {
+		err := rConn.redeliver(msg, queueName, retryCount+1)
+		if err != nil {
+			logger.Error("unable to redeliver message, requeuing it :", err)
+			err = msg.Nack(false, true)
+			if err != nil {
+				logger.Error("unable to requeue message :", err)
+			}
+			return
+		}
+		rConn.acknowledge(msg)
+	})
+}
+
+func (rConn *RabbitConnection) acknowledge(msg amqp.Delivery) {
+	err := msg.Ack(false)
+	if err != nil {
+		logger.Error("unable to acknowledge message :", err)
+	}
+}
+
+// redeliver publishes a copy of a message directly in the consumer's queue, using the default exchange,
+// so other consumers bound on the same topic do not receive it twice
+func (rConn *RabbitConnection) redeliver(msg amqp.Delivery, queueName string, retryCount int) error {
+	headers := copyHeaders(msg.Headers)
+	headers[retryCountHeader] = int32(retryCount)
+	headers[originalTopicHeader] = originalTopicOf(msg)
+	return rConn.partiesChannel.Publish(
+		"",        // exchange
+		queueName, // routing key
+		false,     // mandatory
+		false,     // immediate
+		// a retried message is kept by a durable queue while the broker restarts
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			Type:         msg.Type,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			DeliveryMode: amqp.Persistent,
+			Body:         msg.Body,
+		})
+}
+
+func (rConn *RabbitConnection) deadLetter(msg amqp.Delivery, reason error) error {
+	headers := copyHeaders(msg.Headers)
+	headers[retryCountHeader] = int32(retryCountOf(msg))
+	headers[originalTopicHeader] = originalTopicOf(msg)
+	headers[failureReasonHeader] = reason.Error()
+	return rConn.partiesChannel.Publish(
+		DeadLetterExchange,   // exchange
+		originalTopicOf(msg), // routing key
+		false,                // mandatory
+		false,                // immediate
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			Type:         msg.Type,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			DeliveryMode: amqp.Persistent,
+			Body:         msg.Body,
+		})
+}
+
+// ReplayDeadLetters publishes back at most `limit` dead-lettered messages on their original topic.
+// It returns the number of replayed messages
+func (rConn *RabbitConnection) ReplayDeadLetters(limit int) (int, error) {
+	replayed := 0
+	for replayed < limit {
+		msg, ok, err := rConn.partiesChannel.Get(DeadLetterQueue, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			return replayed, nil
+		}
+		headers := copyHeaders(msg.Headers)
+		delete(headers, retryCountHeader)
+		delete(headers, originalTopicHeader)
+		delete(headers, failureReasonHeader)
+		err = rConn.partiesChannel.Publish(
+			"parties_topic",      // exchange
+			originalTopicOf(msg), // routing key
+			false,                // mandatory
+			false,                // immediate
+			amqp.Publishing{
+				Headers:      headers,
+				ContentType:  msg.ContentType,
+				Type:         msg.Type,
+				MessageId:    msg.MessageId,
+				Timestamp:    msg.Timestamp,
+				DeliveryMode: amqp.Persistent,
+				Body:         msg.Body,
+			})
+		if err != nil {
+			nackErr := msg.Nack(false, true)
+			if nackErr != nil {
+				logger.Error("unable to requeue dead letter :", nackErr)
+			}
+			return replayed, err
+		}
+		err = msg.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (rConn *RabbitConnection) declareDeadLetter() error {
+	err := rConn.partiesChannel.ExchangeDeclare(
+		DeadLetterExchange, // name
+		"topic",            // type
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	if err != nil {
+		return err
+	}
+	_, err = rConn.partiesChannel.QueueDeclare(
+		DeadLetterQueue, // name
+		true,            // durable
+		false,           // auto-deleted
+		false,           // exclusive
+		false,           // no-wait
+		nil,             // arguments
+	)
+	if err != nil {
+		return err
+	}
+	return rConn.partiesChannel.QueueBind(
+		DeadLetterQueue,    //queue name
+		"#",                //routing key
+		DeadLetterExchange, // exchange
+		false,              // no wait
+		nil,                // args
+	)
+}
+
+// callSafely calls a handler and turns a panic into an error
+func callSafely(handler func(amqp.Delivery) error, msg amqp.Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked : %v", r)
+		}
+	}()
+	return handler(msg)
+}
+
+func retryCountOf(msg amqp.Delivery) int {
+	switch count := msg.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	}
+	return 0
+}
+
+func originalTopicOf(msg amqp.Delivery) string {
+	if topic, ok := msg.Headers[originalTopicHeader].(string); ok && topic != "" {
+		return topic
+	}
+	return msg.RoutingKey
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	newHeaders := amqp.Table{}
+	for key, value := range headers {
+		newHeaders[key] = value
+	}
+	return newHeaders
+}
@@ -0,0 +1,109 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries:     5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for index, backoff := range expected {
+		if policy.Backoff(index+1) != backoff {
+			t.Fatal("wrong backoff for attempt", index+1, ":", policy.Backoff(index+1), "instead of", backoff)
+		}
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	err := errors.New("malformed message")
+	if IsPermanent(err) {
+		t.Fatal("a plain error should not be permanent")
+	}
+	if !IsPermanent(Permanent(err)) {
+		t.Fatal("a wrapped error should be permanent")
+	}
+	if !errors.Is(Permanent(err), err) {
+		t.Fatal("a permanent error should unwrap to the original error")
+	}
+	if Permanent(nil) != nil {
+		t.Fatal("wrapping a nil error should return nil")
+	}
+}
+
+func TestCallSafely(t *testing.T) {
+	err := callSafely(func(amqp.Delivery) error {
+		panic("boom")
+	}, amqp.Delivery{})
+	if err == nil {
+		t.Fatal("a panicking handler should return an error")
+	}
+	delivery := amqp.Delivery{
+		RoutingKey: "queue-name",
+		Headers: amqp.Table{
+			retryCountHeader:    int32(2),
+			originalTopicHeader: "autocar.party.creation",
+		},
+	}
+	if retryCountOf(delivery) != 2 {
+		t.Fatal("wrong retry count :", retryCountOf(delivery))
+	}
+	if !IsRetry(delivery) || IsRetry(amqp.Delivery{}) {
+		t.Fatal("only a redelivered message should be a retry")
+	}
+	if originalTopicOf(delivery) != "autocar.party.creation" {
+		t.Fatal("wrong original topic :", originalTopicOf(delivery))
+	}
+}
+
+func TestReliableCallbackHandler(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2}
+	var responses []interface{}
+	callback := func(response interface{}, topic string) {
+		if topic != "autocar.player.creation.session" {
+			t.Error("unexpected response topic :", topic)
+		}
+		responses = append(responses, response)
+	}
+	failure := errors.New("redis is down")
+	handler := reliableCallbackHandler("autocar.player.creation", callback, func(amqp.Delivery) (interface{}, string) {
+		return failure, ".session"
+	}, policy)
+	// the client is not answered while the message is retried
+	if err := handler(amqp.Delivery{}); !errors.Is(err, failure) || len(responses) != 0 {
+		t.Fatal("expected the error to be retried without response, got", err, responses)
+	}
+	lastRetry := amqp.Delivery{Headers: amqp.Table{retryCountHeader: int32(2)}}
+	if err := handler(lastRetry); !errors.Is(err, failure) || len(responses) != 1 {
+		t.Fatal("expected an error response once retries are exhausted, got", err, responses)
+	}
+	handler = reliableCallbackHandler("autocar.player.creation", callback, func(amqp.Delivery) (interface{}, string) {
+		return Permanent(ErrorMalformedMessage), ".session"
+	}, policy)
+	if err := handler(amqp.Delivery{}); !IsPermanent(err) || len(responses) != 2 {
+		t.Fatal("expected a permanent error to be answered at once, got", err, responses)
+	}
+	if response, ok := responses[1].(ErrorResponse); !ok || response.Code != ErrorCodeMalformedMessage {
+		t.Error("expected a malformed message error response, got", responses[1])
+	}
+	handler = reliableCallbackHandler("autocar.player.creation", callback, func(amqp.Delivery) (interface{}, string) {
+		return "created", ".session"
+	}, policy)
+	if err := handler(amqp.Delivery{}); err != nil || responses[2] != "created" {
+		t.Error("expected the response to be sent back, got", err, responses)
+	}
+}
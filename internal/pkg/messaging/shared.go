@@ -0,0 +1,120 @@
+package messaging
+
+import (
+	"time"
+
+	"github.com/clnbs/autorace/pkg/logger"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// SharedQueuePrefix prefixes the name of the shared queue of a topic
+	SharedQueuePrefix = "autocar.shared."
+	// SharedQueuePrefetch is the number of unacknowledged messages a consumer of a shared queue can
+	// hold, other messages are dispatched to the other consumers
+	SharedQueuePrefetch = 8
+	// SharedQueueMessageTTL is how long a message waits in a shared queue without consumer before it
+	// is dropped, the client asking for it has given up by then
+	SharedQueueMessageTTL = time.Minute
+)
+
+// SharedQueueName returns the name of the durable queue shared by every consumer of a topic
+func SharedQueueName(topic string) string {
+	return SharedQueuePrefix + topic
+}
+
+// SendMessageOnSharedTopic works like SendMessageOnTopic for a topic consumed from its shared queue.
+// The message is persistent, so the durable shared queue keeps it while the broker restarts
+func (rConn *RabbitConnection) SendMessageOnSharedTopic(message interface{}, topic string) {
+	publishing, err := rConn.prepare(message)
+	if err != nil {
+		logger.Error("error while preparing message :", err)
+		return
+	}
+	publishing.DeliveryMode = amqp.Persistent
+	err = rConn.publishPrepared(rConn.partiesChannel, publishing, topic)
+	if err != nil {
+		logger.Error("error while sending message :", err)
+	}
+}
+
+// ReceiveMessageOnSharedQueueWithReliableHandler works like ReceiveMessageOnTopicWithReliableHandler on a
+// named, durable queue shared by every consumer of the topic. Consumers compete for its messages : each
+// message is handled by only one of them, and a message left unacknowledged by a consumer which stopped
+// is delivered to another one
+func (rConn *RabbitConnection) ReceiveMessageOnSharedQueueWithReliableHandler(topic string, handler func(amqp.Delivery) error, policy RetryPolicy, readyToReceive chan bool) error {
+	queueName, msgs, err := rConn.consumeOnSharedQueue(topic)
+	if err != nil {
+		readyToReceive <- false
+		return err
+	}
+	logger.Trace("waiting message on shared queue", queueName)
+	go func() {
+		readyToReceive <- true
+		for msg := range msgs {
+			rConn.processReliably(msg, queueName, policy, handler)
+		}
+	}()
+	rConn.waitForStop()
+	return nil
+}
+
+// ReceiveMessageOnSharedQueueWithReliableCallback works like ReceiveMessageOnTopicWithReliableCallback on the
+// shared queue of a topic, see ReceiveMessageOnSharedQueueWithReliableHandler
+func (rConn *RabbitConnection) ReceiveMessageOnSharedQueueWithReliableCallback(topic, responseTopic string, callback func(interface{}, string), responseCreator func(delivery amqp.Delivery) (interface{}, string), policy RetryPolicy, readyToReceive chan bool) error {
+	return rConn.ReceiveMessageOnSharedQueueWithReliableHandler(topic, reliableCallbackHandler(responseTopic, callback, responseCreator, policy), policy, readyToReceive)
+}
+
+// consumeOnSharedQueue declares the shared queue of a topic, binds it and start consuming it with
+// manual acknowledgement
+func (rConn *RabbitConnection) consumeOnSharedQueue(topic string) (string, <-chan amqp.Delivery, error) {
+	queue, err := rConn.sharedChannel.QueueDeclare(
+		SharedQueueName(topic), // name
+		true,                   // durable
+		false,                  // auto-deleted
+		false,                  // exclusive
+		false,                  // no-wait
+		amqp.Table{ // arguments
+			"x-message-ttl": int32(SharedQueueMessageTTL / time.Millisecond),
+		},
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	err = rConn.sharedChannel.QueueBind(
+		queue.Name,      //queue name
+		topic,           //routing key
+		"parties_topic", // exchange
+		false,           // no wait
+		nil,             // args
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	msgs, err := rConn.sharedChannel.Consume(
+		queue.Name, // queue
+		"",         // consumer
+		false,      // auto ack
+		false,      // exclusive
+		false,      // no local
+		false,      // no wait
+		nil,        // args
+	)
+	return queue.Name, msgs, err
+}
+
+// declareSharedChannel opens the channel consuming shared queues. Its prefetch limit applies to each
+// consumer, so a busy consumer does not hold messages other consumers could handle
+func (rConn *RabbitConnection) declareSharedChannel() error {
+	var err error
+	rConn.sharedChannel, err = rConn.conn.Channel()
+	if err != nil {
+		return err
+	}
+	return rConn.sharedChannel.Qos(
+		SharedQueuePrefetch, // prefetch count
+		0,                   // prefetch size
+		false,               // global
+	)
+}
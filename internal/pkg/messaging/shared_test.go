@@ -0,0 +1,76 @@
+package messaging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitConnection_ReceiveMessageOnSharedQueue(t *testing.T) {
+	config := RabbitConnectionConfiguration{
+		Host:     "localhost",
+		Port:     "5672",
+		User:     "guest",
+		Password: "guest",
+	}
+	topic := "test.topic.shared"
+	messageCount := 20
+	var mutex sync.Mutex
+	received := make(map[string]int)
+	handled := make(chan struct{}, messageCount)
+	handler := func(msg amqp.Delivery) error {
+		var payload string
+		err := UnmarshalPayload(msg.Body, "string", &payload)
+		if err != nil {
+			return Permanent(err)
+		}
+		mutex.Lock()
+		received[payload]++
+		mutex.Unlock()
+		handled <- struct{}{}
+		return nil
+	}
+	var consumers []*RabbitConnection
+	for index := 0; index < 2; index++ {
+		rConn, err := NewRabbitConnection(config)
+		if err != nil {
+			t.Fatal("could not get a connection with RabbitMQ :", err)
+		}
+		defer rConn.Close()
+		consumers = append(consumers, rConn)
+		rdyToReceive := make(chan bool)
+		go func() {
+			err := rConn.ReceiveMessageOnSharedQueueWithReliableHandler(topic, handler, DefaultRetryPolicy, rdyToReceive)
+			if err != nil {
+				t.Error("could not receive data on shared queue :", err)
+			}
+		}()
+		if !<-rdyToReceive {
+			t.Fatal("could not listen on shared queue")
+		}
+	}
+	for index := 0; index < messageCount; index++ {
+		consumers[0].SendMessageOnSharedTopic(string(rune('a'+index)), topic)
+	}
+	for index := 0; index < messageCount; index++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("only", index, "messages handled")
+		}
+	}
+	// leave time for a duplicated delivery to show up
+	time.Sleep(500 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	for payload, count := range received {
+		if count != 1 {
+			t.Error("message", payload, "handled", count, "times")
+		}
+	}
+	if len(received) != messageCount {
+		t.Error("expected", messageCount, "different messages, got", len(received))
+	}
+}
@@ -81,6 +81,9 @@ build_server_image() {
   docker build -t autorace_dynamic . -f ./build/package/dynamic/Dockerfile
   RESULT=$?
   check_command_success $RESULT 0 "Could not build dynamic Autorace server"
+  docker build -t autorace_gateway . -f ./build/package/gateway/Dockerfile
+  RESULT=$?
+  check_command_success $RESULT 0 "Could not build Autorace WebSocket gateway"
 }
 
 